@@ -5,27 +5,99 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/edfungus/conduction/diagram"
+	"github.com/edfungus/conduction/manifest"
 	"github.com/edfungus/conduction/messenger"
 
 	"github.com/edfungus/conduction/storage"
+	"github.com/edfungus/conduction/transform"
 	"github.com/gorilla/mux"
 )
 
 const (
-	flowIDPathVariable = "flowID"
-	pathIDPathVariable = "pathID"
+	flowIDPathVariable    = "flowID"
+	pathIDPathVariable    = "pathID"
+	namespacePathVariable = "namespace"
+
+	actorHeader  = "X-Actor"
+	defaultActor = "anonymous"
 )
 
 type Admin struct {
-	Router  *mux.Router
-	Storage storage.Storage
+	Router        *mux.Router
+	Storage       storage.Storage
+	Authenticator Authenticator // Finds who made each request so their role can be checked. Without one, every request is allowed
+	writeLock     sync.Mutex    // Checking If-Match and writing happen together so two requests with the same ETag cannot both succeed
+}
+
+// cacheStatser is a Storage that counts how often its cache is used
+type cacheStatser interface {
+	Stats() storage.CacheStats
+}
+
+var (
+	ErrStorageNotCached  error = fmt.Errorf("Storage does not have a cache")
+	ErrIfMatchRequired   error = fmt.Errorf("Request must have an If-Match header with the ETag of the resource")
+	ErrIfMatchFailed     error = fmt.Errorf("Resource has changed since it was read. If-Match does not match its ETag")
+	ErrInvalidVersion    error = fmt.Errorf("Version must be a whole number above 0")
+	ErrInvalidTime       error = fmt.Errorf("Time must be in RFC 3339 format")
+	ErrNamespaceMismatch error = fmt.Errorf("Namespace in the body does not match the namespace in the URL")
+	ErrMissingSelector   error = fmt.Errorf("Query parameter selector is required so every Flow is not changed by mistake")
+	ErrFlowNameTaken     error = fmt.Errorf("Another Flow in the namespace has this name. Flow names are unique within a namespace so manifests can match them")
+)
+
+// transformPreviewRequest is a transform and a sample message to apply it to. A JSON string payload is used as text, anything else as JSON
+type transformPreviewRequest struct {
+	Transform string            `json:"transform"`
+	Payload   json.RawMessage   `json:"payload"`
+	Metadata  map[string]string `json:"metadata"`
+	Origin    *messenger.Path   `json:"origin"`
+}
+
+type transformPreviewResponse struct {
+	Payload string `json:"payload"`
+}
+
+// flowsPage is a page of listed Flows and the cursor of the next page
+type flowsPage struct {
+	Flows []storage.Flow `json:"flows"`
+	Next  string         `json:"next,omitempty"`
+}
+
+// pathsPage is a page of listed Paths and the cursor of the next page
+type pathsPage struct {
+	Paths []storage.StoredPath `json:"paths"`
+	Next  string               `json:"next,omitempty"`
+}
+
+// auditPage is a page of the audit log and the cursor of the next page
+type auditPage struct {
+	Entries []storage.AuditEntry `json:"entries"`
+	Next    string               `json:"next,omitempty"`
+}
+
+// changedFlows is the Flows a bulk change made and the ETag each has now, by uuid
+type changedFlows struct {
+	Flows []storage.Flow    `json:"flows"`
+	ETags map[string]string `json:"etags"`
 }
 
 type errorResponse struct {
 	Error string `json:"error"`
 }
 
+// applyErrorResponse is the Changes a manifest apply made before the Change it could not make
+type applyErrorResponse struct {
+	Error   string            `json:"error"`
+	Applied []manifest.Change `json:"applied"`
+	Failed  manifest.Change   `json:"failed"`
+}
+
 func NewAdmin(storage storage.Storage) *Admin {
 	r := mux.NewRouter()
 	admin := &Admin{
@@ -33,20 +105,138 @@ func NewAdmin(storage storage.Storage) *Admin {
 		Storage: storage,
 	}
 
-	// r.HandleFunc("/flows", admin.getFlows).Methods("GET") // low priority
-	r.HandleFunc("/flows", admin.postFlow).Methods("POST")
-	r.HandleFunc(fmt.Sprintf("/flows/{%s}", flowIDPathVariable), admin.getFlowByID).Methods("GET")
+	namespaced := r.PathPrefix(fmt.Sprintf("/namespaces/{%s}", namespacePathVariable)).Subrouter()
+	admin.handleFlowsAndPaths(r)
+	admin.handleFlowsAndPaths(namespaced)
+	admin.handleExports(r)
+	admin.handleExports(namespaced)
+	admin.handleManifests(r)
+	admin.handleManifests(namespaced)
 
-	// r.HandleFunc("/paths", getPaths).Methods("GET") // low priority
-	r.HandleFunc("/paths", admin.postPath).Methods("POST")
-	r.HandleFunc(fmt.Sprintf("/paths/{%s}", pathIDPathVariable), admin.getPathByID).Methods("GET")
-	r.HandleFunc(fmt.Sprintf("/paths/{%s}/flows", pathIDPathVariable), admin.getFlowsFromPath).Methods("GET")
-	// r.HandleFunc("/paths/{uuid}/flows/{uuid}", addFlowToPath).Methods("POST")
-	// r.HandleFunc("/paths/{uuid}/flows/{uuid}", deleteFlowFromPath).Methods("DELETE")
+	r.HandleFunc("/cache/stats", admin.require(RoleViewer, admin.getCacheStats)).Methods("GET")
+	r.HandleFunc("/transforms/preview", admin.require(RoleViewer, admin.previewTransform)).Methods("POST")
 
 	return admin
 }
 
+// handleFlowsAndPaths adds the Flow and Path endpoints to the router. Under /namespaces/{namespace} they only see and make Flows and Paths in that namespace
+func (a *Admin) handleFlowsAndPaths(r *mux.Router) {
+	r.HandleFunc("/flows", a.require(RoleViewer, a.getFlows)).Methods("GET")
+	r.HandleFunc("/flows", a.require(RoleEditor, a.postFlow)).Methods("POST")
+	r.HandleFunc("/flows/disable", a.require(RoleEditor, a.setFlowsDisabled(true))).Methods("POST")
+	r.HandleFunc("/flows/enable", a.require(RoleEditor, a.setFlowsDisabled(false))).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/flows/{%s}", flowIDPathVariable), a.require(RoleViewer, a.getFlowByID)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/flows/{%s}", flowIDPathVariable), a.require(RoleEditor, a.putFlow)).Methods("PUT")
+	r.HandleFunc(fmt.Sprintf("/flows/{%s}", flowIDPathVariable), a.require(RoleEditor, a.patchFlow)).Methods("PATCH")
+	r.HandleFunc(fmt.Sprintf("/flows/{%s}", flowIDPathVariable), a.require(RoleEditor, a.deleteFlow)).Methods("DELETE")
+	r.HandleFunc(fmt.Sprintf("/flows/{%s}/rollback", flowIDPathVariable), a.require(RoleEditor, a.rollbackFlow)).Methods("POST")
+
+	r.HandleFunc("/paths", a.require(RoleViewer, a.getPaths)).Methods("GET")
+	r.HandleFunc("/paths", a.require(RoleEditor, a.postPath)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}", pathIDPathVariable), a.require(RoleViewer, a.getPathByID)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}", pathIDPathVariable), a.require(RoleEditor, a.deletePath)).Methods("DELETE")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}/flows", pathIDPathVariable), a.require(RoleViewer, a.getFlowsFromPath)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}/downstream", pathIDPathVariable), a.require(RoleViewer, a.getDownstreamOfPath)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}/upstream", pathIDPathVariable), a.require(RoleViewer, a.getUpstreamOfPath)).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}/flows/{%s}", pathIDPathVariable, flowIDPathVariable), a.require(RoleEditor, a.addFlowToPath)).Methods("POST")
+	r.HandleFunc(fmt.Sprintf("/paths/{%s}/flows/{%s}", pathIDPathVariable, flowIDPathVariable), a.require(RoleEditor, a.deleteFlowFromPath)).Methods("DELETE")
+}
+
+// handleExports adds the graph and audit log endpoints to the router. Under /namespaces/{namespace} they only export that namespace
+func (a *Admin) handleExports(r *mux.Router) {
+	r.HandleFunc("/graph", a.require(RoleViewer, a.getGraph)).Methods("GET")
+	r.HandleFunc("/audit", a.require(RoleAdmin, a.getAudit)).Methods("GET")
+}
+
+// handleManifests adds the manifest export, plan and apply endpoints to the router. Under /namespaces/{namespace} they only read and change that namespace
+func (a *Admin) handleManifests(r *mux.Router) {
+	r.HandleFunc("/manifest", a.require(RoleViewer, a.getManifest)).Methods("GET")
+	r.HandleFunc("/manifest/plan", a.require(RoleViewer, a.postManifestPlan)).Methods("POST")
+	r.HandleFunc("/manifest/apply", a.require(RoleAdmin, a.postManifestApply)).Methods("POST")
+}
+
+func (a *Admin) getFlows(w http.ResponseWriter, r *http.Request) {
+	options, err := getListOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flows, _, next, err := a.Storage.ListFlows(options)
+	if err != nil {
+		respondError(w, err.Error(), listErrorStatus(err))
+		return
+	}
+	response, err := json.Marshal(flowsPage{
+		Flows: storage.NewFlows(flows).Flows,
+		Next:  next,
+	})
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+// setFlowsDisabled returns a handler that disables or enables every Flow picked by the selector query parameter, narrowed by the other list query parameters.
+// Flows already disabled or enabled are left alone. Responds with the Flows that changed and their new ETags. If a change fails, the Flows changed before it stay changed.
+// The Flows are picked by the selector rather than read first, so unlike other writes there is no If-Match to check. Only Disabled changes, so nothing else written since is lost
+func (a *Admin) setFlowsDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, err := getListOptionsFromRequest(r)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if options.Selector.Empty() {
+			respondError(w, ErrMissingSelector.Error(), http.StatusBadRequest)
+			return
+		}
+		options.Cursor = ""
+		options.Limit = storage.MaxListLimit
+		a.writeLock.Lock()
+		defer a.writeLock.Unlock()
+		changed := changedFlows{
+			Flows: []storage.Flow{},
+			ETags: make(map[string]string),
+		}
+		for {
+			flows, keys, next, err := a.Storage.ListFlows(options)
+			if err != nil {
+				respondError(w, err.Error(), listErrorStatus(err))
+				return
+			}
+			for i, flow := range flows {
+				if flow.Disabled == disabled {
+					continue
+				}
+				flow.Disabled = disabled
+				err := a.storageFor(r).UpdateFlow(keys[i], flow)
+				if err != nil {
+					respondError(w, err.Error(), storageErrorStatus(err))
+					return
+				}
+				updated, err := a.Storage.GetFlowByKey(keys[i])
+				if err != nil {
+					respondError(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				changed.Flows = append(changed.Flows, updated)
+				changed.ETags[keys[i].String()] = etagOf(updated.Version)
+			}
+			if next == "" {
+				break
+			}
+			options.Cursor = next
+		}
+		response, err := json.Marshal(changed)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, string(response), http.StatusOK)
+	}
+}
+
 func (a *Admin) postFlow(w http.ResponseWriter, r *http.Request) {
 	var flow storage.Flow
 	err := getObjectFromRequestBody(r, &flow)
@@ -54,20 +244,36 @@ func (a *Admin) postFlow(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	flow.Namespace, err = namespaceForRequest(r, flow.Namespace)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err := validateFlow(flow); err != nil {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	key, err := a.Storage.SaveFlow(flow)
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	if !a.checkFlowNameIsFree(w, flow, storage.Key{}) {
+		return
+	}
+	key, err := a.storageFor(r).SaveFlow(flow)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	created, err := a.Storage.GetFlowByKey(key)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	response, err := json.Marshal(key)
+	response, err := json.Marshal(created)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Add("Location", locationOf(r, key))
 	respondJSON(w, string(response), http.StatusCreated)
 }
 
@@ -77,19 +283,237 @@ func (a *Admin) getFlowByID(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	flow, err := a.Storage.GetFlowByKey(key)
+	flow, err := a.getFlowInNamespace(r, key)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	response, err := json.Marshal(flow)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etagOf(flow.Version))
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+// putFlow replaces every field of the Flow
+func (a *Admin) putFlow(w http.ResponseWriter, r *http.Request) {
+	key, err := getValueFromRequest(r, flowIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	current, err := a.getFlowInNamespace(r, key)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if !checkIfMatch(w, r, current.Version) {
+		return
+	}
+	var flow storage.Flow
+	err = getObjectFromRequestBody(r, &flow)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow.Namespace, err = namespaceForRequest(r, flow.Namespace)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow.Version = current.Version
+	a.updateFlow(w, r, key, flow)
+}
+
+// patchFlow replaces only the fields of the Flow that are in the request. Each field is replaced as a whole, so labels and Paths are not merged with the stored ones
+func (a *Admin) patchFlow(w http.ResponseWriter, r *http.Request) {
+	key, err := getValueFromRequest(r, flowIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	current, err := a.getFlowInNamespace(r, key)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, current.Version) {
+		return
+	}
+	var changes map[string]json.RawMessage
+	err = getObjectFromRequestBody(r, &changes)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow, err := patchedFlow(current, changes)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow.Namespace, err = namespaceForRequest(r, flow.Namespace)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flow.Version = current.Version
+	a.updateFlow(w, r, key, flow)
+}
+
+// patchedFlow returns the Flow with the fields named by the changes set to their values. The Flow is decoded afresh rather than onto the stored one,
+// which would merge maps and nested Paths. Field names match the way encoding/json matches them, ignoring case
+func patchedFlow(flow storage.Flow, changes map[string]json.RawMessage) (storage.Flow, error) {
+	stored, err := json.Marshal(flow)
+	if err != nil {
+		return storage.Flow{}, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(stored, &fields)
+	if err != nil {
+		return storage.Flow{}, err
+	}
+	for name, value := range changes {
+		for field := range fields {
+			if strings.EqualFold(field, name) {
+				delete(fields, field)
+			}
+		}
+		fields[name] = value
+	}
+	patched, err := json.Marshal(fields)
+	if err != nil {
+		return storage.Flow{}, err
+	}
+	var result storage.Flow
+	err = json.Unmarshal(patched, &result)
+	return result, err
+}
+
+func (a *Admin) updateFlow(w http.ResponseWriter, r *http.Request, key storage.Key, flow storage.Flow) {
+	if err := validateFlow(flow); err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.checkFlowNameIsFree(w, flow, key) {
+		return
+	}
+	err := a.storageFor(r).UpdateFlow(key, flow)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	flow, err = a.Storage.GetFlowByKey(key)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response, err := json.Marshal(flow)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etagOf(flow.Version))
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+func (a *Admin) deleteFlow(w http.ResponseWriter, r *http.Request) {
+	key, err := getValueFromRequest(r, flowIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	flow, err := a.getFlowInNamespace(r, key)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	if !checkIfMatch(w, r, flow.Version) {
+		return
+	}
+	err = a.storageFor(r).DeleteFlow(key)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rollbackFlow puts the Flow back to how it was at the version query parameter, as recorded in the audit log
+func (a *Admin) rollbackFlow(w http.ResponseWriter, r *http.Request) {
+	key, err := getValueFromRequest(r, flowIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if err != nil || version < 1 {
+		respondError(w, ErrInvalidVersion.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	current, err := a.getFlowInNamespace(r, key)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	if !checkIfMatch(w, r, current.Version) {
+		return
+	}
+	snapshot, err := a.storageFor(r).FlowAtVersion(key, version)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	if !a.checkFlowNameIsFree(w, snapshot, key) {
+		return
+	}
+	flow, err := a.storageFor(r).RollbackFlow(key, version, current.Version)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
 	response, err := json.Marshal(flow)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("ETag", etagOf(flow.Version))
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+func (a *Admin) getPaths(w http.ResponseWriter, r *http.Request) {
+	options, err := getListOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	paths, _, next, err := a.Storage.ListPaths(options)
+	if err != nil {
+		respondError(w, err.Error(), listErrorStatus(err))
+		return
+	}
+	response, err := json.Marshal(pathsPage{
+		Paths: paths,
+		Next:  next,
+	})
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	respondJSON(w, string(response), http.StatusOK)
 }
 
+// postPath creates the Path, or updates the identity, metadata and labels of the Path with its namespace, type and route.
+// Creating responds with 201. Updating needs If-Match with the ETag of the existing Path like other writes and responds with 200
 func (a *Admin) postPath(w http.ResponseWriter, r *http.Request) {
 	var path messenger.Path
 	err := getObjectFromRequestBody(r, &path)
@@ -97,21 +521,50 @@ func (a *Admin) postPath(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	path.Namespace, err = namespaceForRequest(r, path.Namespace)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err := validatePath(path); err != nil {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	key, err := a.Storage.SavePath(path)
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	status := http.StatusCreated
+	existing, err := a.Storage.GetKeyOfPath(path)
+	switch err {
+	case nil:
+		if !a.checkPathIfMatch(w, r, existing) {
+			return
+		}
+		status = http.StatusOK
+	case storage.ErrPathNotFound:
+	default:
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	key, err := a.storageFor(r).SavePath(path)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	saved, err := a.Storage.GetStoredPathByKey(key)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	response, err := json.Marshal(key)
+	response, err := json.Marshal(saved)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	respondJSON(w, string(response), http.StatusCreated)
+	w.Header().Add("Location", locationOf(r, key))
+	if status == http.StatusOK {
+		w.Header().Set("ETag", etagOf(saved.Version))
+	}
+	respondJSON(w, string(response), status)
 }
 
 func (a *Admin) getPathByID(w http.ResponseWriter, r *http.Request) {
@@ -120,7 +573,7 @@ func (a *Admin) getPathByID(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	path, err := a.Storage.GetPathByKey(key)
+	path, err := a.getPathInNamespace(r, key)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
@@ -130,27 +583,98 @@ func (a *Admin) getPathByID(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("ETag", etagOf(path.Version))
 	respondJSON(w, string(response), http.StatusOK)
 }
 
+func (a *Admin) deletePath(w http.ResponseWriter, r *http.Request) {
+	key, err := getValueFromRequest(r, pathIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	if !a.checkPathIfMatch(w, r, key) {
+		return
+	}
+	err = a.storageFor(r).DeletePath(key)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *Admin) getFlowsFromPath(w http.ResponseWriter, r *http.Request) {
 	key, err := getValueFromRequest(r, pathIDPathVariable)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	_, err = a.Storage.GetPathByKey(key)
+	_, err = a.getPathInNamespace(r, key)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	flows, keys, err := a.Storage.GetNextFlows(key)
+	flows, _, err := a.Storage.GetNextFlows(key)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response, err := json.Marshal(storage.NewFlows(flowsInNamespace(r, flows)))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+func (a *Admin) getDownstreamOfPath(w http.ResponseWriter, r *http.Request) {
+	a.respondTraversal(w, r, a.Storage.GetDownstream)
+}
+
+func (a *Admin) getUpstreamOfPath(w http.ResponseWriter, r *http.Request) {
+	a.respondTraversal(w, r, a.Storage.GetUpstream)
+}
+
+// respondTraversal responds with the traversal from the Path in the request, limited by the depth query parameter
+func (a *Admin) respondTraversal(w http.ResponseWriter, r *http.Request, traverse func(storage.Key, int) (storage.Traversal, error)) {
+	key, err := getValueFromRequest(r, pathIDPathVariable)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	depth := 0
+	if value := r.URL.Query().Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 1 {
+			respondError(w, storage.ErrInvalidDepth.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	_, err = a.getPathInNamespace(r, key)
 	if err != nil {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	traversal, err := traverse(key, depth)
+	switch err {
+	case nil:
+	case storage.ErrPathCannotBeRetrieved:
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	case storage.ErrInvalidDepth:
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	combineFlowList := storage.AddKeyToFlows(flows, keys)
-	response, err := json.Marshal(combineFlowList)
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok {
+		traversal = traversal.InNamespace(namespace)
+	}
+	response, err := json.Marshal(traversal)
 	if err != nil {
 		respondError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -158,6 +682,285 @@ func (a *Admin) getFlowsFromPath(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, string(response), http.StatusOK)
 }
 
+func (a *Admin) addFlowToPath(w http.ResponseWriter, r *http.Request) {
+	pathKey, flowKey, err := getPathAndFlowKeysFromRequest(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	if !a.checkPathIfMatch(w, r, pathKey) {
+		return
+	}
+	err = a.storageFor(r).ChainNextFlowToPath(flowKey, pathKey)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	a.respondFlowsOfPath(w, r, pathKey, http.StatusCreated)
+}
+
+func (a *Admin) deleteFlowFromPath(w http.ResponseWriter, r *http.Request) {
+	pathKey, flowKey, err := getPathAndFlowKeysFromRequest(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	if !a.checkPathIfMatch(w, r, pathKey) {
+		return
+	}
+	err = a.storageFor(r).UnchainNextFlowFromPath(flowKey, pathKey)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return
+	}
+	a.respondFlowsOfPath(w, r, pathKey, http.StatusOK)
+}
+
+// respondFlowsOfPath responds with the Flows the Path triggers after it has been linked or unlinked, and the new ETag of the Path
+func (a *Admin) respondFlowsOfPath(w http.ResponseWriter, r *http.Request, pathKey storage.Key, code int) {
+	flows, _, err := a.Storage.GetNextFlows(pathKey)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	path, err := a.Storage.GetStoredPathByKey(pathKey)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response, err := json.Marshal(storage.NewFlows(flowsInNamespace(r, flows)))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etagOf(path.Version))
+	respondJSON(w, string(response), code)
+}
+
+// checkPathIfMatch checks the If-Match header against the ETag of the Path, responding with an error if the Path is missing or does not match
+func (a *Admin) checkPathIfMatch(w http.ResponseWriter, r *http.Request, pathKey storage.Key) bool {
+	path, err := a.getPathInNamespace(r, pathKey)
+	if err != nil {
+		respondError(w, err.Error(), storageErrorStatus(err))
+		return false
+	}
+	return checkIfMatch(w, r, path.Version)
+}
+
+// checkFlowNameIsFree returns whether no other Flow in the namespace of the Flow has its name. Otherwise it responds with 409.
+// key is the Flow being changed, which may keep its name, or the zero Key for a new Flow. Callers hold writeLock so two requests cannot take the same name
+func (a *Admin) checkFlowNameIsFree(w http.ResponseWriter, flow storage.Flow, key storage.Key) bool {
+	options := storage.ListOptions{Namespace: flow.Namespace, Name: flow.Name, Limit: storage.MaxListLimit}
+	for {
+		flows, keys, next, err := a.Storage.ListFlows(options)
+		if err != nil {
+			respondError(w, err.Error(), listErrorStatus(err))
+			return false
+		}
+		for i, other := range flows {
+			if other.Name == flow.Name && other.Namespace == flow.Namespace && keys[i] != key {
+				respondError(w, ErrFlowNameTaken.Error(), http.StatusConflict)
+				return false
+			}
+		}
+		if next == "" {
+			return true
+		}
+		options.Cursor = next
+	}
+}
+
+// checkIfMatch returns whether the If-Match header of the request has the ETag of the version, or "*". Otherwise it responds with 428 when the header is missing and 412 when it does not match
+func checkIfMatch(w http.ResponseWriter, r *http.Request, version int64) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		respondError(w, ErrIfMatchRequired.Error(), http.StatusPreconditionRequired)
+		return false
+	}
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" || etag == etagOf(version) {
+			return true
+		}
+	}
+	respondError(w, ErrIfMatchFailed.Error(), http.StatusPreconditionFailed)
+	return false
+}
+
+// etagOf returns the ETag of a Flow or Path at the version
+func etagOf(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// locationOf returns the URL of what the request created, under the same route as the request so namespaced routes stay namespaced
+func locationOf(r *http.Request, key storage.Key) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(r.URL.Path, "/"), key)
+}
+
+// storageErrorStatus returns the status code for an error from changing Flows, Paths or the links between them
+func storageErrorStatus(err error) int {
+	switch err {
+	case storage.ErrFlowCannotBeRetrieved, storage.ErrPathCannotBeRetrieved, storage.ErrChainNotFound, storage.ErrVersionNotInAudit:
+		return http.StatusNotFound
+	case storage.ErrChainCreatesCycle, storage.ErrChainAlreadyExists, storage.ErrFlowHasContinuations, storage.ErrPathInUse, storage.ErrNamespaceNotShared:
+		return http.StatusConflict
+	case storage.ErrPathOutsideNamespace:
+		return http.StatusBadRequest
+	case storage.ErrVersionConflict:
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// listErrorStatus returns the status code for an error from listing Flows or Paths
+func listErrorStatus(err error) int {
+	switch err {
+	case storage.ErrInvalidSortField, storage.ErrInvalidCursor, storage.ErrInvalidLimit:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// getListOptionsFromRequest reads the query parameters namespace, type, routePrefix, name, selector, sort, cursor and limit. Sorting by a field prefixed with "-" sorts descending.
+// Requests scoped to a namespace by their URL only list that namespace. The selector picks by labels, like owner=iot,env!=prod
+func getListOptionsFromRequest(r *http.Request) (storage.ListOptions, error) {
+	query := r.URL.Query()
+	options := storage.ListOptions{
+		Namespace:   query.Get("namespace"),
+		Type:        query.Get("type"),
+		RoutePrefix: query.Get("routePrefix"),
+		Name:        query.Get("name"),
+		SortBy:      query.Get("sort"),
+		Cursor:      query.Get("cursor"),
+	}
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok {
+		options.Namespace = namespace
+	}
+	var err error
+	options.Selector, err = storage.ParseSelector(query.Get("selector"))
+	if err != nil {
+		return storage.ListOptions{}, err
+	}
+	if strings.HasPrefix(options.SortBy, "-") {
+		options.SortBy = strings.TrimPrefix(options.SortBy, "-")
+		options.Descending = true
+	}
+	if limit := query.Get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 1 {
+			return storage.ListOptions{}, storage.ErrInvalidLimit
+		}
+	}
+	return options, nil
+}
+
+// getAuditOptionsFromRequest reads the query parameters actor, action, kind, target, since, until, cursor and limit. Times are in RFC 3339 format
+func getAuditOptionsFromRequest(r *http.Request) (storage.AuditOptions, error) {
+	query := r.URL.Query()
+	options := storage.AuditOptions{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Kind:   query.Get("kind"),
+		Cursor: query.Get("cursor"),
+	}
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok {
+		options.Namespace = namespace
+	}
+	var err error
+	if target := query.Get("target"); target != "" {
+		key, err := storage.NewKeyFromString(target)
+		if err != nil {
+			return storage.AuditOptions{}, err
+		}
+		options.Target = key.UUID
+	}
+	for name, t := range map[string]*time.Time{"since": &options.Since, "until": &options.Until} {
+		if value := query.Get(name); value != "" {
+			*t, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				return storage.AuditOptions{}, ErrInvalidTime
+			}
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 1 {
+			return storage.AuditOptions{}, storage.ErrInvalidLimit
+		}
+	}
+	return options, nil
+}
+
+// namespaceForRequest returns the namespace a Flow or Path in the request body belongs to. Requests scoped to a namespace by their URL put it there,
+// and a body naming another namespace is rejected
+func namespaceForRequest(r *http.Request, namespace string) (string, error) {
+	scoped, ok := mux.Vars(r)[namespacePathVariable]
+	if !ok {
+		return namespace, nil
+	}
+	if namespace != "" && namespace != scoped {
+		return "", ErrNamespaceMismatch
+	}
+	return scoped, nil
+}
+
+// getFlowInNamespace returns the Flow of the Key, as if it does not exist when the request is scoped to a namespace the Flow is not in
+func (a *Admin) getFlowInNamespace(r *http.Request, key storage.Key) (storage.Flow, error) {
+	flow, err := a.Storage.GetFlowByKey(key)
+	if err != nil {
+		return storage.Flow{}, err
+	}
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok && flow.Namespace != namespace {
+		return storage.Flow{}, storage.ErrFlowCannotBeRetrieved
+	}
+	return flow, nil
+}
+
+// getPathInNamespace returns the Path of the Key, as if it does not exist when the request is scoped to a namespace the Path is not in
+func (a *Admin) getPathInNamespace(r *http.Request, key storage.Key) (storage.StoredPath, error) {
+	path, err := a.Storage.GetStoredPathByKey(key)
+	if err != nil {
+		return storage.StoredPath{}, err
+	}
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok && path.Namespace != namespace {
+		return storage.StoredPath{}, storage.ErrPathCannotBeRetrieved
+	}
+	return path, nil
+}
+
+// flowsInNamespace returns the Flows in the namespace when the request is scoped to one, leaving out Flows shared from other namespaces
+func flowsInNamespace(r *http.Request, flows []storage.Flow) []storage.Flow {
+	namespace, ok := mux.Vars(r)[namespacePathVariable]
+	if !ok {
+		return flows
+	}
+	kept := []storage.Flow{}
+	for _, flow := range flows {
+		if flow.Namespace == namespace {
+			kept = append(kept, flow)
+		}
+	}
+	return kept
+}
+
+func getPathAndFlowKeysFromRequest(r *http.Request) (storage.Key, storage.Key, error) {
+	pathKey, err := getValueFromRequest(r, pathIDPathVariable)
+	if err != nil {
+		return storage.Key{}, storage.Key{}, err
+	}
+	flowKey, err := getValueFromRequest(r, flowIDPathVariable)
+	if err != nil {
+		return storage.Key{}, storage.Key{}, err
+	}
+	return pathKey, flowKey, nil
+}
+
 func getValueFromRequest(r *http.Request, key string) (storage.Key, error) {
 	vars := mux.Vars(r)
 	id := vars[key]
@@ -171,6 +974,283 @@ func getObjectFromRequestBody(r *http.Request, v interface{}) error {
 	return json.Unmarshal(buf.Bytes(), v)
 }
 
+// getGraph exports the graph in the format query parameter, which defaults to JSON. With the path query parameter, only the part of the graph around that Path, up to depth Flows away, is exported.
+// Requests scoped to a namespace only export the nodes in it
+func (a *Admin) getGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = diagram.FormatJSON
+	}
+	var graph storage.Graph
+	var err error
+	if pathID := query.Get("path"); pathID != "" {
+		key, err := storage.NewKeyFromString(pathID)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		depth := 0
+		if value := query.Get("depth"); value != "" {
+			depth, err = strconv.Atoi(value)
+			if err != nil || depth < 1 {
+				respondError(w, storage.ErrInvalidDepth.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		_, err = a.getPathInNamespace(r, key)
+		if err != nil {
+			respondError(w, err.Error(), storageErrorStatus(err))
+			return
+		}
+		graph, err = a.Storage.GetGraphAroundPath(key, depth)
+		switch err {
+		case nil:
+		case storage.ErrPathCannotBeRetrieved:
+			respondError(w, err.Error(), http.StatusNotFound)
+			return
+		case storage.ErrInvalidDepth:
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		graph, err = a.Storage.GetGraph()
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok {
+		graph = graph.InNamespace(namespace)
+	}
+	response, err := diagram.Render(graph, format)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", diagram.ContentType(format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// getManifest exports every Path and Flow as a manifest in the format query parameter, which defaults to YAML. Requests scoped to a namespace only export that namespace
+func (a *Admin) getManifest(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = manifest.FormatYAML
+	}
+	var m manifest.Manifest
+	var err error
+	if namespace, ok := mux.Vars(r)[namespacePathVariable]; ok {
+		m, err = manifest.ExportNamespace(a.Storage, namespace)
+	} else {
+		m, err = manifest.Export(a.Storage)
+	}
+	if err != nil {
+		respondError(w, err.Error(), manifestErrorStatus(err))
+		return
+	}
+	response, err := m.Encode(format)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := "application/json"
+	if format == manifest.FormatYAML {
+		contentType = "application/yaml"
+	}
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// postManifestPlan responds with the changes applying the YAML or JSON manifest in the body would make, without making them.
+// Requests scoped to a namespace only plan changes to that namespace
+func (a *Admin) postManifestPlan(w http.ResponseWriter, r *http.Request) {
+	plan, ok := a.planManifestFromRequest(w, r)
+	if !ok {
+		return
+	}
+	a.respondPlan(w, plan)
+}
+
+// postManifestApply changes storage to match the YAML or JSON manifest in the body and responds with the changes made.
+// Requests scoped to a namespace only change that namespace, so Flows and Paths of other namespaces missing from the manifest are not deleted.
+// When a change fails, the changes before it stay made, so the response lists them as applied along with the change that failed
+func (a *Admin) postManifestApply(w http.ResponseWriter, r *http.Request) {
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	plan, ok := a.planManifestFromRequest(w, r)
+	if !ok {
+		return
+	}
+	err := plan.Apply(a.storageFor(r))
+	if changeErr, ok := err.(*manifest.ChangeError); ok {
+		applied := changeErr.Applied
+		if applied == nil {
+			applied = []manifest.Change{}
+		}
+		response, err := json.Marshal(applyErrorResponse{
+			Error:   changeErr.Error(),
+			Applied: applied,
+			Failed:  changeErr.Change,
+		})
+		if err != nil {
+			respondError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		respondJSON(w, string(response), manifestErrorStatus(changeErr))
+		return
+	}
+	if err != nil {
+		respondError(w, err.Error(), manifestErrorStatus(err))
+		return
+	}
+	a.respondPlan(w, plan)
+}
+
+func (a *Admin) planManifestFromRequest(w http.ResponseWriter, r *http.Request) (manifest.Plan, bool) {
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+	defer r.Body.Close()
+	m, err := manifest.Parse(buf.Bytes())
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return manifest.Plan{}, false
+	}
+	namespace, scoped := mux.Vars(r)[namespacePathVariable]
+	if scoped {
+		m, err = m.IntoNamespace(namespace)
+		if err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return manifest.Plan{}, false
+		}
+	}
+	err = ValidateManifest(m)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return manifest.Plan{}, false
+	}
+	var plan manifest.Plan
+	if scoped {
+		plan, err = manifest.NewNamespacePlan(a.Storage, m, namespace)
+	} else {
+		plan, err = manifest.NewPlan(a.Storage, m)
+	}
+	if err != nil {
+		respondError(w, err.Error(), manifestErrorStatus(err))
+		return manifest.Plan{}, false
+	}
+	return plan, true
+}
+
+func (a *Admin) respondPlan(w http.ResponseWriter, plan manifest.Plan) {
+	response, err := json.Marshal(plan)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+// manifestErrorStatus returns the status code for an error from exporting, planning or applying a manifest
+func manifestErrorStatus(err error) int {
+	if changeErr, ok := err.(*manifest.ChangeError); ok {
+		return storageErrorStatus(changeErr.Err)
+	}
+	if err == manifest.ErrAmbiguousFlowName {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// getAudit responds with a page of the audit log, newest first, narrowed by the query parameters
+func (a *Admin) getAudit(w http.ResponseWriter, r *http.Request) {
+	options, err := getAuditOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, next, err := a.Storage.ListAuditEntries(options)
+	if err != nil {
+		respondError(w, err.Error(), listErrorStatus(err))
+		return
+	}
+	response, err := json.Marshal(auditPage{
+		Entries: entries,
+		Next:    next,
+	})
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+// storageFor returns the Storage that records changes made by the request in the audit log, as made by its authenticated caller.
+// Without an Authenticator the caller is whoever the X-Actor header names
+func (a *Admin) storageFor(r *http.Request) *storage.AuditedStorage {
+	if identity, ok := IdentityFromRequest(r); ok {
+		return storage.NewAuditedStorage(a.Storage, identity.Name)
+	}
+	actor := strings.TrimSpace(r.Header.Get(actorHeader))
+	if actor == "" {
+		actor = defaultActor
+	}
+	return storage.NewAuditedStorage(a.Storage, actor)
+}
+
+func (a *Admin) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	statser, ok := a.Storage.(cacheStatser)
+	if !ok {
+		respondError(w, ErrStorageNotCached.Error(), http.StatusNotFound)
+		return
+	}
+	response, err := json.Marshal(statser.Stats())
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
+func (a *Admin) previewTransform(w http.ResponseWriter, r *http.Request) {
+	var preview transformPreviewRequest
+	err := getObjectFromRequestBody(r, &preview)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t, err := transform.Parse(preview.Transform)
+	if err != nil {
+		respondError(w, fmt.Sprintf("%v. %v", ErrFlowInvalidTransform, err), http.StatusBadRequest)
+		return
+	}
+	message := messenger.Message{
+		Payload:  preview.Payload,
+		Metadata: make(map[string][]byte),
+		Origin:   preview.Origin,
+	}
+	var textPayload string
+	if json.Unmarshal(preview.Payload, &textPayload) == nil {
+		message.Payload = []byte(textPayload)
+	}
+	for key, value := range preview.Metadata {
+		message.Metadata[key] = []byte(value)
+	}
+	response, err := json.Marshal(transformPreviewResponse{
+		Payload: string(t.Apply(message)),
+	})
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, string(response), http.StatusOK)
+}
+
 func respondJSON(w http.ResponseWriter, response string, code int) {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -9,8 +9,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"time"
 
 	. "github.com/edfungus/conduction/admin"
+	"github.com/edfungus/conduction/manifest"
 	"github.com/edfungus/conduction/messenger"
 	"github.com/edfungus/conduction/storage"
 
@@ -54,7 +56,100 @@ var _ = Describe("Admin", func() {
 					manager.Router.ServeHTTP(w, req)
 
 					Expect(w.Code).To(Equal(http.StatusCreated))
-					Expect(w.Body.String()).To(ContainSubstring("uuid"))
+					var flow storage.Flow
+					err := json.Unmarshal(w.Body.Bytes(), &flow)
+					Expect(err).To(BeNil())
+					Expect(flow.Name).To(Equal("Test Flow"))
+					Expect(flow.Path.Route).To(Equal("/test"))
+					Expect(w.Header().Get("Location")).To(Equal(fmt.Sprintf("/flows/%s", flow.UUID)))
+
+					req, _ = http.NewRequest("GET", w.Header().Get("Location"), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+				})
+			})
+			Context("When the Flow Path is a pattern", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "sensors/+/temp",
+								"type": "MQTT"
+							}
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowPathIsPattern.Error()))
+				})
+			})
+			Context("When the Flow waits for a pattern", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "lights/kitchen",
+								"type": "MQTT"
+							},
+							"wait": true,
+							"waitFor": {
+								"route": "lights/+/status",
+								"type": "MQTT"
+							}
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowWaitForIsPattern.Error()))
+				})
+			})
+			Context("When the Flow has an invalid condition", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "/test",
+								"type": "REST"
+							},
+							"condition": "payload.temperature >"
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowInvalidCondition.Error()))
+				})
+			})
+			Context("When the Flow has an invalid transform", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "/test",
+								"type": "REST"
+							},
+							"transform": "{{payload.temp"
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowInvalidTransform.Error()))
 				})
 			})
 			Context("When the Flow is missing Path", func() {
@@ -90,6 +185,49 @@ var _ = Describe("Admin", func() {
 					Expect(w.Body.String()).To(ContainSubstring("Flow is missing field: description"))
 				})
 			})
+			Context("When the Flow has waitFor but does not wait", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "turnOnLight",
+								"type": "MQTT"
+							},
+							"waitFor": {
+								"route": "lightOn",
+								"type": "MQTT"
+							}
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowWaitForWithoutWait.Error()))
+				})
+			})
+			Context("When the Flow aggregates but does not wait", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"name": "Test Flow",
+							"description": "Some description",
+							"path": {
+								"route": "/test",
+								"type": "REST"
+							},
+							"aggregate": true
+						}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowAggregateWithoutWait.Error()))
+				})
+			})
 		})
 		Describe("Given retieving a new Flow", func() {
 			Context("When the Flow uuid exist", func() {
@@ -142,6 +280,268 @@ var _ = Describe("Admin", func() {
 				})
 			})
 		})
+		Describe("Given listing Flows and Paths", func() {
+			BeforeEach(func() {
+				for _, route := range []string{"home/kitchen/light", "home/bedroom/light", "office/light"} {
+					_, err := manager.Storage.SaveFlow(storage.Flow{
+						Name:        fmt.Sprintf("Flow of %s", route),
+						Description: "Test description",
+						Path: &messenger.Path{
+							Route: route,
+							Type:  "mqtt",
+						},
+					})
+					Expect(err).To(BeNil())
+				}
+			})
+			Context("When Flows are listed a page at a time", func() {
+				It("Then the pages should hold every matching Flow with its uuid", func() {
+					req, _ := http.NewRequest("GET", "/flows?routePrefix=home/&sort=-route&limit=1", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var page struct {
+						Flows []storage.Flow `json:"flows"`
+						Next  string         `json:"next"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Flows).To(HaveLen(1))
+					Expect(page.Flows[0].Path.Route).To(Equal("home/kitchen/light"))
+					Expect(page.Flows[0].UUID).ToNot(BeZero())
+					Expect(page.Next).ToNot(BeEmpty())
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/flows?routePrefix=home/&sort=-route&limit=1&cursor=%s", page.Next), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					page.Next = ""
+					err = json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Flows).To(HaveLen(1))
+					Expect(page.Flows[0].Path.Route).To(Equal("home/bedroom/light"))
+					Expect(page.Next).To(BeEmpty())
+				})
+			})
+			Context("When Paths are listed", func() {
+				It("Then the Paths should be returned with their uuid", func() {
+					req, _ := http.NewRequest("GET", "/paths?type=mqtt&routePrefix=office/", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var page struct {
+						Paths []struct {
+							UUID  string `json:"uuid"`
+							Route string `json:"route"`
+						} `json:"paths"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Paths).To(HaveLen(1))
+					Expect(page.Paths[0].Route).To(Equal("office/light"))
+					key, err := storage.NewKeyFromString(page.Paths[0].UUID)
+					Expect(err).To(BeNil())
+					path, err := manager.Storage.GetPathByKey(key)
+					Expect(err).To(BeNil())
+					Expect(path.Route).To(Equal("office/light"))
+				})
+			})
+			Context("When the query is invalid", func() {
+				It("Then an error should be thrown", func() {
+					for _, query := range []string{"limit=none", "limit=0", "sort=description", "cursor=bad"} {
+						req, _ := http.NewRequest("GET", "/flows?"+query, nil)
+						w := httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					}
+				})
+			})
+		})
+		Describe("Given changing a Flow", func() {
+			var flowID storage.Key
+			BeforeEach(func() {
+				var err error
+				flowID, err = manager.Storage.SaveFlow(storage.Flow{
+					Name:        "Test flow",
+					Description: "Test descriptoin",
+					Path: &messenger.Path{
+						Route:    "Test route",
+						Type:     "Test type",
+						Identity: "Test identity",
+					},
+					Condition: "payload.on == true",
+					Labels:    map[string]string{"owner": "iot", "env": "prod"},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When the whole Flow is put", func() {
+				It("Then every field should be replaced", func() {
+					body :=
+						`{
+							"name": "Test flow",
+							"description": "Test description",
+							"path": {
+								"route": "New route",
+								"type": "Test type"
+							}
+						}`
+					req, _ := http.NewRequest("PUT", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(body))
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var flowResponse storage.Flow
+					err := json.Unmarshal(w.Body.Bytes(), &flowResponse)
+					Expect(err).To(BeNil())
+					Expect(flowResponse.Description).To(Equal("Test description"))
+					Expect(flowResponse.Path.Route).To(Equal("New route"))
+					Expect(flowResponse.Condition).To(BeEmpty())
+					Expect(w.Header().Get("ETag")).To(Equal(`"2"`))
+				})
+			})
+			Context("When part of the Flow is patched", func() {
+				It("Then only those fields should be replaced", func() {
+					body := `{"description": "Test description"}`
+					req, _ := http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(body))
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					flow, err := manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Name).To(Equal("Test flow"))
+					Expect(flow.Description).To(Equal("Test description"))
+					Expect(flow.Path.Route).To(Equal("Test route"))
+					Expect(flow.Condition).To(Equal("payload.on == true"))
+					Expect(flow.Labels).To(Equal(map[string]string{"owner": "iot", "env": "prod"}))
+				})
+			})
+			Context("When the labels of the Flow are patched", func() {
+				It("Then the labels should be replaced rather than merged", func() {
+					req, _ := http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"labels": {"env": "dev"}}`))
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					flow, err := manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Labels).To(Equal(map[string]string{"env": "dev"}))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"labels": null}`))
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					flow, err = manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Labels).To(BeEmpty())
+				})
+			})
+			Context("When the path of the Flow is patched", func() {
+				It("Then the path should be replaced rather than merged", func() {
+					req, _ := http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"path": {"route": "New route", "type": "Test type"}}`))
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					flow, err := manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Path.Route).To(Equal("New route"))
+					Expect(flow.Path.Identity).To(BeEmpty())
+					Expect(flow.Description).To(Equal("Test descriptoin"))
+					Expect(flow.Labels).To(Equal(map[string]string{"owner": "iot", "env": "prod"}))
+				})
+			})
+			Context("When the change is invalid", func() {
+				It("Then an error will be returned and the Flow left as it was", func() {
+					body := `{"condition": "payload.on =="}`
+					req, _ := http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(body))
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowInvalidCondition.Error()))
+					flow, err := manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Condition).To(Equal("payload.on == true"))
+				})
+			})
+			Context("When the Flow does not exist", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", storage.NewRandomKey()), bytes.NewBufferString(`{}`))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+
+					req, _ = http.NewRequest("DELETE", fmt.Sprintf("/flows/%s", storage.NewRandomKey()), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrFlowCannotBeRetrieved.Error()))
+				})
+			})
+			Context("When the If-Match header is missing or stale", func() {
+				It("Then the Flow should be left as it was", func() {
+					req, _ := http.NewRequest("GET", fmt.Sprintf("/flows/%s", flowID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					etag := w.Header().Get("ETag")
+					Expect(etag).To(Equal(`"1"`))
+
+					body := `{"description": "First change"}`
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionRequired))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(body))
+					req.Header.Set("If-Match", etag)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"description": "Second change"}`))
+					req.Header.Set("If-Match", etag)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionFailed))
+
+					req, _ = http.NewRequest("DELETE", fmt.Sprintf("/flows/%s", flowID), nil)
+					req.Header.Set("If-Match", etag)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionFailed))
+
+					flow, err := manager.Storage.GetFlowByKey(flowID)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("First change"))
+				})
+			})
+			Context("When the Flow is deleted", func() {
+				It("Then the Flow should no longer be found", func() {
+					req, _ := http.NewRequest("DELETE", fmt.Sprintf("/flows/%s", flowID), nil)
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNoContent))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/flows/%s", flowID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+				})
+			})
+		})
 		Describe("Given inserting a new Path", func() {
 			Context("When the Path is complete and correct", func() {
 				It("Then the Path will be inserted", func() {
@@ -155,7 +555,12 @@ var _ = Describe("Admin", func() {
 					manager.Router.ServeHTTP(w, req)
 
 					Expect(w.Code).To(Equal(http.StatusCreated))
-					Expect(w.Body.String()).To(ContainSubstring("uuid"))
+					var path storage.StoredPath
+					err := json.Unmarshal(w.Body.Bytes(), &path)
+					Expect(err).To(BeNil())
+					Expect(path.Route).To(Equal("/test"))
+					Expect(path.Triggers).To(BeEmpty())
+					Expect(w.Header().Get("Location")).To(Equal(fmt.Sprintf("/paths/%s", path.UUID)))
 				})
 			})
 			Context("When the Path is missing route", func() {
@@ -172,6 +577,35 @@ var _ = Describe("Admin", func() {
 					Expect(w.Body.String()).To(ContainSubstring("error"))
 				})
 			})
+			Context("When the Path is a pattern with # before the last segment", func() {
+				It("Then an error will be returned", func() {
+					body :=
+						`{
+							"route": "home/#/light",
+							"type": "MQTT"
+						}`
+					req, _ := http.NewRequest("POST", "/paths", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrMultiLevelWildcardNotLast.Error()))
+				})
+			})
+			Context("When the Path is a pattern", func() {
+				It("Then the Path will be inserted", func() {
+					body :=
+						`{
+							"route": "sensors/+/temp",
+							"type": "MQTT"
+						}`
+					req, _ := http.NewRequest("POST", "/paths", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusCreated))
+				})
+			})
 		})
 		Describe("Given retieving a Path", func() {
 			Context("When the Path uuid exist", func() {
@@ -218,33 +652,81 @@ var _ = Describe("Admin", func() {
 				})
 			})
 		})
-		Describe("Given retrieving next Flows from Path", func() {
-			Context("When the Path uuid exists and there are no Flows", func() {
-				It("Then Flows should be return with an empty array", func() {
-					path := messenger.Path{
-						Route: "Test route",
+		Describe("Given deleting a Path", func() {
+			Context("When no Flow sends to the Path", func() {
+				It("Then the Path should no longer be found", func() {
+					pathID, err := manager.Storage.SavePath(messenger.Path{
+						Route: "Obsolete route",
 						Type:  "Test type",
-					}
-					pathID, err := manager.Storage.SavePath(path)
+					})
 					Expect(err).To(BeNil())
 
-					req, _ := http.NewRequest("GET", fmt.Sprintf("/paths/%s/flows", pathID), nil)
+					req, _ := http.NewRequest("DELETE", fmt.Sprintf("/paths/%s", pathID), nil)
+					req.Header.Set("If-Match", `"1"`)
 					w := httptest.NewRecorder()
 					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNoContent))
 
-					Expect(w.Code).To(Equal(http.StatusOK))
-					expectedEmptyFlows := storage.Flows{Flows: []storage.Flow{}}
-					expectEmptyFlowsBytes, err := json.Marshal(expectedEmptyFlows)
-					Expect(err).To(BeNil())
-					Expect(w.Body.Bytes()).To(Equal(expectEmptyFlowsBytes))
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/paths/%s", pathID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
 				})
 			})
-			Context("When the Path uuid exists and there are next Flows", func() {
-				It("Then Flows should be return with the next Flows in an array", func() {
-					path := messenger.Path{
-						Route: "Test route",
-						Type:  "Test type",
-					}
+			Context("When a Flow sends to the Path", func() {
+				It("Then a conflict should be returned", func() {
+					_, err := manager.Storage.SaveFlow(storage.Flow{
+						Name:        "Test flow",
+						Description: "Test description",
+						Path: &messenger.Path{
+							Route: "Used route",
+							Type:  "Test type",
+						},
+					})
+					Expect(err).To(BeNil())
+					pathID, err := manager.Storage.GetKeyOfPath(messenger.Path{
+						Route: "Used route",
+						Type:  "Test type",
+					})
+					Expect(err).To(BeNil())
+
+					req, _ := http.NewRequest("DELETE", fmt.Sprintf("/paths/%s", pathID), nil)
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusConflict))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrPathInUse.Error()))
+				})
+			})
+		})
+		Describe("Given retrieving next Flows from Path", func() {
+			Context("When the Path uuid exists and there are no Flows", func() {
+				It("Then Flows should be return with an empty array", func() {
+					path := messenger.Path{
+						Route: "Test route",
+						Type:  "Test type",
+					}
+					pathID, err := manager.Storage.SavePath(path)
+					Expect(err).To(BeNil())
+
+					req, _ := http.NewRequest("GET", fmt.Sprintf("/paths/%s/flows", pathID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					expectedEmptyFlows := storage.Flows{Flows: []storage.Flow{}}
+					expectEmptyFlowsBytes, err := json.Marshal(expectedEmptyFlows)
+					Expect(err).To(BeNil())
+					Expect(w.Body.Bytes()).To(Equal(expectEmptyFlowsBytes))
+				})
+			})
+			Context("When the Path uuid exists and there are next Flows", func() {
+				It("Then Flows should be return with the next Flows in an array", func() {
+					path := messenger.Path{
+						Route: "Test route",
+						Type:  "Test type",
+					}
 					pathID, err := manager.Storage.SavePath(path)
 					Expect(err).To(BeNil())
 
@@ -298,5 +780,926 @@ var _ = Describe("Admin", func() {
 				})
 			})
 		})
+		Describe("Given linking a Flow to a Path", func() {
+			var (
+				pathID storage.Key
+				flowID storage.Key
+			)
+			BeforeEach(func() {
+				var err error
+				pathID, err = manager.Storage.SavePath(messenger.Path{
+					Route: "Link route",
+					Type:  "Test type",
+				})
+				Expect(err).To(BeNil())
+				flowID, err = manager.Storage.SaveFlow(storage.Flow{
+					Name:        "Test flow",
+					Description: "Test description",
+					Path: &messenger.Path{
+						Route: "Linked route",
+						Type:  "Test type",
+					},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When the Path and Flow exist", func() {
+				It("Then the Path should trigger the Flow until it is unlinked", func() {
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/paths/%s/flows/%s", pathID, flowID), nil)
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusCreated))
+					Expect(w.Header().Get("ETag")).To(Equal(`"2"`))
+					var flowsResponse storage.Flows
+					err := json.Unmarshal(w.Body.Bytes(), &flowsResponse)
+					Expect(err).To(BeNil())
+					Expect(flowsResponse.Flows).To(HaveLen(1))
+					Expect(flowsResponse.Flows[0].UUID).To(Equal(flowID.UUID))
+
+					req, _ = http.NewRequest("POST", fmt.Sprintf("/paths/%s/flows/%s", pathID, flowID), nil)
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrChainAlreadyExists.Error()))
+
+					req, _ = http.NewRequest("DELETE", fmt.Sprintf("/paths/%s/flows/%s", pathID, flowID), nil)
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("ETag")).To(Equal(`"3"`))
+					err = json.Unmarshal(w.Body.Bytes(), &flowsResponse)
+					Expect(err).To(BeNil())
+					Expect(flowsResponse.Flows).To(HaveLen(0))
+
+					req, _ = http.NewRequest("DELETE", fmt.Sprintf("/paths/%s/flows/%s", pathID, flowID), nil)
+					req.Header.Set("If-Match", `"3"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrChainNotFound.Error()))
+				})
+			})
+			Context("When the link would create a loop", func() {
+				It("Then a conflict should be returned", func() {
+					loopPathID, err := manager.Storage.SavePath(messenger.Path{
+						Route: "Linked route",
+						Type:  "Test type",
+					})
+					Expect(err).To(BeNil())
+
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/paths/%s/flows/%s", loopPathID, flowID), nil)
+					req.Header.Set("If-Match", "*")
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusConflict))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrChainCreatesCycle.Error()))
+				})
+			})
+			Context("When the Flow does not exist", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/paths/%s/flows/%s", pathID, storage.NewRandomKey()), nil)
+					req.Header.Set("If-Match", "*")
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrFlowCannotBeRetrieved.Error()))
+				})
+			})
+			Context("When the uuid is invalid", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("DELETE", fmt.Sprintf("/paths/%s/flows/not-a-uuid", pathID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+		})
+		Describe("Given traversing from a Path", func() {
+			var (
+				inID  storage.Key
+				outID storage.Key
+			)
+			BeforeEach(func() {
+				var err error
+				inID, err = manager.Storage.SavePath(messenger.Path{Route: "/in", Type: "rest"})
+				Expect(err).To(BeNil())
+				flowID, err := manager.Storage.SaveFlow(storage.Flow{
+					Name:        "Test flow",
+					Description: "Test description",
+					Path:        &messenger.Path{Route: "/out", Type: "rest"},
+				})
+				Expect(err).To(BeNil())
+				outID, err = manager.Storage.GetKeyOfPath(messenger.Path{Route: "/out", Type: "rest"})
+				Expect(err).To(BeNil())
+				err = manager.Storage.ChainNextFlowToPath(flowID, inID)
+				Expect(err).To(BeNil())
+			})
+			Context("When looking downstream and upstream", func() {
+				It("Then the Flows and Paths on either side should be returned", func() {
+					var traversal storage.Traversal
+					req, _ := http.NewRequest("GET", fmt.Sprintf("/paths/%s/downstream?depth=5", inID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					err := json.Unmarshal(w.Body.Bytes(), &traversal)
+					Expect(err).To(BeNil())
+					Expect(traversal.Flows).To(HaveLen(1))
+					Expect(traversal.Flows[0].Name).To(Equal("Test flow"))
+					Expect(traversal.Paths).To(HaveLen(1))
+					Expect(traversal.Paths[0].UUID).To(Equal(outID.UUID))
+					Expect(traversal.Paths[0].Route).To(Equal("/out"))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/paths/%s/upstream", outID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					traversal = storage.Traversal{}
+					err = json.Unmarshal(w.Body.Bytes(), &traversal)
+					Expect(err).To(BeNil())
+					Expect(traversal.Paths).To(HaveLen(1))
+					Expect(traversal.Paths[0].UUID).To(Equal(inID.UUID))
+				})
+			})
+			Context("When the graph is exported", func() {
+				It("Then it should be rendered in the requested format", func() {
+					req, _ := http.NewRequest("GET", "/graph", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+					var graph storage.Graph
+					err := json.Unmarshal(w.Body.Bytes(), &graph)
+					Expect(err).To(BeNil())
+					Expect(graph.Nodes).To(HaveLen(3))
+					Expect(graph.Edges).To(HaveLen(2))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/graph?format=dot&path=%s&depth=1", outID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("Content-Type")).To(Equal("text/vnd.graphviz"))
+					Expect(w.Body.String()).To(MatchRegexp(`"%s" -> "[^"]+" \[label="triggers"\];`, inID))
+
+					req, _ = http.NewRequest("GET", "/graph?format=mermaid", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).To(HavePrefix("flowchart LR"))
+				})
+			})
+			Context("When the graph export is invalid", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("GET", "/graph?format=svg", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/graph?path=%s", storage.NewRandomKey()), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+				})
+			})
+			Context("When the Path does not exist or the depth is invalid", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("GET", fmt.Sprintf("/paths/%s/downstream", storage.NewRandomKey()), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/paths/%s/upstream?depth=deep", inID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+		})
+		Describe("Given a routing manifest", func() {
+			body := `
+flows:
+  - name: Kitchen light
+    description: Turns on the kitchen light
+    path: {route: lights/kitchen, type: MQTT}
+    triggeredBy:
+      - {route: sensors/kitchen/motion, type: MQTT}
+`
+			Context("When it is planned and then applied", func() {
+				It("Then only applying should change storage and exporting should return it", func() {
+					req, _ := http.NewRequest("POST", "/manifest/plan", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var plan manifest.Plan
+					err := json.Unmarshal(w.Body.Bytes(), &plan)
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(HaveLen(4))
+					flows, _, _, err := graph.ListFlows(storage.ListOptions{})
+					Expect(err).To(BeNil())
+					Expect(flows).To(BeEmpty())
+
+					req, _ = http.NewRequest("POST", "/manifest/apply", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					flows, _, _, err = graph.ListFlows(storage.ListOptions{})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+
+					req, _ = http.NewRequest("GET", "/manifest?format=json", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+					var exported manifest.Manifest
+					err = json.Unmarshal(w.Body.Bytes(), &exported)
+					Expect(err).To(BeNil())
+					Expect(exported.Flows).To(HaveLen(1))
+					Expect(exported.Flows[0].TriggeredBy).To(Equal([]manifest.Path{{Route: "sensors/kitchen/motion", Type: "MQTT"}}))
+
+					req, _ = http.NewRequest("GET", "/manifest", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("Content-Type")).To(Equal("application/yaml"))
+					Expect(w.Body.String()).To(ContainSubstring("name: Kitchen light"))
+				})
+			})
+			Context("When it is invalid or cannot be applied", func() {
+				It("Then an error should be thrown", func() {
+					req, _ := http.NewRequest("POST", "/manifest/plan", bytes.NewBufferString(`flows: [{name: a, description: d}]`))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					badLabels := `paths: [{route: spare, type: MQTT, labels: {owner: "i ot"}}]`
+					req, _ = http.NewRequest("POST", "/manifest/plan", bytes.NewBufferString(badLabels))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					looping := `flows: [{name: a, description: d, path: {route: loop, type: MQTT}, triggeredBy: [{route: loop, type: MQTT}]}]`
+					req, _ = http.NewRequest("POST", "/manifest/apply", bytes.NewBufferString(looping))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+					var applyErr struct {
+						Error   string            `json:"error"`
+						Applied []manifest.Change `json:"applied"`
+						Failed  manifest.Change   `json:"failed"`
+					}
+					Expect(json.Unmarshal(w.Body.Bytes(), &applyErr)).To(BeNil())
+					Expect(applyErr.Error).ToNot(BeEmpty())
+					Expect(applyErr.Applied).ToNot(BeEmpty())
+					Expect(applyErr.Failed.Kind).To(Equal(manifest.KindLink))
+
+					req, _ = http.NewRequest("GET", "/manifest?format=toml", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+		})
+		Describe("Given previewing a transform", func() {
+			Context("When the transform and sample message are valid", func() {
+				It("Then the transformed payload should be returned", func() {
+					body :=
+						`{
+							"transform": "{\"celsius\": \"{{payload.temp}}\", \"room\": \"{{metadata.room}}\", \"from\": \"{{origin.route}}\"}",
+							"payload": {"temp": 21.5},
+							"metadata": {"room": "kitchen"},
+							"origin": {"route": "sensors/kitchen/temp", "type": "MQTT"}
+						}`
+					req, _ := http.NewRequest("POST", "/transforms/preview", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var response struct {
+						Payload string `json:"payload"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &response)
+					Expect(err).To(BeNil())
+					Expect(response.Payload).To(MatchJSON(`{"celsius": 21.5, "room": "kitchen", "from": "sensors/kitchen/temp"}`))
+				})
+			})
+			Context("When the sample payload is a string", func() {
+				It("Then it should be used as text", func() {
+					body := `{"transform": "state={{payload}}", "payload": "ON"}`
+					req, _ := http.NewRequest("POST", "/transforms/preview", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).To(MatchJSON(`{"payload": "state=ON"}`))
+				})
+			})
+			Context("When the transform is invalid", func() {
+				It("Then an error should be returned", func() {
+					body := `{"transform": "{{payload.temp", "payload": {}}`
+					req, _ := http.NewRequest("POST", "/transforms/preview", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowInvalidTransform.Error()))
+				})
+			})
+		})
+		Describe("Given changes recorded in the audit log", func() {
+			var flowID string
+			BeforeEach(func() {
+				body := `{"name": "Test flow", "description": "First description", "path": {"route": "/audit", "type": "REST"}}`
+				req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+				req.Header.Set("X-Actor", "alice")
+				w := httptest.NewRecorder()
+				manager.Router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusCreated))
+				var flow storage.Flow
+				err := json.Unmarshal(w.Body.Bytes(), &flow)
+				Expect(err).To(BeNil())
+				flowID = flow.UUID.String()
+
+				req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"description": "Second description"}`))
+				req.Header.Set("If-Match", `"1"`)
+				w = httptest.NewRecorder()
+				manager.Router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+			})
+			Context("When the audit log is retrieved", func() {
+				It("Then the changes should be returned newest first with who made them", func() {
+					req, _ := http.NewRequest("GET", "/audit", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var page struct {
+						Entries []storage.AuditEntry `json:"entries"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Entries).To(HaveLen(3))
+					Expect(page.Entries[0].Action).To(Equal(storage.AuditActionUpdate))
+					Expect(page.Entries[0].Actor).To(Equal("anonymous"))
+					Expect(page.Entries[1].Action).To(Equal(storage.AuditActionCreate))
+					Expect(page.Entries[1].Actor).To(Equal("alice"))
+					Expect(page.Entries[1].Target.String()).To(Equal(flowID))
+					Expect(page.Entries[2].Action).To(Equal(storage.AuditActionCreate))
+					Expect(page.Entries[2].Kind).To(Equal(storage.AuditKindPath))
+					Expect(page.Entries[2].Actor).To(Equal("alice"))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/audit?actor=alice&target=%s&since=%s", flowID, time.Now().Add(-time.Hour).Format(time.RFC3339)), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					err = json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Entries).To(HaveLen(1))
+				})
+			})
+			Context("When the audit log filters are invalid", func() {
+				It("Then an error should be returned", func() {
+					for _, query := range []string{"since=yesterday", "target=not-a-uuid", "limit=0", "cursor=bad"} {
+						req, _ := http.NewRequest("GET", fmt.Sprintf("/audit?%s", query), nil)
+						w := httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					}
+				})
+			})
+			Context("When the Flow is rolled back to its first version", func() {
+				It("Then the Flow should be as it was with a new ETag", func() {
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/flows/%s/rollback?version=1", flowID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionRequired))
+
+					req, _ = http.NewRequest("POST", fmt.Sprintf("/flows/%s/rollback?version=1", flowID), nil)
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("ETag")).To(Equal(`"3"`))
+					var flow storage.Flow
+					err := json.Unmarshal(w.Body.Bytes(), &flow)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("First description"))
+				})
+			})
+			Context("When the version to roll back to is invalid or unknown", func() {
+				It("Then an error should be returned", func() {
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/flows/%s/rollback?version=zero", flowID), nil)
+					req.Header.Set("If-Match", `"2"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					req, _ = http.NewRequest("POST", fmt.Sprintf("/flows/%s/rollback?version=7", flowID), nil)
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+					Expect(w.Body.String()).To(ContainSubstring(storage.ErrVersionNotInAudit.Error()))
+				})
+			})
+			Context("When a Flow is created, updated or rolled back to the name of another Flow in its namespace", func() {
+				It("Then the change should be refused", func() {
+					body := `{"name": "Test flow", "description": "Copy", "path": {"route": "/copy", "type": "REST"}}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+					Expect(w.Body.String()).To(ContainSubstring(ErrFlowNameTaken.Error()))
+
+					req, _ = http.NewRequest("POST", "/namespaces/team-a/flows", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"name": "Renamed flow"}`))
+					req.Header.Set("If-Match", `"2"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					body = `{"name": "Test flow", "description": "Copy", "path": {"route": "/copy", "type": "REST"}}`
+					req, _ = http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+
+					req, _ = http.NewRequest("POST", fmt.Sprintf("/flows/%s/rollback?version=1", flowID), nil)
+					req.Header.Set("If-Match", `"3"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"name": "Test flow"}`))
+					req.Header.Set("If-Match", `"3"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+
+					req, _ = http.NewRequest("PATCH", fmt.Sprintf("/flows/%s", flowID), bytes.NewBufferString(`{"description": "Third description"}`))
+					req.Header.Set("If-Match", `"3"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+		Describe("Given the admin API requires authentication", func() {
+			BeforeEach(func() {
+				authenticator, err := NewAPIKeyAuthenticator([]APIKey{
+					{Key: "viewer-key", Identity: Identity{Name: "dashboard", Role: RoleViewer}},
+					{Key: "editor-key", Identity: Identity{Name: "alice", Role: RoleEditor}},
+				})
+				Expect(err).To(BeNil())
+				manager.Authenticator = authenticator
+			})
+			Context("When a request has no credentials", func() {
+				It("Then it should be rejected", func() {
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					Expect(w.Header().Get("WWW-Authenticate")).ToNot(BeEmpty())
+				})
+			})
+			Context("When the role of the caller is too low", func() {
+				It("Then reads should be allowed and changes forbidden", func() {
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					req.Header.Set("X-API-Key", "viewer-key")
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					body := `{"name": "Test flow", "description": "Some description", "path": {"route": "/auth", "type": "REST"}}`
+					req, _ = http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					req.Header.Set("X-API-Key", "viewer-key")
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusForbidden))
+
+					req, _ = http.NewRequest("GET", "/audit", nil)
+					req.Header.Set("X-API-Key", "editor-key")
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusForbidden))
+				})
+			})
+			Context("When an editor makes a change", func() {
+				It("Then the change should be recorded as made by the caller rather than X-Actor", func() {
+					body := `{"name": "Test flow", "description": "Some description", "path": {"route": "/auth", "type": "REST"}}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					req.Header.Set("X-API-Key", "editor-key")
+					req.Header.Set("X-Actor", "mallory")
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+
+					entries, _, err := graph.ListAuditEntries(storage.AuditOptions{})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(2))
+					for _, entry := range entries {
+						Expect(entry.Actor).To(Equal("alice"))
+					}
+				})
+			})
+		})
+		Describe("Given Flows scoped to a namespace", func() {
+			var (
+				flow     storage.Flow
+				location string
+			)
+			BeforeEach(func() {
+				body := `{"name": "Team flow", "description": "Some description", "path": {"route": "/team", "type": "REST"}}`
+				req, _ := http.NewRequest("POST", "/namespaces/team-a/flows", bytes.NewBufferString(body))
+				w := httptest.NewRecorder()
+				manager.Router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusCreated))
+				err := json.Unmarshal(w.Body.Bytes(), &flow)
+				Expect(err).To(BeNil())
+				location = w.Header().Get("Location")
+			})
+			Context("When the Flow is created under the namespace", func() {
+				It("Then the Flow and its Path should be in the namespace and located under it", func() {
+					Expect(flow.Namespace).To(Equal("team-a"))
+					Expect(flow.Path.Namespace).To(Equal("team-a"))
+					Expect(location).To(Equal(fmt.Sprintf("/namespaces/team-a/flows/%s", flow.UUID)))
+				})
+			})
+			Context("When a Path is created under the namespace", func() {
+				It("Then it should be located under the namespace", func() {
+					body := `{"route": "/team-path", "type": "REST"}`
+					req, _ := http.NewRequest("POST", "/namespaces/team-a/paths", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+					var path storage.StoredPath
+					Expect(json.Unmarshal(w.Body.Bytes(), &path)).To(BeNil())
+					Expect(w.Header().Get("Location")).To(Equal(fmt.Sprintf("/namespaces/team-a/paths/%s", path.UUID)))
+
+					req, _ = http.NewRequest("GET", w.Header().Get("Location"), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+				})
+			})
+			Context("When the Flow is retrieved under another namespace", func() {
+				It("Then it should not be found", func() {
+					req, _ := http.NewRequest("GET", fmt.Sprintf("/namespaces/team-b/flows/%s", flow.UUID), nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/namespaces/team-a/flows/%s", flow.UUID), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+				})
+			})
+			Context("When Flows are listed under a namespace", func() {
+				It("Then only Flows in the namespace should be returned", func() {
+					req, _ := http.NewRequest("GET", "/namespaces/team-b/flows", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).ToNot(ContainSubstring("Team flow"))
+
+					req, _ = http.NewRequest("GET", "/flows?namespace=team-a", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).To(ContainSubstring("Team flow"))
+				})
+			})
+			Context("When the body names another namespace", func() {
+				It("Then the Flow should be rejected", func() {
+					body := `{"name": "Other flow", "description": "Some description", "namespace": "team-b", "path": {"route": "/team", "type": "REST"}}`
+					req, _ := http.NewRequest("POST", "/namespaces/team-a/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+			Context("When a Path in another namespace is linked to the Flow", func() {
+				It("Then the link should be refused because the Flow is not shared", func() {
+					pathKey, err := graph.SavePath(messenger.Path{Route: "/team", Type: "REST", Namespace: "team-b"})
+					Expect(err).To(BeNil())
+					req, _ := http.NewRequest("POST", fmt.Sprintf("/paths/%s/flows/%s", pathKey, flow.UUID), nil)
+					req.Header.Set("If-Match", `"1"`)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+				})
+			})
+			Context("When the Flows and traversals of a Path are read under a namespace", func() {
+				It("Then Flows from other namespaces should be left out", func() {
+					pathKey, err := graph.GetKeyOfPath(*flow.Path)
+					Expect(err).To(BeNil())
+					sharedKey, err := graph.SaveFlow(storage.Flow{
+						Name:        "Shared flow",
+						Description: "Some description",
+						Namespace:   "team-b",
+						SharedWith:  []string{"team-a"},
+						Path:        &messenger.Path{Route: "/shared", Type: "REST", Namespace: "team-b"},
+					})
+					Expect(err).To(BeNil())
+					Expect(graph.ChainNextFlowToPath(sharedKey, pathKey)).To(BeNil())
+
+					for _, url := range []string{"/paths/%s/flows", "/paths/%s/downstream"} {
+						req, _ := http.NewRequest("GET", fmt.Sprintf(url, pathKey), nil)
+						w := httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Body.String()).To(ContainSubstring("Shared flow"))
+
+						req, _ = http.NewRequest("GET", "/namespaces/team-a"+fmt.Sprintf(url, pathKey), nil)
+						w = httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Body.String()).ToNot(ContainSubstring("Shared flow"))
+						Expect(w.Body.String()).ToNot(ContainSubstring("/shared"))
+					}
+				})
+			})
+			Context("When the graph, manifest and audit log are exported under a namespace", func() {
+				It("Then only the namespace should be exported", func() {
+					body := `{"name": "Other flow", "description": "Some description", "path": {"route": "/other", "type": "REST"}}`
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+
+					for _, url := range []string{"/namespaces/team-a/graph", "/namespaces/team-a/manifest?format=json", "/namespaces/team-a/audit"} {
+						req, _ = http.NewRequest("GET", url, nil)
+						w = httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Body.String()).To(ContainSubstring("Team flow"))
+						Expect(w.Body.String()).ToNot(ContainSubstring("Other flow"))
+					}
+
+					req, _ = http.NewRequest("GET", "/namespaces/team-b/audit", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).ToNot(ContainSubstring("Team flow"))
+
+					otherPath, err := graph.GetKeyOfPath(messenger.Path{Route: "/other", Type: "REST"})
+					Expect(err).To(BeNil())
+					req, _ = http.NewRequest("GET", fmt.Sprintf("/namespaces/team-a/graph?path=%s", otherPath), nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+				})
+			})
+			Context("When a manifest is applied under a namespace", func() {
+				It("Then only the namespace should be changed", func() {
+					_, err := graph.SaveFlow(storage.Flow{
+						Name:        "Other flow",
+						Description: "Some description",
+						Namespace:   "team-b",
+						Path:        &messenger.Path{Route: "/other", Type: "REST", Namespace: "team-b"},
+					})
+					Expect(err).To(BeNil())
+					body := `flows: [{name: New flow, description: d, path: {route: /new, type: REST}}]`
+
+					req, _ := http.NewRequest("POST", "/namespaces/team-a/manifest/plan", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).To(ContainSubstring("Team flow"))
+					Expect(w.Body.String()).ToNot(ContainSubstring("Other flow"))
+
+					req, _ = http.NewRequest("POST", "/namespaces/team-a/manifest/apply", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					flows, _, _, err := graph.ListFlows(storage.ListOptions{Namespace: "team-a"})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+					Expect(flows[0].Name).To(Equal("New flow"))
+					flows, _, _, err = graph.ListFlows(storage.ListOptions{Namespace: "team-b"})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+					_, err = graph.GetKeyOfPath(messenger.Path{Route: "/other", Type: "REST", Namespace: "team-b"})
+					Expect(err).To(BeNil())
+				})
+			})
+			Context("When a manifest applied under a namespace names another namespace", func() {
+				It("Then it should be rejected", func() {
+					body := `flows: [{name: New flow, description: d, namespace: team-b, path: {route: /new, type: REST}}]`
+					req, _ := http.NewRequest("POST", "/namespaces/team-a/manifest/apply", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					body = `flows: [{name: New flow, description: d, path: {route: /new, type: REST}, triggeredBy: [{route: /in, type: REST, namespace: team-b}]}]`
+					req, _ = http.NewRequest("POST", "/namespaces/team-a/manifest/apply", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+			Context("When the manifest is exported under a namespace while another has Flows with the same name", func() {
+				It("Then the namespace should still be exported", func() {
+					for i := 0; i < 2; i++ {
+						_, err := graph.SaveFlow(storage.Flow{
+							Name:        "Same name",
+							Description: "Some description",
+							Namespace:   "team-b",
+							Path:        &messenger.Path{Route: fmt.Sprintf("/same/%d", i), Type: "REST", Namespace: "team-b"},
+						})
+						Expect(err).To(BeNil())
+					}
+
+					req, _ := http.NewRequest("GET", "/namespaces/team-a/manifest", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Body.String()).To(ContainSubstring("Team flow"))
+
+					req, _ = http.NewRequest("GET", "/namespaces/team-b/manifest", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusConflict))
+				})
+			})
+		})
+		Describe("Given Flows with labels", func() {
+			BeforeEach(func() {
+				for _, body := range []string{
+					`{"name": "Kitchen flow", "description": "Some description", "labels": {"owner": "iot", "env": "prod"}, "path": {"route": "/kitchen", "type": "REST"}}`,
+					`{"name": "Porch flow", "description": "Some description", "labels": {"owner": "iot", "env": "dev"}, "path": {"route": "/porch", "type": "REST"}}`,
+					`{"name": "Billing flow", "description": "Some description", "labels": {"owner": "billing"}, "path": {"route": "/billing", "type": "REST"}}`,
+				} {
+					req, _ := http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusCreated))
+				}
+			})
+			Context("When Flows are listed with a selector", func() {
+				It("Then only the Flows it picks should be returned", func() {
+					req, _ := http.NewRequest("GET", "/flows?selector=owner%3Diot,env!%3Dprod", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var page struct {
+						Flows []storage.Flow `json:"flows"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &page)
+					Expect(err).To(BeNil())
+					Expect(page.Flows).To(HaveLen(1))
+					Expect(page.Flows[0].Name).To(Equal("Porch flow"))
+				})
+			})
+			Context("When the selector or labels are not valid", func() {
+				It("Then the request should be rejected", func() {
+					req, _ := http.NewRequest("GET", "/flows?selector=owner%3D%3D%3D", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+					body := `{"name": "Bad flow", "description": "Some description", "labels": {"owner": "i ot"}, "path": {"route": "/bad", "type": "REST"}}`
+					req, _ = http.NewRequest("POST", "/flows", bytes.NewBufferString(body))
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+			Context("When the Flows picked by a selector are disabled and enabled", func() {
+				It("Then only those Flows should change and be returned", func() {
+					req, _ := http.NewRequest("POST", "/flows/disable?selector=owner%3Diot", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var changed struct {
+						Flows []storage.Flow      `json:"flows"`
+						ETags map[string]string `json:"etags"`
+					}
+					err := json.Unmarshal(w.Body.Bytes(), &changed)
+					Expect(err).To(BeNil())
+					Expect(changed.Flows).To(HaveLen(2))
+					Expect(changed.ETags).To(HaveLen(2))
+					for _, flow := range changed.Flows {
+						Expect(flow.Disabled).To(BeTrue())
+						req, _ = http.NewRequest("GET", fmt.Sprintf("/flows/%s", flow.UUID), nil)
+						w = httptest.NewRecorder()
+						manager.Router.ServeHTTP(w, req)
+						Expect(w.Header().Get("ETag")).To(Equal(changed.ETags[flow.UUID.String()]))
+					}
+
+					flows, _, _, err := graph.ListFlows(storage.ListOptions{})
+					Expect(err).To(BeNil())
+					for _, flow := range flows {
+						Expect(flow.Disabled).To(Equal(flow.Labels["owner"] == "iot"))
+					}
+
+					req, _ = http.NewRequest("POST", "/flows/enable?selector=env%3Ddev", nil)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					err = json.Unmarshal(w.Body.Bytes(), &changed)
+					Expect(err).To(BeNil())
+					Expect(changed.Flows).To(HaveLen(1))
+					Expect(changed.Flows[0].Name).To(Equal("Porch flow"))
+				})
+			})
+			Context("When an existing Path is posted with other labels", func() {
+				It("Then its labels should be replaced once If-Match matches and the change recorded", func() {
+					body := `{"route": "/kitchen", "type": "REST", "labels": {"owner": "lights"}}`
+					req, _ := http.NewRequest("POST", "/paths", bytes.NewBufferString(body))
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionRequired))
+
+					req, _ = http.NewRequest("POST", "/paths", bytes.NewBufferString(body))
+					req.Header.Set("If-Match", `"7"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusPreconditionFailed))
+
+					req, _ = http.NewRequest("POST", "/paths", bytes.NewBufferString(body))
+					req.Header.Set("If-Match", `"1"`)
+					w = httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(w.Header().Get("ETag")).To(Equal(`"2"`))
+					var path storage.StoredPath
+					err := json.Unmarshal(w.Body.Bytes(), &path)
+					Expect(err).To(BeNil())
+					Expect(path.Labels).To(Equal(map[string]string{"owner": "lights"}))
+
+					entries, _, err := graph.ListAuditEntries(storage.AuditOptions{Kind: storage.AuditKindPath, Target: path.UUID})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(2))
+					Expect(entries[0].Action).To(Equal(storage.AuditActionUpdate))
+					Expect(entries[1].Action).To(Equal(storage.AuditActionCreate))
+				})
+			})
+			Context("When Flows are disabled without a selector", func() {
+				It("Then the request should be rejected", func() {
+					req, _ := http.NewRequest("POST", "/flows/disable", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+					Expect(w.Code).To(Equal(http.StatusBadRequest))
+				})
+			})
+		})
+		Describe("Given retrieving cache stats", func() {
+			Context("When the Storage is not cached", func() {
+				It("Then an error should be returned", func() {
+					req, _ := http.NewRequest("GET", "/cache/stats", nil)
+					w := httptest.NewRecorder()
+					manager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusNotFound))
+					Expect(w.Body.String()).To(ContainSubstring(ErrStorageNotCached.Error()))
+				})
+			})
+			Context("When the Storage is cached and a Path has been looked up", func() {
+				It("Then the hits and misses should be returned", func() {
+					cachedManager := NewAdmin(storage.NewCachedStorage(graph, time.Minute))
+					path := messenger.Path{
+						Route: "/cached",
+						Type:  "REST",
+					}
+					_, err := cachedManager.Storage.SavePath(path)
+					Expect(err).To(BeNil())
+					cachedManager.Storage.GetKeyOfPath(path)
+					cachedManager.Storage.GetKeyOfPath(path)
+
+					req, _ := http.NewRequest("GET", "/cache/stats", nil)
+					w := httptest.NewRecorder()
+					cachedManager.Router.ServeHTTP(w, req)
+
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var stats storage.CacheStats
+					err = json.Unmarshal(w.Body.Bytes(), &stats)
+					Expect(err).To(BeNil())
+					Expect(stats).To(Equal(storage.CacheStats{Hits: 1, Misses: 1}))
+				})
+			})
+		})
 	})
 })
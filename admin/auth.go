@@ -0,0 +1,277 @@
+package admin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Role is what a caller of the admin API may do. Each role may do everything the roles before it may
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // Read Flows, Paths, the graph and manifests
+	RoleEditor Role = "editor" // Also change Flows, Paths and the links between them
+	RoleAdmin  Role = "admin"  // Also apply whole manifests and read the audit log
+
+	apiKeyHeader = "X-API-Key"
+	bearerPrefix = "Bearer "
+)
+
+var (
+	ErrNoCredentials        error = fmt.Errorf("Request has no credentials. Send an API key in the X-API-Key header or a token in the Authorization header")
+	ErrInvalidAPIKey        error = fmt.Errorf("API key is not valid")
+	ErrInvalidToken         error = fmt.Errorf("Token is not valid")
+	ErrTokenExpired         error = fmt.Errorf("Token has expired or is not valid yet")
+	ErrInvalidRole          error = fmt.Errorf("Role must be viewer, editor or admin")
+	ErrMissingIdentity      error = fmt.Errorf("Identity must have a name")
+	ErrRoleNotAllowed       error = fmt.Errorf("Role of the caller is not allowed to do this")
+	ErrDuplicateAPIKey      error = fmt.Errorf("API key is given more than once")
+	ErrMissingJWTKey        error = fmt.Errorf("Key for verifying tokens cannot be empty")
+	ErrUnsupportedAlgorithm error = fmt.Errorf("Token must be signed with HS256")
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// Identity is who made a request to the admin API and what they may do
+type Identity struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// Authenticator finds who made a request. It returns ErrNoCredentials when the request has none of the credentials it checks, so another Authenticator can be tried
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Authenticators tries each Authenticator in order and returns the Identity from the first that finds credentials in the request
+type Authenticators []Authenticator
+
+// APIKey is a static key and the Identity of whoever sends it
+type APIKey struct {
+	Key string `json:"key"`
+	Identity
+}
+
+// APIKeyAuthenticator finds who made a request from the API key in its X-API-Key header
+type APIKeyAuthenticator struct {
+	keys []APIKey
+}
+
+// JWTAuthenticator finds who made a request from the bearer token in its Authorization header. Tokens are JWTs signed with HS256 by the key,
+// naming the caller in the sub claim and their role in the role claim. The exp and nbf claims are checked when given
+type JWTAuthenticator struct {
+	key []byte
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+type identityContextKey struct{}
+
+// Allows returns whether the role may do what the other role may
+func (role Role) Allows(other Role) bool {
+	return roleRanks[role] >= roleRanks[other]
+}
+
+func (identity Identity) validate() error {
+	if identity.Name == "" {
+		return ErrMissingIdentity
+	}
+	if _, ok := roleRanks[identity.Role]; !ok {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// Authenticate returns the Identity from the first Authenticator that finds credentials, or ErrNoCredentials if none do
+func (authenticators Authenticators) Authenticate(r *http.Request) (Identity, error) {
+	for _, authenticator := range authenticators {
+		identity, err := authenticator.Authenticate(r)
+		if err != ErrNoCredentials {
+			return identity, err
+		}
+	}
+	return Identity{}, ErrNoCredentials
+}
+
+// NewAPIKeyAuthenticator returns an APIKeyAuthenticator accepting the keys
+func NewAPIKeyAuthenticator(keys []APIKey) (*APIKeyAuthenticator, error) {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.Key == "" {
+			return nil, ErrInvalidAPIKey
+		}
+		if seen[key.Key] {
+			return nil, ErrDuplicateAPIKey
+		}
+		seen[key.Key] = true
+		if err := key.Identity.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &APIKeyAuthenticator{
+		keys: keys,
+	}, nil
+}
+
+// LoadAPIKeyAuthenticator returns an APIKeyAuthenticator accepting the keys in the JSON file, which is a list of objects with key, name and role
+func LoadAPIKeyAuthenticator(filename string) (*APIKeyAuthenticator, error) {
+	document, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	err = json.Unmarshal(document, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return NewAPIKeyAuthenticator(keys)
+}
+
+// Authenticate returns the Identity of the API key in the request. Every key is compared so the time taken does not tell which key was close
+func (aka *APIKeyAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	sent := r.Header.Get(apiKeyHeader)
+	if sent == "" {
+		return Identity{}, ErrNoCredentials
+	}
+	var identity Identity
+	found := false
+	for _, key := range aka.keys {
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(key.Key)) == 1 {
+			identity = key.Identity
+			found = true
+		}
+	}
+	if !found {
+		return Identity{}, ErrInvalidAPIKey
+	}
+	return identity, nil
+}
+
+// NewJWTAuthenticator returns a JWTAuthenticator accepting tokens signed with the key
+func NewJWTAuthenticator(key []byte) (*JWTAuthenticator, error) {
+	if len(key) == 0 {
+		return nil, ErrMissingJWTKey
+	}
+	return &JWTAuthenticator{
+		key: key,
+	}, nil
+}
+
+// LoadJWTAuthenticator returns a JWTAuthenticator accepting tokens signed with the key in the file. Surrounding whitespace in the file is ignored
+func LoadJWTAuthenticator(filename string) (*JWTAuthenticator, error) {
+	key, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewJWTAuthenticator([]byte(strings.TrimSpace(string(key))))
+}
+
+// Authenticate returns the Identity named by the bearer token in the request
+func (ja *JWTAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return Identity{}, ErrNoCredentials
+	}
+	return ja.verify(strings.TrimPrefix(authorization, bearerPrefix))
+}
+
+// verify checks the signature and times of the token and returns the Identity in its claims
+func (ja *JWTAuthenticator) verify(token string) (Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, ErrInvalidToken
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+	if header.Algorithm != "HS256" {
+		return Identity{}, ErrUnsupportedAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+	if !hmac.Equal(signature, signJWT(ja.key, parts[0]+"."+parts[1])) {
+		return Identity{}, ErrInvalidToken
+	}
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return Identity{}, ErrInvalidToken
+	}
+	now := time.Now().Unix()
+	if (claims.ExpiresAt != 0 && now >= claims.ExpiresAt) || (claims.NotBefore != 0 && now < claims.NotBefore) {
+		return Identity{}, ErrTokenExpired
+	}
+	identity := Identity{
+		Name: claims.Subject,
+		Role: claims.Role,
+	}
+	if err := identity.validate(); err != nil {
+		return Identity{}, fmt.Errorf("%v. %v", ErrInvalidToken, err)
+	}
+	return identity, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+func signJWT(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// require wraps the handler so it is only called for requests whose caller has at least the role. Without an Authenticator every request is allowed
+func (a *Admin) require(role Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.Authenticator == nil {
+			handler(w, r)
+			return
+		}
+		identity, err := a.Authenticator.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="conduction"`)
+			respondError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !identity.Role.Allows(role) {
+			respondError(w, fmt.Sprintf("%v. %s needs %s", ErrRoleNotAllowed, identity.Role, role), http.StatusForbidden)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), identityContextKey{}, identity)))
+	}
+}
+
+// IdentityFromRequest returns who made the request, if it was authenticated
+func IdentityFromRequest(r *http.Request) (Identity, bool) {
+	identity, ok := r.Context().Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,166 @@
+// +build all unit
+
+package admin_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/edfungus/conduction/admin"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// signToken makes an HS256 JWT of the claims, signed with the key
+func signToken(key string, algorithm string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var _ = Describe("Admin", func() {
+	Describe("Auth", func() {
+		Describe("Given roles", func() {
+			Context("When one role is compared to another", func() {
+				It("Then each role should allow the roles before it", func() {
+					Expect(RoleAdmin.Allows(RoleEditor)).To(BeTrue())
+					Expect(RoleEditor.Allows(RoleViewer)).To(BeTrue())
+					Expect(RoleEditor.Allows(RoleEditor)).To(BeTrue())
+					Expect(RoleViewer.Allows(RoleEditor)).To(BeFalse())
+					Expect(Role("guest").Allows(RoleViewer)).To(BeFalse())
+				})
+			})
+		})
+		Describe("Given an APIKeyAuthenticator", func() {
+			var authenticator *APIKeyAuthenticator
+			BeforeEach(func() {
+				var err error
+				authenticator, err = NewAPIKeyAuthenticator([]APIKey{
+					{Key: "viewer-key", Identity: Identity{Name: "dashboard", Role: RoleViewer}},
+					{Key: "admin-key", Identity: Identity{Name: "ops", Role: RoleAdmin}},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When the request has a known key", func() {
+				It("Then the Identity of the key should be returned", func() {
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					req.Header.Set("X-API-Key", "admin-key")
+					identity, err := authenticator.Authenticate(req)
+					Expect(err).To(BeNil())
+					Expect(identity).To(Equal(Identity{Name: "ops", Role: RoleAdmin}))
+				})
+			})
+			Context("When the request has an unknown key or none", func() {
+				It("Then an error should be returned", func() {
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					_, err := authenticator.Authenticate(req)
+					Expect(err).To(Equal(ErrNoCredentials))
+					req.Header.Set("X-API-Key", "other-key")
+					_, err = authenticator.Authenticate(req)
+					Expect(err).To(Equal(ErrInvalidAPIKey))
+				})
+			})
+			Context("When the keys are invalid", func() {
+				It("Then the APIKeyAuthenticator should not be made", func() {
+					_, err := NewAPIKeyAuthenticator([]APIKey{{Key: "key", Identity: Identity{Name: "ops", Role: "root"}}})
+					Expect(err).To(Equal(ErrInvalidRole))
+					_, err = NewAPIKeyAuthenticator([]APIKey{{Key: "key", Identity: Identity{Role: RoleAdmin}}})
+					Expect(err).To(Equal(ErrMissingIdentity))
+					_, err = NewAPIKeyAuthenticator([]APIKey{
+						{Key: "key", Identity: Identity{Name: "a", Role: RoleAdmin}},
+						{Key: "key", Identity: Identity{Name: "b", Role: RoleViewer}},
+					})
+					Expect(err).To(Equal(ErrDuplicateAPIKey))
+				})
+			})
+		})
+		Describe("Given a JWTAuthenticator", func() {
+			var authenticator *JWTAuthenticator
+			BeforeEach(func() {
+				var err error
+				authenticator, err = NewJWTAuthenticator([]byte("secret"))
+				Expect(err).To(BeNil())
+			})
+			Context("When the request has a token signed with the key", func() {
+				It("Then the Identity in the claims should be returned", func() {
+					token := signToken("secret", "HS256", map[string]interface{}{
+						"sub":  "deploy-bot",
+						"role": "editor",
+						"exp":  time.Now().Add(time.Hour).Unix(),
+					})
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					req.Header.Set("Authorization", "Bearer "+token)
+					identity, err := authenticator.Authenticate(req)
+					Expect(err).To(BeNil())
+					Expect(identity).To(Equal(Identity{Name: "deploy-bot", Role: RoleEditor}))
+				})
+			})
+			Context("When the token is signed with another key or algorithm, or is malformed", func() {
+				It("Then an error should be returned", func() {
+					claims := map[string]interface{}{"sub": "deploy-bot", "role": "editor"}
+					for token, expected := range map[string]error{
+						signToken("other", "HS256", claims): ErrInvalidToken,
+						signToken("secret", "none", claims): ErrUnsupportedAlgorithm,
+						"not-a-token":                       ErrInvalidToken,
+					} {
+						req, _ := http.NewRequest("GET", "/flows", nil)
+						req.Header.Set("Authorization", "Bearer "+token)
+						_, err := authenticator.Authenticate(req)
+						Expect(err).To(Equal(expected))
+					}
+				})
+			})
+			Context("When the token has expired or is not valid yet", func() {
+				It("Then an error should be returned", func() {
+					for _, claims := range []map[string]interface{}{
+						{"sub": "deploy-bot", "role": "editor", "exp": time.Now().Add(-time.Minute).Unix()},
+						{"sub": "deploy-bot", "role": "editor", "nbf": time.Now().Add(time.Minute).Unix()},
+					} {
+						req, _ := http.NewRequest("GET", "/flows", nil)
+						req.Header.Set("Authorization", "Bearer "+signToken("secret", "HS256", claims))
+						_, err := authenticator.Authenticate(req)
+						Expect(err).To(Equal(ErrTokenExpired))
+					}
+				})
+			})
+			Context("When the token has an unknown role", func() {
+				It("Then an error should be returned", func() {
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					req.Header.Set("Authorization", "Bearer "+signToken("secret", "HS256", map[string]interface{}{"sub": "deploy-bot", "role": "root"}))
+					_, err := authenticator.Authenticate(req)
+					Expect(err).ToNot(BeNil())
+					Expect(err.Error()).To(ContainSubstring(ErrInvalidRole.Error()))
+				})
+			})
+		})
+		Describe("Given Authenticators", func() {
+			Context("When the request has credentials for the second", func() {
+				It("Then the Identity from the second should be returned", func() {
+					apiKeys, err := NewAPIKeyAuthenticator([]APIKey{{Key: "key", Identity: Identity{Name: "ops", Role: RoleAdmin}}})
+					Expect(err).To(BeNil())
+					jwt, err := NewJWTAuthenticator([]byte("secret"))
+					Expect(err).To(BeNil())
+					authenticators := Authenticators{apiKeys, jwt}
+
+					req, _ := http.NewRequest("GET", "/flows", nil)
+					req.Header.Set("Authorization", "Bearer "+signToken("secret", "HS256", map[string]interface{}{"sub": "deploy-bot", "role": "viewer"}))
+					identity, err := authenticators.Authenticate(req)
+					Expect(err).To(BeNil())
+					Expect(identity.Name).To(Equal("deploy-bot"))
+
+					req, _ = http.NewRequest("GET", "/flows", nil)
+					_, err = authenticators.Authenticate(req)
+					Expect(err).To(Equal(ErrNoCredentials))
+				})
+			})
+		})
+	})
+})
@@ -3,19 +3,32 @@ package admin
 import (
 	"fmt"
 
+	"github.com/edfungus/conduction/expression"
+	"github.com/edfungus/conduction/manifest"
 	"github.com/edfungus/conduction/messenger"
 	"github.com/edfungus/conduction/storage"
+	"github.com/edfungus/conduction/transform"
 )
 
 var (
-	ErrFlowMissingName        error = fmt.Errorf("Flow is missing field: name")
-	ErrFlowMissingDescription error = fmt.Errorf("Flow is missing field: description")
-	ErrFlowMissingPath        error = fmt.Errorf("Flow is missing field: path")
-	ErrPathMissingRoute       error = fmt.Errorf("Path is missing field: route")
-	ErrPathMissingType        error = fmt.Errorf("Path is missing field: type")
+	ErrFlowMissingName          error = fmt.Errorf("Flow is missing field: name")
+	ErrFlowMissingDescription   error = fmt.Errorf("Flow is missing field: description")
+	ErrFlowMissingPath          error = fmt.Errorf("Flow is missing field: path")
+	ErrPathMissingRoute         error = fmt.Errorf("Path is missing field: route")
+	ErrPathMissingType          error = fmt.Errorf("Path is missing field: type")
+	ErrFlowWaitForWithoutWait   error = fmt.Errorf("Flow has field waitFor but wait is not true")
+	ErrFlowAggregateWithoutWait error = fmt.Errorf("Flow has field aggregate but wait is not true")
+	ErrFlowPathIsPattern        error = fmt.Errorf("Flow path cannot be a pattern because messages cannot be sent to it")
+	ErrFlowWaitForIsPattern     error = fmt.Errorf("Flow waitFor cannot be a pattern because responses are matched to it by their exact route")
+	ErrFlowInvalidCondition     error = fmt.Errorf("Flow has an invalid condition")
+	ErrFlowInvalidTransform     error = fmt.Errorf("Flow has an invalid transform")
+	ErrInvalidNamespace         error = fmt.Errorf("Namespace %s is reserved for sharing a Flow with every namespace", storage.AllNamespaces)
 )
 
 func validateFlow(flow storage.Flow) error {
+	if flow.Namespace == storage.AllNamespaces {
+		return ErrInvalidNamespace
+	}
 	if flow.Name == "" {
 		return ErrFlowMissingName
 	}
@@ -25,15 +38,85 @@ func validateFlow(flow storage.Flow) error {
 	if flow.Path == nil {
 		return ErrFlowMissingPath
 	}
+	if err := storage.ValidateLabels(flow.Labels); err != nil {
+		return err
+	}
+	if flow.WaitFor != nil {
+		if !flow.Wait {
+			return ErrFlowWaitForWithoutWait
+		}
+		if storage.IsPatternRoute(flow.WaitFor.Route) {
+			return ErrFlowWaitForIsPattern
+		}
+		if !inNamespaceOfFlow(flow, *flow.WaitFor) {
+			return storage.ErrPathOutsideNamespace
+		}
+		if err := validatePath(*flow.WaitFor); err != nil {
+			return err
+		}
+	}
+	if flow.Aggregate && !flow.Wait {
+		return ErrFlowAggregateWithoutWait
+	}
+	if flow.Condition != "" {
+		if _, err := expression.Parse(flow.Condition); err != nil {
+			return fmt.Errorf("%v. %v", ErrFlowInvalidCondition, err)
+		}
+	}
+	if flow.Transform != "" {
+		if _, err := transform.Parse(flow.Transform); err != nil {
+			return fmt.Errorf("%v. %v", ErrFlowInvalidTransform, err)
+		}
+	}
+	if storage.IsPatternRoute(flow.Path.Route) {
+		return ErrFlowPathIsPattern
+	}
+	if !inNamespaceOfFlow(flow, *flow.Path) {
+		return storage.ErrPathOutsideNamespace
+	}
 	return validatePath(*flow.Path)
 }
 
+// inNamespaceOfFlow returns whether the Path is in the namespace of the Flow or names none, in which case storage puts it there
+func inNamespaceOfFlow(flow storage.Flow, path messenger.Path) bool {
+	return path.Namespace == "" || path.Namespace == flow.Namespace
+}
+
 func validatePath(path messenger.Path) error {
+	if path.Namespace == storage.AllNamespaces {
+		return ErrInvalidNamespace
+	}
 	if path.Route == "" {
 		return ErrPathMissingRoute
 	}
 	if path.Type == "" {
 		return ErrPathMissingType
 	}
+	if err := storage.ValidateLabels(path.Labels); err != nil {
+		return err
+	}
+	return storage.ValidatePatternRoute(path.Route)
+}
+
+// ValidateManifest checks every Flow and Path in the manifest the same way they are checked when added one at a time
+func ValidateManifest(m manifest.Manifest) error {
+	for _, flow := range m.Flows {
+		err := validateFlow(flow.StorageFlow())
+		if err != nil {
+			return fmt.Errorf("%v. %s", err, flow.Name)
+		}
+		for _, path := range flow.Triggers() {
+			err := validatePath(messenger.Path{Route: path.Route, Type: path.Type, Namespace: path.Namespace, Labels: path.Labels})
+			if err != nil {
+				return fmt.Errorf("%v. %s", err, path)
+			}
+		}
+	}
+	for _, path := range m.Paths {
+		err := validatePath(messenger.Path{Route: path.Route, Type: path.Type, Namespace: path.Namespace, Labels: path.Labels})
+		if err != nil {
+			return fmt.Errorf("%v. %s", err, path)
+		}
+	}
 	return nil
 }
@@ -1,12 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/edfungus/conduction/admin"
+	"github.com/edfungus/conduction/diagram"
+	"github.com/edfungus/conduction/manifest"
 	"github.com/edfungus/conduction/messenger"
 	"github.com/edfungus/conduction/router"
 	"github.com/edfungus/conduction/storage"
@@ -16,31 +26,112 @@ import (
 // Logger controls logging and levels
 var Logger = logrus.New()
 
+const (
+	inputTopic      = "KAFKA-topic"
+	deadLetterTopic = "DEADLETTER-topic"
+	manifestActor   = "cli" // Who changes applied from a manifest file are recorded as made by in the audit log
+
+	messengerKafka  = "kafka"
+	messengerMemory = "memory" // Messages are read from stdin and those sent to Paths are printed to stdout, so Conduction can be tried without Kafka
+)
+
+// memoryBroker connects the MemoryMessengers of -messenger=memory
+var memoryBroker = messenger.NewMemoryBroker()
+
+var (
+	messengerType       = flag.String("messenger", messengerKafka, "Messenger to route with: kafka, or memory to route JSON messages read from stdin, one per line, and print the messages sent to Paths")
+	kafkaBroker         = flag.String("kafka", "localhost:9092", "Kafka broker to connect to")
+	cacheTTL            = flag.Duration("cache-ttl", time.Second*30, "How long Path to Flows lookups are cached")
+	workers             = flag.Int("workers", router.DefaultWorkers, "Number of messages the router processes at once")
+	waitTimeout         = flag.Duration("wait-timeout", 0, "How long to wait for the responses of waiting Flows before continuing without them. Zero waits forever")
+	exportGraph         = flag.String("export-graph", "", "Print the graph of Paths and Flows as dot, mermaid or json and exit instead of routing")
+	exportPath          = flag.String("export-path", "", "With -export-graph, only print the part of the graph around the Path of this uuid")
+	exportDepth         = flag.Int("export-depth", storage.DefaultTraversalDepth, "With -export-path, how many Flows away from the Path to print")
+	exportManifest      = flag.String("export-manifest", "", "Print every Path and Flow as a yaml or json manifest and exit instead of routing")
+	applyManifest       = flag.String("apply-manifest", "", "Change storage to match the manifest file, print the changes made and exit instead of routing")
+	planOnly            = flag.Bool("plan-only", false, "With -apply-manifest, print the changes without making them")
+	apiKeys             = flag.String("api-keys", "", "JSON file of API keys accepted by the admin API, each with a key, name and role")
+	jwtKeyFile          = flag.String("jwt-key", "", "File of the key that signs HS256 tokens accepted by the admin API")
+	namespaceTopicsFile = flag.String("namespace-topics", "", "JSON file mapping each namespace to the topics of its Path types, for namespaces that send to their own topics")
+	replayDeadLetters   = flag.Bool("replay-dead-letters", false, "Replay the messages on the dead letter topic, print how many were replayed and exit instead of routing")
+	replayIdleTimeout   = flag.Duration("replay-idle-timeout", time.Second*5, "With -replay-dead-letters, stop once no dead letter has arrived for this long")
+)
+
 func main() {
+	flag.Parse()
+	if *exportGraph != "" {
+		err := printGraph(*exportGraph, *exportPath, *exportDepth)
+		if err != nil {
+			Logger.Fatalf("Could not export graph. %v", err)
+		}
+		return
+	}
+	if *exportManifest != "" {
+		err := printManifest(*exportManifest)
+		if err != nil {
+			Logger.Fatalf("Could not export manifest. %v", err)
+		}
+		return
+	}
+	if *applyManifest != "" {
+		err := applyManifestFile(*applyManifest, *planOnly)
+		if err != nil {
+			Logger.Fatalf("Could not apply manifest. %v", err)
+		}
+		return
+	}
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		Logger.Fatalf("Could not read namespace topics. %v", err)
+	}
+	if *replayDeadLetters {
+		if *messengerType == messengerMemory {
+			Logger.Fatal("Could not replay dead letters. Those of the memory messenger are gone once Conduction exits")
+		}
+		err := replayDeadLetterTopic(routerConfig, *replayIdleTimeout)
+		if err != nil {
+			Logger.Fatalf("Could not replay dead letters. %v", err)
+		}
+		return
+	}
 	Logger.Info("Hello Conduction! :)")
 
-	messengerConfig := &messenger.KafkaMessengerConfig{
-		ConsumerGroup:   "conduction",
-		TopicsToConsume: []string{"KAFKA-topic"},
-	}
-	messenger, err := messenger.NewKafkaMessenger("localhost:9092", messengerConfig)
+	messenger, err := newMessenger(inputTopic)
 	if err != nil {
-		Logger.Fatal("Could not make messenger")
+		Logger.Fatalf("Could not make messenger. %v", err)
+	}
+	if *messengerType == messengerMemory {
+		go func() {
+			err := printSentMessages(routerConfig)
+			if err != nil {
+				Logger.Errorf("Could not print sent messages. %v", err)
+			}
+		}()
+		go func() {
+			err := feedMessages(os.Stdin)
+			if err != nil {
+				Logger.Errorf("Could not read messages from stdin. %v", err)
+			}
+		}()
 	}
 
-	storage, err := storage.NewGraphStorageBolt("./database.bolt")
+	graphStorage, err := storage.NewGraphStorageBolt("./database.bolt")
 	if err != nil {
 		Logger.Fatal("Could not make storage")
 	}
+	storage := storage.NewCachedStorage(graphStorage, *cacheTTL)
 
-	topicNames := map[string]string{"REST": "REST-topic", "MQTT": "MQTT-topic"}
-	routerConfig := router.RouterConfig{
-		TopicNames: topicNames,
-	}
 	router := router.NewRouter(messenger, storage, routerConfig)
 	go router.Start()
 
 	admin := admin.NewAdmin(storage)
+	admin.Authenticator, err = newAuthenticator(*apiKeys, *jwtKeyFile)
+	if err != nil {
+		Logger.Fatalf("Could not set up admin authentication. %v", err)
+	}
+	if admin.Authenticator == nil {
+		Logger.Warn("Admin API allows every request. Set -api-keys or -jwt-key to require authentication")
+	}
 	go log.Fatal(http.ListenAndServe(":8080", admin.Router))
 
 	signalChan := make(chan os.Signal, 1)
@@ -48,10 +139,260 @@ func main() {
 	signal.Notify(signalChan, os.Interrupt)
 	go func() {
 		<-signalChan
-		router.Stop()
-		storage.Close()
+		router.Close()
+		Logger.Infof("Storage cache stats %+v", storage.Stats())
+		graphStorage.Close()
 		messenger.Close()
 		exitReady <- true
 	}()
 	<-exitReady
 }
+
+// printGraph writes the graph in storage to stdout in the format, scoped to the Path of pathID when it is given
+func printGraph(format string, pathID string, depth int) error {
+	graphStorage, err := storage.NewGraphStorageBolt("./database.bolt")
+	if err != nil {
+		return err
+	}
+	defer graphStorage.Close()
+	var graph storage.Graph
+	if pathID != "" {
+		key, err := storage.NewKeyFromString(pathID)
+		if err != nil {
+			return err
+		}
+		graph, err = graphStorage.GetGraphAroundPath(key, depth)
+		if err != nil {
+			return err
+		}
+	} else {
+		graph, err = graphStorage.GetGraph()
+		if err != nil {
+			return err
+		}
+	}
+	output, err := diagram.Render(graph, format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+// printManifest writes every Path and Flow in storage to stdout as a manifest in the format
+func printManifest(format string) error {
+	graphStorage, err := storage.NewGraphStorageBolt("./database.bolt")
+	if err != nil {
+		return err
+	}
+	defer graphStorage.Close()
+	m, err := manifest.Export(graphStorage)
+	if err != nil {
+		return err
+	}
+	output, err := m.Encode(format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+// applyManifestFile changes storage to match the manifest in the file and prints each change, or only prints them when planOnly is set
+func applyManifestFile(filename string, planOnly bool) error {
+	document, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	m, err := manifest.Parse(document)
+	if err != nil {
+		return err
+	}
+	err = admin.ValidateManifest(m)
+	if err != nil {
+		return err
+	}
+	graphStorage, err := storage.NewGraphStorageBolt("./database.bolt")
+	if err != nil {
+		return err
+	}
+	defer graphStorage.Close()
+	plan, err := manifest.NewPlan(graphStorage, m)
+	if err != nil {
+		return err
+	}
+	for _, change := range plan.Changes {
+		fmt.Println(change)
+	}
+	if len(plan.Changes) == 0 {
+		fmt.Println("No changes")
+	}
+	if planOnly {
+		return nil
+	}
+	err = plan.Apply(storage.NewAuditedStorage(graphStorage, manifestActor))
+	if changeErr, ok := err.(*manifest.ChangeError); ok {
+		fmt.Printf("Applied %d of %d changes\n", len(changeErr.Applied), len(plan.Changes))
+	}
+	return err
+}
+
+// newRouterConfig returns the RouterConfig shared by routing and replaying dead letters
+func newRouterConfig() (router.RouterConfig, error) {
+	namespaceTopics, err := loadNamespaceTopics(*namespaceTopicsFile)
+	if err != nil {
+		return router.RouterConfig{}, err
+	}
+	return router.RouterConfig{
+		TopicNames:      map[string]string{"REST": "REST-topic", "MQTT": "MQTT-topic"},
+		NamespaceTopics: namespaceTopics,
+		InputTopic:      inputTopic,
+		DeadLetterTopic: deadLetterTopic,
+		RetryPolicy: router.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond * 100,
+			MaxBackoff:     time.Second * 2,
+			Jitter:         0.2,
+		},
+		Workers:     *workers,
+		WaitTimeout: *waitTimeout,
+	}, nil
+}
+
+// replayDeadLetterTopic replays the messages on the dead letter topic until none arrive for idleTimeout and prints how many were replayed
+func replayDeadLetterTopic(routerConfig router.RouterConfig, idleTimeout time.Duration) error {
+	deadLetters, err := newMessenger(deadLetterTopic)
+	if err != nil {
+		return err
+	}
+	defer deadLetters.Close()
+	replayed, err := router.NewReplayer(deadLetters, routerConfig).ReplayDeadLetters(deadLetters, idleTimeout)
+	fmt.Printf("Replayed %d dead letters\n", replayed)
+	return err
+}
+
+// loadNamespaceTopics returns the topics of each namespace in the JSON file, or nil if no file is given
+func loadNamespaceTopics(filename string) (map[string]router.TopicNames, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	document, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var namespaceTopics map[string]router.TopicNames
+	err = json.Unmarshal(document, &namespaceTopics)
+	if err != nil {
+		return nil, err
+	}
+	return namespaceTopics, nil
+}
+
+// newAuthenticator returns an Authenticator for the API keys file and the JWT key file that are given, or nil if neither is
+func newAuthenticator(apiKeysFile string, jwtKeyFile string) (admin.Authenticator, error) {
+	var authenticators admin.Authenticators
+	if apiKeysFile != "" {
+		authenticator, err := admin.LoadAPIKeyAuthenticator(apiKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if jwtKeyFile != "" {
+		authenticator, err := admin.LoadJWTAuthenticator(jwtKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return authenticators, nil
+}
+
+// newMessenger returns a Messenger that consumes the topic. It is a Kafka Messenger, or with -messenger=memory a MemoryMessenger on the broker of this process
+func newMessenger(topic string) (messenger.Messenger, error) {
+	switch *messengerType {
+	case messengerKafka:
+		return messenger.NewKafkaMessenger(*kafkaBroker, &messenger.KafkaMessengerConfig{
+			ConsumerGroup:   "conduction",
+			TopicsToConsume: []string{topic},
+		})
+	case messengerMemory:
+		return messenger.NewMemoryMessenger(memoryBroker, &messenger.MemoryMessengerConfig{
+			ConsumerGroup:   "conduction",
+			TopicsToConsume: []string{topic},
+		})
+	}
+	return nil, fmt.Errorf("Unknown messenger %s. Use %s or %s", *messengerType, messengerKafka, messengerMemory)
+}
+
+// feedMessages sends each line of input, a Message as JSON, to the input topic of the memory broker. Lines that are not a Message are logged and skipped
+func feedMessages(input io.Reader) error {
+	feeder, err := messenger.NewMemoryMessenger(memoryBroker, &messenger.MemoryMessengerConfig{ConsumerGroup: "stdin"})
+	if err != nil {
+		return err
+	}
+	defer feeder.Close()
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var message messenger.Message
+		err := json.Unmarshal(line, &message)
+		if err != nil {
+			Logger.Errorf("Could not read message %s. %v", line, err)
+			continue
+		}
+		err = feeder.Send(inputTopic, &message)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// printSentMessages prints each message the router sends to the topics of Paths, or dead-letters, on the memory broker as a line of JSON on stdout,
+// standing in for the connectors that would consume them
+func printSentMessages(routerConfig router.RouterConfig) error {
+	var topics []string
+	seen := make(map[string]bool)
+	addTopic := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	addTopic(routerConfig.DeadLetterTopic)
+	for _, topic := range routerConfig.TopicNames {
+		addTopic(topic)
+	}
+	for _, topicNames := range routerConfig.NamespaceTopics {
+		for _, topic := range topicNames {
+			addTopic(topic)
+		}
+	}
+	printer, err := messenger.NewMemoryMessenger(memoryBroker, &messenger.MemoryMessengerConfig{
+		ConsumerGroup:   "stdout",
+		TopicsToConsume: topics,
+	})
+	if err != nil {
+		return err
+	}
+	defer printer.Close()
+	for message := range printer.Receive() {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(encoded))
+		err = printer.Acknowledge(message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package diagram renders the graph of Paths and Flows (see storage.Graph) for people to read, as Graphviz DOT, Mermaid or a JSON document of nodes and edges
+package diagram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/edfungus/conduction/storage"
+)
+
+const (
+	FormatDOT     string = "dot"
+	FormatMermaid string = "mermaid"
+	FormatJSON    string = "json"
+)
+
+var (
+	ErrUnknownFormat error = fmt.Errorf("Unknown graph format. Expected %s, %s or %s", FormatDOT, FormatMermaid, FormatJSON)
+)
+
+// Render returns the graph in the format
+func Render(graph storage.Graph, format string) ([]byte, error) {
+	switch format {
+	case FormatDOT:
+		return []byte(DOT(graph)), nil
+	case FormatMermaid:
+		return []byte(Mermaid(graph)), nil
+	case FormatJSON:
+		return json.Marshal(graph)
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// ContentType returns the media type of the format for HTTP responses
+func ContentType(format string) string {
+	switch format {
+	case FormatDOT:
+		return "text/vnd.graphviz"
+	case FormatJSON:
+		return "application/json"
+	default:
+		return "text/plain"
+	}
+}
+
+// DOT returns the graph as a Graphviz digraph. Paths are boxes, dashed when they are patterns, and Flows are ellipses
+func DOT(graph storage.Graph) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph conduction {\n\trankdir=LR;\n")
+	for _, node := range graph.Nodes {
+		shape := "ellipse"
+		if node.Kind == storage.NodeKindPath {
+			shape = "box"
+		}
+		style := ""
+		if node.Pattern {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&buf, "\t%s [label=%s, shape=%s%s];\n", dotQuote(node.ID), dotQuote(node.Label), shape, style)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(edge.Kind))
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Mermaid returns the graph as a Mermaid flowchart. Paths are rectangles, with parallelogram sides when they are patterns, and Flows are rounded
+func Mermaid(graph storage.Graph) string {
+	var buf bytes.Buffer
+	buf.WriteString("flowchart LR\n")
+	for _, node := range graph.Nodes {
+		open, close := "(", ")"
+		if node.Kind == storage.NodeKindPath {
+			open, close = "[", "]"
+			if node.Pattern {
+				open, close = "[/", "/]"
+			}
+		}
+		fmt.Fprintf(&buf, "\t%s%s%s%s\n", mermaidID(node.ID), open, mermaidQuote(node.Label), close)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&buf, "\t%s -->|%s| %s\n", mermaidID(edge.From), edge.Kind, mermaidID(edge.To))
+	}
+	return buf.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// mermaidID makes an id Mermaid accepts from a uuid, which may start with a digit and has dashes
+func mermaidID(id string) string {
+	return "n" + strings.Replace(id, "-", "", -1)
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s) + `"`
+}
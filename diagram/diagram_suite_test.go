@@ -0,0 +1,13 @@
+package diagram
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDiagram(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Diagram Suite")
+}
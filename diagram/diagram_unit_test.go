@@ -0,0 +1,78 @@
+// +build all unit
+
+package diagram
+
+import (
+	"github.com/edfungus/conduction/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Diagram", func() {
+		pathID := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+		patternID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+		flowID := "6ba7b812-9dad-11d1-80b4-00c04fd430c8"
+		graph := storage.Graph{
+			Nodes: []storage.GraphNode{
+				{ID: pathID, Kind: storage.NodeKindPath, Label: "MQTT sensors/kitchen"},
+				{ID: patternID, Kind: storage.NodeKindPath, Label: "MQTT sensors/+", Pattern: true},
+				{ID: flowID, Kind: storage.NodeKindFlow, Label: `Say "hi"`},
+			},
+			Edges: []storage.GraphEdge{
+				{From: pathID, To: patternID, Kind: storage.EdgeKindMatches},
+				{From: patternID, To: flowID, Kind: storage.EdgeKindTriggers},
+			},
+		}
+		Describe("Given a graph rendered as DOT", func() {
+			Context("When it has Paths, pattern Paths and Flows", func() {
+				It("Then each should have its own shape and labels should be escaped", func() {
+					dot := DOT(graph)
+					Expect(dot).To(HavePrefix("digraph conduction {\n"))
+					Expect(dot).To(ContainSubstring(`"` + pathID + `" [label="MQTT sensors/kitchen", shape=box];`))
+					Expect(dot).To(ContainSubstring(`"` + patternID + `" [label="MQTT sensors/+", shape=box, style=dashed];`))
+					Expect(dot).To(ContainSubstring(`"` + flowID + `" [label="Say \"hi\"", shape=ellipse];`))
+					Expect(dot).To(ContainSubstring(`"` + patternID + `" -> "` + flowID + `" [label="triggers"];`))
+					Expect(dot).To(HaveSuffix("}\n"))
+				})
+			})
+		})
+		Describe("Given a graph rendered as Mermaid", func() {
+			Context("When it has Paths, pattern Paths and Flows", func() {
+				It("Then ids should be usable by Mermaid and labels should be escaped", func() {
+					mermaid := Mermaid(graph)
+					Expect(mermaid).To(HavePrefix("flowchart LR\n"))
+					Expect(mermaid).To(ContainSubstring(`n6ba7b8109dad11d180b400c04fd430c8["MQTT sensors/kitchen"]`))
+					Expect(mermaid).To(ContainSubstring(`n6ba7b8119dad11d180b400c04fd430c8[/"MQTT sensors/+"/]`))
+					Expect(mermaid).To(ContainSubstring(`n6ba7b8129dad11d180b400c04fd430c8("Say #quot;hi#quot;")`))
+					Expect(mermaid).To(ContainSubstring(`n6ba7b8109dad11d180b400c04fd430c8 -->|matches| n6ba7b8119dad11d180b400c04fd430c8`))
+				})
+			})
+		})
+		Describe("Given a graph rendered by format", func() {
+			Context("When the format is JSON", func() {
+				It("Then the nodes and edges should be returned as a document", func() {
+					document, err := Render(graph, FormatJSON)
+					Expect(err).To(BeNil())
+					Expect(document).To(MatchJSON(`{
+						"nodes": [
+							{"id": "` + pathID + `", "kind": "path", "label": "MQTT sensors/kitchen"},
+							{"id": "` + patternID + `", "kind": "path", "label": "MQTT sensors/+", "pattern": true},
+							{"id": "` + flowID + `", "kind": "flow", "label": "Say \"hi\""}
+						],
+						"edges": [
+							{"from": "` + pathID + `", "to": "` + patternID + `", "kind": "matches"},
+							{"from": "` + patternID + `", "to": "` + flowID + `", "kind": "triggers"}
+						]
+					}`))
+				})
+			})
+			Context("When the format is unknown", func() {
+				It("Then an error should be returned", func() {
+					_, err := Render(graph, "svg")
+					Expect(err).To(Equal(ErrUnknownFormat))
+				})
+			})
+		})
+	})
+})
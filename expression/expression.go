@@ -0,0 +1,290 @@
+// Package expression evaluates conditions against messages, such as
+//
+//	payload.temperature > 30 && metadata.routeParam.room == "kitchen" || origin.type == "MQTT"
+//
+// payload.* reads fields of a JSON payload, with numbers indexing into arrays, and payload on its own is the whole payload. metadata.* reads a metadata value as a string
+// and origin.route, origin.type and origin.identity read the origin Path. A reference that does not exist is null.
+// Operators are ==, !=, <, <=, >, >=, contains, &&, || and !, and a reference on its own is true when it is set and not false, null, 0 or ""
+package expression
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	referencePayload  string = "payload"
+	referenceMetadata string = "metadata"
+	referenceOrigin   string = "origin"
+)
+
+// Expression is a parsed condition that can be evaluated against messages
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse returns the Expression of source or an error describing where it is invalid
+func Parse(source string) (*Expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("Unexpected '%s' at position %d", t.text, t.position)
+	}
+	return &Expression{
+		source: source,
+		root:   root,
+	}, nil
+}
+
+// String returns the source the Expression was parsed from
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate returns whether the message matches the Expression. A payload that is not JSON is a string with no fields
+func (e *Expression) Evaluate(message messenger.Message) bool {
+	return truthy(e.Value(message))
+}
+
+// Value returns what the Expression evaluates to for the message. It is a bool, float64, string, nil or a value decoded from JSON
+func (e *Expression) Value(message messenger.Message) interface{} {
+	env := &environment{message: message}
+	return e.root.evaluate(env)
+}
+
+// environment lazily decodes the payload so conditions that never read it do not pay for it
+type environment struct {
+	message       messenger.Message
+	payload       interface{}
+	payloadParsed bool
+}
+
+func (env *environment) getPayload() interface{} {
+	if !env.payloadParsed {
+		env.payloadParsed = true
+		if err := json.Unmarshal(env.message.Payload, &env.payload); err != nil {
+			env.payload = string(env.message.Payload)
+		}
+	}
+	return env.payload
+}
+
+type node interface {
+	evaluate(env *environment) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) evaluate(env *environment) interface{} {
+	return n.value
+}
+
+type referenceNode struct {
+	source string
+	path   []string
+}
+
+func (n referenceNode) evaluate(env *environment) interface{} {
+	switch n.source {
+	case referencePayload:
+		return lookup(env.getPayload(), n.path)
+	case referenceMetadata:
+		value, ok := env.message.Metadata[n.path[0]]
+		if !ok {
+			return nil
+		}
+		return string(value)
+	case referenceOrigin:
+		origin := env.message.Origin
+		if origin == nil {
+			return nil
+		}
+		switch n.path[0] {
+		case "route":
+			return origin.Route
+		case "type":
+			return origin.Type
+		case "identity":
+			return origin.Identity
+		}
+	}
+	return nil
+}
+
+// lookup follows the path through decoded JSON objects and arrays
+func lookup(value interface{}, path []string) interface{} {
+	for _, part := range path {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			value = current[part]
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil
+			}
+			value = current[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) evaluate(env *environment) interface{} {
+	return !truthy(n.operand.evaluate(env))
+}
+
+type andNode struct {
+	left  node
+	right node
+}
+
+func (n andNode) evaluate(env *environment) interface{} {
+	return truthy(n.left.evaluate(env)) && truthy(n.right.evaluate(env))
+}
+
+type orNode struct {
+	left  node
+	right node
+}
+
+func (n orNode) evaluate(env *environment) interface{} {
+	return truthy(n.left.evaluate(env)) || truthy(n.right.evaluate(env))
+}
+
+type comparisonNode struct {
+	operator string
+	left     node
+	right    node
+}
+
+// evaluate compares numbers with numbers and strings with strings. Ordering values of different kinds is false
+func (n comparisonNode) evaluate(env *environment) interface{} {
+	left, right := n.left.evaluate(env), n.right.evaluate(env)
+	switch n.operator {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "contains":
+		return contains(left, right)
+	}
+	order, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+	switch n.operator {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+// equal compares a string with a number by the number the string holds, since metadata values are always strings
+func equal(left interface{}, right interface{}) bool {
+	if order, ok := compare(left, right); ok {
+		return order == 0
+	}
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return false
+}
+
+func compare(left interface{}, right interface{}) (int, bool) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := toNumber(right); ok {
+			return compareNumbers(l, r), true
+		}
+	case string:
+		switch r := right.(type) {
+		case string:
+			return strings.Compare(l, r), true
+		case float64:
+			if number, ok := toNumber(l); ok {
+				return compareNumbers(number, r), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func compareNumbers(left float64, right float64) int {
+	switch {
+	case left < right:
+		return -1
+	case left > right:
+		return 1
+	}
+	return 0
+}
+
+func contains(left interface{}, right interface{}) bool {
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		return ok && strings.Contains(l, r)
+	case []interface{}:
+		for _, item := range l {
+			if equal(item, right) {
+				return true
+			}
+		}
+	}
+	return false
+}
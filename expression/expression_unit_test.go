@@ -0,0 +1,99 @@
+// +build all unit
+
+package expression
+
+import (
+	"github.com/edfungus/conduction/messenger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Expression", func() {
+		message := messenger.Message{
+			Payload: []byte(`{"temperature": 31.5, "room": {"name": "kitchen"}, "tags": ["hot", "indoor"], "on": true}`),
+			Metadata: map[string][]byte{
+				"routeParam.room": []byte("kitchen"),
+				"retries":         []byte("3"),
+			},
+			Origin: &messenger.Path{
+				Route: "sensors/kitchen/temp",
+				Type:  "MQTT",
+			},
+		}
+		evaluate := func(source string) bool {
+			e, err := Parse(source)
+			Expect(err).To(BeNil())
+			return e.Evaluate(message)
+		}
+		Describe("Given an Expression referencing the payload", func() {
+			Context("When the fields exist", func() {
+				It("Then they should be compared by value", func() {
+					Expect(evaluate(`payload.temperature > 30`)).To(BeTrue())
+					Expect(evaluate(`payload.temperature <= 30`)).To(BeFalse())
+					Expect(evaluate(`payload.room.name == "kitchen"`)).To(BeTrue())
+					Expect(evaluate(`payload.tags.0 == 'hot'`)).To(BeTrue())
+					Expect(evaluate(`payload.tags contains "indoor"`)).To(BeTrue())
+					Expect(evaluate(`payload.on`)).To(BeTrue())
+				})
+			})
+			Context("When the fields do not exist", func() {
+				It("Then they should be null", func() {
+					Expect(evaluate(`payload.humidity == null`)).To(BeTrue())
+					Expect(evaluate(`payload.humidity > 0`)).To(BeFalse())
+					Expect(evaluate(`payload.tags.5`)).To(BeFalse())
+				})
+			})
+			Context("When the payload is not JSON", func() {
+				It("Then every payload field should be null and the payload should be a string", func() {
+					e, err := Parse(`payload.temperature > 30`)
+					Expect(err).To(BeNil())
+					Expect(e.Evaluate(messenger.Message{Payload: []byte("not json")})).To(BeFalse())
+					e, err = Parse(`payload == "ON"`)
+					Expect(err).To(BeNil())
+					Expect(e.Evaluate(messenger.Message{Payload: []byte("ON")})).To(BeTrue())
+				})
+			})
+		})
+		Describe("Given an Expression referencing metadata and origin", func() {
+			Context("When the values exist", func() {
+				It("Then they should be compared as strings or as numbers against numbers", func() {
+					Expect(evaluate(`metadata.routeParam.room == "kitchen"`)).To(BeTrue())
+					Expect(evaluate(`metadata.retries >= 3`)).To(BeTrue())
+					Expect(evaluate(`origin.type == "MQTT" && origin.route contains "kitchen"`)).To(BeTrue())
+					Expect(evaluate(`metadata.missing`)).To(BeFalse())
+				})
+			})
+		})
+		Describe("Given an Expression with boolean operators", func() {
+			Context("When it is evaluated", func() {
+				It("Then ! should bind tighter than && and && tighter than ||", func() {
+					Expect(evaluate(`false && false || true`)).To(BeTrue())
+					Expect(evaluate(`false && (false || true)`)).To(BeFalse())
+					Expect(evaluate(`!payload.on || payload.temperature > 30`)).To(BeTrue())
+					Expect(evaluate(`!(payload.temperature > 30)`)).To(BeFalse())
+				})
+			})
+		})
+		Describe("Given an invalid Expression", func() {
+			Context("When it is parsed", func() {
+				It("Then an error should be returned", func() {
+					for _, source := range []string{
+						``,
+						`payload.temperature >`,
+						`(payload.on`,
+						`payload.on payload.on`,
+						`body.temperature > 30`,
+						`origin.name == "x"`,
+						`metadata == "x"`,
+						`"unterminated`,
+						`payload.a = 1`,
+					} {
+						_, err := Parse(source)
+						Expect(err).ToNot(BeNil(), source)
+					}
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,248 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	position int
+}
+
+// lex splits the source into tokens
+func lex(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, text: "(", position: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, text: ")", position: i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string at position %d", i)
+			}
+			text, err := unquote(string(runes[i+1:end]), r)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid string at position %d. %v", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, position: i})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:end]), position: i})
+			i = end
+		case isIdentifierRune(r):
+			end := i + 1
+			for end < len(runes) && (isIdentifierRune(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i:end]), position: i})
+			i = end
+		default:
+			operator := matchOperator(runes[i:])
+			if operator == "" {
+				return nil, fmt.Errorf("Unexpected character '%c' at position %d", r, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, position: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, position: len(runes)}), nil
+}
+
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '#'
+}
+
+func matchOperator(runes []rune) string {
+	for _, operator := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"} {
+		if strings.HasPrefix(string(runes), operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+// unquote resolves escapes in the text between quotes. Single quoted text is rewritten as double quoted first
+func unquote(text string, quote rune) (string, error) {
+	if quote == '\'' {
+		text = strings.Replace(strings.Replace(text, `\'`, `'`, -1), `"`, `\"`, -1)
+	}
+	return strconv.Unquote(`"` + text + `"`)
+}
+
+// parser is a recursive descent parser over the tokens. From lowest to highest precedence: ||, &&, !, comparisons
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "||" {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "&&" {
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind == tokenOperator && p.peek().text == "!" {
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	isComparison := (t.kind == tokenOperator && t.text != "&&" && t.text != "||" && t.text != "!") ||
+		(t.kind == tokenIdentifier && t.text == "contains")
+	if !isComparison {
+		return left, nil
+	}
+	p.advance()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparisonNode{operator: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenLeftParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.advance().kind != tokenRightParen {
+			return nil, fmt.Errorf("Missing ) for ( at position %d", t.position)
+		}
+		return inner, nil
+	case tokenString:
+		return literalNode{value: t.text}, nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number '%s' at position %d", t.text, t.position)
+		}
+		return literalNode{value: number}, nil
+	case tokenIdentifier:
+		return parseIdentifier(t)
+	case tokenEOF:
+		return nil, fmt.Errorf("Expression ended early")
+	default:
+		return nil, fmt.Errorf("Unexpected '%s' at position %d", t.text, t.position)
+	}
+}
+
+func parseIdentifier(t token) (node, error) {
+	switch t.text {
+	case "true":
+		return literalNode{value: true}, nil
+	case "false":
+		return literalNode{value: false}, nil
+	case "null":
+		return literalNode{value: nil}, nil
+	}
+	parts := strings.Split(t.text, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("Invalid reference '%s' at position %d", t.text, t.position)
+		}
+	}
+	switch parts[0] {
+	case referencePayload:
+		return referenceNode{source: referencePayload, path: parts[1:]}, nil
+	case referenceMetadata:
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("Metadata reference needs a key at position %d", t.position)
+		}
+		return referenceNode{source: referenceMetadata, path: []string{strings.Join(parts[1:], ".")}}, nil
+	case referenceOrigin:
+		if len(parts) != 2 || (parts[1] != "route" && parts[1] != "type" && parts[1] != "identity") {
+			return nil, fmt.Errorf("Origin reference must be origin.route, origin.type or origin.identity at position %d", t.position)
+		}
+		return referenceNode{source: referenceOrigin, path: parts[1:]}, nil
+	default:
+		return nil, fmt.Errorf("Unknown reference '%s' at position %d. References start with payload, metadata or origin", t.text, t.position)
+	}
+}
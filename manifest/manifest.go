@@ -0,0 +1,391 @@
+// Package manifest describes routing as one document of Flows, the Paths that trigger them and any other Paths, so it can be kept in git.
+// A manifest is exported from storage, and a Plan reconciles storage to match a manifest by creating, updating and deleting Paths, Flows and
+// trigger links. Flows are identified by namespace and name, and Paths by namespace, type and route.
+// The Path, waitFor and triggeredBy of a Flow are in the namespace of the Flow unless they name another, so a Path of the default namespace cannot be
+// written as triggering a Flow in another namespace. Those links are left out of exports and left alone by Plans.
+// A manifest can also be exported and planned for one namespace. Then only the Flows and Paths of that namespace are read and changed,
+// and links with Paths or Flows of other namespaces are left out and left alone the same way.
+// The identity and metadata of the Path and waitFor of a Flow are what the Flow sends with, while those of other Paths, and the labels of every Path,
+// are kept on the stored Path
+//
+//	flows:
+//	  - name: Kitchen light
+//	    description: Turns on the kitchen light
+//	    path: {route: lights/kitchen, type: MQTT}
+//	    condition: payload.motion == true
+//	    labels: {owner: iot, env: prod}
+//	    triggeredBy:
+//	      - {route: sensors/kitchen/motion, type: MQTT}
+//
+// JSON documents are read the same way
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/edfungus/conduction/messenger"
+	"github.com/edfungus/conduction/storage"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatYAML string = "yaml"
+	FormatJSON string = "json"
+)
+
+var (
+	ErrUnknownFormat     error = fmt.Errorf("Unknown manifest format. Expected %s or %s", FormatYAML, FormatJSON)
+	ErrFlowMissingName   error = fmt.Errorf("Manifest has a Flow without a name")
+	ErrDuplicateFlowName error = fmt.Errorf("Manifest has more than one Flow with the same name in a namespace")
+)
+
+// Manifest is every Flow with the Paths that trigger it, and the Paths no Flow sends to, waits on or is triggered by,
+// or whose identity or metadata no Flow shows
+type Manifest struct {
+	Paths []Path `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Flows []Flow `json:"flows" yaml:"flows"`
+}
+
+// Path is a Path without the fields that are only set on messages. Metadata values are written as text
+type Path struct {
+	Route     string            `json:"route" yaml:"route"`
+	Type      string            `json:"type" yaml:"type"`
+	Namespace string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Identity  string            `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// pathID is what identifies a Path in a manifest
+type pathID struct {
+	namespace string
+	pathType  string
+	route     string
+}
+
+// Flow is a storage.Flow and the Paths that trigger it
+type Flow struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description" yaml:"description"`
+	Path        Path              `json:"path" yaml:"path"`
+	Wait        bool              `json:"wait,omitempty" yaml:"wait,omitempty"`
+	Aggregate   bool              `json:"aggregate,omitempty" yaml:"aggregate,omitempty"`
+	WaitFor     *Path             `json:"waitFor,omitempty" yaml:"waitFor,omitempty"`
+	Condition   string            `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Transform   string            `json:"transform,omitempty" yaml:"transform,omitempty"`
+	TriggeredBy []Path            `json:"triggeredBy,omitempty" yaml:"triggeredBy,omitempty"`
+	Namespace   string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	SharedWith  []string          `json:"sharedWith,omitempty" yaml:"sharedWith,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Disabled    bool              `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// Parse reads a YAML or JSON manifest and checks that every Flow has a name unique within its namespace
+func Parse(document []byte) (Manifest, error) {
+	var manifest Manifest
+	err := yaml.Unmarshal(document, &manifest)
+	if err != nil {
+		return Manifest{}, err
+	}
+	ids := make(map[flowID]bool)
+	for _, flow := range manifest.Flows {
+		if flow.Name == "" {
+			return Manifest{}, ErrFlowMissingName
+		}
+		if ids[flow.id()] {
+			return Manifest{}, fmt.Errorf("%v. %s", ErrDuplicateFlowName, flow.id())
+		}
+		ids[flow.id()] = true
+	}
+	return manifest, nil
+}
+
+// Encode returns the manifest as a document in the format
+func (m Manifest) Encode(format string) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(m)
+	case FormatJSON:
+		return json.MarshalIndent(m, "", "  ")
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+// InNamespace returns the part of the manifest in the namespace: its Flows and its other Paths
+func (m Manifest) InNamespace(namespace string) Manifest {
+	scoped := Manifest{
+		Flows: []Flow{},
+	}
+	for _, path := range m.Paths {
+		if path.Namespace == namespace {
+			scoped.Paths = append(scoped.Paths, path)
+		}
+	}
+	for _, flow := range m.Flows {
+		if flow.Namespace == namespace {
+			scoped.Flows = append(scoped.Flows, flow)
+		}
+	}
+	return scoped
+}
+
+// IntoNamespace puts the Flows and Paths of the manifest that name no namespace into the namespace. Returns ErrOutsideNamespace if any name another
+func (m Manifest) IntoNamespace(namespace string) (Manifest, error) {
+	scoped := Manifest{
+		Flows: []Flow{},
+	}
+	for _, path := range m.Paths {
+		if path.Namespace == "" {
+			path.Namespace = namespace
+		}
+		scoped.Paths = append(scoped.Paths, path)
+	}
+	ids := make(map[flowID]bool)
+	for _, flow := range m.Flows {
+		if flow.Namespace == "" {
+			flow.Namespace = namespace
+		}
+		if ids[flow.id()] {
+			return Manifest{}, fmt.Errorf("%v. %s", ErrDuplicateFlowName, flow.id())
+		}
+		ids[flow.id()] = true
+		scoped.Flows = append(scoped.Flows, flow)
+	}
+	for _, path := range scoped.paths() {
+		if path.Namespace != namespace {
+			return Manifest{}, fmt.Errorf("%v. %s", ErrOutsideNamespace, path)
+		}
+	}
+	for _, flow := range scoped.Flows {
+		if flow.Namespace != namespace {
+			return Manifest{}, fmt.Errorf("%v. %s", ErrOutsideNamespace, flow.id())
+		}
+	}
+	return scoped, nil
+}
+
+// StorageFlow returns the Flow as it is saved to storage
+func (f Flow) StorageFlow() storage.Flow {
+	flow := storage.Flow{
+		Name:        f.Name,
+		Description: f.Description,
+		Path:        f.inNamespace(f.Path).messengerPath(),
+		Wait:        f.Wait,
+		Aggregate:   f.Aggregate,
+		Condition:   f.Condition,
+		Transform:   f.Transform,
+		Namespace:   f.Namespace,
+		SharedWith:  sortedNamespaces(f.SharedWith),
+		Labels:      labelsOrNil(f.Labels),
+		Disabled:    f.Disabled,
+	}
+	if f.WaitFor != nil {
+		flow.WaitFor = f.inNamespace(*f.WaitFor).messengerPath()
+	}
+	return flow
+}
+
+// inNamespace returns the Path in the namespace of the Flow if it names none
+func (f Flow) inNamespace(path Path) Path {
+	if path.Namespace == "" {
+		path.Namespace = f.Namespace
+	}
+	return path
+}
+
+// Triggers returns the Paths that trigger the Flow, each in the namespace of the Flow if it names none
+func (f Flow) Triggers() []Path {
+	var triggers []Path
+	for _, path := range f.TriggeredBy {
+		triggers = append(triggers, f.inNamespace(path))
+	}
+	return triggers
+}
+
+// String returns the type and route of the Path, and its namespace if it has one
+func (p Path) String() string {
+	if p.Namespace != "" {
+		return fmt.Sprintf("%s %s in %s", p.Type, p.Route, p.Namespace)
+	}
+	return fmt.Sprintf("%s %s", p.Type, p.Route)
+}
+
+func (p Path) id() pathID {
+	return pathID{namespace: p.Namespace, pathType: p.Type, route: p.Route}
+}
+
+func (p Path) messengerPath() *messenger.Path {
+	return &messenger.Path{
+		Route:     p.Route,
+		Type:      p.Type,
+		Namespace: p.Namespace,
+		Identity:  p.Identity,
+		Metadata:  metadataBytes(p.Metadata),
+		Labels:    labelsOrNil(p.Labels),
+	}
+}
+
+// withoutDetails returns the Path without the identity and metadata, which on the Path of a Flow belong to the Flow
+func (p Path) withoutDetails() Path {
+	p.Identity = ""
+	p.Metadata = nil
+	return p
+}
+
+// withDetailsOf fills in the identity and metadata, and the labels, the Path leaves out from another mention of it
+func (p Path) withDetailsOf(other Path) Path {
+	if p.Identity == "" && len(p.Metadata) == 0 {
+		p.Identity = other.Identity
+		p.Metadata = other.Metadata
+	}
+	if len(p.Labels) == 0 {
+		p.Labels = other.Labels
+	}
+	return p
+}
+
+// sameDetails returns whether the Paths have the same identity, metadata and labels
+func (p Path) sameDetails(other Path) bool {
+	return p.Identity == other.Identity && reflect.DeepEqual(p.Metadata, other.Metadata) && reflect.DeepEqual(p.Labels, other.Labels)
+}
+
+// changes returns whether saving the Path would change the stored Path. Like storage, a Path without identity or metadata leaves them alone,
+// and one without labels leaves its labels alone
+func (p Path) changes(stored Path) bool {
+	if (p.Identity != "" || len(p.Metadata) > 0) && (p.Identity != stored.Identity || !reflect.DeepEqual(p.Metadata, stored.Metadata)) {
+		return true
+	}
+	return len(p.Labels) > 0 && !reflect.DeepEqual(p.Labels, stored.Labels)
+}
+
+// newFlow returns the storage.Flow as it is written in a manifest. Its Path, waitFor and triggeredBy leave out the namespace they share with the Flow
+func newFlow(flow storage.Flow, triggeredBy []Path) Flow {
+	for i := range triggeredBy {
+		if triggeredBy[i].Namespace == flow.Namespace {
+			triggeredBy[i].Namespace = ""
+		}
+	}
+	f := Flow{
+		Name:        flow.Name,
+		Description: flow.Description,
+		Path:        newPathOfFlow(flow, *flow.Path),
+		Wait:        flow.Wait,
+		Aggregate:   flow.Aggregate,
+		Condition:   flow.Condition,
+		Transform:   flow.Transform,
+		TriggeredBy: triggeredBy,
+		Namespace:   flow.Namespace,
+		SharedWith:  sortedNamespaces(flow.SharedWith),
+		Labels:      labelsOrNil(flow.Labels),
+		Disabled:    flow.Disabled,
+	}
+	if flow.WaitFor != nil {
+		waitFor := newPathOfFlow(flow, *flow.WaitFor)
+		f.WaitFor = &waitFor
+	}
+	return f
+}
+
+// sortedNamespaces returns a sorted copy of the namespaces, or nil if there are none, so Flows sharing with the same namespaces compare equal
+func sortedNamespaces(namespaces []string) []string {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	sorted := append([]string{}, namespaces...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// labelsOrNil returns nil for empty labels, so Flows and Paths without labels compare equal however they were read
+func labelsOrNil(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+func newPathOfFlow(flow storage.Flow, path messenger.Path) Path {
+	p := newPath(path)
+	if p.Namespace == flow.Namespace {
+		p.Namespace = ""
+	}
+	return p
+}
+
+func newPath(path messenger.Path) Path {
+	return Path{
+		Route:     path.Route,
+		Type:      path.Type,
+		Namespace: path.Namespace,
+		Identity:  path.Identity,
+		Metadata:  metadataText(path.Metadata),
+		Labels:    labelsOrNil(path.Labels),
+	}
+}
+
+// metadataText returns the metadata with its values as text, or nil if there is none
+func metadataText(metadata map[string][]byte) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	text := make(map[string]string)
+	for key, value := range metadata {
+		text[key] = string(value)
+	}
+	return text
+}
+
+func metadataBytes(metadata map[string]string) map[string][]byte {
+	if len(metadata) == 0 {
+		return nil
+	}
+	bytes := make(map[string][]byte)
+	for key, value := range metadata {
+		bytes[key] = []byte(value)
+	}
+	return bytes
+}
+
+// paths returns every Path the manifest names, explicitly or through its Flows, once each. The details of a Path are those of the first mention giving any,
+// leaving out the identity and metadata of Flows
+func (m Manifest) paths() []Path {
+	seen := make(map[pathID]int)
+	var paths []Path
+	add := func(path Path) {
+		if i, ok := seen[path.id()]; ok {
+			paths[i] = paths[i].withDetailsOf(path)
+			return
+		}
+		seen[path.id()] = len(paths)
+		paths = append(paths, path)
+	}
+	for _, path := range m.Paths {
+		add(path)
+	}
+	for _, flow := range m.Flows {
+		add(flow.inNamespace(flow.Path).withoutDetails())
+		if flow.WaitFor != nil {
+			add(flow.inNamespace(*flow.WaitFor).withoutDetails())
+		}
+		for _, path := range flow.Triggers() {
+			add(path)
+		}
+	}
+	return paths
+}
+
+func sortPaths(paths []Path) {
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Namespace != paths[j].Namespace {
+			return paths[i].Namespace < paths[j].Namespace
+		}
+		if paths[i].Type != paths[j].Type {
+			return paths[i].Type < paths[j].Type
+		}
+		return paths[i].Route < paths[j].Route
+	})
+}
@@ -0,0 +1,369 @@
+// +build all integration
+
+package manifest
+
+import (
+	"os"
+
+	"github.com/edfungus/conduction/messenger"
+	"github.com/edfungus/conduction/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Manifest", func() {
+		var (
+			graph *storage.GraphStorage
+		)
+		BeforeEach(func() {
+			var err error
+			os.Create(tempFilePath)
+			graph, err = storage.NewGraphStorageBolt(tempFilePath)
+			Expect(err).To(BeNil())
+		})
+		AfterEach(func() {
+			graph.Close()
+			os.Remove(tempFilePath)
+		})
+		kitchen := Flow{
+			Name:        "Kitchen light",
+			Description: "Turns on the kitchen light",
+			Path:        Path{Route: "lights/kitchen", Type: "MQTT"},
+			Condition:   "payload.motion == true",
+			TriggeredBy: []Path{{Route: "sensors/kitchen/motion", Type: "MQTT"}},
+		}
+		hallway := Flow{
+			Name:        "Hallway light",
+			Description: "Turns on the hallway light",
+			Path:        Path{Route: "lights/hallway", Type: "MQTT"},
+			TriggeredBy: []Path{{Route: "sensors/+/motion", Type: "MQTT"}},
+		}
+		Describe("Given exporting storage", func() {
+			Context("When it has Flows, triggers and a Path no Flow uses", func() {
+				It("Then should return a manifest of all of them", func() {
+					flowKey, err := graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+					triggerKey, err := graph.SavePath(messenger.Path{Route: "sensors/kitchen/motion", Type: "MQTT"})
+					Expect(err).To(BeNil())
+					Expect(graph.ChainNextFlowToPath(flowKey, triggerKey)).To(BeNil())
+					_, err = graph.SavePath(messenger.Path{Route: "lights/unused", Type: "MQTT"})
+					Expect(err).To(BeNil())
+
+					m, err := Export(graph)
+					Expect(err).To(BeNil())
+					Expect(m).To(Equal(Manifest{
+						Paths: []Path{{Route: "lights/unused", Type: "MQTT"}},
+						Flows: []Flow{kitchen},
+					}))
+				})
+			})
+		})
+		Describe("Given exporting and applying Paths with identities and metadata", func() {
+			Context("When Flows send to one Path with their own metadata and the Path has its own", func() {
+				It("Then should export each and plan no changes for the export", func() {
+					bright := kitchen
+					bright.Path.Identity = "kitchen"
+					bright.Path.Metadata = map[string]string{"qos": "1"}
+					dim := Flow{
+						Name:        "Dim kitchen light",
+						Description: "Dims the kitchen light",
+						Path:        Path{Route: "lights/kitchen", Type: "MQTT", Metadata: map[string]string{"qos": "2"}},
+					}
+					stored := Path{Route: "lights/kitchen", Type: "MQTT", Metadata: map[string]string{"retain": "true"}}
+					m := Manifest{
+						Paths: []Path{stored},
+						Flows: []Flow{dim, bright},
+					}
+					plan, err := NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					exported, err := Export(graph)
+					Expect(err).To(BeNil())
+					Expect(exported).To(Equal(m))
+					plan, err = NewPlan(graph, exported)
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+
+					cleared := bright
+					cleared.Path = Path{Route: "lights/kitchen", Type: "MQTT"}
+					stored.Metadata = map[string]string{"retain": "false"}
+					plan, err = NewPlan(graph, Manifest{Paths: []Path{stored}, Flows: []Flow{dim, cleared}})
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"update path MQTT lights/kitchen",
+						"update flow Kitchen light",
+					}))
+					Expect(plan.Apply(graph)).To(BeNil())
+					exported, err = Export(graph)
+					Expect(err).To(BeNil())
+					Expect(exported).To(Equal(Manifest{Paths: []Path{stored}, Flows: []Flow{dim, cleared}}))
+				})
+			})
+		})
+		Describe("Given exporting and applying Paths with labels", func() {
+			Context("When the labels of a Path differ from storage", func() {
+				It("Then should plan to update the Path but not the Flows sending to it", func() {
+					labelled := kitchen
+					labelled.Path.Labels = map[string]string{"room": "kitchen"}
+					labelled.TriggeredBy = []Path{{Route: "sensors/kitchen/motion", Type: "MQTT", Labels: map[string]string{"kind": "motion"}}}
+					m := Manifest{Flows: []Flow{labelled}}
+					plan, err := NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					exported, err := Export(graph)
+					Expect(err).To(BeNil())
+					Expect(exported).To(Equal(m))
+
+					// Leaving out labels leaves the stored labels alone
+					plan, err = NewPlan(graph, Manifest{Flows: []Flow{kitchen}})
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+
+					relabelled := labelled
+					relabelled.Path.Labels = map[string]string{"room": "kitchen", "floor": "ground"}
+					plan, err = NewPlan(graph, Manifest{Flows: []Flow{relabelled}})
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{"update path MQTT lights/kitchen"}))
+					Expect(plan.Apply(graph)).To(BeNil())
+					exported, err = Export(graph)
+					Expect(err).To(BeNil())
+					Expect(exported.Flows[0].Path.Labels).To(Equal(relabelled.Path.Labels))
+				})
+			})
+		})
+		Describe("Given planning a manifest", func() {
+			Context("When storage is empty", func() {
+				It("Then should create the Paths, then the Flows, then the links", func() {
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{kitchen}})
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"create path MQTT lights/kitchen",
+						"create path MQTT sensors/kitchen/motion",
+						"create flow Kitchen light",
+						"create link from MQTT sensors/kitchen/motion to Kitchen light",
+					}))
+				})
+			})
+			Context("When storage already matches the manifest", func() {
+				It("Then should have no changes", func() {
+					m := Manifest{Flows: []Flow{kitchen, hallway}}
+					plan, err := NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					plan, err = NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+				})
+			})
+			Context("When storage has two Flows with the same name", func() {
+				It("Then should return an error", func() {
+					_, err := graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+					_, err = graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+					_, err = NewPlan(graph, Manifest{})
+					Expect(err).To(Equal(ErrAmbiguousFlowName))
+				})
+			})
+			Context("When Flows in different namespaces have the same name", func() {
+				It("Then should plan each Flow in its own namespace", func() {
+					garage := kitchen
+					garage.Namespace = "garage"
+					garage.Path.Namespace = "garage"
+					garage.TriggeredBy = nil
+					_, err := graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{kitchen, garage}})
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"create path MQTT sensors/kitchen/motion",
+						"create path MQTT lights/kitchen in garage",
+						"create flow Kitchen light in garage",
+						"create link from MQTT sensors/kitchen/motion to Kitchen light",
+					}))
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					plan, err = NewPlan(graph, Manifest{Flows: []Flow{kitchen, garage}})
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+				})
+			})
+		})
+		Describe("Given planning and exporting a manifest for one namespace", func() {
+			garage := Flow{
+				Name:        "Garage light",
+				Description: "Turns on the garage light",
+				Path:        Path{Route: "lights/garage", Type: "MQTT"},
+				TriggeredBy: []Path{{Route: "sensors/garage/motion", Type: "MQTT"}},
+			}
+			Context("When other namespaces have Flows and Paths missing from the manifest", func() {
+				It("Then should only change and export the namespace", func() {
+					_, err := graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+					_, err = graph.SaveFlow(kitchen.StorageFlow())
+					Expect(err).To(BeNil())
+
+					plan, err := NewNamespacePlan(graph, Manifest{Flows: []Flow{garage}}, "garage")
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"create path MQTT lights/garage in garage",
+						"create path MQTT sensors/garage/motion in garage",
+						"create flow Garage light in garage",
+						"create link from MQTT sensors/garage/motion in garage to Garage light in garage",
+					}))
+					Expect(plan.Apply(graph)).To(BeNil())
+					flows, _, _, err := graph.ListFlows(storage.ListOptions{})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(3))
+
+					m, err := ExportNamespace(graph, "garage")
+					Expect(err).To(BeNil())
+					scoped, err := Manifest{Flows: []Flow{garage}}.IntoNamespace("garage")
+					Expect(err).To(BeNil())
+					Expect(m).To(Equal(scoped))
+
+					plan, err = NewNamespacePlan(graph, m, "garage")
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+				})
+			})
+			Context("When the namespace is empty", func() {
+				It("Then should return an error", func() {
+					_, err := NewNamespacePlan(graph, Manifest{}, "")
+					Expect(err).To(Equal(ErrOutsideNamespace))
+					_, err = ExportNamespace(graph, "")
+					Expect(err).To(Equal(ErrOutsideNamespace))
+				})
+			})
+		})
+		Describe("Given a Flow in a namespace triggered by a Path that names none", func() {
+			garage := Flow{
+				Name:        "Garage light",
+				Description: "Turns on the garage light",
+				Namespace:   "garage",
+				Path:        Path{Route: "lights/garage", Type: "MQTT"},
+				TriggeredBy: []Path{{Route: "sensors/garage/motion", Type: "MQTT"}},
+			}
+			Context("When the manifest is planned, applied and exported", func() {
+				It("Then the Path should be in the namespace of the Flow and be exported without it", func() {
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{garage}})
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"create path MQTT lights/garage in garage",
+						"create path MQTT sensors/garage/motion in garage",
+						"create flow Garage light in garage",
+						"create link from MQTT sensors/garage/motion in garage to Garage light in garage",
+					}))
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					m, err := Export(graph)
+					Expect(err).To(BeNil())
+					Expect(m).To(Equal(Manifest{Flows: []Flow{garage}}))
+				})
+			})
+			Context("When a Path of the default namespace also triggers the Flow", func() {
+				It("Then the link should be left alone", func() {
+					shared := garage
+					shared.SharedWith = []string{storage.AllNamespaces}
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{shared}})
+					Expect(err).To(BeNil())
+					Expect(plan.Apply(graph)).To(BeNil())
+					_, flowKeys, _, err := graph.ListFlows(storage.ListOptions{})
+					Expect(err).To(BeNil())
+					flowKey := flowKeys[0]
+					pathKey, err := graph.SavePath(messenger.Path{Route: "sensors/garage/motion", Type: "MQTT"})
+					Expect(err).To(BeNil())
+					Expect(graph.ChainNextFlowToPath(flowKey, pathKey)).To(BeNil())
+
+					m, err := Export(graph)
+					Expect(err).To(BeNil())
+					plan, err = NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					Expect(plan.Changes).To(BeEmpty())
+				})
+			})
+		})
+		Describe("Given applying a manifest", func() {
+			Context("When Flows, links and Paths are changed or removed", func() {
+				It("Then should make storage match the manifest", func() {
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{kitchen, hallway}})
+					Expect(err).To(BeNil())
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					changed := kitchen
+					changed.Description = "Turns on the kitchen light at night"
+					changed.TriggeredBy = []Path{{Route: "sensors/kitchen/door", Type: "MQTT"}}
+					m := Manifest{Flows: []Flow{changed}}
+					plan, err = NewPlan(graph, m)
+					Expect(err).To(BeNil())
+					var changes []string
+					for _, change := range plan.Changes {
+						changes = append(changes, change.String())
+					}
+					Expect(changes).To(Equal([]string{
+						"delete link from MQTT sensors/kitchen/motion to Kitchen light",
+						"delete flow Hallway light",
+						"create path MQTT sensors/kitchen/door",
+						"update flow Kitchen light",
+						"create link from MQTT sensors/kitchen/door to Kitchen light",
+						"delete path MQTT lights/hallway",
+						"delete path MQTT sensors/+/motion",
+						"delete path MQTT sensors/kitchen/motion",
+					}))
+					Expect(plan.Apply(graph)).To(BeNil())
+
+					exported, err := Export(graph)
+					Expect(err).To(BeNil())
+					Expect(exported).To(Equal(m))
+				})
+			})
+			Context("When a Change fails", func() {
+				It("Then should return which Change failed", func() {
+					looping := Flow{
+						Name:        "Loop",
+						Description: "Sends to the Path that triggers it",
+						Path:        Path{Route: "loop", Type: "MQTT"},
+						TriggeredBy: []Path{{Route: "loop", Type: "MQTT"}},
+					}
+					plan, err := NewPlan(graph, Manifest{Flows: []Flow{looping}})
+					Expect(err).To(BeNil())
+					err = plan.Apply(graph)
+					changeErr, ok := err.(*ChangeError)
+					Expect(ok).To(BeTrue())
+					Expect(changeErr.Change.Kind).To(Equal(KindLink))
+					Expect(changeErr.Err).To(Equal(storage.ErrChainCreatesCycle))
+					Expect(changeErr.Applied).To(Equal(plan.Changes[:len(plan.Changes)-1]))
+				})
+			})
+		})
+	})
+})
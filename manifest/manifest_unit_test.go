@@ -0,0 +1,143 @@
+// +build all unit
+
+package manifest
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Manifest", func() {
+		document := []byte(`
+paths:
+  - {route: lights/unused, type: MQTT, metadata: {retain: "true"}, labels: {owner: iot}}
+flows:
+  - name: Kitchen light
+    description: Turns on the kitchen light
+    path: {route: lights/kitchen, type: MQTT, identity: kitchen, metadata: {qos: "1"}}
+    condition: payload.motion == true
+    labels: {owner: iot}
+    triggeredBy:
+      - {route: sensors/kitchen/motion, type: MQTT}
+`)
+		Describe("Given parsing a manifest", func() {
+			Context("When it is YAML", func() {
+				It("Then should read its Paths, Flows and triggers", func() {
+					m, err := Parse(document)
+					Expect(err).To(BeNil())
+					Expect(m.Paths).To(Equal([]Path{{
+						Route:    "lights/unused",
+						Type:     "MQTT",
+						Metadata: map[string]string{"retain": "true"},
+						Labels:   map[string]string{"owner": "iot"},
+					}}))
+					Expect(m.Flows).To(HaveLen(1))
+					Expect(m.Flows[0].Name).To(Equal("Kitchen light"))
+					Expect(m.Flows[0].Path).To(Equal(Path{Route: "lights/kitchen", Type: "MQTT", Identity: "kitchen", Metadata: map[string]string{"qos": "1"}}))
+					Expect(m.Flows[0].Condition).To(Equal("payload.motion == true"))
+					Expect(m.Flows[0].Labels).To(Equal(map[string]string{"owner": "iot"}))
+					Expect(m.Flows[0].TriggeredBy).To(Equal([]Path{{Route: "sensors/kitchen/motion", Type: "MQTT"}}))
+				})
+			})
+			Context("When it is JSON", func() {
+				It("Then should read it the same as YAML", func() {
+					m, err := Parse([]byte(`{"flows": [{"name": "Kitchen light", "description": "d", "path": {"route": "lights/kitchen", "type": "MQTT"}}]}`))
+					Expect(err).To(BeNil())
+					Expect(m.Flows[0].Path.Route).To(Equal("lights/kitchen"))
+				})
+			})
+			Context("When a Flow has no name", func() {
+				It("Then should return an error", func() {
+					_, err := Parse([]byte(`flows: [{description: d}]`))
+					Expect(err).To(Equal(ErrFlowMissingName))
+				})
+			})
+			Context("When two Flows have the same name", func() {
+				It("Then should return an error", func() {
+					_, err := Parse([]byte(`flows: [{name: a}, {name: a}]`))
+					Expect(err).ToNot(BeNil())
+					Expect(err.Error()).To(HavePrefix(ErrDuplicateFlowName.Error()))
+				})
+			})
+			Context("When two Flows have the same name in different namespaces", func() {
+				It("Then should return both Flows", func() {
+					m, err := Parse([]byte(`flows: [{name: a}, {name: a, namespace: garage}]`))
+					Expect(err).To(BeNil())
+					Expect(m.Flows).To(HaveLen(2))
+				})
+			})
+		})
+		Describe("Given encoding a manifest", func() {
+			Context("When the format is YAML or JSON", func() {
+				It("Then should parse back to the same manifest", func() {
+					m, err := Parse(document)
+					Expect(err).To(BeNil())
+					for _, format := range []string{FormatYAML, FormatJSON} {
+						encoded, err := m.Encode(format)
+						Expect(err).To(BeNil())
+						decoded, err := Parse(encoded)
+						Expect(err).To(BeNil())
+						Expect(decoded).To(Equal(m))
+					}
+				})
+			})
+			Context("When the format is unknown", func() {
+				It("Then should return an error", func() {
+					_, err := Manifest{}.Encode("toml")
+					Expect(err).To(Equal(ErrUnknownFormat))
+				})
+			})
+		})
+		Describe("Given putting a manifest into a namespace", func() {
+			Context("When its Flows and Paths name no namespace", func() {
+				It("Then should put them in the namespace", func() {
+					m, err := Parse([]byte(`{paths: [{route: spare, type: MQTT}], flows: [{name: a, path: {route: out, type: MQTT}}]}`))
+					Expect(err).To(BeNil())
+					scoped, err := m.IntoNamespace("garage")
+					Expect(err).To(BeNil())
+					Expect(scoped.Paths).To(Equal([]Path{{Route: "spare", Type: "MQTT", Namespace: "garage"}}))
+					Expect(scoped.Flows[0].Namespace).To(Equal("garage"))
+				})
+			})
+			Context("When a Flow or Path names another namespace", func() {
+				It("Then should return an error", func() {
+					for _, document := range []string{
+						`flows: [{name: a, namespace: kitchen}]`,
+						`paths: [{route: spare, type: MQTT, namespace: kitchen}]`,
+						`flows: [{name: a, triggeredBy: [{route: in, type: MQTT, namespace: kitchen}]}]`,
+					} {
+						m, err := Parse([]byte(document))
+						Expect(err).To(BeNil())
+						_, err = m.IntoNamespace("garage")
+						Expect(err).ToNot(BeNil())
+						Expect(err.Error()).To(HavePrefix(ErrOutsideNamespace.Error()))
+					}
+				})
+			})
+			Context("When a Flow naming the namespace has the name of one naming none", func() {
+				It("Then should return an error", func() {
+					m, err := Parse([]byte(`flows: [{name: a}, {name: a, namespace: garage}]`))
+					Expect(err).To(BeNil())
+					_, err = m.IntoNamespace("garage")
+					Expect(err).ToNot(BeNil())
+					Expect(err.Error()).To(HavePrefix(ErrDuplicateFlowName.Error()))
+				})
+			})
+		})
+		Describe("Given the Paths of a manifest", func() {
+			Context("When Flows send to, wait on and are triggered by Paths", func() {
+				It("Then should include each Path once, with the details of its first mention outside the Paths of Flows", func() {
+					m, err := Parse(document)
+					Expect(err).To(BeNil())
+					m.Flows[0].TriggeredBy = append(m.Flows[0].TriggeredBy, Path{Route: "lights/kitchen", Type: "MQTT", Identity: "lights"})
+					Expect(m.paths()).To(Equal([]Path{
+						{Route: "lights/unused", Type: "MQTT", Metadata: map[string]string{"retain": "true"}, Labels: map[string]string{"owner": "iot"}},
+						{Route: "lights/kitchen", Type: "MQTT", Identity: "lights"},
+						{Route: "sensors/kitchen/motion", Type: "MQTT"},
+					}))
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,411 @@
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/edfungus/conduction/storage"
+)
+
+const (
+	ActionCreate string = "create"
+	ActionUpdate string = "update"
+	ActionDelete string = "delete"
+
+	KindPath string = "path"
+	KindFlow string = "flow"
+	KindLink string = "link" // A Path triggering a Flow
+)
+
+var (
+	ErrAmbiguousFlowName error = fmt.Errorf("Storage has more than one Flow with the same name in a namespace so it cannot be matched to the manifest")
+	ErrOutsideNamespace  error = fmt.Errorf("Manifest has a Flow or Path outside the namespace it is planned for")
+)
+
+// Plan is the changes that make storage match a manifest, in the order they are applied
+type Plan struct {
+	Changes  []Change `json:"changes"`
+	flowKeys map[flowID]storage.Key
+	pathKeys map[pathID]storage.Key
+}
+
+// flowID is what identifies a Flow in a manifest. Flows in different namespaces may share a name
+type flowID struct {
+	namespace string
+	name      string
+}
+
+// Change creates, updates or deletes one Path, Flow or link
+type Change struct {
+	Action    string `json:"action"`
+	Kind      string `json:"kind"`
+	Flow      string `json:"flow,omitempty"`      // Name of the Flow changed, or linked to Path
+	Namespace string `json:"namespace,omitempty"` // Namespace of the Flow
+	Path      *Path  `json:"path,omitempty"`      // Path changed, or linked to Flow
+	flow      storage.Flow
+}
+
+// ChangeError is the Change that could not be applied and why, along with the Changes applied before it, which are left made
+type ChangeError struct {
+	Change  Change
+	Err     error
+	Applied []Change
+}
+
+func (ce *ChangeError) Error() string {
+	return fmt.Sprintf("Could not %s. %v", ce.Change, ce.Err)
+}
+
+// String describes the Change in one line
+func (c Change) String() string {
+	switch c.Kind {
+	case KindLink:
+		return fmt.Sprintf("%s link from %s to %s", c.Action, c.Path, c.flowID())
+	case KindPath:
+		return fmt.Sprintf("%s path %s", c.Action, c.Path)
+	default:
+		return fmt.Sprintf("%s flow %s", c.Action, c.flowID())
+	}
+}
+
+func (c Change) flowID() flowID {
+	return flowID{namespace: c.Namespace, name: c.Flow}
+}
+
+// String returns the name of the Flow, and its namespace if it has one
+func (id flowID) String() string {
+	if id.namespace != "" {
+		return fmt.Sprintf("%s in %s", id.name, id.namespace)
+	}
+	return id.name
+}
+
+func (f Flow) id() flowID {
+	return flowID{namespace: f.Namespace, name: f.Name}
+}
+
+func idOfStorageFlow(flow storage.Flow) flowID {
+	return flowID{namespace: flow.Namespace, name: flow.Name}
+}
+
+// change returns a Change to the Flow of the id
+func (id flowID) change(action string, kind string) Change {
+	return Change{Action: action, Kind: kind, Flow: id.name, Namespace: id.namespace}
+}
+
+// state is what storage holds, keyed the way a manifest identifies things
+type state struct {
+	flows    map[flowID]storage.Flow
+	flowKeys map[flowID]storage.Key
+	paths    map[pathID]Path
+	pathKeys map[pathID]storage.Key
+	triggers map[flowID]map[pathID]Path // Flow to the Paths that trigger it
+}
+
+// Export returns a manifest of everything in storage
+func Export(s storage.Storage) (Manifest, error) {
+	return export(s, "")
+}
+
+// ExportNamespace returns a manifest of the Flows and Paths in the namespace and the links between them. Only the namespace is read,
+// so other namespaces cannot make it fail
+func ExportNamespace(s storage.Storage, namespace string) (Manifest, error) {
+	if namespace == "" {
+		return Manifest{}, ErrOutsideNamespace
+	}
+	return export(s, namespace)
+}
+
+// export returns a manifest of the namespace, or of everything when namespace is empty
+func export(s storage.Storage, namespace string) (Manifest, error) {
+	st, err := readState(s, namespace)
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest := Manifest{
+		Flows: []Flow{},
+	}
+	for _, id := range sortedFlowIDs(st.flows) {
+		manifest.Flows = append(manifest.Flows, newFlow(st.flows[id], sortedPathSet(st.triggers[id])))
+	}
+	// Paths no Flow uses are listed, and so are Paths with an identity or metadata their Flows do not show
+	named := make(map[pathID]Path)
+	for _, path := range manifest.paths() {
+		named[path.id()] = path
+	}
+	for id, path := range st.paths {
+		if mention, ok := named[id]; !ok || !mention.sameDetails(path) {
+			manifest.Paths = append(manifest.Paths, path)
+		}
+	}
+	sortPaths(manifest.Paths)
+	return manifest, nil
+}
+
+// NewPlan compares storage to the manifest and returns the changes that would make them match. Nothing is changed until the Plan is applied
+func NewPlan(s storage.Storage, manifest Manifest) (Plan, error) {
+	st, err := readState(s, "")
+	if err != nil {
+		return Plan{}, err
+	}
+	return newPlan(st, manifest), nil
+}
+
+// NewNamespacePlan is NewPlan for one namespace. Only the Flows and Paths of the namespace are compared, so nothing in other namespaces is changed or deleted.
+// Flows and Paths of the manifest that name no namespace are put in it, and ErrOutsideNamespace is returned if any name another
+func NewNamespacePlan(s storage.Storage, manifest Manifest, namespace string) (Plan, error) {
+	if namespace == "" {
+		return Plan{}, ErrOutsideNamespace
+	}
+	manifest, err := manifest.IntoNamespace(namespace)
+	if err != nil {
+		return Plan{}, err
+	}
+	st, err := readState(s, namespace)
+	if err != nil {
+		return Plan{}, err
+	}
+	return newPlan(st, manifest), nil
+}
+
+// newPlan returns the changes that make the state read from storage match the manifest
+func newPlan(st state, manifest Manifest) Plan {
+	plan := Plan{
+		Changes:  []Change{},
+		flowKeys: st.flowKeys,
+		pathKeys: st.pathKeys,
+	}
+	desired := make(map[flowID]Flow)
+	for _, flow := range manifest.Flows {
+		desired[flow.id()] = flow
+	}
+	desiredTriggers := make(map[flowID]map[pathID]Path)
+	for _, flow := range manifest.Flows {
+		desiredTriggers[flow.id()] = make(map[pathID]Path)
+		for _, path := range flow.Triggers() {
+			desiredTriggers[flow.id()][path.id()] = path
+		}
+	}
+	desiredPaths := manifest.paths()
+	named := make(map[pathID]bool)
+	for _, path := range desiredPaths {
+		named[path.id()] = true
+	}
+
+	// Links go first so that Flows can be updated without passing through a loop that the manifest removes
+	for _, id := range sortedFlowIDs(st.flows) {
+		if _, ok := desired[id]; !ok {
+			continue
+		}
+		for _, path := range sortedPathSet(st.triggers[id]) {
+			if _, ok := desiredTriggers[id][path.id()]; !ok {
+				change := id.change(ActionDelete, KindLink)
+				change.Path = pointerTo(path)
+				plan.add(change)
+			}
+		}
+	}
+	for _, id := range sortedFlowIDs(st.flows) {
+		if _, ok := desired[id]; !ok {
+			plan.add(id.change(ActionDelete, KindFlow))
+		}
+	}
+	sortPaths(desiredPaths)
+	for _, path := range desiredPaths {
+		stored, ok := st.paths[path.id()]
+		if !ok {
+			plan.add(Change{Action: ActionCreate, Kind: KindPath, Path: pointerTo(path)})
+		} else if path.changes(stored) {
+			plan.add(Change{Action: ActionUpdate, Kind: KindPath, Path: pointerTo(path)})
+		}
+	}
+	for _, flow := range manifest.Flows {
+		current, ok := st.flows[flow.id()]
+		if ok && !reflect.DeepEqual(comparableFlow(current), comparableFlow(flow.StorageFlow())) {
+			change := flow.id().change(ActionUpdate, KindFlow)
+			change.flow = flow.StorageFlow()
+			plan.add(change)
+		}
+	}
+	for _, flow := range manifest.Flows {
+		if _, ok := st.flows[flow.id()]; !ok {
+			change := flow.id().change(ActionCreate, KindFlow)
+			change.flow = flow.StorageFlow()
+			plan.add(change)
+		}
+	}
+	for _, flow := range manifest.Flows {
+		for _, path := range sortedPathSet(desiredTriggers[flow.id()]) {
+			if _, ok := st.triggers[flow.id()][path.id()]; !ok {
+				change := flow.id().change(ActionCreate, KindLink)
+				change.Path = pointerTo(path)
+				plan.add(change)
+			}
+		}
+	}
+	var stalePaths []Path
+	for id, path := range st.paths {
+		if !named[id] {
+			stalePaths = append(stalePaths, path)
+		}
+	}
+	sortPaths(stalePaths)
+	for _, path := range stalePaths {
+		plan.add(Change{Action: ActionDelete, Kind: KindPath, Path: pointerTo(path)})
+	}
+	return plan
+}
+
+// Apply makes the changes in order. It stops at the first Change that fails and returns a ChangeError naming the Changes before it, which are left made
+func (p Plan) Apply(s storage.Storage) error {
+	flowKeys := make(map[flowID]storage.Key)
+	for id, key := range p.flowKeys {
+		flowKeys[id] = key
+	}
+	for i, change := range p.Changes {
+		err := p.applyChange(s, change, flowKeys)
+		if err != nil {
+			return &ChangeError{Change: change, Err: err, Applied: p.Changes[:i]}
+		}
+	}
+	return nil
+}
+
+func (p Plan) applyChange(s storage.Storage, change Change, flowKeys map[flowID]storage.Key) error {
+	switch {
+	case change.Kind == KindFlow && change.Action == ActionCreate:
+		key, err := s.SaveFlow(change.flow)
+		if err != nil {
+			return err
+		}
+		flowKeys[change.flowID()] = key
+		return nil
+	case change.Kind == KindFlow && change.Action == ActionUpdate:
+		return s.UpdateFlow(flowKeys[change.flowID()], change.flow)
+	case change.Kind == KindFlow && change.Action == ActionDelete:
+		return s.DeleteFlow(flowKeys[change.flowID()])
+	case change.Kind == KindPath && (change.Action == ActionCreate || change.Action == ActionUpdate):
+		_, err := s.SavePath(*change.Path.messengerPath())
+		return err
+	case change.Kind == KindPath && change.Action == ActionDelete:
+		return s.DeletePath(p.pathKeys[change.Path.id()])
+	case change.Kind == KindLink:
+		pathKey, err := s.SavePath(*change.Path.messengerPath())
+		if err != nil {
+			return err
+		}
+		if change.Action == ActionCreate {
+			return s.ChainNextFlowToPath(flowKeys[change.flowID()], pathKey)
+		}
+		return s.UnchainNextFlowFromPath(flowKeys[change.flowID()], pathKey)
+	}
+	return fmt.Errorf("Unknown change %s", change)
+}
+
+func (p *Plan) add(change Change) {
+	p.Changes = append(p.Changes, change)
+}
+
+// readState lists every Path and Flow in the namespace, or in storage when namespace is empty, and which of those Paths trigger which of those Flows.
+// Paths of the default namespace triggering Flows in other namespaces are left out as a manifest cannot write them
+func readState(s storage.Storage, namespace string) (state, error) {
+	st := state{
+		flows:    make(map[flowID]storage.Flow),
+		flowKeys: make(map[flowID]storage.Key),
+		paths:    make(map[pathID]Path),
+		pathKeys: make(map[pathID]storage.Key),
+		triggers: make(map[flowID]map[pathID]Path),
+	}
+	options := storage.ListOptions{Namespace: namespace, Limit: storage.MaxListLimit}
+	for {
+		flows, keys, next, err := s.ListFlows(options)
+		if err != nil {
+			return state{}, err
+		}
+		for i, flow := range flows {
+			id := idOfStorageFlow(flow)
+			if _, ok := st.flows[id]; ok {
+				return state{}, ErrAmbiguousFlowName
+			}
+			st.flows[id] = flow
+			st.flowKeys[id] = keys[i]
+			st.triggers[id] = make(map[pathID]Path)
+		}
+		if next == "" {
+			break
+		}
+		options.Cursor = next
+	}
+	options = storage.ListOptions{Namespace: namespace, Limit: storage.MaxListLimit}
+	for {
+		paths, keys, next, err := s.ListPaths(options)
+		if err != nil {
+			return state{}, err
+		}
+		for i, path := range paths {
+			stored := newPath(path.Path)
+			st.paths[stored.id()] = stored
+			st.pathKeys[stored.id()] = keys[i]
+		}
+		if next == "" {
+			break
+		}
+		options.Cursor = next
+	}
+	for id, pathKey := range st.pathKeys {
+		flows, _, err := s.GetNextFlows(pathKey)
+		if err != nil {
+			return state{}, err
+		}
+		for _, flow := range flows {
+			triggers, ok := st.triggers[idOfStorageFlow(flow)]
+			if !ok || (id.namespace == "" && flow.Namespace != "") {
+				continue
+			}
+			triggers[id] = st.paths[id]
+		}
+	}
+	return st, nil
+}
+
+// comparableFlow returns the Flow as written in a manifest without the labels of its Paths, which are compared as changes to the Paths
+func comparableFlow(flow storage.Flow) Flow {
+	f := newFlow(flow, nil)
+	f.Path.Labels = nil
+	if f.WaitFor != nil {
+		waitFor := *f.WaitFor
+		waitFor.Labels = nil
+		f.WaitFor = &waitFor
+	}
+	return f
+}
+
+// sortedFlowIDs returns the ids of the Flows sorted by namespace, then name
+func sortedFlowIDs(flows map[flowID]storage.Flow) []flowID {
+	var ids []flowID
+	for id := range flows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].namespace != ids[j].namespace {
+			return ids[i].namespace < ids[j].namespace
+		}
+		return ids[i].name < ids[j].name
+	})
+	return ids
+}
+
+func sortedPathSet(set map[pathID]Path) []Path {
+	var paths []Path
+	for _, path := range set {
+		paths = append(paths, path)
+	}
+	sortPaths(paths)
+	return paths
+}
+
+// pointerTo returns a pointer to a copy of the Path, so Changes made in a loop do not share the loop variable
+func pointerTo(path Path) *Path {
+	return &path
+}
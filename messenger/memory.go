@@ -0,0 +1,303 @@
+package messenger
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	memoryPartition int32 = 0
+)
+
+var (
+	ErrMessengerClosed      error = errors.New("Messenger has been closed")
+	ErrMemoryBrokerRequired error = errors.New("MemoryMessenger requires a MemoryBroker")
+	ErrConsumerGroupMissing error = errors.New("MemoryMessenger requires a consumer group")
+)
+
+// MemoryBroker holds topics and consumer group offsets in memory so MemoryMessengers can talk without Kafka
+type MemoryBroker struct {
+	lock    sync.Mutex
+	topics  map[string][][]byte
+	groups  map[string]map[string]*memoryGroupTopic
+	changed chan bool
+}
+
+// memoryGroupTopic tracks what a consumer group has consumed from a topic
+type memoryGroupTopic struct {
+	next     int64
+	inFlight map[int64]*memoryDelivery
+	released []int64
+}
+
+type memoryDelivery struct {
+	owner       *MemoryMessenger
+	deliveredAt time.Time
+}
+
+// NewMemoryBroker returns a new empty MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics:  make(map[string][][]byte),
+		groups:  make(map[string]map[string]*memoryGroupTopic),
+		changed: make(chan bool),
+	}
+}
+
+// MemoryMessenger implements Messenger using Go channels and a MemoryBroker. It is meant for tests and for running Conduction inside another Go process,
+// as only MemoryMessengers sharing the same MemoryBroker in the same process can reach each other
+type MemoryMessenger struct {
+	broker            *MemoryBroker
+	consumerGroup     string
+	topicsToConsume   []string
+	redeliveryTimeout time.Duration
+
+	messages  chan *Message
+	close     chan bool
+	done      chan bool
+	closeOnce sync.Once
+}
+
+type MemoryMessengerConfig struct {
+	ConsumerGroup   string
+	TopicsToConsume []string
+	// RedeliveryTimeout is how long a Message can go unacknowledged before it is delivered again. Zero redelivers only when the receiving Messenger closes
+	RedeliveryTimeout time.Duration
+}
+
+// NewMemoryMessenger returns a new MemoryMessenger consuming from broker
+func NewMemoryMessenger(broker *MemoryBroker, config *MemoryMessengerConfig) (*MemoryMessenger, error) {
+	if broker == nil {
+		return nil, ErrMemoryBrokerRequired
+	}
+	if config == nil || config.ConsumerGroup == "" {
+		return nil, ErrConsumerGroupMissing
+	}
+	mm := &MemoryMessenger{
+		broker:            broker,
+		consumerGroup:     config.ConsumerGroup,
+		topicsToConsume:   config.TopicsToConsume,
+		redeliveryTimeout: config.RedeliveryTimeout,
+		messages:          make(chan *Message),
+		close:             make(chan bool),
+		done:              make(chan bool),
+	}
+	go mm.consume()
+	return mm, nil
+}
+
+// Send adds the message to the end of the topic
+func (mm *MemoryMessenger) Send(topic string, message *Message) error {
+	if mm.isClosed() {
+		return ErrMessengerClosed
+	}
+	value, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	mm.broker.append(topic, value)
+	return nil
+}
+
+// Receive returns messages from the topics being consumed
+func (mm *MemoryMessenger) Receive() <-chan *Message {
+	return mm.messages
+}
+
+// Acknowledge marks the message as processed so it will not be delivered to the consumer group again
+func (mm *MemoryMessenger) Acknowledge(message *Message) error {
+	if mm.isClosed() {
+		return ErrMessengerClosed
+	}
+	topic, _, offset, err := message.getTopicPartitionOffsetFromMessageMetadata()
+	if err != nil {
+		return err
+	}
+	mm.broker.acknowledge(mm.consumerGroup, topic, offset)
+	return nil
+}
+
+// Close stops the MemoryMessenger. Unacknowledged messages are released to the rest of the consumer group
+func (mm *MemoryMessenger) Close() error {
+	mm.closeOnce.Do(func() {
+		close(mm.close)
+		<-mm.done
+	})
+	return nil
+}
+
+func (mm *MemoryMessenger) isClosed() bool {
+	select {
+	case <-mm.close:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mm *MemoryMessenger) consume() {
+	defer close(mm.done)
+	defer mm.broker.release(mm)
+	for {
+		consumerMessage, changed, retryIn := mm.broker.next(mm)
+		if consumerMessage != nil {
+			message, err := NewMessageFromSaramaConsumerMessage(consumerMessage)
+			if err != nil {
+				Logger.Debugln(err)
+				mm.broker.acknowledge(mm.consumerGroup, consumerMessage.Topic, consumerMessage.Offset)
+				continue
+			}
+			select {
+			case mm.messages <- message:
+			case <-mm.close:
+				return
+			}
+			continue
+		}
+		var retry <-chan time.Time
+		if retryIn > 0 {
+			retry = time.After(retryIn)
+		}
+		select {
+		case <-changed:
+		case <-retry:
+		case <-mm.close:
+			return
+		}
+	}
+}
+
+func (mb *MemoryBroker) append(topic string, value []byte) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.topics[topic] = append(mb.topics[topic], value)
+	mb.notifyChanged()
+}
+
+// next returns the next message for the messenger. If there is none, it returns a channel that closes when the broker changes and how long until an unacknowledged message is due for redelivery
+func (mb *MemoryBroker) next(mm *MemoryMessenger) (*sarama.ConsumerMessage, <-chan bool, time.Duration) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	now := time.Now()
+	var retryIn time.Duration
+	for _, topic := range mm.topicsToConsume {
+		groupTopic := mb.getGroupTopic(mm.consumerGroup, topic)
+		if offset, ok := groupTopic.popReleased(); ok {
+			return mb.deliver(mm, groupTopic, topic, offset, now), nil, 0
+		}
+		if mm.redeliveryTimeout > 0 {
+			offset, deliveredAt, ok := groupTopic.oldestInFlight()
+			if ok {
+				waited := now.Sub(deliveredAt)
+				if waited >= mm.redeliveryTimeout {
+					return mb.deliver(mm, groupTopic, topic, offset, now), nil, 0
+				}
+				if wait := mm.redeliveryTimeout - waited; retryIn == 0 || wait < retryIn {
+					retryIn = wait
+				}
+			}
+		}
+		if groupTopic.next < int64(len(mb.topics[topic])) {
+			offset := groupTopic.next
+			groupTopic.next++
+			return mb.deliver(mm, groupTopic, topic, offset, now), nil, 0
+		}
+	}
+	return nil, mb.changed, retryIn
+}
+
+func (mb *MemoryBroker) deliver(mm *MemoryMessenger, groupTopic *memoryGroupTopic, topic string, offset int64, now time.Time) *sarama.ConsumerMessage {
+	groupTopic.inFlight[offset] = &memoryDelivery{
+		owner:       mm,
+		deliveredAt: now,
+	}
+	return &sarama.ConsumerMessage{
+		Topic:     topic,
+		Partition: memoryPartition,
+		Offset:    offset,
+		Value:     mb.topics[topic][offset],
+	}
+}
+
+func (mb *MemoryBroker) acknowledge(consumerGroup string, topic string, offset int64) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	groupTopic := mb.getGroupTopic(consumerGroup, topic)
+	delete(groupTopic.inFlight, offset)
+	for i, releasedOffset := range groupTopic.released {
+		if releasedOffset == offset {
+			groupTopic.released = append(groupTopic.released[:i], groupTopic.released[i+1:]...)
+			break
+		}
+	}
+}
+
+// release puts messages delivered to but not acknowledged by the messenger back for redelivery
+func (mb *MemoryBroker) release(mm *MemoryMessenger) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	for _, groupTopic := range mb.groups[mm.consumerGroup] {
+		for offset, delivery := range groupTopic.inFlight {
+			if delivery.owner == mm {
+				delete(groupTopic.inFlight, offset)
+				groupTopic.released = append(groupTopic.released, offset)
+			}
+		}
+		sort.Slice(groupTopic.released, func(i, j int) bool {
+			return groupTopic.released[i] < groupTopic.released[j]
+		})
+	}
+	mb.notifyChanged()
+}
+
+func (mb *MemoryBroker) getGroupTopic(consumerGroup string, topic string) *memoryGroupTopic {
+	group, ok := mb.groups[consumerGroup]
+	if !ok {
+		group = make(map[string]*memoryGroupTopic)
+		mb.groups[consumerGroup] = group
+	}
+	groupTopic, ok := group[topic]
+	if !ok {
+		groupTopic = &memoryGroupTopic{
+			inFlight: make(map[int64]*memoryDelivery),
+		}
+		group[topic] = groupTopic
+	}
+	return groupTopic
+}
+
+// notifyChanged wakes up every messenger waiting on the broker. Lock must be held
+func (mb *MemoryBroker) notifyChanged() {
+	close(mb.changed)
+	mb.changed = make(chan bool)
+}
+
+func (gt *memoryGroupTopic) popReleased() (int64, bool) {
+	if len(gt.released) == 0 {
+		return 0, false
+	}
+	offset := gt.released[0]
+	gt.released = gt.released[1:]
+	return offset, true
+}
+
+func (gt *memoryGroupTopic) oldestInFlight() (int64, time.Time, bool) {
+	var (
+		oldestOffset int64
+		oldestTime   time.Time
+		found        bool
+	)
+	for offset, delivery := range gt.inFlight {
+		if !found || delivery.deliveredAt.Before(oldestTime) {
+			oldestOffset = offset
+			oldestTime = delivery.deliveredAt
+			found = true
+		}
+	}
+	return oldestOffset, oldestTime, found
+}
@@ -0,0 +1,179 @@
+// +build all unit
+
+package messenger
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("MemoryMessenger", func() {
+		var (
+			broker *MemoryBroker
+			config = &MemoryMessengerConfig{
+				ConsumerGroup:   "memory-group",
+				TopicsToConsume: []string{"memory-topic"},
+			}
+			message = &Message{
+				Payload: []byte("payload"),
+			}
+		)
+		BeforeEach(func() {
+			broker = NewMemoryBroker()
+		})
+		Describe("Given creating a new MemoryMessenger", func() {
+			Context("When there is no MemoryBroker", func() {
+				It("Then an error should be returned", func() {
+					_, err := NewMemoryMessenger(nil, config)
+					Expect(err).To(Equal(ErrMemoryBrokerRequired))
+				})
+			})
+			Context("When there is no consumer group", func() {
+				It("Then an error should be returned", func() {
+					_, err := NewMemoryMessenger(broker, &MemoryMessengerConfig{})
+					Expect(err).To(Equal(ErrConsumerGroupMissing))
+				})
+			})
+		})
+		Describe("Given a MemoryMessenger is connected to a MemoryBroker", func() {
+			var memoryMessenger *MemoryMessenger
+			BeforeEach(func() {
+				var err error
+				memoryMessenger, err = NewMemoryMessenger(broker, config)
+				Expect(err).To(BeNil())
+			})
+			AfterEach(func() {
+				memoryMessenger.Close()
+			})
+			Context("When a message is sent to a consumed topic", func() {
+				It("Then the message should be received with topic, partition and offset metadata", func() {
+					err := memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+
+					msg := receiveWithin(memoryMessenger, time.Second)
+					Expect(msg).ToNot(BeNil())
+					Expect(msg.Payload).To(Equal(message.Payload))
+					topic, partition, offset, err := msg.getTopicPartitionOffsetFromMessageMetadata()
+					Expect(err).To(BeNil())
+					Expect(topic).To(Equal(config.TopicsToConsume[0]))
+					Expect(partition).To(Equal(memoryPartition))
+					Expect(offset).To(Equal(int64(0)))
+					Expect(memoryMessenger.Acknowledge(msg)).To(BeNil())
+				})
+			})
+			Context("When a message is sent to a topic that is not consumed", func() {
+				It("Then nothing should be received", func() {
+					err := memoryMessenger.Send("other-topic", message)
+					Expect(err).To(BeNil())
+					Expect(receiveWithin(memoryMessenger, time.Millisecond*200)).To(BeNil())
+				})
+			})
+			Context("When another MemoryMessenger is in the same consumer group", func() {
+				It("Then each message should only be received once by the group", func() {
+					otherMessenger, err := NewMemoryMessenger(broker, config)
+					Expect(err).To(BeNil())
+					defer otherMessenger.Close()
+
+					err = memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+
+					received := 0
+					for _, m := range []*MemoryMessenger{memoryMessenger, otherMessenger} {
+						if msg := receiveWithin(m, time.Millisecond*200); msg != nil {
+							Expect(m.Acknowledge(msg)).To(BeNil())
+							received++
+						}
+					}
+					Expect(received).To(Equal(1))
+				})
+			})
+			Context("When another MemoryMessenger is in a different consumer group", func() {
+				It("Then both consumer groups should receive the message", func() {
+					otherMessenger, err := NewMemoryMessenger(broker, &MemoryMessengerConfig{
+						ConsumerGroup:   "other-group",
+						TopicsToConsume: config.TopicsToConsume,
+					})
+					Expect(err).To(BeNil())
+					defer otherMessenger.Close()
+
+					err = memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+					Expect(receiveWithin(memoryMessenger, time.Second)).ToNot(BeNil())
+					Expect(receiveWithin(otherMessenger, time.Second)).ToNot(BeNil())
+				})
+			})
+			Context("When a message is not acknowledged before the MemoryMessenger closes", func() {
+				It("Then the message should be received again on reconnect", func() {
+					err := memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+					Expect(receiveWithin(memoryMessenger, time.Second)).ToNot(BeNil())
+					Expect(memoryMessenger.Close()).To(BeNil())
+
+					memoryMessenger, err = NewMemoryMessenger(broker, config)
+					Expect(err).To(BeNil())
+					msg := receiveWithin(memoryMessenger, time.Second)
+					Expect(msg).ToNot(BeNil())
+					Expect(msg.Payload).To(Equal(message.Payload))
+					Expect(memoryMessenger.Acknowledge(msg)).To(BeNil())
+				})
+			})
+			Context("When a message is acknowledged before the MemoryMessenger closes", func() {
+				It("Then the message should not be received again on reconnect", func() {
+					err := memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+					msg := receiveWithin(memoryMessenger, time.Second)
+					Expect(msg).ToNot(BeNil())
+					Expect(memoryMessenger.Acknowledge(msg)).To(BeNil())
+					Expect(memoryMessenger.Close()).To(BeNil())
+
+					memoryMessenger, err = NewMemoryMessenger(broker, config)
+					Expect(err).To(BeNil())
+					Expect(receiveWithin(memoryMessenger, time.Millisecond*200)).To(BeNil())
+				})
+			})
+			Context("When the MemoryMessenger is closed", func() {
+				It("Then sending and acknowledging should return an error", func() {
+					Expect(memoryMessenger.Close()).To(BeNil())
+					Expect(memoryMessenger.Send(config.TopicsToConsume[0], message)).To(Equal(ErrMessengerClosed))
+					Expect(memoryMessenger.Acknowledge(message)).To(Equal(ErrMessengerClosed))
+				})
+			})
+		})
+		Describe("Given a MemoryMessenger with a redelivery timeout", func() {
+			Context("When a message is not acknowledged in time", func() {
+				It("Then the message should be received again", func() {
+					memoryMessenger, err := NewMemoryMessenger(broker, &MemoryMessengerConfig{
+						ConsumerGroup:     config.ConsumerGroup,
+						TopicsToConsume:   config.TopicsToConsume,
+						RedeliveryTimeout: time.Millisecond * 100,
+					})
+					Expect(err).To(BeNil())
+					defer memoryMessenger.Close()
+
+					err = memoryMessenger.Send(config.TopicsToConsume[0], message)
+					Expect(err).To(BeNil())
+					first := receiveWithin(memoryMessenger, time.Second)
+					Expect(first).ToNot(BeNil())
+					second := receiveWithin(memoryMessenger, time.Second)
+					Expect(second).ToNot(BeNil())
+					Expect(second.Metadata[messageOffset]).To(Equal(first.Metadata[messageOffset]))
+
+					Expect(memoryMessenger.Acknowledge(second)).To(BeNil())
+					Expect(receiveWithin(memoryMessenger, time.Millisecond*300)).To(BeNil())
+				})
+			})
+		})
+	})
+})
+
+func receiveWithin(m Messenger, timeout time.Duration) *Message {
+	select {
+	case msg := <-m.Receive():
+		return msg
+	case <-time.After(timeout):
+		return nil
+	}
+}
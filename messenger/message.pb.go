@@ -80,10 +80,12 @@ func (m *Message) GetMetadata() map[string][]byte {
 }
 
 type Path struct {
-	Route    string            `protobuf:"bytes,1,opt,name=route" json:"route,omitempty"`
-	Type     string            `protobuf:"bytes,2,opt,name=type" json:"type,omitempty"`
-	Identity string            `protobuf:"bytes,3,opt,name=identity" json:"identity,omitempty"`
-	Metadata map[string][]byte `protobuf:"bytes,4,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Route     string            `protobuf:"bytes,1,opt,name=route" json:"route,omitempty"`
+	Type      string            `protobuf:"bytes,2,opt,name=type" json:"type,omitempty"`
+	Identity  string            `protobuf:"bytes,3,opt,name=identity" json:"identity,omitempty"`
+	Metadata  map[string][]byte `protobuf:"bytes,4,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Namespace string            `protobuf:"bytes,5,opt,name=namespace" json:"namespace,omitempty"`
+	Labels    map[string]string `protobuf:"bytes,6,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *Path) Reset()                    { *m = Path{} }
@@ -119,6 +121,20 @@ func (m *Path) GetMetadata() map[string][]byte {
 	return nil
 }
 
+func (m *Path) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *Path) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Message)(nil), "messenger.Message")
 	proto.RegisterType((*Path)(nil), "messenger.Path")
@@ -127,23 +143,25 @@ func init() {
 func init() { proto.RegisterFile("message.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 274 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x91, 0xcd, 0x4a, 0xf4, 0x30,
-	0x14, 0x86, 0xe9, 0xb4, 0xf3, 0xd3, 0xd3, 0x19, 0xbe, 0x8f, 0x83, 0x8b, 0x30, 0x20, 0x94, 0xd9,
-	0xd8, 0x55, 0xc1, 0x71, 0xe3, 0xdf, 0xd6, 0xe5, 0x80, 0xe4, 0x0e, 0x22, 0x3d, 0xd4, 0xe0, 0x4c,
-	0x52, 0xd2, 0x53, 0xa1, 0xd7, 0xe3, 0xad, 0x78, 0x61, 0xd2, 0xb4, 0x96, 0x2a, 0xba, 0x74, 0x77,
-	0x5e, 0xde, 0xe7, 0x84, 0x3c, 0x09, 0x6c, 0x4e, 0x54, 0xd7, 0xaa, 0xa4, 0xbc, 0x72, 0x96, 0x2d,
-	0xc6, 0x5d, 0x24, 0x53, 0x92, 0xdb, 0xbd, 0xcd, 0x60, 0x79, 0xe8, 0x4b, 0xbc, 0x80, 0x85, 0x75,
-	0xba, 0xd4, 0x46, 0x04, 0x69, 0x90, 0x25, 0xfb, 0x7f, 0xf9, 0xc8, 0xe5, 0x8f, 0x8a, 0x9f, 0xe5,
-	0x50, 0x77, 0xa0, 0x23, 0x6e, 0x9c, 0x11, 0xb3, 0x5f, 0xc0, 0xbe, 0xc6, 0x4b, 0x48, 0x0a, 0xaa,
-	0x59, 0x1b, 0xc5, 0xda, 0x1a, 0x11, 0xfe, 0x4c, 0x4f, 0x19, 0x14, 0xb0, 0xac, 0x54, 0x7b, 0xb4,
-	0xaa, 0x10, 0x51, 0x1a, 0x64, 0x6b, 0xf9, 0x19, 0xf1, 0x1e, 0x56, 0x27, 0x62, 0x55, 0x28, 0x56,
-	0x62, 0x9e, 0x86, 0x59, 0xb2, 0x4f, 0x27, 0x27, 0x0d, 0x12, 0xf9, 0x61, 0x40, 0x1e, 0x0c, 0xbb,
-	0x56, 0x8e, 0x1b, 0xdb, 0x3b, 0xd8, 0x7c, 0xa9, 0xf0, 0x3f, 0x84, 0x2f, 0xd4, 0x7a, 0xd5, 0x58,
-	0x76, 0x23, 0x9e, 0xc1, 0xfc, 0x55, 0x1d, 0x1b, 0xf2, 0x56, 0x6b, 0xd9, 0x87, 0xdb, 0xd9, 0x75,
-	0xb0, 0x7b, 0x0f, 0x20, 0xea, 0xae, 0xda, 0x21, 0xce, 0x36, 0x4c, 0xc3, 0x5a, 0x1f, 0x10, 0x21,
-	0xe2, 0xb6, 0xea, 0xf7, 0x62, 0xe9, 0x67, 0xdc, 0xc2, 0x4a, 0x17, 0x64, 0x58, 0x73, 0xeb, 0xbd,
-	0x63, 0x39, 0x66, 0xbc, 0x99, 0x98, 0x44, 0xde, 0xe4, 0xfc, 0xdb, 0x9b, 0xfc, 0x89, 0xc6, 0xd3,
-	0xc2, 0x7f, 0xff, 0xd5, 0x47, 0x00, 0x00, 0x00, 0xff, 0xff, 0x2e, 0x14, 0xd4, 0x42, 0x0f, 0x02,
-	0x00, 0x00,
+	// 318 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x92, 0xc1, 0x4a, 0xc3, 0x40,
+	0x18, 0x84, 0x49, 0x9a, 0xa6, 0xcd, 0x9f, 0x16, 0xe5, 0xc7, 0xc3, 0x52, 0x15, 0x42, 0x2f, 0xf6,
+	0x14, 0xb0, 0xbd, 0x58, 0xf5, 0xea, 0xcd, 0x82, 0xec, 0x1b, 0x6c, 0xcd, 0x4f, 0x0d, 0xa6, 0x9b,
+	0xb0, 0xf9, 0x2b, 0xe4, 0x79, 0x7c, 0x23, 0x9f, 0x48, 0xb2, 0x89, 0x35, 0x16, 0x8b, 0x07, 0x6f,
+	0x3b, 0x99, 0x6f, 0x86, 0xcc, 0xb2, 0x30, 0xde, 0x52, 0x59, 0xaa, 0x0d, 0xc5, 0x85, 0xc9, 0x39,
+	0xc7, 0xa0, 0x96, 0xa4, 0x37, 0x64, 0xa6, 0xef, 0x2e, 0x0c, 0x56, 0x8d, 0x89, 0x57, 0xe0, 0xe7,
+	0x26, 0xdd, 0xa4, 0x5a, 0x38, 0x91, 0x33, 0x0b, 0xe7, 0x27, 0xf1, 0x9e, 0x8b, 0x9f, 0x14, 0xbf,
+	0xc8, 0xd6, 0xae, 0x41, 0x43, 0xbc, 0x33, 0x5a, 0xb8, 0x47, 0xc0, 0xc6, 0xc6, 0x6b, 0x08, 0x13,
+	0x2a, 0x39, 0xd5, 0x8a, 0xd3, 0x5c, 0x8b, 0xde, 0xef, 0x74, 0x97, 0x41, 0x01, 0x83, 0x42, 0x55,
+	0x59, 0xae, 0x12, 0xe1, 0x45, 0xce, 0x6c, 0x24, 0xbf, 0x24, 0xde, 0xc3, 0x70, 0x4b, 0xac, 0x12,
+	0xc5, 0x4a, 0xf4, 0xa3, 0xde, 0x2c, 0x9c, 0x47, 0x9d, 0xa6, 0x76, 0x44, 0xbc, 0x6a, 0x91, 0x07,
+	0xcd, 0xa6, 0x92, 0xfb, 0xc4, 0xe4, 0x0e, 0xc6, 0x3f, 0x2c, 0x3c, 0x85, 0xde, 0x2b, 0x55, 0x76,
+	0x6a, 0x20, 0xeb, 0x23, 0x9e, 0x41, 0xff, 0x4d, 0x65, 0x3b, 0xb2, 0xab, 0x46, 0xb2, 0x11, 0xb7,
+	0xee, 0x8d, 0x33, 0xfd, 0x70, 0xc1, 0xab, 0x7f, 0xb5, 0x46, 0x4c, 0xbe, 0x63, 0x6a, 0x63, 0x8d,
+	0x40, 0x04, 0x8f, 0xab, 0xa2, 0xc9, 0x05, 0xd2, 0x9e, 0x71, 0x02, 0xc3, 0x34, 0x21, 0xcd, 0x29,
+	0x57, 0x76, 0x77, 0x20, 0xf7, 0x1a, 0x97, 0x9d, 0x25, 0x9e, 0x5d, 0x72, 0x79, 0x70, 0x27, 0xc7,
+	0x66, 0xe0, 0x05, 0x04, 0x5a, 0x6d, 0xa9, 0x2c, 0xd4, 0x33, 0x89, 0xbe, 0xed, 0xfd, 0xfe, 0x80,
+	0x0b, 0xf0, 0x33, 0xb5, 0xa6, 0xac, 0x14, 0xbe, 0xad, 0x3d, 0x3f, 0xac, 0x7d, 0xb4, 0x6e, 0x53,
+	0xda, 0xa2, 0xff, 0xba, 0x99, 0xc9, 0x12, 0xc2, 0x4e, 0xe7, 0x5f, 0xd1, 0xa0, 0x13, 0x5d, 0xfb,
+	0xf6, 0x31, 0x2e, 0x3e, 0x07, 0x00, 0x31, 0xf4, 0x65, 0x92, 0x9d, 0x02, 0x00, 0x00,
 }
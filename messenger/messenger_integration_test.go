@@ -10,6 +10,10 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+var _ = BeforeSuite(func() {
+	ClearKafkaTopic(kafkaBroker, kafkaInputTopic, kafkaConsumerGroup)
+})
+
 var _ = Describe("Conduction", func() {
 	Describe("Messenger", func() {
 		var (
@@ -24,7 +24,6 @@ func TestMessenger(t *testing.T) {
 	if os.Getenv("KAFKA_URL") != "" {
 		kafkaBroker = os.Getenv("KAFKA_URL")
 	}
-	ClearKafkaTopic(kafkaBroker, kafkaInputTopic, kafkaConsumerGroup)
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Messenger Suite")
 }
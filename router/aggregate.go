@@ -0,0 +1,66 @@
+package router
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	DefaultSweepInterval time.Duration = time.Second
+	// AggregateMissingKey is set on messages continued after a wait timed out. It holds a JSON array of the names of the Flows that did not respond
+	AggregateMissingKey string = "aggregateMissing"
+)
+
+// startSweeping continues expired Continuations every interval while the Router is routing. It exits once the Router is closed
+func (r *Router) startSweeping(interval time.Duration) {
+	defer r.running.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			if !r.isRouting() {
+				continue
+			}
+			err := r.sweepExpiredContinuations(now)
+			if err != nil {
+				Logger.Debugln(err)
+			}
+		}
+	}
+}
+
+// sweepExpiredContinuations continues every Continuation past its deadline with the responses it has received
+func (r *Router) sweepExpiredContinuations(now time.Time) error {
+	continuations, err := r.storage.TakeExpiredContinuations(now)
+	if err != nil {
+		return err
+	}
+	for _, continuation := range continuations {
+		message := messenger.Message{
+			Origin:   continuation.Origin,
+			Payload:  continuation.Payload(),
+			Return:   continuation.Return,
+			Metadata: copyMetadata(continuation.Metadata),
+		}
+		if names := continuation.Missing(); len(names) > 0 {
+			missing, err := json.Marshal(names)
+			if err != nil {
+				return err
+			}
+			Logger.Infof("Continuing after wait timed out without responses from %s", missing)
+			message.Metadata[AggregateMissingKey] = missing
+		} else {
+			Logger.Infoln("Continuing after wait timed out")
+		}
+		err = r.routeMessageToFlows(message, continuation.Flows)
+		if _, forwarded := err.(*ForwardError); err != nil && !forwarded {
+			r.sendToDeadLetterTopic(message, stageContinuation, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+// Metadata keys set on messages sent to the dead letter topic
+const (
+	DeadLetterReasonKey    string = "deadLetterReason"
+	DeadLetterStageKey     string = "deadLetterStage"
+	DeadLetterTimestampKey string = "deadLetterTimestamp"
+	DeadLetterAttemptsKey  string = "deadLetterAttempts"
+)
+
+// Stages of routing a message can fail in
+const (
+	stageHops         string = "hops"
+	stageContinuation string = "continuation"
+	stageLookup       string = "lookup"
+	stageForward      string = "forward"
+)
+
+var (
+	ErrInputTopicNotSet error = errors.New("Router has no input topic to replay dead letters to")
+	ErrReplayWouldLoop  error = errors.New("Dead letter hit the max hop count and would go round the same loop if replayed")
+)
+
+// sendToDeadLetterTopic publishes the original message with why and where it failed. Without a dead letter topic the failure is only logged
+func (r *Router) sendToDeadLetterTopic(message messenger.Message, stage string, reason error) {
+	if r.deadLetterTopic == "" {
+		Logger.Warnf("Dropping message from %v that failed at %s. %v", message.Origin, stage, reason)
+		return
+	}
+	attempts, err := getDeadLetterAttempts(message)
+	if err != nil {
+		Logger.Debugln(err)
+	}
+	message.Metadata = copyMetadata(message.Metadata)
+	message.Metadata[DeadLetterReasonKey] = []byte(reason.Error())
+	message.Metadata[DeadLetterStageKey] = []byte(stage)
+	message.Metadata[DeadLetterTimestampKey] = []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	message.Metadata[DeadLetterAttemptsKey] = []byte(strconv.Itoa(attempts + 1))
+	err = r.messenger.Send(r.deadLetterTopic, &message)
+	if err != nil {
+		Logger.Errorf("Could not send message from %v to dead letter topic. %v", message.Origin, err)
+	}
+}
+
+// Replayer sends dead letters back to be processed again. It needs no storage and starts no workers, so dead letters can be replayed without running a Router
+type Replayer struct {
+	router *Router
+}
+
+// NewReplayer returns a Replayer that sends through messenger to the topics of config, retrying with its retry policies
+func NewReplayer(messenger messenger.Messenger, config RouterConfig) *Replayer {
+	return &Replayer{
+		router: newRouter(messenger, nil, config),
+	}
+}
+
+// ReplayDeadLetter sends a dead letter back to be processed again. The attempt count is kept so repeated failures can be spotted.
+// Messages that failed to forward go straight to their destination rather than the input topic. They were already routed, so routing them again would run the Flow a second time and resend to every destination that succeeded.
+// The rest go to the input topic to be routed again, except messages that hit the max hop count, which return ErrReplayWouldLoop as the Flows would route them round the same loop
+func (rp *Replayer) ReplayDeadLetter(message *messenger.Message) error {
+	if string(message.Metadata[DeadLetterStageKey]) == stageHops {
+		return ErrReplayWouldLoop
+	}
+	replay := *message
+	replay.Metadata = copyMetadata(message.Metadata)
+	delete(replay.Metadata, DeadLetterReasonKey)
+	delete(replay.Metadata, DeadLetterStageKey)
+	delete(replay.Metadata, DeadLetterTimestampKey)
+	if string(message.Metadata[DeadLetterStageKey]) == stageForward && message.Destination != nil {
+		return rp.router.forwardMessageToPath(replay, *message.Destination)
+	}
+	if rp.router.inputTopic == "" {
+		return ErrInputTopicNotSet
+	}
+	return rp.router.messenger.Send(rp.router.inputTopic, &replay)
+}
+
+// ReplayDeadLetters replays every dead letter received from deadLetters until none arrive for idleTimeout. Returns how many were replayed.
+// Dead letters that would loop are logged and acknowledged without being replayed, so the loop in the Flows can be fixed and the message sent again
+func (rp *Replayer) ReplayDeadLetters(deadLetters messenger.Messenger, idleTimeout time.Duration) (int, error) {
+	replayed := 0
+	for {
+		select {
+		case message := <-deadLetters.Receive():
+			err := rp.ReplayDeadLetter(message)
+			switch {
+			case err == ErrReplayWouldLoop:
+				Logger.Warnf("Skipping dead letter from %v. %v", message.Origin, err)
+			case err != nil:
+				return replayed, err
+			default:
+				replayed++
+			}
+			err = deadLetters.Acknowledge(message)
+			if err != nil {
+				return replayed, err
+			}
+		case <-time.After(idleTimeout):
+			return replayed, nil
+		}
+	}
+}
+
+func getDeadLetterAttempts(message messenger.Message) (int, error) {
+	value, ok := message.Metadata[DeadLetterAttemptsKey]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.Atoi(string(value))
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"github.com/edfungus/conduction/expression"
+	"github.com/edfungus/conduction/messenger"
+	"github.com/edfungus/conduction/storage"
+	"github.com/edfungus/conduction/transform"
+)
+
+// filterFlowsByCondition returns the Flows without a condition and the Flows whose condition the message matches. Flows with a condition that cannot be parsed are skipped
+func (r *Router) filterFlowsByCondition(message messenger.Message, flows []storage.Flow) []storage.Flow {
+	var matchingFlows []storage.Flow
+	for _, flow := range flows {
+		if flow.Condition == "" {
+			matchingFlows = append(matchingFlows, flow)
+			continue
+		}
+		condition, err := r.getCondition(flow.Condition)
+		if err != nil {
+			Logger.Warnf("Skipping Flow '%s' with invalid condition. %v", flow.Name, err)
+			continue
+		}
+		if condition.Evaluate(message) {
+			matchingFlows = append(matchingFlows, flow)
+		}
+	}
+	return matchingFlows
+}
+
+// getCondition parses the condition once and reuses it for later messages
+func (r *Router) getCondition(source string) (*expression.Expression, error) {
+	if cached, ok := r.conditions.Load(source); ok {
+		return cached.(*expression.Expression), nil
+	}
+	condition, err := expression.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	r.conditions.Store(source, condition)
+	return condition, nil
+}
+
+// transformMessage returns the message with its payload reshaped by the Flow's transform. Flows without a transform get the message unchanged
+func (r *Router) transformMessage(message messenger.Message, flow storage.Flow) (messenger.Message, error) {
+	if flow.Transform == "" {
+		return message, nil
+	}
+	t, err := r.getTransform(flow.Transform)
+	if err != nil {
+		return message, err
+	}
+	message.Payload = t.Apply(message)
+	return message, nil
+}
+
+// getTransform parses the transform once and reuses it for later messages
+func (r *Router) getTransform(source string) (*transform.Transform, error) {
+	if cached, ok := r.transforms.Load(source); ok {
+		return cached.(*transform.Transform), nil
+	}
+	t, err := transform.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	r.transforms.Store(source, t)
+	return t, nil
+}
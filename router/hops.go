@@ -0,0 +1,99 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	// DefaultMaxHops is used when RouterConfig does not set MaxHops
+	DefaultMaxHops int = 16
+
+	hopsMetadataKey  string = "conductionHops"
+	trailMetadataKey string = "conductionTrail"
+)
+
+var (
+	ErrMaxHopsExceeded error = errors.New("Message has been routed more times than the max hop count")
+)
+
+// recordHop counts the message's arrival as a hop and adds its origin to the trail of visited Paths. Messages over the max hop count return an error so they are dropped
+func (r *Router) recordHop(message messenger.Message) (messenger.Message, error) {
+	hops, err := getHops(message)
+	if err != nil {
+		return message, err
+	}
+	trail, err := getTrail(message)
+	if err != nil {
+		return message, err
+	}
+	hops++
+	if message.Origin != nil {
+		trail = append(trail, describePath(*message.Origin))
+	}
+	if hops > r.maxHops {
+		Logger.Warnf("Dropping message after %d hops. Trail: %v", hops, trail)
+		return message, ErrMaxHopsExceeded
+	}
+	encodedTrail, err := json.Marshal(trail)
+	if err != nil {
+		return message, err
+	}
+	message.Metadata = copyMetadata(message.Metadata)
+	message.Metadata[hopsMetadataKey] = []byte(strconv.Itoa(hops))
+	message.Metadata[trailMetadataKey] = encodedTrail
+	return message, nil
+}
+
+func getHops(message messenger.Message) (int, error) {
+	value, ok := message.Metadata[hopsMetadataKey]
+	if !ok {
+		return 0, nil
+	}
+	hops, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("Could not read hop count from Message metadata. %v", err)
+	}
+	return hops, nil
+}
+
+func getTrail(message messenger.Message) ([]string, error) {
+	value, ok := message.Metadata[trailMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	var trail []string
+	err := json.Unmarshal(value, &trail)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read trail from Message metadata. %v", err)
+	}
+	return trail, nil
+}
+
+// hopMetadata returns the hop count and trail in the metadata, so a message continued later counts the hops made before it
+func hopMetadata(metadata map[string][]byte) map[string][]byte {
+	hops := make(map[string][]byte)
+	for _, key := range []string{hopsMetadataKey, trailMetadataKey} {
+		if value, ok := metadata[key]; ok {
+			hops[key] = value
+		}
+	}
+	return hops
+}
+
+func describePath(path messenger.Path) string {
+	return fmt.Sprintf("%s:%s", path.Type, path.Route)
+}
+
+// copyMetadata copies the map so changes are not seen by the received message
+func copyMetadata(metadata map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(metadata)+2)
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
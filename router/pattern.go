@@ -0,0 +1,82 @@
+package router
+
+import (
+	"strings"
+
+	"github.com/edfungus/conduction/messenger"
+	"github.com/edfungus/conduction/storage"
+	"github.com/satori/go.uuid"
+)
+
+// RouteParamMetadataPrefix prefixes the route segments captured by pattern Paths in message metadata. A capture named id is at routeParam.id
+const RouteParamMetadataPrefix string = "routeParam."
+
+// getNextFlowsOfMatchingPatterns returns the next Flows of every pattern Path of the origin's namespace and type that the origin matches.
+// When patterns capture the same name, the most specific pattern wins. Returns whether any pattern matched
+func (r *Router) getNextFlowsOfMatchingPatterns(origin messenger.Path) ([]storage.Flow, []storage.Key, map[string]string, bool, error) {
+	patterns, patternKeys, err := r.storage.GetPatternPaths(origin.Type)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	var flows []storage.Flow
+	var flowKeys []storage.Key
+	routeParams := make(map[string]string)
+	matched := false
+	for i, pattern := range patterns {
+		if pattern.Namespace != origin.Namespace {
+			continue
+		}
+		captures, ok := storage.MatchRoute(pattern.Route, origin.Route)
+		if !ok || i >= len(patternKeys) {
+			continue
+		}
+		matched = true
+		for name, value := range captures {
+			if _, ok := routeParams[name]; !ok {
+				routeParams[name] = value
+			}
+		}
+		patternFlows, patternFlowKeys, err := r.storage.GetNextFlows(patternKeys[i])
+		if err != nil {
+			return nil, nil, nil, false, err
+		}
+		flows = append(flows, patternFlows...)
+		flowKeys = append(flowKeys, patternFlowKeys...)
+	}
+	return flows, flowKeys, routeParams, matched, nil
+}
+
+// appendNewFlows appends the Flows that are not already in flows. Flows without a UUID are always appended
+func appendNewFlows(flows []storage.Flow, newFlows []storage.Flow) []storage.Flow {
+	seen := make(map[uuid.UUID]bool)
+	for _, flow := range flows {
+		seen[flow.UUID] = true
+	}
+	for _, flow := range newFlows {
+		if flow.UUID != uuid.Nil && seen[flow.UUID] {
+			continue
+		}
+		seen[flow.UUID] = true
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+// setRouteParams replaces the route params in metadata with the ones captured for this hop
+func setRouteParams(metadata map[string][]byte, routeParams map[string]string) map[string][]byte {
+	for key := range metadata {
+		if strings.HasPrefix(key, RouteParamMetadataPrefix) {
+			delete(metadata, key)
+		}
+	}
+	if len(routeParams) == 0 {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = make(map[string][]byte)
+	}
+	for name, value := range routeParams {
+		metadata[RouteParamMetadataPrefix+name] = []byte(value)
+	}
+	return metadata
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	defaultRetryMultiplier float64 = 2
+)
+
+// RetryPolicy controls how many times and how often a message is sent again when sending to a destination fails
+type RetryPolicy struct {
+	MaxAttempts    int           // Total sends including the first. Less than 2 does not retry
+	InitialBackoff time.Duration // Wait before the first retry
+	MaxBackoff     time.Duration // Cap on the wait between retries. Zero does not cap
+	Multiplier     float64       // Growth of the wait after each retry. Defaults to 2
+	Jitter         float64       // Fraction of the wait to randomly add or remove, from 0 to 1
+}
+
+// backoff returns how long to wait before the retry following the given attempt
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	wait := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if rp.MaxBackoff > 0 && wait > float64(rp.MaxBackoff) {
+		wait = float64(rp.MaxBackoff)
+	}
+	if rp.Jitter > 0 {
+		wait += wait * rp.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(wait)
+}
+
+// DestinationError is a failure to forward a message to one destination
+type DestinationError struct {
+	Destination messenger.Path
+	Err         error
+}
+
+// ForwardError holds every destination a message could not be forwarded to. Each failed destination has already been dead lettered
+type ForwardError struct {
+	Failures []DestinationError
+}
+
+func (fe *ForwardError) Error() string {
+	reasons := make([]string, len(fe.Failures))
+	for i, failure := range fe.Failures {
+		reasons[i] = fmt.Sprintf("%s: %v", describePath(failure.Destination), failure.Err)
+	}
+	return fmt.Sprintf("Could not forward message to %d destination(s). %s", len(fe.Failures), strings.Join(reasons, "; "))
+}
+
+func (r *Router) getRetryPolicy(pathType string) RetryPolicy {
+	if policy, ok := r.retryPolicies[pathType]; ok {
+		return policy
+	}
+	return r.retryPolicy
+}
+
+// sendWithRetry sends the message to the topic, retrying with backoff according to the retry policy of the Path type.
+// Closing the Router stops the retries and returns the error of the last attempt
+func (r *Router) sendWithRetry(topic string, message *messenger.Message, pathType string) error {
+	policy := r.getRetryPolicy(pathType)
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.messenger.Send(topic, message)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return err
+		}
+		wait := policy.backoff(attempt)
+		Logger.Debugf("Send to %s failed on attempt %d, retrying in %v. %v", topic, attempt, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-r.done:
+			return err
+		}
+	}
+}
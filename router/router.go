@@ -3,24 +3,55 @@ package router
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/edfungus/conduction/messenger"
 	"github.com/edfungus/conduction/storage"
 	"github.com/sirupsen/logrus"
 )
 
+// CorrelationMetadataKey is set on messages sent to waiting Flows to the identity of the Continuation waiting on them. Connectors send it back in the
+// metadata of the response so the response is matched to its Continuation. Responses without it are dead lettered
+const CorrelationMetadataKey string = "conductionCorrelation"
+
 // Router handles all incoming messages and uses storage to reroute those messages
 type Router struct {
-	messenger  messenger.Messenger
-	storage    storage.Storage
-	topicNames TopicNames
+	messenger       messenger.Messenger
+	storage         storage.Storage
+	topicNames      TopicNames
+	namespaceTopics map[string]TopicNames
+	maxHops         int
+	inputTopic      string
+	deadLetterTopic string
+	retryPolicy     RetryPolicy
+	retryPolicies   map[string]RetryPolicy
+	waitTimeout     time.Duration
+	queues          []chan job
+	acks            *ackTracker
+	conditions      sync.Map
+	transforms      sync.Map
 
-	stop  chan bool
-	start chan bool
+	stop    chan bool
+	start   chan bool
+	done    chan bool
+	closing sync.Once
+	running sync.WaitGroup // Routing, the workers and the sweeper, which Close waits for
+	routing int32          // 1 between Start and Stop, so the sweeper only continues Continuations while routing
 }
 
 type RouterConfig struct {
-	TopicNames TopicNames
+	TopicNames      TopicNames
+	NamespaceTopics map[string]TopicNames  // Topics for the Path types of each namespace. Types a namespace has no topic for use TopicNames
+	MaxHops         int                    // Messages routed more than this many times are dropped. Defaults to DefaultMaxHops
+	InputTopic      string                 // Topic the Router consumes. Dead letters are replayed here
+	DeadLetterTopic string                 // Messages that could not be routed are sent here. Leave empty to drop them
+	RetryPolicy     RetryPolicy            // Retries sends to destinations whose Path type has no policy in RetryPolicies
+	RetryPolicies   map[string]RetryPolicy // Retry policy for each Path type
+	Workers         int                    // Messages processed at once. Messages from the same origin are always processed in order. Defaults to DefaultWorkers
+	WaitTimeout     time.Duration          // How long to wait for waiting Flows to respond before continuing with the responses received. Zero waits forever
+	SweepInterval   time.Duration          // How often to look for Continuations past their WaitTimeout. Defaults to DefaultSweepInterval
 }
 
 // TopicNames maps a routable type to a topic
@@ -31,97 +62,409 @@ var Logger = logrus.New()
 
 // NewRouter returns a new router that routes messages in/out of messenger based on storage
 func NewRouter(messenger messenger.Messenger, storage storage.Storage, config RouterConfig) *Router {
-	r := &Router{
-		messenger:  messenger,
-		storage:    storage,
-		topicNames: config.TopicNames,
-		stop:       make(chan bool),
-		start:      make(chan bool),
+	r := newRouter(messenger, storage, config)
+	workers := config.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	r.startWorkers(workers)
+	if r.waitTimeout > 0 {
+		sweepInterval := config.SweepInterval
+		if sweepInterval <= 0 {
+			sweepInterval = DefaultSweepInterval
+		}
+		r.running.Add(1)
+		go r.startSweeping(sweepInterval)
 	}
+	r.running.Add(1)
 	go r.startRouting()
 	return r
 }
 
+// newRouter returns a Router of the config without starting any of its goroutines
+func newRouter(messenger messenger.Messenger, storage storage.Storage, config RouterConfig) *Router {
+	r := &Router{
+		messenger:       messenger,
+		storage:         storage,
+		topicNames:      config.TopicNames,
+		namespaceTopics: config.NamespaceTopics,
+		maxHops:         config.MaxHops,
+		inputTopic:      config.InputTopic,
+		deadLetterTopic: config.DeadLetterTopic,
+		retryPolicy:     config.RetryPolicy,
+		retryPolicies:   config.RetryPolicies,
+		waitTimeout:     config.WaitTimeout,
+		acks:            newAckTracker(messenger),
+		stop:            make(chan bool),
+		start:           make(chan bool),
+		done:            make(chan bool),
+	}
+	if r.maxHops <= 0 {
+		r.maxHops = DefaultMaxHops
+	}
+	return r
+}
+
 // Start begins the message routing
 // Careful! Calling this multiple times will queue starts which can unexpectedly cancel out a stop calls
 func (r *Router) Start() {
 	go func() {
-		r.start <- true
+		select {
+		case r.start <- true:
+		case <-r.done:
+		}
 	}()
 }
 
-// Stop stops message routing
+// Stop pauses message routing and the continuing of expired Continuations until Start is called again
 func (r *Router) Stop() {
 	go func() {
-		r.stop <- true
+		select {
+		case r.stop <- true:
+		case <-r.done:
+		}
 	}()
 }
 
+// Close stops the Router for good and waits for its workers and sweeper to exit. Messages received but not yet processed are left unacknowledged so they are received again
+func (r *Router) Close() {
+	r.closing.Do(func() {
+		close(r.done)
+	})
+	r.running.Wait()
+}
+
 func (r *Router) startRouting() {
-	<-r.start
+	defer r.running.Done()
+	if !r.waitForStart() {
+		return
+	}
+	var sequence uint64
 	for {
 		select {
+		case <-r.done:
+			return
 		case <-r.stop:
-			<-r.start
+			if !r.waitForStart() {
+				return
+			}
 		case message := <-r.messenger.Receive():
-			err := r.processMessage(*message)
-			Logger.Debugln(err)
+			r.dispatch(sequence, message)
+			sequence++
 		}
 	}
 }
 
-func (r *Router) processMessage(message messenger.Message) (err error) {
-	defer func() {
-		switch {
-		case err == nil:
-			err = r.messenger.Acknowledge(&message)
-		default:
-			r.messenger.Acknowledge(&message)
-		}
-	}()
+// waitForStart pauses routing until Start is called. Returns false if the Router is closed first
+func (r *Router) waitForStart() bool {
+	atomic.StoreInt32(&r.routing, 0)
+	select {
+	case <-r.start:
+		atomic.StoreInt32(&r.routing, 1)
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// isRouting returns whether the Router has been started and not stopped since
+func (r *Router) isRouting() bool {
+	return atomic.LoadInt32(&r.routing) == 1
+}
+
+// processMessage routes the message and sends it to the dead letter topic if it could not be routed. The message is not acknowledged
+func (r *Router) processMessage(message messenger.Message) error {
+	stage, err := r.routeMessage(message)
+	if _, forwarded := err.(*ForwardError); err != nil && !forwarded {
+		r.sendToDeadLetterTopic(message, stage, err)
+	}
+	return err
+}
 
-	nextFlows, err := r.getNextFlowsForMessage(message)
+// routeMessage routes the message and returns the stage that failed if there is an error
+func (r *Router) routeMessage(message messenger.Message) (string, error) {
+	routed, err := r.recordHop(moveDestinationToReturn(message))
 	if err != nil {
-		return err
+		return stageHops, err
+	}
+	continued, err := r.continueWaitingFlows(routed)
+	switch {
+	case err == storage.ErrAwaitAlreadyReceived:
+		Logger.Infof("Dropping response from %v that every Continuation waiting on it has already received", routed.Origin)
+		return "", nil
+	case err != nil:
+		return stageContinuation, err
+	}
+	nextFlows, routeParams, err := r.getNextFlowsForMessage(routed)
+	routed.Metadata = setRouteParams(routed.Metadata, routeParams)
+	switch {
+	case err == storage.ErrPathNotFound && (continued || routed.Return != nil):
+		nextFlows = nil
+	case err != nil:
+		return stageLookup, err
+	}
+	nextFlows = r.filterFlowsByCondition(routed, nextFlows)
+	if continued && len(nextFlows) == 0 {
+		return "", nil
+	}
+	err = r.routeMessageToFlows(routed, nextFlows)
+	if err != nil {
+		return stageForward, err
 	}
-	if len(nextFlows) == 0 {
+	return "", nil
+}
+
+// routeMessageToFlows forwards the message to the Flows. When there are no Flows, the message has reached the end of its chain and is returned
+func (r *Router) routeMessageToFlows(message messenger.Message, flows []storage.Flow) error {
+	if len(flows) == 0 {
+		return r.returnMessage(message)
+	}
+	return r.forwardMessageToFlows(message, flows)
+}
+
+// returnMessage sends the message to its return Path if there is one
+func (r *Router) returnMessage(message messenger.Message) error {
+	if message.Return == nil {
 		Logger.Debugln("No next Flow for path", message.Origin)
-		return
+		return nil
 	}
-	err = r.forwardMessageToFlows(message, nextFlows)
-	return err
+	returnPath := *message.Return
+	message.Return = nil
+	return r.forwardMessageToDestinations(message, []messenger.Path{returnPath})
 }
 
-func (r *Router) getNextFlowsForMessage(message messenger.Message) ([]storage.Flow, error) {
+// moveDestinationToReturn treats a destination set by a connector as the Path to return to once the chain ends
+func moveDestinationToReturn(message messenger.Message) messenger.Message {
+	if message.Destination != nil {
+		if message.Return == nil {
+			message.Return = message.Destination
+		}
+		message.Destination = nil
+	}
+	return message
+}
+
+// getNextFlowsForMessage returns the Flows of the Path matching the origin exactly and of every pattern Path the origin matches, along with the route segments captured by the patterns
+func (r *Router) getNextFlowsForMessage(message messenger.Message) ([]storage.Flow, map[string]string, error) {
 	if message.Origin == nil {
-		return nil, errors.New("Message does not have an origin property")
+		return nil, nil, errors.New("Message does not have an origin property")
 	}
+	var nextFlows []storage.Flow
+	var nextFlowKeys []storage.Key
 	pathKey, err := r.storage.GetKeyOfPath(*message.Origin)
+	found := err == nil
+	switch {
+	case found:
+		nextFlows, nextFlowKeys, err = r.storage.GetNextFlows(pathKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	case err != storage.ErrPathNotFound:
+		return nil, nil, err
+	}
+	patternFlows, patternFlowKeys, routeParams, matched, err := r.getNextFlowsOfMatchingPatterns(*message.Origin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found && !matched {
+		return nil, nil, storage.ErrPathNotFound
+	}
+	nextFlows = setFlowUUIDs(nextFlows, nextFlowKeys)
+	nextFlows = appendNewFlows(nextFlows, setFlowUUIDs(patternFlows, patternFlowKeys))
+	return filterFlowsByNamespace(message.Origin.Namespace, filterEnabledFlows(nextFlows)), routeParams, nil
+}
+
+// filterEnabledFlows drops disabled Flows, which stay linked to their Paths but are not sent messages
+func filterEnabledFlows(flows []storage.Flow) []storage.Flow {
+	var enabled []storage.Flow
+	for _, flow := range flows {
+		if flow.Disabled {
+			Logger.Debugf("Skipping disabled Flow '%s'", flow.Name)
+			continue
+		}
+		enabled = append(enabled, flow)
+	}
+	return enabled
+}
+
+// filterFlowsByNamespace drops Flows that Paths of the namespace may not trigger. Storage rejects such links, so a dropped Flow means the Flow stopped sharing after it was linked
+func filterFlowsByNamespace(namespace string, flows []storage.Flow) []storage.Flow {
+	var allowed []storage.Flow
+	for _, flow := range flows {
+		if !flow.AllowsNamespace(namespace) {
+			Logger.Warnf("Skipping Flow '%s' in namespace '%s' that is not shared with namespace '%s'", flow.Name, flow.Namespace, namespace)
+			continue
+		}
+		allowed = append(allowed, flow)
+	}
+	return allowed
+}
+
+func setFlowUUIDs(flows []storage.Flow, keys []storage.Key) []storage.Flow {
+	for i := range keys {
+		if i < len(flows) {
+			flows[i].UUID = keys[i].UUID
+		}
+	}
+	return flows
+}
+
+// continueWaitingFlows resumes a Continuation if the message is a response it is waiting for. Returns whether the message was a response
+func (r *Router) continueWaitingFlows(message messenger.Message) (bool, error) {
+	if message.Origin == nil {
+		return false, nil
+	}
+	response := *message.Origin
+	response.Identity = string(message.Metadata[CorrelationMetadataKey])
+	awaitKeys, err := r.storage.GetKeysOfAwaits(response)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	nextFlows, _, err := r.storage.GetNextFlows(pathKey)
-	return nextFlows, err
+	if len(awaitKeys) == 0 {
+		return false, nil
+	}
+	continuation, continuationKey, err := r.resolveFirstAwait(awaitKeys, message.Payload)
+	if err != nil {
+		return true, err
+	}
+	if !continuation.IsComplete() {
+		return true, nil
+	}
+	err = r.storage.DeleteContinuation(continuationKey)
+	if err != nil {
+		return true, err
+	}
+	message.Payload = continuation.Payload()
+	message.Return = continuation.Return
+	message.Metadata = copyMetadata(message.Metadata)
+	delete(message.Metadata, CorrelationMetadataKey)
+	return true, r.routeMessageToFlows(message, continuation.Flows)
 }
 
+// resolveFirstAwait resolves the first Await that has not received its response yet, so several Continuations waiting on the same Path each get a response.
+// Returns ErrAwaitAlreadyReceived if every Await has, such as when the response is redelivered
+func (r *Router) resolveFirstAwait(awaitKeys []storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+	var err error
+	for _, awaitKey := range awaitKeys {
+		var continuation storage.Continuation
+		var continuationKey storage.Key
+		continuation, continuationKey, err = r.storage.ResolveAwait(awaitKey, payload)
+		if err != storage.ErrAwaitAlreadyReceived {
+			return continuation, continuationKey, err
+		}
+	}
+	return storage.Continuation{}, storage.Key{}, err
+}
+
+// forwardMessageToFlows sends the message to every Flow. If some Flows wait, the rest of the Flows and the return Path are held in a Continuation until the waiting Flows respond.
+// The identity of the Continuation is sent to the waiting Flows in the CorrelationMetadataKey metadata, leaving the identity of their Paths as the Flows set it
 func (r *Router) forwardMessageToFlows(message messenger.Message, nextFlows []storage.Flow) error {
-	for _, flow := range nextFlows {
-		err := r.forwardMessageToPath(message, *flow.Path)
+	waitingFlows, remainingFlows := splitWaitingFlows(nextFlows)
+	if len(waitingFlows) == 0 || (len(remainingFlows) == 0 && message.Return == nil) {
+		return r.forwardMessageToEachFlow(message, nextFlows, "")
+	}
+	identity := storage.NewRandomKey().String()
+	continuation := storage.Continuation{
+		Identity: identity,
+		Flows:    remainingFlows,
+		Return:   message.Return,
+		Origin:   message.Origin,
+		Metadata: hopMetadata(message.Metadata),
+	}
+	if r.waitTimeout > 0 {
+		continuation.Deadline = time.Now().Add(r.waitTimeout)
+	}
+	for _, flow := range waitingFlows {
+		continuation.Awaits = append(continuation.Awaits, storage.Await{
+			Name: flow.Name,
+			Path: flow.ResponsePath(),
+		})
+		continuation.Aggregate = continuation.Aggregate || flow.Aggregate
+	}
+	_, err := r.storage.SaveContinuation(continuation)
+	if err != nil {
+		return err
+	}
+	message.Return = nil
+	return r.forwardMessageToEachFlow(message, waitingFlows, identity)
+}
+
+func (r *Router) forwardMessageToEachFlow(message messenger.Message, flows []storage.Flow, identity string) error {
+	var outgoing []outgoingMessage
+	for _, flow := range flows {
+		flowMessage, err := r.transformMessage(message, flow)
 		if err != nil {
-			return err
+			Logger.Warnf("Skipping Flow '%s' with invalid transform. %v", flow.Name, err)
+			continue
+		}
+		if identity != "" {
+			flowMessage.Metadata = copyMetadata(flowMessage.Metadata)
+			flowMessage.Metadata[CorrelationMetadataKey] = []byte(identity)
 		}
+		outgoing = append(outgoing, outgoingMessage{
+			message:     flowMessage,
+			destination: *flow.Path,
+		})
+	}
+	return r.forwardOutgoingMessages(outgoing)
+}
+
+// forwardMessageToDestinations sends the same message to every destination
+func (r *Router) forwardMessageToDestinations(message messenger.Message, destinationPaths []messenger.Path) error {
+	outgoing := make([]outgoingMessage, len(destinationPaths))
+	for i, destinationPath := range destinationPaths {
+		outgoing[i] = outgoingMessage{
+			message:     message,
+			destination: destinationPath,
+		}
+	}
+	return r.forwardOutgoingMessages(outgoing)
+}
+
+// outgoingMessage is a message and the destination it is forwarded to
+type outgoingMessage struct {
+	message     messenger.Message
+	destination messenger.Path
+}
+
+// forwardOutgoingMessages sends every message even if some fail. Each failed destination is dead lettered and returned in a ForwardError
+func (r *Router) forwardOutgoingMessages(outgoing []outgoingMessage) error {
+	forwardErr := &ForwardError{}
+	for _, o := range outgoing {
+		err := r.forwardMessageToPath(o.message, o.destination)
+		if err != nil {
+			r.sendToDeadLetterTopic(addPathAsDestination(o.message, o.destination), stageForward, err)
+			forwardErr.Failures = append(forwardErr.Failures, DestinationError{
+				Destination: o.destination,
+				Err:         err,
+			})
+		}
+	}
+	if len(forwardErr.Failures) > 0 {
+		return forwardErr
 	}
 	return nil
 }
 
+func splitWaitingFlows(flows []storage.Flow) ([]storage.Flow, []storage.Flow) {
+	var waitingFlows, remainingFlows []storage.Flow
+	for _, flow := range flows {
+		if flow.Wait {
+			waitingFlows = append(waitingFlows, flow)
+		} else {
+			remainingFlows = append(remainingFlows, flow)
+		}
+	}
+	return waitingFlows, remainingFlows
+}
+
 func (r *Router) forwardMessageToPath(message messenger.Message, destinationPath messenger.Path) error {
 	message = addPathAsDestination(message, destinationPath)
-	topic, err := r.getTopicForPathType(destinationPath.Type)
+	topic, err := r.getTopicForPath(destinationPath)
 	if err != nil {
 		return err
 	}
-	err = r.messenger.Send(topic, &message)
-	return err
+	return r.sendWithRetry(topic, &message, destinationPath.Type)
 }
 
 func addPathAsDestination(message messenger.Message, path messenger.Path) messenger.Message {
@@ -129,6 +472,14 @@ func addPathAsDestination(message messenger.Message, path messenger.Path) messen
 	return message
 }
 
+// getTopicForPath returns the topic of the Path's type in its namespace, falling back to the topic of the type shared by every namespace
+func (r *Router) getTopicForPath(path messenger.Path) (string, error) {
+	if topic, ok := r.namespaceTopics[path.Namespace][path.Type]; ok {
+		return topic, nil
+	}
+	return r.getTopicForPathType(path.Type)
+}
+
 func (r *Router) getTopicForPathType(pathType string) (string, error) {
 	topic, ok := r.topicNames[pathType]
 	if !ok {
@@ -7,7 +7,7 @@ import (
 	"testing"
 )
 
-func TestStorage(t *testing.T) {
+func TestRouter(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Router Suite")
 }
@@ -3,7 +3,9 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/edfungus/conduction/messenger"
@@ -79,6 +81,7 @@ var _ = Describe("Conduction", func() {
 			})
 			Context("Cleanup", func() {
 				It("Cleanup mock functions", func() {
+					router.Close()
 					mockAcknowledge = nil
 					mockReceive = nil
 				})
@@ -100,6 +103,25 @@ var _ = Describe("Conduction", func() {
 					Expect(err).ToNot(BeNil())
 				})
 			})
+			Context("When the namespace of the Path has its own topic for the type", func() {
+				It("Then the topic of the namespace should be returned and other types should use the shared topic", func() {
+					namespaceConfig := config
+					namespaceConfig.NamespaceTopics = map[string]TopicNames{"team-a": {typeKeyREST: "team-a-REST"}}
+					namespaceRouter := NewRouter(mockMessenger, mockStorage, namespaceConfig)
+					defer namespaceRouter.Close()
+					topic, err := namespaceRouter.getTopicForPath(messenger.Path{Type: typeKeyREST, Namespace: "team-a"})
+					Expect(err).To(BeNil())
+					Expect(topic).To(Equal("team-a-REST"))
+					topic, err = namespaceRouter.getTopicForPath(messenger.Path{Type: typeKeyREST, Namespace: "team-b"})
+					Expect(err).To(BeNil())
+					Expect(topic).To(Equal(topicNames[typeKeyREST]))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup the Router", func() {
+					router.Close()
+				})
+			})
 		})
 		Describe("Given a message to be forwarded to a Path", func() {
 			router := NewRouter(mockMessenger, mockStorage, config)
@@ -117,125 +139,1545 @@ var _ = Describe("Conduction", func() {
 						Route: "/pass",
 						Type:  typeKeyREST,
 					}
-					mockSend = func(topic string, message *messenger.Message) error {
-						Expect(topic).To(Equal(topicNames[destinationToBeForwardedTo.Type]))
-						Expect(*message.Destination).To(Equal(destinationToBeForwardedTo))
+					mockSend = func(topic string, message *messenger.Message) error {
+						Expect(topic).To(Equal(topicNames[destinationToBeForwardedTo.Type]))
+						Expect(*message.Destination).To(Equal(destinationToBeForwardedTo))
+						return nil
+					}
+
+					err := router.forwardMessageToPath(messageToBeForwarded, destinationToBeForwardedTo)
+					Expect(err).To(BeNil())
+				})
+			})
+			Context("When the Path has a invalid type", func() {
+				It("Then a message should be not be sent and an error returned", func() {
+					badDestinationToBeForwardedTo := messenger.Path{
+						Route: "/pass",
+						Type:  "badType",
+					}
+					mockSend = func(topic string, message *messenger.Message) error {
+						Fail("Send should not have been called")
+						return nil
+					}
+
+					err := router.forwardMessageToPath(messageToBeForwarded, badDestinationToBeForwardedTo)
+					Expect(err).ToNot(BeNil())
+				})
+			})
+			Context("When the Path is incomplete", func() {
+				It("Then a message should be not be sent and an error returned", func() {
+					incompleteDestinationToBeForwardedTo := messenger.Path{}
+					mockSend = func(topic string, message *messenger.Message) error {
+						Fail("Send should not have been called")
+						return nil
+					}
+
+					err := router.forwardMessageToPath(messageToBeForwarded, incompleteDestinationToBeForwardedTo)
+					Expect(err).ToNot(BeNil())
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+				})
+			})
+		})
+		Describe("Given a message is to be processed", func() {
+			router := NewRouter(mockMessenger, mockStorage, config)
+			messageToBeForwarded := messenger.Message{
+				Origin: &messenger.Path{
+					Route: "/test",
+					Type:  "Origin type doesn't matter",
+				},
+				Payload: []byte("payload"),
+			}
+			Context("When the message has next Flows", func() {
+				It("Then the message should be forwarded to the next Flows", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					flow1 := storage.Flow{
+						Path: &messenger.Path{
+							Route: "/test1",
+							Type:  typeKeyREST,
+						},
+					}
+					flow2 := storage.Flow{
+						Path: &messenger.Path{
+							Route: "/test2",
+							Type:  typeKeyREST,
+						},
+					}
+					nextFlowArray := []storage.Flow{flow1, flow2}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return nextFlowArray, nil, nil
+					}
+					mockSendCalled := 0
+					mockSend = func(topic string, message *messenger.Message) error {
+						mockSendCalled++
+						return nil
+					}
+
+					err := router.processMessage(messageToBeForwarded)
+					Expect(err).To(BeNil())
+					Expect(mockSendCalled).To(Equal(2))
+				})
+			})
+			Context("When some of the next Flows are disabled", func() {
+				It("Then the message should only be forwarded to the enabled Flows", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					nextFlowArray := []storage.Flow{
+						{Path: &messenger.Path{Route: "/enabled", Type: typeKeyREST}},
+						{Path: &messenger.Path{Route: "/disabled", Type: typeKeyREST}, Disabled: true},
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return nextFlowArray, nil, nil
+					}
+					var sentRoutes []string
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentRoutes = append(sentRoutes, message.Destination.Route)
+						return nil
+					}
+
+					err := router.processMessage(messageToBeForwarded)
+					Expect(err).To(BeNil())
+					Expect(sentRoutes).To(Equal([]string{"/enabled"}))
+				})
+			})
+			Context("When the message has no next Flows", func() {
+				It("Then no messages should be forwarded", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					nextFlowArray := []storage.Flow{}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return nextFlowArray, nil, nil
+					}
+					mockSendCalled := 0
+					mockSend = func(topic string, message *messenger.Message) error {
+						mockSendCalled++
+						return nil
+					}
+
+					err := router.processMessage(messageToBeForwarded)
+					Expect(err).To(BeNil())
+					Expect(mockSendCalled).To(Equal(0))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+				})
+			})
+		})
+		Describe("Given a message triggers a Flow that waits", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, config)
+			messageToBeForwarded := messenger.Message{
+				Origin: &messenger.Path{
+					Route: "/test",
+					Type:  typeKeyREST,
+				},
+				Payload: []byte("payload"),
+			}
+			waitingFlow := storage.Flow{
+				Name: "waiting",
+				Path: &messenger.Path{
+					Route:    "turnOnLight",
+					Type:     "MQTT",
+					Identity: "kitchen-light",
+				},
+				Wait: true,
+				WaitFor: &messenger.Path{
+					Route: "lightOn",
+					Type:  "MQTT",
+				},
+			}
+			remainingFlow := storage.Flow{
+				Name: "remaining",
+				Path: &messenger.Path{
+					Route: "/remaining",
+					Type:  typeKeyREST,
+				},
+			}
+			Context("When the message is processed", func() {
+				It("Then only the waiting Flow should be forwarded with the identity of the Continuation and the rest saved as a Continuation", func() {
+					remainingKey := storage.NewRandomKey()
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{waitingFlow, remainingFlow}, []storage.Key{storage.NewRandomKey(), remainingKey}, nil
+					}
+					var savedContinuation storage.Continuation
+					mockSaveContinuation = func(continuation storage.Continuation) (storage.Key, error) {
+						savedContinuation = continuation
+						return storage.NewRandomKey(), nil
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						Expect(topic).To(Equal(topicNames["MQTT"]))
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messageToBeForwarded)
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(*sentMessages[0].Destination).To(Equal(*waitingFlow.Path))
+					Expect(sentMessages[0].Metadata[CorrelationMetadataKey]).To(Equal([]byte(savedContinuation.Identity)))
+					Expect(savedContinuation.Identity).ToNot(BeEmpty())
+					Expect(savedContinuation.Flows).To(HaveLen(1))
+					Expect(savedContinuation.Flows[0].UUID).To(Equal(remainingKey.UUID))
+					Expect(savedContinuation.Awaits).To(HaveLen(1))
+					Expect(*savedContinuation.Awaits[0].Path).To(Equal(*waitingFlow.WaitFor))
+				})
+			})
+			Context("When the response to the waiting Flow arrives", func() {
+				It("Then the Continuation should be removed and the remaining Flows forwarded with the response payload", func() {
+					awaitKey := storage.NewRandomKey()
+					continuationKey := storage.NewRandomKey()
+					response := messenger.Message{
+						Origin:   waitingFlow.WaitFor,
+						Payload:  []byte("DONE"),
+						Metadata: map[string][]byte{CorrelationMetadataKey: []byte("continuation")},
+					}
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						expected := *waitingFlow.WaitFor
+						expected.Identity = "continuation"
+						Expect(path).To(Equal(expected))
+						return []storage.Key{awaitKey}, nil
+					}
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						Expect(key).To(Equal(awaitKey))
+						return storage.Continuation{
+							Flows: []storage.Flow{remainingFlow},
+							Awaits: []storage.Await{{
+								Path:     waitingFlow.WaitFor,
+								Payload:  payload,
+								Received: true,
+							}},
+						}, continuationKey, nil
+					}
+					deleted := false
+					mockDeleteContinuation = func(key storage.Key) error {
+						Expect(key).To(Equal(continuationKey))
+						deleted = true
+						return nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, response)
+					Expect(deleted).To(BeTrue())
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(sentMessages[0].Destination.Route).To(Equal(remainingFlow.Path.Route))
+					Expect(sentMessages[0].Payload).To(Equal(response.Payload))
+					Expect(sentMessages[0].Metadata).ToNot(HaveKey(CorrelationMetadataKey))
+				})
+			})
+			Context("When a response without the identity of its Continuation arrives", func() {
+				It("Then it should be dead lettered rather than resolve an Await", func() {
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						Expect(path.Identity).To(BeEmpty())
+						return nil, storage.ErrAwaitIdentityMissing
+					}
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						Fail("No Await should have been resolved")
+						return storage.Continuation{}, storage.Key{}, nil
+					}
+					var sentTopics []string
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentTopics = append(sentTopics, topic)
+						return nil
+					}
+					deadLetterRouter := NewRouter(newNotifyingMessenger(), mockStorage, RouterConfig{
+						TopicNames:      topicNames,
+						DeadLetterTopic: "conductionDeadLetter",
+					})
+					defer deadLetterRouter.Close()
+
+					err := deadLetterRouter.processMessage(messenger.Message{Origin: waitingFlow.WaitFor, Payload: []byte("DONE")})
+					Expect(err).To(Equal(storage.ErrAwaitIdentityMissing))
+					Expect(sentTopics).To(Equal([]string{"conductionDeadLetter"}))
+				})
+			})
+			Context("When the first Await waiting on the response has already received one", func() {
+				It("Then the next Await should be resolved and its Continuation forwarded", func() {
+					received := storage.NewRandomKey()
+					waiting := storage.NewRandomKey()
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						return []storage.Key{received, waiting}, nil
+					}
+					var resolved []storage.Key
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						resolved = append(resolved, key)
+						if key == received {
+							return storage.Continuation{}, storage.Key{}, storage.ErrAwaitAlreadyReceived
+						}
+						return storage.Continuation{
+							Flows:  []storage.Flow{remainingFlow},
+							Awaits: []storage.Await{{Path: waitingFlow.WaitFor, Payload: payload, Received: true}},
+						}, storage.NewRandomKey(), nil
+					}
+					mockDeleteContinuation = func(key storage.Key) error {
+						return nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{Origin: waitingFlow.WaitFor, Payload: []byte("DONE")})
+					Expect(resolved).To(Equal([]storage.Key{received, waiting}))
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(sentMessages[0].Destination.Route).To(Equal(remainingFlow.Path.Route))
+				})
+			})
+			Context("When every Await waiting on the response has already received one", func() {
+				It("Then the response should be dropped without being dead lettered", func() {
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						return []storage.Key{storage.NewRandomKey()}, nil
+					}
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						return storage.Continuation{}, storage.Key{}, storage.ErrAwaitAlreadyReceived
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						Fail("Flows of the response Path should not have been looked up")
+						return storage.Key{}, nil
+					}
+					mockSend = func(topic string, message *messenger.Message) error {
+						Fail("Send should not have been called")
+						return nil
+					}
+					duplicateRouter := NewRouter(newNotifyingMessenger(), mockStorage, RouterConfig{
+						TopicNames:      topicNames,
+						DeadLetterTopic: "conductionDeadLetter",
+					})
+					defer duplicateRouter.Close()
+
+					err := duplicateRouter.processMessage(messenger.Message{Origin: waitingFlow.WaitFor, Payload: []byte("DONE")})
+					Expect(err).To(BeNil())
+				})
+			})
+			Context("When the response has not fully arrived", func() {
+				It("Then nothing should be forwarded", func() {
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						return []storage.Key{storage.NewRandomKey()}, nil
+					}
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						return storage.Continuation{
+							Flows:  []storage.Flow{remainingFlow},
+							Awaits: []storage.Await{{Received: true}, {Received: false}},
+						}, storage.NewRandomKey(), nil
+					}
+					mockDeleteContinuation = func(key storage.Key) error {
+						Fail("Continuation should not have been deleted")
+						return nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					mockSend = func(topic string, message *messenger.Message) error {
+						Fail("Send should not have been called")
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{Origin: waitingFlow.WaitFor})
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+					mockSaveContinuation = nil
+					mockGetKeysOfAwaits = nil
+					mockResolveAwait = nil
+					mockDeleteContinuation = nil
+				})
+			})
+		})
+		Describe("Given a message with a return Path", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, config)
+			returnPath := messenger.Path{
+				Type: typeKeyREST,
+				Metadata: map[string][]byte{
+					"controllerID": []byte("1"),
+				},
+			}
+			nextFlow := storage.Flow{
+				Name: "next",
+				Path: &messenger.Path{
+					Route:    "GET_/catpics",
+					Type:     typeKeyREST,
+					Metadata: map[string][]byte{"accept": []byte("image/png")},
+				},
+			}
+			BeforeEach(func() {
+				mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+					return storage.Key{}, nil
+				}
+			})
+			Context("When the origin has next Flows", func() {
+				It("Then the return Path should be passed along to the next Flows with their Path metadata", func() {
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{nextFlow}, nil, nil
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{
+						Origin: &messenger.Path{Route: "GET_/home", Type: typeKeyREST},
+						Return: &returnPath,
+					})
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(*sentMessages[0].Destination).To(Equal(*nextFlow.Path))
+					Expect(*sentMessages[0].Return).To(Equal(returnPath))
+				})
+			})
+			Context("When the origin has no next Flows", func() {
+				It("Then the payload should be sent to the return Path", func() {
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return nil, nil, nil
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						Expect(topic).To(Equal(topicNames[typeKeyREST]))
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{
+						Origin:  nextFlow.Path,
+						Return:  &returnPath,
+						Payload: []byte("catpic"),
+					})
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(*sentMessages[0].Destination).To(Equal(returnPath))
+					Expect(sentMessages[0].Return).To(BeNil())
+					Expect(sentMessages[0].Payload).To(Equal([]byte("catpic")))
+				})
+			})
+			Context("When a connector set the destination and the origin has no next Flows", func() {
+				It("Then the payload should be sent to the destination", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{
+						Origin:      nextFlow.Path,
+						Destination: &returnPath,
+						Payload:     []byte("catpic"),
+					})
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(*sentMessages[0].Destination).To(Equal(returnPath))
+				})
+			})
+			Context("When the only next Flow waits", func() {
+				It("Then the return Path should be held in a Continuation and not sent to the waiting Flow", func() {
+					waitingFlow := nextFlow
+					waitingFlow.Wait = true
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{waitingFlow}, nil, nil
+					}
+					var savedContinuation storage.Continuation
+					mockSaveContinuation = func(continuation storage.Continuation) (storage.Key, error) {
+						savedContinuation = continuation
+						return storage.NewRandomKey(), nil
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{
+						Origin: &messenger.Path{Route: "GET_/home", Type: typeKeyREST},
+						Return: &returnPath,
+					})
+					Expect(savedContinuation.Flows).To(HaveLen(0))
+					Expect(*savedContinuation.Return).To(Equal(returnPath))
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(sentMessages[0].Return).To(BeNil())
+					Expect(sentMessages[0].Metadata[CorrelationMetadataKey]).To(Equal([]byte(savedContinuation.Identity)))
+				})
+			})
+			Context("When the response completes a Continuation with a return Path and no Flows", func() {
+				It("Then the response payload should be sent to the return Path", func() {
+					mockGetKeysOfAwaits = func(path messenger.Path) ([]storage.Key, error) {
+						return []storage.Key{storage.NewRandomKey()}, nil
+					}
+					mockResolveAwait = func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+						return storage.Continuation{
+							Awaits: []storage.Await{{Payload: payload, Received: true}},
+							Return: &returnPath,
+						}, storage.NewRandomKey(), nil
+					}
+					mockDeleteContinuation = func(key storage.Key) error {
+						return nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
+						return nil
+					}
+
+					dispatchAndWait(router, messenger.Message{
+						Origin:  &messenger.Path{Route: "lightOn", Type: "MQTT"},
+						Payload: []byte("DONE"),
+					})
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(*sentMessages[0].Destination).To(Equal(returnPath))
+					Expect(sentMessages[0].Payload).To(Equal([]byte("DONE")))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+					mockSaveContinuation = nil
+					mockGetKeysOfAwaits = nil
+					mockResolveAwait = nil
+					mockDeleteContinuation = nil
+				})
+			})
+		})
+		Describe("Given a message is routed through several hops", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, RouterConfig{
+				TopicNames: topicNames,
+				MaxHops:    2,
+			})
+			origin := messenger.Path{
+				Route: "/origin",
+				Type:  typeKeyREST,
+			}
+			BeforeEach(func() {
+				mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+					return storage.Key{}, nil
+				}
+				mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+					return []storage.Flow{{Path: &messenger.Path{Route: "/next", Type: typeKeyREST}}}, nil, nil
+				}
+			})
+			Context("When the message is forwarded", func() {
+				It("Then the hop count and trail of visited Paths should be stamped in metadata", func() {
+					var sentMessage *messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessage = message
+						return nil
+					}
+					received := messenger.Message{Origin: &origin}
+
+					dispatchAndWait(router, received)
+					Expect(sentMessage).ToNot(BeNil())
+					Expect(string(sentMessage.Metadata[hopsMetadataKey])).To(Equal("1"))
+					Expect(string(sentMessage.Metadata[trailMetadataKey])).To(Equal(`["REST:/origin"]`))
+					Expect(received.Metadata).To(BeNil())
+
+					sentMessage.Origin = &messenger.Path{Route: "/next", Type: typeKeyREST}
+					sentMessage.Destination = nil
+					dispatchAndWait(router, *sentMessage)
+					Expect(string(sentMessage.Metadata[hopsMetadataKey])).To(Equal("2"))
+					Expect(string(sentMessage.Metadata[trailMetadataKey])).To(Equal(`["REST:/origin","REST:/next"]`))
+				})
+			})
+			Context("When the message has reached the max hop count", func() {
+				It("Then the message should be dropped with an error", func() {
+					mockSend = func(topic string, message *messenger.Message) error {
+						Fail("Send should not have been called")
+						return nil
+					}
+					acknowledged := false
+					mockAcknowledge = func(message *messenger.Message) error {
+						acknowledged = true
+						return nil
+					}
+
+					received := messenger.Message{
+						Origin: &origin,
+						Metadata: map[string][]byte{
+							hopsMetadataKey: []byte("2"),
+						},
+					}
+
+					err := router.processMessage(received)
+					Expect(err).To(Equal(ErrMaxHopsExceeded))
+					dispatchAndWait(router, received)
+					Expect(acknowledged).To(BeTrue())
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockAcknowledge = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+				})
+			})
+		})
+		Describe("Given Router has a dead letter topic", func() {
+			deadLetterConfig := RouterConfig{
+				TopicNames:      topicNames,
+				InputTopic:      "conductionIn",
+				DeadLetterTopic: "conductionDeadLetter",
+			}
+			router := NewRouter(newNotifyingMessenger(), mockStorage, deadLetterConfig)
+			replayer := NewReplayer(mockMessenger, deadLetterConfig)
+			origin := messenger.Path{
+				Route: "/origin",
+				Type:  typeKeyREST,
+			}
+			Context("When the next Flows cannot be found", func() {
+				It("Then the original message should be sent to the dead letter topic with the reason and stage", func() {
+					lookupErr := errors.New("lookup failed")
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, lookupErr
+					}
+					var sentTopic string
+					var sentMessage *messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentTopic = topic
+						sentMessage = message
+						return nil
+					}
+					acknowledged := false
+					mockAcknowledge = func(message *messenger.Message) error {
+						acknowledged = true
+						return nil
+					}
+					received := messenger.Message{
+						Origin:  &origin,
+						Payload: []byte("payload"),
+					}
+
+					err := router.processMessage(received)
+					Expect(err).To(Equal(lookupErr))
+					Expect(sentTopic).To(Equal("conductionDeadLetter"))
+					Expect(sentMessage.Payload).To(Equal(received.Payload))
+					Expect(sentMessage.Metadata[hopsMetadataKey]).To(BeNil())
+					Expect(string(sentMessage.Metadata[DeadLetterReasonKey])).To(Equal(lookupErr.Error()))
+					Expect(string(sentMessage.Metadata[DeadLetterStageKey])).To(Equal(stageLookup))
+					Expect(string(sentMessage.Metadata[DeadLetterAttemptsKey])).To(Equal("1"))
+					_, err = time.Parse(time.RFC3339Nano, string(sentMessage.Metadata[DeadLetterTimestampKey]))
+					Expect(err).To(BeNil())
+
+					dispatchAndWait(router, received)
+					Expect(acknowledged).To(BeTrue())
+				})
+			})
+			Context("When forwarding to the next Flow fails", func() {
+				It("Then the dead letter should record the forward stage and count the attempt", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/next", Type: typeKeyREST}}}, nil, nil
+					}
+					var deadLetter *messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						if topic == "conductionDeadLetter" {
+							deadLetter = message
+							return nil
+						}
+						return errors.New("send failed")
+					}
+
+					err := router.processMessage(messenger.Message{
+						Origin: &origin,
+						Metadata: map[string][]byte{
+							DeadLetterAttemptsKey: []byte("1"),
+						},
+					})
+					Expect(err).ToNot(BeNil())
+					Expect(deadLetter).ToNot(BeNil())
+					Expect(string(deadLetter.Metadata[DeadLetterStageKey])).To(Equal(stageForward))
+					Expect(string(deadLetter.Metadata[DeadLetterAttemptsKey])).To(Equal("2"))
+				})
+			})
+			Context("When a dead letter is replayed", func() {
+				It("Then it should be sent to the input topic without the failure metadata", func() {
+					var sentTopic string
+					var sentMessage *messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentTopic = topic
+						sentMessage = message
+						return nil
+					}
+					deadLetter := &messenger.Message{
+						Origin: &origin,
+						Metadata: map[string][]byte{
+							DeadLetterReasonKey:    []byte("lookup failed"),
+							DeadLetterStageKey:     []byte(stageLookup),
+							DeadLetterTimestampKey: []byte(time.Now().Format(time.RFC3339Nano)),
+							DeadLetterAttemptsKey:  []byte("1"),
+						},
+					}
+
+					err := replayer.ReplayDeadLetter(deadLetter)
+					Expect(err).To(BeNil())
+					Expect(sentTopic).To(Equal("conductionIn"))
+					Expect(sentMessage.Metadata).To(Equal(map[string][]byte{DeadLetterAttemptsKey: []byte("1")}))
+					Expect(deadLetter.Metadata).To(HaveLen(4))
+				})
+			})
+			Context("When a dead letter is replayed without an input topic", func() {
+				It("Then an error should be returned", func() {
+					replayer := NewReplayer(mockMessenger, RouterConfig{TopicNames: topicNames})
+					err := replayer.ReplayDeadLetter(&messenger.Message{Origin: &origin})
+					Expect(err).To(Equal(ErrInputTopicNotSet))
+				})
+			})
+			Context("When dead letters are replayed with a wait timeout", func() {
+				It("Then they should be replayed without sweeping storage for expired Continuations", func() {
+					waitConfig := deadLetterConfig
+					waitConfig.WaitTimeout = time.Millisecond
+					waitConfig.SweepInterval = time.Millisecond
+					replayer := NewReplayer(mockMessenger, waitConfig)
+					Expect(replayer.router.queues).To(BeEmpty())
+					broker := messenger.NewMemoryBroker()
+					deadLetters, err := messenger.NewMemoryMessenger(broker, &messenger.MemoryMessengerConfig{
+						ConsumerGroup:   "replay",
+						TopicsToConsume: []string{"conductionDeadLetter"},
+					})
+					Expect(err).To(BeNil())
+					defer deadLetters.Close()
+					Expect(deadLetters.Send("conductionDeadLetter", &messenger.Message{Origin: &origin})).To(BeNil())
+					mockSend = func(topic string, message *messenger.Message) error {
+						return nil
+					}
+
+					replayed, err := replayer.ReplayDeadLetters(deadLetters, time.Millisecond*200)
+					Expect(err).To(BeNil())
+					Expect(replayed).To(Equal(1))
+				})
+			})
+			Context("When dead letters are replayed from a dead letter Messenger", func() {
+				It("Then each dead letter should be replayed and acknowledged until none are left", func() {
+					broker := messenger.NewMemoryBroker()
+					deadLetters, err := messenger.NewMemoryMessenger(broker, &messenger.MemoryMessengerConfig{
+						ConsumerGroup:   "replay",
+						TopicsToConsume: []string{"conductionDeadLetter"},
+					})
+					Expect(err).To(BeNil())
+					defer deadLetters.Close()
+					for i := 0; i < 2; i++ {
+						Expect(deadLetters.Send("conductionDeadLetter", &messenger.Message{Origin: &origin})).To(BeNil())
+					}
+					replayedTopics := []string{}
+					mockSend = func(topic string, message *messenger.Message) error {
+						replayedTopics = append(replayedTopics, topic)
+						return nil
+					}
+
+					replayed, err := replayer.ReplayDeadLetters(deadLetters, time.Millisecond*200)
+					Expect(err).To(BeNil())
+					Expect(replayed).To(Equal(2))
+					Expect(replayedTopics).To(Equal([]string{"conductionIn", "conductionIn"}))
+				})
+			})
+			Context("When a dead letter that hit the max hop count is replayed", func() {
+				It("Then it should not be sent again and the rest should still be replayed", func() {
+					Expect(replayer.ReplayDeadLetter(&messenger.Message{
+						Origin:   &origin,
+						Metadata: map[string][]byte{DeadLetterStageKey: []byte(stageHops)},
+					})).To(Equal(ErrReplayWouldLoop))
+
+					broker := messenger.NewMemoryBroker()
+					deadLetters, err := messenger.NewMemoryMessenger(broker, &messenger.MemoryMessengerConfig{
+						ConsumerGroup:   "replay",
+						TopicsToConsume: []string{"conductionDeadLetter"},
+					})
+					Expect(err).To(BeNil())
+					defer deadLetters.Close()
+					Expect(deadLetters.Send("conductionDeadLetter", &messenger.Message{
+						Origin:   &origin,
+						Metadata: map[string][]byte{DeadLetterStageKey: []byte(stageHops)},
+					})).To(BeNil())
+					Expect(deadLetters.Send("conductionDeadLetter", &messenger.Message{Origin: &origin})).To(BeNil())
+					replayedTopics := []string{}
+					mockSend = func(topic string, message *messenger.Message) error {
+						replayedTopics = append(replayedTopics, topic)
+						return nil
+					}
+
+					replayed, err := replayer.ReplayDeadLetters(deadLetters, time.Millisecond*200)
+					Expect(err).To(BeNil())
+					Expect(replayed).To(Equal(1))
+					Expect(replayedTopics).To(Equal([]string{"conductionIn"}))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockAcknowledge = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+				})
+			})
+		})
+		Describe("Given sending to a destination can fail", func() {
+			retryConfig := RouterConfig{
+				TopicNames:      topicNames,
+				DeadLetterTopic: "conductionDeadLetter",
+				RetryPolicies: map[string]RetryPolicy{
+					typeKeyREST: {
+						MaxAttempts:    3,
+						InitialBackoff: time.Millisecond,
+					},
+				},
+			}
+			router := NewRouter(newNotifyingMessenger(), mockStorage, retryConfig)
+			origin := messenger.Path{
+				Route: "/origin",
+				Type:  typeKeyREST,
+			}
+			BeforeEach(func() {
+				mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+					return storage.Key{}, nil
+				}
+			})
+			Context("When a send fails fewer times than the retry policy allows", func() {
+				It("Then the message should be sent again until it succeeds", func() {
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/flaky", Type: typeKeyREST}}}, nil, nil
+					}
+					sends := 0
+					mockSend = func(topic string, message *messenger.Message) error {
+						sends++
+						if sends < 3 {
+							return errors.New("send failed")
+						}
+						return nil
+					}
+
+					err := router.processMessage(messenger.Message{Origin: &origin})
+					Expect(err).To(BeNil())
+					Expect(sends).To(Equal(3))
+				})
+			})
+			Context("When the Path type has no retry policy", func() {
+				It("Then the default retry policy should be used", func() {
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/flaky", Type: "MQTT"}}}, nil, nil
+					}
+					sends := 0
+					mockSend = func(topic string, message *messenger.Message) error {
+						if topic == "mqttTopic" {
+							sends++
+							return errors.New("send failed")
+						}
+						return nil
+					}
+
+					err := router.processMessage(messenger.Message{Origin: &origin})
+					Expect(err).ToNot(BeNil())
+					Expect(sends).To(Equal(1))
+				})
+			})
+			Context("When one of several destinations keeps failing", func() {
+				It("Then the other destinations should still receive the message and only the failed destination should be dead lettered", func() {
+					badPath := messenger.Path{Route: "/bad", Type: typeKeyREST}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{
+							{Path: &badPath},
+							{Path: &messenger.Path{Route: "/good", Type: typeKeyREST}},
+						}, nil, nil
+					}
+					delivered := []string{}
+					deadLetters := []*messenger.Message{}
+					mockSend = func(topic string, message *messenger.Message) error {
+						switch {
+						case topic == "conductionDeadLetter":
+							deadLetters = append(deadLetters, message)
+						case message.Destination.Route == badPath.Route:
+							return errors.New("send failed")
+						default:
+							delivered = append(delivered, message.Destination.Route)
+						}
+						return nil
+					}
+
+					err := router.processMessage(messenger.Message{Origin: &origin})
+					forwardErr, ok := err.(*ForwardError)
+					Expect(ok).To(BeTrue())
+					Expect(forwardErr.Failures).To(HaveLen(1))
+					Expect(forwardErr.Failures[0].Destination).To(Equal(badPath))
+					Expect(delivered).To(Equal([]string{"/good"}))
+					Expect(deadLetters).To(HaveLen(1))
+					Expect(*deadLetters[0].Destination).To(Equal(badPath))
+					Expect(string(deadLetters[0].Metadata[DeadLetterStageKey])).To(Equal(stageForward))
+				})
+			})
+			Context("When the Router is closed while waiting to retry a send", func() {
+				It("Then the retries should stop without waiting out the backoff", func() {
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/flaky", Type: typeKeyREST}}}, nil, nil
+					}
+					var sends int32
+					mockSend = func(topic string, message *messenger.Message) error {
+						if topic == "conductionDeadLetter" {
+							return nil
+						}
+						atomic.AddInt32(&sends, 1)
+						return errors.New("send failed")
+					}
+					slowConfig := retryConfig
+					slowConfig.RetryPolicies = map[string]RetryPolicy{
+						typeKeyREST: {MaxAttempts: 3, InitialBackoff: time.Hour},
+					}
+					slowRouter := NewRouter(newNotifyingMessenger(), mockStorage, slowConfig)
+					handled := make(chan error, 1)
+					go func() {
+						handled <- slowRouter.processMessage(messenger.Message{Origin: &origin})
+					}()
+					Eventually(func() int32 { return atomic.LoadInt32(&sends) }).Should(Equal(int32(1)))
+
+					slowRouter.Close()
+					var err error
+					Eventually(handled).Should(Receive(&err))
+					Expect(err).ToNot(BeNil())
+					Expect(atomic.LoadInt32(&sends)).To(Equal(int32(1)))
+				})
+			})
+			Context("When a dead letter that failed to forward is replayed", func() {
+				It("Then it should be sent straight to the topic of its destination", func() {
+					var sentTopic string
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentTopic = topic
+						return nil
+					}
+
+					err := NewReplayer(mockMessenger, retryConfig).ReplayDeadLetter(&messenger.Message{
+						Origin:      &origin,
+						Destination: &messenger.Path{Route: "/bad", Type: "MQTT"},
+						Metadata: map[string][]byte{
+							DeadLetterStageKey: []byte(stageForward),
+						},
+					})
+					Expect(err).To(BeNil())
+					Expect(sentTopic).To(Equal("mqttTopic"))
+				})
+			})
+			Context("When the backoff is calculated", func() {
+				It("Then it should grow by the multiplier up to the max backoff", func() {
+					policy := RetryPolicy{
+						InitialBackoff: time.Millisecond * 10,
+						MaxBackoff:     time.Millisecond * 30,
+					}
+					Expect(policy.backoff(1)).To(Equal(time.Millisecond * 10))
+					Expect(policy.backoff(2)).To(Equal(time.Millisecond * 20))
+					Expect(policy.backoff(3)).To(Equal(time.Millisecond * 30))
+
+					policy.Multiplier = 3
+					Expect(policy.backoff(2)).To(Equal(time.Millisecond * 30))
+				})
+				It("Then jitter should keep it within the jitter fraction", func() {
+					policy := RetryPolicy{
+						InitialBackoff: time.Millisecond * 100,
+						Jitter:         0.5,
+					}
+					for i := 0; i < 20; i++ {
+						Expect(policy.backoff(1)).To(BeNumerically("~", time.Millisecond*100, time.Millisecond*50))
+					}
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+				})
+			})
+		})
+		Describe("Given Router has several workers", func() {
+			slowOrigin := messenger.Path{Route: "/slow", Type: typeKeyREST}
+			fastOrigin := messenger.Path{Route: "/fast", Type: typeKeyREST}
+			Context("When messages from different origins are received", func() {
+				It("Then a slow origin should not hold up the others but acknowledgements should stay in received order", func() {
+					messageInChannel := make(chan *messenger.Message)
+					mockReceive = func() <-chan *messenger.Message {
+						return messageInChannel
+					}
+					acknowledged := make(chan string, 10)
+					mockAcknowledge = func(message *messenger.Message) error {
+						acknowledged <- string(message.Payload)
+						return nil
+					}
+					forwarded := make(chan string, 10)
+					mockSend = func(topic string, message *messenger.Message) error {
+						forwarded <- string(message.Payload)
+						return nil
+					}
+					releaseSlow := make(chan bool)
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						if path.Route == slowOrigin.Route {
+							<-releaseSlow
+						}
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/next", Type: typeKeyREST}}}, nil, nil
+					}
+					router := NewRouter(mockMessenger, mockStorage, RouterConfig{
+						TopicNames: topicNames,
+						Workers:    4,
+					})
+					Expect(workerForOrigin(&slowOrigin, 4)).ToNot(Equal(workerForOrigin(&fastOrigin, 4)))
+					router.Start()
+					defer router.Close()
+
+					messageInChannel <- &messenger.Message{Origin: &slowOrigin, Payload: []byte("slow")}
+					messageInChannel <- &messenger.Message{Origin: &fastOrigin, Payload: []byte("fast")}
+
+					Eventually(forwarded).Should(Receive(Equal("fast")))
+					Consistently(acknowledged, time.Millisecond*200).ShouldNot(Receive())
+
+					close(releaseSlow)
+					Eventually(forwarded).Should(Receive(Equal("slow")))
+					Eventually(acknowledged).Should(Receive(Equal("slow")))
+					Eventually(acknowledged).Should(Receive(Equal("fast")))
+				})
+			})
+			Context("When several messages from the same origin are received", func() {
+				It("Then they should be forwarded in the order they were received", func() {
+					messageInChannel := make(chan *messenger.Message)
+					mockReceive = func() <-chan *messenger.Message {
+						return messageInChannel
+					}
+					mockAcknowledge = func(message *messenger.Message) error {
+						return nil
+					}
+					forwarded := make(chan string, 10)
+					mockSend = func(topic string, message *messenger.Message) error {
+						forwarded <- string(message.Payload)
+						return nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &messenger.Path{Route: "/next", Type: typeKeyREST}}}, nil, nil
+					}
+					router := NewRouter(mockMessenger, mockStorage, RouterConfig{
+						TopicNames: topicNames,
+						Workers:    4,
+					})
+					router.Start()
+					defer router.Close()
+
+					payloads := []string{"1", "2", "3", "4", "5"}
+					for _, payload := range payloads {
+						messageInChannel <- &messenger.Message{Origin: &fastOrigin, Payload: []byte(payload)}
+					}
+					for _, payload := range payloads {
+						Eventually(forwarded).Should(Receive(Equal(payload)))
+					}
+				})
+			})
+			Context("When the same route is received in different namespaces", func() {
+				It("Then the namespace should be part of which worker is picked", func() {
+					workersUsed := map[int]bool{}
+					for _, namespace := range []string{"team-a", "team-b", "team-c", "team-d", "team-e", "team-f"} {
+						workersUsed[workerForOrigin(&messenger.Path{Route: "/fast", Type: typeKeyREST, Namespace: namespace}, 4)] = true
+					}
+					Expect(len(workersUsed)).To(BeNumerically(">", 1))
+				})
+			})
+			Context("When messages finish out of order", func() {
+				It("Then the ack tracker should acknowledge them in received order", func() {
+					acknowledged := []string{}
+					mockAcknowledge = func(message *messenger.Message) error {
+						acknowledged = append(acknowledged, string(message.Payload))
 						return nil
 					}
+					acks := newAckTracker(mockMessenger)
 
-					err := router.forwardMessageToPath(messageToBeForwarded, destinationToBeForwardedTo)
+					acks.finish(2, &messenger.Message{Payload: []byte("2")})
+					acks.finish(1, &messenger.Message{Payload: []byte("1")})
+					Expect(acknowledged).To(BeEmpty())
+					acks.finish(0, &messenger.Message{Payload: []byte("0")})
+					Expect(acknowledged).To(Equal([]string{"0", "1", "2"}))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					mockReceive = nil
+					mockAcknowledge = nil
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+				})
+			})
+		})
+		Describe("Given there are pattern Paths", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, config)
+			exactKey := storage.NewRandomKey()
+			templateKey := storage.NewRandomKey()
+			wildcardKey := storage.NewRandomKey()
+			sharedFlowKey := storage.NewRandomKey()
+			BeforeEach(func() {
+				mockGetPatternPaths = func(pathType string) ([]messenger.Path, []storage.Key, error) {
+					return []messenger.Path{
+						{Route: "sensors/{room}/temp", Type: "MQTT"},
+						{Route: "sensors/#", Type: "MQTT"},
+					}, []storage.Key{templateKey, wildcardKey}, nil
+				}
+				mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+					switch key {
+					case exactKey:
+						return []storage.Flow{{Name: "exact", Path: &messenger.Path{Route: "/exact", Type: typeKeyREST}}}, []storage.Key{storage.NewRandomKey()}, nil
+					case templateKey:
+						return []storage.Flow{
+							{Name: "template", Path: &messenger.Path{Route: "/template", Type: typeKeyREST}},
+							{Name: "shared", Path: &messenger.Path{Route: "/shared", Type: typeKeyREST}},
+						}, []storage.Key{storage.NewRandomKey(), sharedFlowKey}, nil
+					case wildcardKey:
+						return []storage.Flow{{Name: "shared", Path: &messenger.Path{Route: "/shared", Type: typeKeyREST}}}, []storage.Key{sharedFlowKey}, nil
+					}
+					return nil, nil, nil
+				}
+			})
+			Context("When the origin only matches patterns", func() {
+				It("Then the Flows of every matching pattern should be returned once with the captured segments", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					flows, routeParams, err := router.getNextFlowsForMessage(messenger.Message{
+						Origin: &messenger.Path{Route: "sensors/kitchen/temp", Type: "MQTT"},
+					})
 					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(2))
+					Expect(flows[0].Name).To(Equal("template"))
+					Expect(flows[1].Name).To(Equal("shared"))
+					Expect(routeParams).To(Equal(map[string]string{
+						"room": "kitchen",
+						"#":    "kitchen/temp",
+					}))
 				})
 			})
-			Context("When the Path has a invalid type", func() {
-				It("Then a message should be not be sent and an error returned", func() {
-					badDestinationToBeForwardedTo := messenger.Path{
-						Route: "/pass",
-						Type:  "badType",
+			Context("When the origin matches a Path exactly and a pattern", func() {
+				It("Then the Flows of both should be returned", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return exactKey, nil
+					}
+					flows, _, err := router.getNextFlowsForMessage(messenger.Message{
+						Origin: &messenger.Path{Route: "sensors/door", Type: "MQTT"},
+					})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(2))
+					Expect(flows[0].Name).To(Equal("exact"))
+					Expect(flows[1].Name).To(Equal("shared"))
+				})
+			})
+			Context("When the origin matches nothing", func() {
+				It("Then the Path should not be found", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					_, _, err := router.getNextFlowsForMessage(messenger.Message{
+						Origin: &messenger.Path{Route: "lights/kitchen", Type: "MQTT"},
+					})
+					Expect(err).To(Equal(storage.ErrPathNotFound))
+				})
+			})
+			Context("When the origin is in another namespace", func() {
+				It("Then patterns and Flows of other namespaces should not be matched unless shared", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					_, _, err := router.getNextFlowsForMessage(messenger.Message{
+						Origin: &messenger.Path{Route: "sensors/kitchen/temp", Type: "MQTT", Namespace: "team-b"},
+					})
+					Expect(err).To(Equal(storage.ErrPathNotFound))
+
+					flows := filterFlowsByNamespace("team-b", []storage.Flow{
+						{Name: "private", Namespace: "team-a"},
+						{Name: "shared", Namespace: "team-a", SharedWith: []string{"team-b"}},
+						{Name: "everyone", Namespace: "team-c", SharedWith: []string{storage.AllNamespaces}},
+						{Name: "own", Namespace: "team-b"},
+					})
+					Expect(flows).To(HaveLen(3))
+					Expect(flows[0].Name).To(Equal("shared"))
+				})
+			})
+			Context("When a message matching a pattern is forwarded", func() {
+				It("Then the captured segments should replace earlier route params in metadata", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
 					}
+					var sentMessage *messenger.Message
 					mockSend = func(topic string, message *messenger.Message) error {
-						Fail("Send should not have been called")
+						sentMessage = message
+						return nil
+					}
+					dispatchAndWait(router, messenger.Message{
+						Origin: &messenger.Path{Route: "sensors/porch/humidity", Type: "MQTT"},
+						Metadata: map[string][]byte{
+							RouteParamMetadataPrefix + "room": []byte("stale"),
+						},
+					})
+					Expect(sentMessage.Metadata).ToNot(HaveKey(RouteParamMetadataPrefix + "room"))
+					Expect(string(sentMessage.Metadata[RouteParamMetadataPrefix+"#"])).To(Equal("porch/humidity"))
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+					mockGetPatternPaths = nil
+				})
+			})
+		})
+		Describe("Given next Flows have conditions", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, config)
+			origin := messenger.Path{
+				Route: "sensors/kitchen/temp",
+				Type:  "MQTT",
+			}
+			BeforeEach(func() {
+				mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+					return storage.Key{}, nil
+				}
+				mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+					return []storage.Flow{
+						{Name: "always", Path: &messenger.Path{Route: "/always", Type: typeKeyREST}},
+						{Name: "hot", Path: &messenger.Path{Route: "/hot", Type: typeKeyREST}, Condition: `payload.temperature > 30`},
+						{Name: "mqtt", Path: &messenger.Path{Route: "/mqtt", Type: typeKeyREST}, Condition: `origin.type == "MQTT"`},
+						{Name: "invalid", Path: &messenger.Path{Route: "/invalid", Type: typeKeyREST}, Condition: `payload.temperature >`},
+					}, nil, nil
+				}
+			})
+			Context("When the message matches some conditions", func() {
+				It("Then the message should only be forwarded to Flows without a condition or with a matching condition", func() {
+					forwarded := []string{}
+					mockSend = func(topic string, message *messenger.Message) error {
+						forwarded = append(forwarded, message.Destination.Route)
 						return nil
 					}
 
-					err := router.forwardMessageToPath(messageToBeForwarded, badDestinationToBeForwardedTo)
-					Expect(err).ToNot(BeNil())
+					dispatchAndWait(router, messenger.Message{
+						Origin:  &origin,
+						Payload: []byte(`{"temperature": 20}`),
+					})
+					Expect(forwarded).To(Equal([]string{"/always", "/mqtt"}))
+
+					forwarded = []string{}
+					dispatchAndWait(router, messenger.Message{
+						Origin:  &origin,
+						Payload: []byte(`{"temperature": 35}`),
+					})
+					Expect(forwarded).To(Equal([]string{"/always", "/hot", "/mqtt"}))
 				})
 			})
-			Context("When the Path is incomplete", func() {
-				It("Then a message should be not be sent and an error returned", func() {
-					incompleteDestinationToBeForwardedTo := messenger.Path{}
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+				})
+			})
+		})
+		Describe("Given next Flows have transforms", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, config)
+			Context("When the message is forwarded", func() {
+				It("Then each Flow should receive the payload reshaped by its own transform", func() {
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{
+							{Name: "raw", Path: &messenger.Path{Route: "/raw", Type: typeKeyREST}},
+							{Name: "extract", Path: &messenger.Path{Route: "/extract", Type: typeKeyREST}, Transform: `{{payload.temp}}`},
+							{Name: "wrap", Path: &messenger.Path{Route: "/wrap", Type: typeKeyREST}, Transform: `{"reading": "{{payload}}", "from": "{{origin.route}}"}`},
+							{Name: "invalid", Path: &messenger.Path{Route: "/invalid", Type: typeKeyREST}, Transform: `{{payload.temp`},
+						}, nil, nil
+					}
+					forwarded := map[string]string{}
 					mockSend = func(topic string, message *messenger.Message) error {
-						Fail("Send should not have been called")
+						forwarded[message.Destination.Route] = string(message.Payload)
 						return nil
 					}
+					payload := `{"temp":21.5}`
 
-					err := router.forwardMessageToPath(messageToBeForwarded, incompleteDestinationToBeForwardedTo)
-					Expect(err).ToNot(BeNil())
+					dispatchAndWait(router, messenger.Message{
+						Origin:  &messenger.Path{Route: "/sensor", Type: typeKeyREST},
+						Payload: []byte(payload),
+					})
+					Expect(forwarded).To(HaveLen(3))
+					Expect(forwarded["/raw"]).To(Equal(payload))
+					Expect(forwarded["/extract"]).To(Equal("21.5"))
+					Expect(forwarded["/wrap"]).To(MatchJSON(`{"reading": {"temp": 21.5}, "from": "/sensor"}`))
 				})
 			})
 			Context("Cleanup", func() {
 				It("Cleanup mock functions", func() {
+					router.Close()
 					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
 				})
 			})
 		})
-		Describe("Given a message is to be processed", func() {
-			router := NewRouter(mockMessenger, mockStorage, config)
-			messageToBeForwarded := messenger.Message{
-				Origin: &messenger.Path{
-					Route: "/test",
-					Type:  "Origin type doesn't matter",
-				},
-				Payload: []byte("payload"),
+		Describe("Given waiting Flows aggregate their responses", func() {
+			router := NewRouter(newNotifyingMessenger(), mockStorage, RouterConfig{
+				TopicNames:    topicNames,
+				WaitTimeout:   time.Minute,
+				SweepInterval: time.Hour,
+			})
+			rest := storage.Flow{
+				Name: "rest",
+				Path: &messenger.Path{Route: "/merge", Type: typeKeyREST},
 			}
-			Context("When the message has next Flows", func() {
-				It("Then the message should be forwarded to the next Flows and original message acknowledged", func() {
+			Context("When the message is processed", func() {
+				It("Then the Continuation should aggregate and expire after the wait timeout", func() {
 					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
 						return storage.Key{}, nil
 					}
-					flow1 := storage.Flow{
-						Path: &messenger.Path{
-							Route: "/test1",
-							Type:  typeKeyREST,
-						},
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{
+							{Name: "weather", Path: &messenger.Path{Route: "/weather", Type: typeKeyREST}, Wait: true, Aggregate: true},
+							{Name: "traffic", Path: &messenger.Path{Route: "/traffic", Type: typeKeyREST}, Wait: true},
+							rest,
+						}, []storage.Key{storage.NewRandomKey(), storage.NewRandomKey(), storage.NewRandomKey()}, nil
 					}
-					flow2 := storage.Flow{
-						Path: &messenger.Path{
-							Route: "/test2",
-							Type:  typeKeyREST,
-						},
+					var savedContinuation storage.Continuation
+					mockSaveContinuation = func(continuation storage.Continuation) (storage.Key, error) {
+						savedContinuation = continuation
+						return storage.NewRandomKey(), nil
 					}
-					nextFlowArray := []storage.Flow{flow1, flow2}
-					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
-						return nextFlowArray, nil, nil
+					mockSend = func(topic string, message *messenger.Message) error {
+						return nil
 					}
-					acknowledgeCalled := 0
-					mockAcknowledge = func(message *messenger.Message) error {
-						acknowledgeCalled++
+					before := time.Now()
+
+					dispatchAndWait(router, messenger.Message{
+						Origin: &messenger.Path{Route: "/commute", Type: typeKeyREST},
+					})
+					Expect(savedContinuation.Aggregate).To(BeTrue())
+					Expect(savedContinuation.Awaits).To(HaveLen(2))
+					Expect(savedContinuation.Deadline).To(BeTemporally(">=", before.Add(time.Minute)))
+					Expect(*savedContinuation.Origin).To(Equal(messenger.Path{Route: "/commute", Type: typeKeyREST}))
+					Expect(savedContinuation.Metadata).To(Equal(map[string][]byte{
+						hopsMetadataKey:  []byte("1"),
+						trailMetadataKey: []byte(`["REST:/commute"]`),
+					}))
+				})
+			})
+			Context("When an aggregating Continuation expires", func() {
+				It("Then the responses received should be forwarded from the origin with its hops and the names of the Flows that did not respond", func() {
+					origin := messenger.Path{Route: "/commute", Type: typeKeyREST}
+					mockTakeExpiredContinuations = func(now time.Time) ([]storage.Continuation, error) {
+						return []storage.Continuation{{
+							Flows:     []storage.Flow{rest},
+							Aggregate: true,
+							Awaits: []storage.Await{
+								{Name: "weather", Payload: []byte(`{"rain":true}`), Received: true},
+								{Name: "traffic"},
+							},
+							Origin: &origin,
+							Metadata: map[string][]byte{
+								hopsMetadataKey:  []byte("3"),
+								trailMetadataKey: []byte(`["REST:/a","REST:/b","REST:/commute"]`),
+							},
+						}}, nil
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, storage.ErrPathNotFound
+					}
+					var sentMessages []*messenger.Message
+					mockSend = func(topic string, message *messenger.Message) error {
+						sentMessages = append(sentMessages, message)
 						return nil
 					}
-					mockSendCalled := 0
+
+					err := router.sweepExpiredContinuations(time.Now())
+					Expect(err).To(BeNil())
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(sentMessages[0].Destination.Route).To(Equal(rest.Path.Route))
+					Expect(sentMessages[0].Payload).To(MatchJSON(`{"weather": {"rain": true}, "traffic": null}`))
+					Expect(sentMessages[0].Metadata[AggregateMissingKey]).To(MatchJSON(`["traffic"]`))
+					Expect(*sentMessages[0].Origin).To(Equal(origin))
+					Expect(sentMessages[0].Metadata[hopsMetadataKey]).To(Equal([]byte("3")))
+					Expect(sentMessages[0].Metadata[trailMetadataKey]).To(MatchJSON(`["REST:/a","REST:/b","REST:/commute"]`))
+				})
+			})
+			Context("When an expired Continuation is missing no responses", func() {
+				It("Then the message should be forwarded without the names of missing Flows", func() {
+					mockTakeExpiredContinuations = func(now time.Time) ([]storage.Continuation, error) {
+						return []storage.Continuation{{Flows: []storage.Flow{rest}}}, nil
+					}
+					var sentMessages []*messenger.Message
 					mockSend = func(topic string, message *messenger.Message) error {
-						mockSendCalled++
+						sentMessages = append(sentMessages, message)
 						return nil
 					}
 
-					err := router.processMessage(messageToBeForwarded)
+					err := router.sweepExpiredContinuations(time.Now())
 					Expect(err).To(BeNil())
-					Expect(acknowledgeCalled).To(Equal(1))
-					Expect(mockSendCalled).To(Equal(2))
+					Expect(sentMessages).To(HaveLen(1))
+					Expect(sentMessages[0].Metadata).ToNot(HaveKey(AggregateMissingKey))
 				})
 			})
-			Context("When the message has no next Flows", func() {
-				It("Then no messages should be forwarded and original message acknowledged", func() {
-					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
-						return storage.Key{}, nil
+			Context("When taking expired Continuations fails", func() {
+				It("Then the error should be returned", func() {
+					mockTakeExpiredContinuations = func(now time.Time) ([]storage.Continuation, error) {
+						return nil, errors.New("storage unavailable")
 					}
-					nextFlowArray := []storage.Flow{}
-					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
-						return nextFlowArray, nil, nil
+
+					err := router.sweepExpiredContinuations(time.Now())
+					Expect(err).ToNot(BeNil())
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					router.Close()
+					mockSend = nil
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
+					mockSaveContinuation = nil
+					mockTakeExpiredContinuations = nil
+				})
+			})
+		})
+		Describe("Given Router continues expired Continuations", func() {
+			Context("When the Router is stopped and then closed", func() {
+				It("Then expired Continuations should only be forwarded while the Router is routing", func() {
+					mockReceive = func() <-chan *messenger.Message {
+						return make(chan *messenger.Message)
 					}
-					acknowledgeCalled := 0
-					mockAcknowledge = func(message *messenger.Message) error {
-						acknowledgeCalled++
-						return nil
+					mockTakeExpiredContinuations = func(now time.Time) ([]storage.Continuation, error) {
+						return []storage.Continuation{{
+							Flows: []storage.Flow{{Name: "rest", Path: &messenger.Path{Route: "/rest", Type: typeKeyREST}}},
+						}}, nil
 					}
-					mockSendCalled := 0
+					forwarded := make(chan string, 100)
 					mockSend = func(topic string, message *messenger.Message) error {
-						mockSendCalled++
+						forwarded <- message.Destination.Route
 						return nil
 					}
+					drain := func() {
+						for len(forwarded) > 0 {
+							<-forwarded
+						}
+					}
+					router := NewRouter(mockMessenger, mockStorage, RouterConfig{
+						TopicNames:    topicNames,
+						WaitTimeout:   time.Minute,
+						SweepInterval: time.Millisecond * 10,
+					})
+					Consistently(forwarded, time.Millisecond*100).ShouldNot(Receive())
 
-					err := router.processMessage(messageToBeForwarded)
-					Expect(err).To(BeNil())
-					Expect(acknowledgeCalled).To(Equal(1))
-					Expect(mockSendCalled).To(Equal(0))
+					router.Start()
+					Eventually(forwarded).Should(Receive(Equal("/rest")))
+					router.Stop()
+					Eventually(router.isRouting).Should(BeFalse())
+					time.Sleep(time.Millisecond * 50)
+					drain()
+					Consistently(forwarded, time.Millisecond*100).ShouldNot(Receive())
+
+					router.Start()
+					Eventually(forwarded).Should(Receive(Equal("/rest")))
+					router.Close()
+					drain()
+					Consistently(forwarded, time.Millisecond*100).ShouldNot(Receive())
 				})
 			})
 			Context("Cleanup", func() {
 				It("Cleanup mock functions", func() {
+					mockReceive = nil
 					mockSend = nil
+					mockTakeExpiredContinuations = nil
+				})
+			})
+		})
+		Describe("Given Router is connected to an in-memory Messenger", func() {
+			Context("When a message arrives with next Flows", func() {
+				It("Then the message should be delivered to the topic of the next Flow and acknowledged", func() {
+					broker := messenger.NewMemoryBroker()
+					routerMessenger, err := messenger.NewMemoryMessenger(broker, &messenger.MemoryMessengerConfig{
+						ConsumerGroup:   "conduction",
+						TopicsToConsume: []string{"conductionIn"},
+					})
+					Expect(err).To(BeNil())
+					defer routerMessenger.Close()
+					connectorMessenger, err := messenger.NewMemoryMessenger(broker, &messenger.MemoryMessengerConfig{
+						ConsumerGroup:   "connector",
+						TopicsToConsume: []string{topicNames[typeKeyREST]},
+					})
+					Expect(err).To(BeNil())
+					defer connectorMessenger.Close()
+
+					destination := messenger.Path{
+						Route: "/destination",
+						Type:  typeKeyREST,
+					}
+					mockGetKeyOfPath = func(path messenger.Path) (storage.Key, error) {
+						return storage.Key{}, nil
+					}
+					mockGetNextFlows = func(key storage.Key) ([]storage.Flow, []storage.Key, error) {
+						return []storage.Flow{{Path: &destination}}, nil, nil
+					}
+
+					router := NewRouter(routerMessenger, mockStorage, config)
+					router.Start()
+					defer router.Close()
+
+					err = connectorMessenger.Send("conductionIn", &messenger.Message{
+						Origin: &messenger.Path{
+							Route: "/origin",
+							Type:  typeKeyREST,
+						},
+						Payload: []byte("payload"),
+					})
+					Expect(err).To(BeNil())
+
+					select {
+					case message := <-connectorMessenger.Receive():
+						Expect(message.Destination.Route).To(Equal(destination.Route))
+						Expect(message.Payload).To(Equal([]byte("payload")))
+						Expect(connectorMessenger.Acknowledge(message)).To(BeNil())
+					case <-time.After(time.Second * 1):
+						Fail("Message should have been routed to the connector")
+					}
+				})
+			})
+			Context("Cleanup", func() {
+				It("Cleanup mock functions", func() {
+					mockGetKeyOfPath = nil
+					mockGetNextFlows = nil
 				})
 			})
 		})
@@ -246,11 +1688,31 @@ type mockStorage struct{}
 
 var mockSaveFlow func(flow storage.Flow) (storage.Key, error)
 var mockGetFlowByKey func(key storage.Key) (storage.Flow, error)
+var mockUpdateFlow func(key storage.Key, flow storage.Flow) error
+var mockDeleteFlow func(key storage.Key) error
+var mockListFlows func(options storage.ListOptions) ([]storage.Flow, []storage.Key, string, error)
 var mockSavePath func(path messenger.Path) (storage.Key, error)
 var mockGetPathByKey func(key storage.Key) (messenger.Path, error)
+var mockGetStoredPathByKey func(key storage.Key) (storage.StoredPath, error)
 var mockGetKeyOfPath func(path messenger.Path) (storage.Key, error)
+var mockGetPatternPaths func(pathType string) ([]messenger.Path, []storage.Key, error)
+var mockDeletePath func(key storage.Key) error
+var mockListPaths func(options storage.ListOptions) ([]storage.StoredPath, []storage.Key, string, error)
 var mockChainNextFlowToPath func(flowKey storage.Key, pathKey storage.Key) error
+var mockUnchainNextFlowFromPath func(flowKey storage.Key, pathKey storage.Key) error
 var mockGetNextFlows func(key storage.Key) ([]storage.Flow, []storage.Key, error)
+var mockGetDownstream func(pathKey storage.Key, maxDepth int) (storage.Traversal, error)
+var mockGetUpstream func(pathKey storage.Key, maxDepth int) (storage.Traversal, error)
+var mockGetGraph func() (storage.Graph, error)
+var mockGetGraphAroundPath func(pathKey storage.Key, maxDepth int) (storage.Graph, error)
+var mockSaveContinuation func(continuation storage.Continuation) (storage.Key, error)
+var mockGetContinuationByKey func(key storage.Key) (storage.Continuation, error)
+var mockGetKeysOfAwaits func(path messenger.Path) ([]storage.Key, error)
+var mockResolveAwait func(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error)
+var mockDeleteContinuation func(key storage.Key) error
+var mockTakeExpiredContinuations func(now time.Time) ([]storage.Continuation, error)
+var mockAppendAuditEntry func(entry storage.AuditEntry) error
+var mockListAuditEntries func(options storage.AuditOptions) ([]storage.AuditEntry, string, error)
 
 func (ms *mockStorage) SaveFlow(flow storage.Flow) (storage.Key, error) {
 	if mockSaveFlow == nil {
@@ -268,6 +1730,30 @@ func (ms *mockStorage) GetFlowByKey(key storage.Key) (storage.Flow, error) {
 	return mockGetFlowByKey(key)
 }
 
+func (ms *mockStorage) UpdateFlow(key storage.Key, flow storage.Flow) error {
+	if mockUpdateFlow == nil {
+		fmt.Println("UpdateFlow not implemented")
+		return nil
+	}
+	return mockUpdateFlow(key, flow)
+}
+
+func (ms *mockStorage) DeleteFlow(key storage.Key) error {
+	if mockDeleteFlow == nil {
+		fmt.Println("DeleteFlow not implemented")
+		return nil
+	}
+	return mockDeleteFlow(key)
+}
+
+func (ms *mockStorage) ListFlows(options storage.ListOptions) ([]storage.Flow, []storage.Key, string, error) {
+	if mockListFlows == nil {
+		fmt.Println("ListFlows not implemented")
+		return nil, nil, "", nil
+	}
+	return mockListFlows(options)
+}
+
 func (ms *mockStorage) SavePath(path messenger.Path) (storage.Key, error) {
 	if mockSavePath == nil {
 		fmt.Println("SavePath not implemented")
@@ -284,6 +1770,14 @@ func (ms *mockStorage) GetPathByKey(key storage.Key) (messenger.Path, error) {
 	return mockGetPathByKey(key)
 }
 
+func (ms *mockStorage) GetStoredPathByKey(key storage.Key) (storage.StoredPath, error) {
+	if mockGetStoredPathByKey == nil {
+		fmt.Println("GetStoredPathByKey not implemented")
+		return storage.StoredPath{}, nil
+	}
+	return mockGetStoredPathByKey(key)
+}
+
 func (ms *mockStorage) GetKeyOfPath(path messenger.Path) (storage.Key, error) {
 	if mockGetKeyOfPath == nil {
 		fmt.Println("GetKeyOfPath not implemented")
@@ -292,6 +1786,22 @@ func (ms *mockStorage) GetKeyOfPath(path messenger.Path) (storage.Key, error) {
 	return mockGetKeyOfPath(path)
 }
 
+func (ms *mockStorage) DeletePath(key storage.Key) error {
+	if mockDeletePath == nil {
+		fmt.Println("DeletePath not implemented")
+		return nil
+	}
+	return mockDeletePath(key)
+}
+
+func (ms *mockStorage) ListPaths(options storage.ListOptions) ([]storage.StoredPath, []storage.Key, string, error) {
+	if mockListPaths == nil {
+		fmt.Println("ListPaths not implemented")
+		return nil, nil, "", nil
+	}
+	return mockListPaths(options)
+}
+
 func (ms *mockStorage) ChainNextFlowToPath(flowKey storage.Key, pathKey storage.Key) error {
 	if mockChainNextFlowToPath == nil {
 		fmt.Println("ChainNextFlowToPath not implemented")
@@ -300,6 +1810,14 @@ func (ms *mockStorage) ChainNextFlowToPath(flowKey storage.Key, pathKey storage.
 	return mockChainNextFlowToPath(flowKey, pathKey)
 }
 
+func (ms *mockStorage) UnchainNextFlowFromPath(flowKey storage.Key, pathKey storage.Key) error {
+	if mockUnchainNextFlowFromPath == nil {
+		fmt.Println("UnchainNextFlowFromPath not implemented")
+		return nil
+	}
+	return mockUnchainNextFlowFromPath(flowKey, pathKey)
+}
+
 func (ms *mockStorage) GetNextFlows(key storage.Key) ([]storage.Flow, []storage.Key, error) {
 	if mockGetNextFlows == nil {
 		fmt.Println("GetNextFlows not implemented")
@@ -308,6 +1826,111 @@ func (ms *mockStorage) GetNextFlows(key storage.Key) ([]storage.Flow, []storage.
 	return mockGetNextFlows(key)
 }
 
+func (ms *mockStorage) GetDownstream(pathKey storage.Key, maxDepth int) (storage.Traversal, error) {
+	if mockGetDownstream == nil {
+		fmt.Println("GetDownstream not implemented")
+		return storage.Traversal{}, nil
+	}
+	return mockGetDownstream(pathKey, maxDepth)
+}
+
+func (ms *mockStorage) GetUpstream(pathKey storage.Key, maxDepth int) (storage.Traversal, error) {
+	if mockGetUpstream == nil {
+		fmt.Println("GetUpstream not implemented")
+		return storage.Traversal{}, nil
+	}
+	return mockGetUpstream(pathKey, maxDepth)
+}
+
+func (ms *mockStorage) GetGraph() (storage.Graph, error) {
+	if mockGetGraph == nil {
+		fmt.Println("GetGraph not implemented")
+		return storage.Graph{}, nil
+	}
+	return mockGetGraph()
+}
+
+func (ms *mockStorage) GetGraphAroundPath(pathKey storage.Key, maxDepth int) (storage.Graph, error) {
+	if mockGetGraphAroundPath == nil {
+		fmt.Println("GetGraphAroundPath not implemented")
+		return storage.Graph{}, nil
+	}
+	return mockGetGraphAroundPath(pathKey, maxDepth)
+}
+
+func (ms *mockStorage) SaveContinuation(continuation storage.Continuation) (storage.Key, error) {
+	if mockSaveContinuation == nil {
+		fmt.Println("SaveContinuation not implemented")
+		return storage.Key{}, nil
+	}
+	return mockSaveContinuation(continuation)
+}
+
+func (ms *mockStorage) GetContinuationByKey(key storage.Key) (storage.Continuation, error) {
+	if mockGetContinuationByKey == nil {
+		fmt.Println("GetContinuationByKey not implemented")
+		return storage.Continuation{}, nil
+	}
+	return mockGetContinuationByKey(key)
+}
+
+func (ms *mockStorage) GetPatternPaths(pathType string) ([]messenger.Path, []storage.Key, error) {
+	if mockGetPatternPaths == nil {
+		return nil, nil, nil
+	}
+	return mockGetPatternPaths(pathType)
+}
+
+func (ms *mockStorage) GetKeysOfAwaits(path messenger.Path) ([]storage.Key, error) {
+	if mockGetKeysOfAwaits == nil {
+		return nil, nil
+	}
+	return mockGetKeysOfAwaits(path)
+}
+
+func (ms *mockStorage) ResolveAwait(key storage.Key, payload []byte) (storage.Continuation, storage.Key, error) {
+	if mockResolveAwait == nil {
+		fmt.Println("ResolveAwait not implemented")
+		return storage.Continuation{}, storage.Key{}, nil
+	}
+	return mockResolveAwait(key, payload)
+}
+
+func (ms *mockStorage) DeleteContinuation(key storage.Key) error {
+	if mockDeleteContinuation == nil {
+		fmt.Println("DeleteContinuation not implemented")
+		return nil
+	}
+	return mockDeleteContinuation(key)
+}
+
+func (ms *mockStorage) TakeExpiredContinuations(now time.Time) ([]storage.Continuation, error) {
+	if mockTakeExpiredContinuations == nil {
+		return nil, nil
+	}
+	return mockTakeExpiredContinuations(now)
+}
+
+func (ms *mockStorage) AppendAuditEntry(entry storage.AuditEntry) error {
+	if mockAppendAuditEntry == nil {
+		fmt.Println("AppendAuditEntry not implemented")
+		return nil
+	}
+	return mockAppendAuditEntry(entry)
+}
+
+func (ms *mockStorage) ListAuditEntries(options storage.AuditOptions) ([]storage.AuditEntry, string, error) {
+	if mockListAuditEntries == nil {
+		fmt.Println("ListAuditEntries not implemented")
+		return nil, "", nil
+	}
+	return mockListAuditEntries(options)
+}
+
+func (ms *mockStorage) Atomically(change func(storage.Storage) error) error {
+	return change(ms)
+}
+
 type mockMessenger struct{}
 
 var mockSend func(topic string, message *messenger.Message) error
@@ -346,3 +1969,32 @@ func (mm *mockMessenger) Close() error {
 	}
 	return mockClose()
 }
+
+// dispatchAndWait hands the message to the workers of a Router made with a notifyingMessenger as if it had been received, and waits until it is acknowledged
+func dispatchAndWait(router *Router, message messenger.Message) {
+	notifying := router.messenger.(*notifyingMessenger)
+	router.dispatch(notifying.dispatched, &message)
+	notifying.dispatched++
+	Eventually(notifying.acknowledged).Should(Receive())
+}
+
+// notifyingMessenger passes every call to the mock Messenger and reports each acknowledgement, so tests can wait for the workers of a Router to finish a message
+type notifyingMessenger struct {
+	messenger.Messenger
+	dispatched   uint64
+	acknowledged chan *messenger.Message
+}
+
+func newNotifyingMessenger() *notifyingMessenger {
+	return &notifyingMessenger{
+		Messenger:    &mockMessenger{},
+		acknowledged: make(chan *messenger.Message, workerQueueSize),
+	}
+}
+
+func (nm *notifyingMessenger) Acknowledge(message *messenger.Message) error {
+	defer func() {
+		nm.acknowledged <- message
+	}()
+	return nm.Messenger.Acknowledge(message)
+}
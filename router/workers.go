@@ -0,0 +1,100 @@
+package router
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	DefaultWorkers  int = 1
+	workerQueueSize int = 16
+)
+
+// job is a received message and the order it was received in
+type job struct {
+	sequence uint64
+	message  *messenger.Message
+}
+
+// ackTracker acknowledges messages in the order they were received even when they finish out of order, so offsets are never committed past an unfinished message
+type ackTracker struct {
+	lock      sync.Mutex
+	messenger messenger.Messenger
+	next      uint64
+	finished  map[uint64]*messenger.Message
+}
+
+func newAckTracker(m messenger.Messenger) *ackTracker {
+	return &ackTracker{
+		messenger: m,
+		finished:  make(map[uint64]*messenger.Message),
+	}
+}
+
+// finish records the message as processed and acknowledges every message received before it that has also finished
+func (at *ackTracker) finish(sequence uint64, message *messenger.Message) {
+	at.lock.Lock()
+	defer at.lock.Unlock()
+	at.finished[sequence] = message
+	for {
+		message, ok := at.finished[at.next]
+		if !ok {
+			return
+		}
+		delete(at.finished, at.next)
+		at.next++
+		err := at.messenger.Acknowledge(message)
+		if err != nil {
+			Logger.Debugln(err)
+		}
+	}
+}
+
+// startWorkers starts a worker for each queue. Workers keep running while the Router is stopped so in flight messages finish, and exit once it is closed
+func (r *Router) startWorkers(workers int) {
+	r.queues = make([]chan job, workers)
+	for i := range r.queues {
+		r.queues[i] = make(chan job, workerQueueSize)
+		r.running.Add(1)
+		go r.work(r.queues[i])
+	}
+}
+
+func (r *Router) work(queue <-chan job) {
+	defer r.running.Done()
+	for {
+		select {
+		case <-r.done:
+			return
+		case job := <-queue:
+			err := r.processMessage(*job.message)
+			if err != nil {
+				Logger.Debugln(err)
+			}
+			r.acks.finish(job.sequence, job.message)
+		}
+	}
+}
+
+// dispatch queues the message to the worker for its origin so messages from the same origin are processed in order. The message is dropped unacknowledged if the Router is closed first
+func (r *Router) dispatch(sequence uint64, message *messenger.Message) {
+	select {
+	case r.queues[workerForOrigin(message.Origin, len(r.queues))] <- job{
+		sequence: sequence,
+		message:  message,
+	}:
+	case <-r.done:
+	}
+}
+
+// workerForOrigin hashes the namespace along with the type and route so the same route in different namespaces is not tied to one worker
+func workerForOrigin(origin *messenger.Path, workers int) int {
+	if origin == nil {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(origin.Namespace + "/" + describePath(*origin)))
+	return int(hash.Sum32() % uint32(workers))
+}
@@ -0,0 +1,567 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/graph/iterator"
+	"github.com/cayleygraph/cayley/graph/path"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+	"github.com/edfungus/conduction/messenger"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	AuditActionCreate   string = "create"
+	AuditActionUpdate   string = "update"
+	AuditActionDelete   string = "delete"
+	AuditActionLink     string = "link"   // A Path was chained to trigger a Flow
+	AuditActionUnlink   string = "unlink" // A Path was unchained from a Flow
+	AuditActionRollback string = "rollback"
+
+	AuditKindFlow string = "flow"
+	AuditKindPath string = "path"
+)
+
+var (
+	ErrVersionNotInAudit error = fmt.Errorf("Audit log has no snapshot of the Flow at that version")
+)
+
+// AuditEntry records one change to a Flow or Path: who made it, when, and the Flow or Path before and after.
+// Links and unlinks are recorded against the Path, whose snapshots list the Flows it triggers, and against the Flow, whose version they move
+type AuditEntry struct {
+	UUID      uuid.UUID       `json:"uuid"`
+	At        time.Time       `json:"at"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Kind      string          `json:"kind"`
+	Target    uuid.UUID       `json:"target"`              // Flow or Path that was changed
+	Flow      uuid.UUID       `json:"flow,omitempty"`      // Flow linked to or unlinked from the Path
+	Namespace string          `json:"namespace,omitempty"` // Namespace of the Flow or Path that was changed
+	Before    json.RawMessage `json:"before,omitempty"`    // Empty when created
+	After     json.RawMessage `json:"after,omitempty"`     // Empty when deleted
+}
+
+// AuditOptions narrows and pages the audit log, which is listed newest first
+type AuditOptions struct {
+	Actor     string
+	Action    string
+	Kind      string
+	Target    uuid.UUID // Only changes to this Flow or Path. Also matches links naming it as the Flow
+	Namespace string    // Only changes to Flows and Paths in this namespace. Empty lists every namespace
+	Since     time.Time // Only changes at or after this
+	Until     time.Time // Only changes before this
+	Cursor    string    // Next cursor of the previous page. Empty for the first page
+	Limit     int       // Most entries on the page. Defaults to DefaultListLimit
+}
+
+// auditDTO keeps the target as a string rather than an IRI so the history of a Flow or Path survives its deletion
+type auditDTO struct {
+	ID        quad.IRI `quad:"@id"`
+	At        int64    `quad:"auditAt"`
+	Actor     string   `quad:"auditActor,optional"`
+	Action    string   `quad:"auditAction"`
+	Kind      string   `quad:"auditKind"`
+	Target    string   `quad:"auditTarget"`
+	Flow      string   `quad:"auditFlow,optional"`
+	Namespace string   `quad:"auditNamespace,optional"`
+	Before    string   `quad:"auditBefore,optional"`
+	After     string   `quad:"auditAfter,optional"`
+}
+
+// AppendAuditEntry adds the entry to the audit log. A missing uuid or time is filled in
+func (gs *GraphStorage) AppendAuditEntry(entry AuditEntry) error {
+	if uuid.Equal(entry.UUID, uuid.Nil) {
+		entry.UUID = NewRandomKey().UUID
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	dto := auditDTO{
+		ID:        Key{UUID: entry.UUID}.QuadIRI(),
+		At:        entry.At.UnixNano(),
+		Actor:     entry.Actor,
+		Action:    entry.Action,
+		Kind:      entry.Kind,
+		Target:    entry.Target.String(),
+		Namespace: entry.Namespace,
+		Before:    string(entry.Before),
+		After:     string(entry.After),
+	}
+	if !uuid.Equal(entry.Flow, uuid.Nil) {
+		dto.Flow = entry.Flow.String()
+	}
+	return gs.writeToGraph(dto)
+}
+
+// ListAuditEntries returns a page of the audit log matching the options, newest first, and the cursor of the next page, which is empty on the last page.
+// The options narrow the query itself, and only the time of each match is read to order them, so just the entries on the page are loaded
+func (gs *GraphStorage) ListAuditEntries(options AuditOptions) ([]AuditEntry, string, error) {
+	p, err := options.path(gs.store)
+	if err != nil {
+		return nil, "", err
+	}
+	var listEntries []listEntry
+	var keyErr error
+	err = p.Tag("entry").Save(quad.IRI("auditAt"), "at").Iterate(nil).TagValues(gs.store, func(tags map[string]quad.Value) {
+		id, ok := tags["entry"].(quad.IRI)
+		at, isInt := tags["at"].(quad.Int)
+		if !ok || !isInt {
+			return
+		}
+		key, err := NewKeyFromQuadIRI(id)
+		if err != nil {
+			keyErr = err
+			return
+		}
+		listEntries = append(listEntries, listEntry{
+			sortValue: fmt.Sprintf("%020d", int64(at)),
+			key:       key,
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if keyErr != nil {
+		return nil, "", keyErr
+	}
+	page, next, err := pageEntries(listEntries, ListOptions{
+		Descending: true,
+		Cursor:     options.Cursor,
+		Limit:      options.Limit,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	pageAuditEntries := []AuditEntry{}
+	for _, listEntry := range page {
+		var dto auditDTO
+		err := schema.LoadTo(nil, gs.store, &dto, listEntry.key.QuadValue())
+		if err != nil {
+			return nil, "", err
+		}
+		entry, err := dto.auditEntry()
+		if err != nil {
+			return nil, "", err
+		}
+		pageAuditEntries = append(pageAuditEntries, entry)
+	}
+	return pageAuditEntries, next, nil
+}
+
+// path returns the query for the entries matching the options. A target starts the query from the entries naming it rather than from the whole audit log,
+// and a cursor leaves out entries newer than the last one on the previous page
+func (options AuditOptions) path(store *cayley.Handle) (*path.Path, error) {
+	p := cayley.StartPath(store).Has(quad.IRI("auditAction"))
+	if !uuid.Equal(options.Target, uuid.Nil) {
+		target := quad.String(options.Target.String())
+		p = cayley.StartPath(store, target).In(quad.IRI("auditTarget")).Or(cayley.StartPath(store, target).In(quad.IRI("auditFlow"))).Unique()
+	}
+	for _, has := range []struct {
+		predicate string
+		value     string
+	}{
+		{"auditActor", options.Actor},
+		{"auditAction", options.Action},
+		{"auditKind", options.Kind},
+		{"auditNamespace", options.Namespace},
+	} {
+		if has.value != "" {
+			p = p.Has(quad.IRI(has.predicate), quad.String(has.value))
+		}
+	}
+	if !options.Since.IsZero() {
+		p = atFiltered(p, iterator.CompareGTE, options.Since.UnixNano())
+	}
+	if !options.Until.IsZero() {
+		p = atFiltered(p, iterator.CompareLT, options.Until.UnixNano())
+	}
+	if options.Cursor != "" {
+		after, err := decodeCursor(options.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		at, err := strconv.ParseInt(after.sortValue, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		p = atFiltered(p, iterator.CompareLTE, at)
+	}
+	return p, nil
+}
+
+// atFiltered keeps the entries of p whose time compares to at with op
+func atFiltered(p *path.Path, op iterator.Operator, at int64) *path.Path {
+	return p.Tag("filtered").Out(quad.IRI("auditAt")).Filter(op, quad.Int(at)).Back("filtered")
+}
+
+func (dto auditDTO) auditEntry() (AuditEntry, error) {
+	key, err := NewKeyFromQuadIRI(dto.ID)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	target, err := uuid.FromString(dto.Target)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry := AuditEntry{
+		UUID:      key.UUID,
+		At:        time.Unix(0, dto.At).UTC(),
+		Actor:     dto.Actor,
+		Action:    dto.Action,
+		Kind:      dto.Kind,
+		Target:    target,
+		Namespace: dto.Namespace,
+	}
+	if dto.Flow != "" {
+		entry.Flow, err = uuid.FromString(dto.Flow)
+		if err != nil {
+			return AuditEntry{}, err
+		}
+	}
+	if dto.Before != "" {
+		entry.Before = json.RawMessage(dto.Before)
+	}
+	if dto.After != "" {
+		entry.After = json.RawMessage(dto.After)
+	}
+	return entry, nil
+}
+
+// AuditedStorage wraps a Storage and appends an entry to its audit log for every change made through it, naming actor as who made it.
+// It is cheap to make, so one can be made for each request with the actor of that request.
+// Each change and its entry are made while holding the write lock of the wrapped Storage, so the Flow or Path recorded before a change is the one it replaced.
+// An entry that cannot be written is returned as the error of the change, even though the change has been made
+type AuditedStorage struct {
+	Storage
+	actor string
+}
+
+// NewAuditedStorage returns an AuditedStorage that records changes as made by actor
+func NewAuditedStorage(storage Storage, actor string) *AuditedStorage {
+	return &AuditedStorage{
+		Storage: storage,
+		actor:   actor,
+	}
+}
+
+// Atomically runs change on an AuditedStorage holding the write lock of the wrapped Storage, so its changes are still recorded
+func (as *AuditedStorage) Atomically(change func(Storage) error) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		return change(locked)
+	})
+}
+
+// locked runs change on an AuditedStorage whose Storage holds the write lock
+func (as *AuditedStorage) locked(change func(*AuditedStorage) error) error {
+	return as.Storage.Atomically(func(storage Storage) error {
+		return change(NewAuditedStorage(storage, as.actor))
+	})
+}
+
+// SaveFlow saves the Flow and records its creation, along with the creation of its Path and waitFor if saving the Flow made them
+func (as *AuditedStorage) SaveFlow(flow Flow) (Key, error) {
+	var key Key
+	err := as.locked(func(locked *AuditedStorage) error {
+		newPaths := locked.newPathsOf(flow)
+		var err error
+		key, err = locked.Storage.SaveFlow(flow)
+		if err != nil {
+			return err
+		}
+		err = locked.recordNewPaths(newPaths)
+		if err != nil {
+			return err
+		}
+		return locked.recordFlow(AuditActionCreate, key, nil)
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// UpdateFlow updates the Flow and records it before and after, along with the creation of its Path and waitFor if updating the Flow made them
+func (as *AuditedStorage) UpdateFlow(key Key, flow Flow) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		before, err := locked.Storage.GetFlowByKey(key)
+		if err != nil {
+			return err
+		}
+		newPaths := locked.newPathsOf(flow)
+		err = locked.Storage.UpdateFlow(key, flow)
+		if err != nil {
+			return err
+		}
+		err = locked.recordNewPaths(newPaths)
+		if err != nil {
+			return err
+		}
+		return locked.recordFlow(AuditActionUpdate, key, &before)
+	})
+}
+
+// newPathsOf returns the Path and waitFor of the Flow that are not stored, so saving the Flow will make them
+func (as *AuditedStorage) newPathsOf(flow Flow) []messenger.Path {
+	flow, err := flow.inNamespace()
+	if err != nil {
+		return nil
+	}
+	var paths []messenger.Path
+	for _, path := range []*messenger.Path{flow.Path, flow.WaitFor} {
+		if path == nil || (len(paths) > 0 && samePath(paths[0], *path)) {
+			continue
+		}
+		if _, err := as.Storage.GetKeyOfPath(*path); err == ErrPathNotFound {
+			paths = append(paths, *path)
+		}
+	}
+	return paths
+}
+
+// recordNewPaths records the creation of Paths made by saving a Flow
+func (as *AuditedStorage) recordNewPaths(paths []messenger.Path) error {
+	for _, path := range paths {
+		key, err := as.Storage.GetKeyOfPath(path)
+		if err != nil {
+			Logger.Errorf("%s of %s %s %s by %s was made but could not be recorded in the audit log. %v", AuditActionCreate, AuditKindPath, path.Type, path.Route, as.actor, err)
+			return err
+		}
+		err = as.recordPath(AuditEntry{Action: AuditActionCreate}, key, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func samePath(a messenger.Path, b messenger.Path) bool {
+	return a.Namespace == b.Namespace && a.Type == b.Type && a.Route == b.Route
+}
+
+// DeleteFlow deletes the Flow and records what it was
+func (as *AuditedStorage) DeleteFlow(key Key) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		before, err := locked.Storage.GetFlowByKey(key)
+		if err != nil {
+			return err
+		}
+		err = locked.Storage.DeleteFlow(key)
+		if err != nil {
+			return err
+		}
+		return locked.record(AuditEntry{Action: AuditActionDelete, Kind: AuditKindFlow, Target: key.UUID}, before, nil)
+	})
+}
+
+// SavePath saves the Path and records its creation, or its update when saving changed an existing Path's identity, metadata or labels
+func (as *AuditedStorage) SavePath(path messenger.Path) (Key, error) {
+	var key Key
+	err := as.locked(func(locked *AuditedStorage) error {
+		var before *StoredPath
+		if existingKey, err := locked.Storage.GetKeyOfPath(path); err == nil {
+			existing, err := locked.Storage.GetStoredPathByKey(existingKey)
+			if err != nil {
+				return err
+			}
+			before = &existing
+		}
+		var err error
+		key, err = locked.Storage.SavePath(path)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return locked.recordPath(AuditEntry{Action: AuditActionCreate}, key, nil)
+		}
+		entry := AuditEntry{Action: AuditActionUpdate, Kind: AuditKindPath, Target: key.UUID}
+		after, err := locked.Storage.GetStoredPathByKey(key)
+		if err != nil {
+			return locked.unrecorded(entry, err)
+		}
+		if after.Version == before.Version {
+			return nil
+		}
+		return locked.record(entry, *before, after)
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+// DeletePath deletes the Path and records what it was
+func (as *AuditedStorage) DeletePath(key Key) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		before, err := locked.Storage.GetStoredPathByKey(key)
+		if err != nil {
+			return err
+		}
+		err = locked.Storage.DeletePath(key)
+		if err != nil {
+			return err
+		}
+		return locked.record(AuditEntry{Action: AuditActionDelete, Kind: AuditKindPath, Target: key.UUID}, before, nil)
+	})
+}
+
+// ChainNextFlowToPath chains the Flow to the Path and records the Path and the Flow before and after
+func (as *AuditedStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		return locked.recordChain(AuditActionLink, flowKey, pathKey, locked.Storage.ChainNextFlowToPath)
+	})
+}
+
+// UnchainNextFlowFromPath unchains the Flow from the Path and records the Path and the Flow before and after
+func (as *AuditedStorage) UnchainNextFlowFromPath(flowKey Key, pathKey Key) error {
+	return as.locked(func(locked *AuditedStorage) error {
+		return locked.recordChain(AuditActionUnlink, flowKey, pathKey, locked.Storage.UnchainNextFlowFromPath)
+	})
+}
+
+// recordChain makes the link or unlink and records it against the Path and the Flow, so every version of the Flow has a snapshot to roll back to
+func (as *AuditedStorage) recordChain(action string, flowKey Key, pathKey Key, chain func(Key, Key) error) error {
+	pathBefore, err := as.Storage.GetStoredPathByKey(pathKey)
+	if err != nil {
+		return err
+	}
+	flowBefore, err := as.Storage.GetFlowByKey(flowKey)
+	if err != nil {
+		return err
+	}
+	err = chain(flowKey, pathKey)
+	if err != nil {
+		return err
+	}
+	err = as.recordPath(AuditEntry{Action: action, Flow: flowKey.UUID}, pathKey, &pathBefore)
+	if err != nil {
+		return err
+	}
+	return as.recordFlow(action, flowKey, &flowBefore)
+}
+
+// RollbackFlow puts the Flow back to how it was at an earlier version, as recorded in the audit log. The rollback is a new version of the Flow.
+// When the Flow has a version, it must be the stored version or ErrVersionConflict is returned
+func (as *AuditedStorage) RollbackFlow(key Key, version int64, expectedVersion int64) (Flow, error) {
+	var flow Flow
+	err := as.locked(func(locked *AuditedStorage) error {
+		before, err := locked.Storage.GetFlowByKey(key)
+		if err != nil {
+			return err
+		}
+		snapshot, err := locked.FlowAtVersion(key, version)
+		if err != nil {
+			return err
+		}
+		snapshot.Version = expectedVersion
+		err = locked.Storage.UpdateFlow(key, snapshot)
+		if err != nil {
+			return err
+		}
+		err = locked.recordFlow(AuditActionRollback, key, &before)
+		if err != nil {
+			return err
+		}
+		flow, err = locked.Storage.GetFlowByKey(key)
+		return err
+	})
+	if err != nil {
+		return Flow{}, err
+	}
+	return flow, nil
+}
+
+// FlowAtVersion returns the Flow as the audit log recorded it at the version, or ErrVersionNotInAudit.
+// Only the entries of the Flow are read, newest first, stopping at the first snapshot of the version
+func (as *AuditedStorage) FlowAtVersion(key Key, version int64) (Flow, error) {
+	options := AuditOptions{Kind: AuditKindFlow, Target: key.UUID, Limit: MaxListLimit}
+	for {
+		entries, next, err := as.Storage.ListAuditEntries(options)
+		if err != nil {
+			return Flow{}, err
+		}
+		for _, entry := range entries {
+			for _, snapshot := range []json.RawMessage{entry.After, entry.Before} {
+				if len(snapshot) == 0 {
+					continue
+				}
+				var flow Flow
+				err := json.Unmarshal(snapshot, &flow)
+				if err != nil {
+					return Flow{}, err
+				}
+				if flow.Version == version {
+					return flow, nil
+				}
+			}
+		}
+		if next == "" {
+			return Flow{}, ErrVersionNotInAudit
+		}
+		options.Cursor = next
+	}
+}
+
+func (as *AuditedStorage) recordFlow(action string, key Key, before *Flow) error {
+	entry := AuditEntry{Action: action, Kind: AuditKindFlow, Target: key.UUID}
+	after, err := as.Storage.GetFlowByKey(key)
+	if err != nil {
+		return as.unrecorded(entry, err)
+	}
+	if before == nil {
+		return as.record(entry, nil, after)
+	}
+	return as.record(entry, *before, after)
+}
+
+func (as *AuditedStorage) recordPath(entry AuditEntry, key Key, before *StoredPath) error {
+	entry.Kind = AuditKindPath
+	entry.Target = key.UUID
+	after, err := as.Storage.GetStoredPathByKey(key)
+	if err != nil {
+		return as.unrecorded(entry, err)
+	}
+	if before == nil {
+		return as.record(entry, nil, after)
+	}
+	return as.record(entry, *before, after)
+}
+
+// record fills in the actor, namespace and snapshots of the entry and appends it. A nil snapshot is left empty
+func (as *AuditedStorage) record(entry AuditEntry, before interface{}, after interface{}) error {
+	entry.Actor = as.actor
+	for _, snapshot := range []struct {
+		value  interface{}
+		target *json.RawMessage
+	}{{before, &entry.Before}, {after, &entry.After}} {
+		if snapshot.value == nil {
+			continue
+		}
+		switch value := snapshot.value.(type) {
+		case Flow:
+			entry.Namespace = value.Namespace
+		case StoredPath:
+			entry.Namespace = value.Namespace
+		}
+		encoded, err := json.Marshal(snapshot.value)
+		if err != nil {
+			return as.unrecorded(entry, err)
+		}
+		*snapshot.target = encoded
+	}
+	err := as.Storage.AppendAuditEntry(entry)
+	if err != nil {
+		return as.unrecorded(entry, err)
+	}
+	return nil
+}
+
+// unrecorded logs a change that was made but could not be recorded in the audit log and returns why
+func (as *AuditedStorage) unrecorded(entry AuditEntry, err error) error {
+	Logger.Errorf("%s of %s %s by %s was made but could not be recorded in the audit log. %v", entry.Action, entry.Kind, entry.Target, as.actor, err)
+	return err
+}
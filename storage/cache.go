@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/edfungus/conduction/messenger"
+)
+
+// CachedStorage wraps a Storage and caches the Path to next Flows lookups done for every routed message.
+// Saving or chaining through CachedStorage clears the cache, so writes must go through it rather than the wrapped Storage
+type CachedStorage struct {
+	Storage
+	ttl time.Duration
+	now func() time.Time
+
+	lock      sync.Mutex
+	pathKeys  map[pathCacheKey]cachedPathKey
+	nextFlows map[Key]cachedNextFlows
+	patterns  map[string]cachedPatternPaths
+	hits      uint64
+	misses    uint64
+
+	// generation counts calls to Invalidate so a lookup that raced with a write does not cache what it read before the write
+	generation uint64
+}
+
+// CacheStats counts lookups answered from the cache and lookups passed to the wrapped Storage
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// pathCacheKey is what makes a Path unique, so Paths with the same route and type in different namespaces are cached apart
+type pathCacheKey struct {
+	namespace string
+	pathType  string
+	route     string
+}
+
+type cachedPathKey struct {
+	key     Key
+	err     error
+	expires time.Time
+}
+
+type cachedNextFlows struct {
+	flows   []Flow
+	keys    []Key
+	expires time.Time
+}
+
+type cachedPatternPaths struct {
+	paths   []messenger.Path
+	keys    []Key
+	expires time.Time
+}
+
+// NewCachedStorage returns a CachedStorage that keeps lookups for ttl
+func NewCachedStorage(storage Storage, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		Storage:   storage,
+		ttl:       ttl,
+		now:       time.Now,
+		pathKeys:  make(map[pathCacheKey]cachedPathKey),
+		nextFlows: make(map[Key]cachedNextFlows),
+		patterns:  make(map[string]cachedPatternPaths),
+	}
+}
+
+// GetKeyOfPath returns the Key of the Path from the cache if it has not expired. A Path that was not found is cached too
+func (cs *CachedStorage) GetKeyOfPath(path messenger.Path) (Key, error) {
+	cacheKey := pathCacheKey{namespace: path.Namespace, pathType: path.Type, route: path.Route}
+	cs.lock.Lock()
+	cached, ok := cs.pathKeys[cacheKey]
+	if ok && cs.now().Before(cached.expires) {
+		cs.hits++
+		cs.lock.Unlock()
+		return cached.key, cached.err
+	}
+	cs.misses++
+	generation := cs.generation
+	cs.lock.Unlock()
+
+	key, err := cs.Storage.GetKeyOfPath(path)
+	if err != nil && err != ErrPathNotFound {
+		return key, err
+	}
+	cs.lock.Lock()
+	if generation == cs.generation {
+		cs.pathKeys[cacheKey] = cachedPathKey{
+			key:     key,
+			err:     err,
+			expires: cs.now().Add(cs.ttl),
+		}
+	}
+	cs.lock.Unlock()
+	return key, err
+}
+
+// GetNextFlows returns the next Flows of the Path from the cache if they have not expired
+func (cs *CachedStorage) GetNextFlows(key Key) ([]Flow, []Key, error) {
+	cs.lock.Lock()
+	cached, ok := cs.nextFlows[key]
+	if ok && cs.now().Before(cached.expires) {
+		cs.hits++
+		cs.lock.Unlock()
+		return copyFlows(cached.flows), copyKeys(cached.keys), nil
+	}
+	cs.misses++
+	generation := cs.generation
+	cs.lock.Unlock()
+
+	flows, keys, err := cs.Storage.GetNextFlows(key)
+	if err != nil {
+		return flows, keys, err
+	}
+	cs.lock.Lock()
+	if generation == cs.generation {
+		cs.nextFlows[key] = cachedNextFlows{
+			flows:   copyFlows(flows),
+			keys:    copyKeys(keys),
+			expires: cs.now().Add(cs.ttl),
+		}
+	}
+	cs.lock.Unlock()
+	return flows, keys, nil
+}
+
+// GetPatternPaths returns the pattern Paths of the type from the cache if they have not expired
+func (cs *CachedStorage) GetPatternPaths(pathType string) ([]messenger.Path, []Key, error) {
+	cs.lock.Lock()
+	cached, ok := cs.patterns[pathType]
+	if ok && cs.now().Before(cached.expires) {
+		cs.hits++
+		cs.lock.Unlock()
+		return append([]messenger.Path(nil), cached.paths...), copyKeys(cached.keys), nil
+	}
+	cs.misses++
+	generation := cs.generation
+	cs.lock.Unlock()
+
+	paths, keys, err := cs.Storage.GetPatternPaths(pathType)
+	if err != nil {
+		return paths, keys, err
+	}
+	cs.lock.Lock()
+	if generation == cs.generation {
+		cs.patterns[pathType] = cachedPatternPaths{
+			paths:   append([]messenger.Path(nil), paths...),
+			keys:    copyKeys(keys),
+			expires: cs.now().Add(cs.ttl),
+		}
+	}
+	cs.lock.Unlock()
+	return paths, keys, nil
+}
+
+// SaveFlow saves the Flow and clears the cache
+func (cs *CachedStorage) SaveFlow(flow Flow) (Key, error) {
+	defer cs.Invalidate()
+	return cs.Storage.SaveFlow(flow)
+}
+
+// SavePath saves the Path and clears the cache
+func (cs *CachedStorage) SavePath(path messenger.Path) (Key, error) {
+	defer cs.Invalidate()
+	return cs.Storage.SavePath(path)
+}
+
+// ChainNextFlowToPath chains the Flow to the Path and clears the cache
+func (cs *CachedStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
+	defer cs.Invalidate()
+	return cs.Storage.ChainNextFlowToPath(flowKey, pathKey)
+}
+
+// UpdateFlow updates the Flow and clears the cache
+func (cs *CachedStorage) UpdateFlow(key Key, flow Flow) error {
+	defer cs.Invalidate()
+	return cs.Storage.UpdateFlow(key, flow)
+}
+
+// DeleteFlow deletes the Flow and clears the cache
+func (cs *CachedStorage) DeleteFlow(key Key) error {
+	defer cs.Invalidate()
+	return cs.Storage.DeleteFlow(key)
+}
+
+// DeletePath deletes the Path and clears the cache
+func (cs *CachedStorage) DeletePath(key Key) error {
+	defer cs.Invalidate()
+	return cs.Storage.DeletePath(key)
+}
+
+// UnchainNextFlowFromPath unchains the Flow from the Path and clears the cache
+func (cs *CachedStorage) UnchainNextFlowFromPath(flowKey Key, pathKey Key) error {
+	defer cs.Invalidate()
+	return cs.Storage.UnchainNextFlowFromPath(flowKey, pathKey)
+}
+
+// Atomically runs change on the wrapped Storage and clears the cache once it is done, since change writes past the cache
+func (cs *CachedStorage) Atomically(change func(Storage) error) error {
+	defer cs.Invalidate()
+	return cs.Storage.Atomically(change)
+}
+
+// Invalidate clears every cached lookup. Lookups already passed to the wrapped Storage are not cached when they return
+func (cs *CachedStorage) Invalidate() {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.generation++
+	cs.pathKeys = make(map[pathCacheKey]cachedPathKey)
+	cs.nextFlows = make(map[Key]cachedNextFlows)
+	cs.patterns = make(map[string]cachedPatternPaths)
+}
+
+// Stats returns the hit and miss counts since the CachedStorage was made
+func (cs *CachedStorage) Stats() CacheStats {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	return CacheStats{
+		Hits:   cs.hits,
+		Misses: cs.misses,
+	}
+}
+
+// copyFlows returns a copy so callers setting fields on the Flows do not change the cache
+func copyFlows(flows []Flow) []Flow {
+	if flows == nil {
+		return nil
+	}
+	return append([]Flow(nil), flows...)
+}
+
+func copyKeys(keys []Key) []Key {
+	if keys == nil {
+		return nil
+	}
+	return append([]Key(nil), keys...)
+}
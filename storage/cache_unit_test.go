@@ -0,0 +1,259 @@
+// +build all unit
+
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/edfungus/conduction/messenger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("CachedStorage", func() {
+		var (
+			backing       *countingStorage
+			cachedStorage *CachedStorage
+			now           time.Time
+			path          = messenger.Path{Route: "/cached", Type: "REST"}
+			pathKey       = NewRandomKey()
+			flowKey       = NewRandomKey()
+		)
+		BeforeEach(func() {
+			backing = &countingStorage{
+				pathKey:   pathKey,
+				nextFlows: []Flow{{Name: "next"}},
+				nextKeys:  []Key{flowKey},
+			}
+			now = time.Now()
+			cachedStorage = NewCachedStorage(backing, time.Minute)
+			cachedStorage.now = func() time.Time { return now }
+		})
+		Describe("Given a lookup has been made", func() {
+			Context("When the same lookup is made again before the TTL", func() {
+				It("Then the cached result should be returned without asking the wrapped Storage", func() {
+					for i := 0; i < 2; i++ {
+						key, err := cachedStorage.GetKeyOfPath(path)
+						Expect(err).To(BeNil())
+						Expect(key).To(Equal(pathKey))
+						flows, keys, err := cachedStorage.GetNextFlows(key)
+						Expect(err).To(BeNil())
+						Expect(flows).To(Equal(backing.nextFlows))
+						Expect(keys).To(Equal(backing.nextKeys))
+					}
+					Expect(backing.getKeyOfPathCalls).To(Equal(1))
+					Expect(backing.getNextFlowsCalls).To(Equal(1))
+					Expect(cachedStorage.Stats()).To(Equal(CacheStats{Hits: 2, Misses: 2}))
+				})
+			})
+			Context("When the same lookup is made after the TTL", func() {
+				It("Then the wrapped Storage should be asked again", func() {
+					cachedStorage.GetKeyOfPath(path)
+					now = now.Add(time.Minute)
+					cachedStorage.GetKeyOfPath(path)
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+					Expect(cachedStorage.Stats()).To(Equal(CacheStats{Hits: 0, Misses: 2}))
+				})
+			})
+			Context("When the pattern Paths of a type are looked up again", func() {
+				It("Then the cached patterns should be returned until a Path is saved", func() {
+					cachedStorage.GetPatternPaths("MQTT")
+					paths, keys, err := cachedStorage.GetPatternPaths("MQTT")
+					Expect(err).To(BeNil())
+					Expect(paths).To(Equal([]messenger.Path{{Route: "sensors/#", Type: "MQTT"}}))
+					Expect(keys).To(Equal([]Key{pathKey}))
+					Expect(backing.getPatternsCalls).To(Equal(1))
+
+					cachedStorage.SavePath(messenger.Path{Route: "sensors/+", Type: "MQTT"})
+					cachedStorage.GetPatternPaths("MQTT")
+					Expect(backing.getPatternsCalls).To(Equal(2))
+				})
+			})
+			Context("When the returned Flows are changed by the caller", func() {
+				It("Then the cached Flows should not change", func() {
+					flows, _, _ := cachedStorage.GetNextFlows(pathKey)
+					flows[0].Name = "changed"
+					flows, _, _ = cachedStorage.GetNextFlows(pathKey)
+					Expect(flows[0].Name).To(Equal("next"))
+				})
+			})
+		})
+		Describe("Given Paths with the same route and type in different namespaces", func() {
+			Context("When each is looked up after the other was cached", func() {
+				It("Then each should resolve to the Key of its own namespace", func() {
+					teamKey := NewRandomKey()
+					backing.namespaceKeys = map[string]Key{"team-a": teamKey}
+					teamPath := path
+					teamPath.Namespace = "team-a"
+					for i := 0; i < 2; i++ {
+						key, err := cachedStorage.GetKeyOfPath(path)
+						Expect(err).To(BeNil())
+						Expect(key).To(Equal(pathKey))
+						key, err = cachedStorage.GetKeyOfPath(teamPath)
+						Expect(err).To(BeNil())
+						Expect(key).To(Equal(teamKey))
+					}
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+				})
+			})
+		})
+		Describe("Given the Path does not exist", func() {
+			Context("When it is looked up twice", func() {
+				It("Then not found should be cached", func() {
+					backing.getKeyOfPathErr = ErrPathNotFound
+					for i := 0; i < 2; i++ {
+						_, err := cachedStorage.GetKeyOfPath(path)
+						Expect(err).To(Equal(ErrPathNotFound))
+					}
+					Expect(backing.getKeyOfPathCalls).To(Equal(1))
+				})
+			})
+		})
+		Describe("Given the wrapped Storage fails", func() {
+			Context("When it is looked up twice", func() {
+				It("Then the failure should not be cached", func() {
+					backing.getKeyOfPathErr = errors.New("storage failed")
+					cachedStorage.GetKeyOfPath(path)
+					cachedStorage.GetKeyOfPath(path)
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+				})
+			})
+		})
+		Describe("Given lookups are cached", func() {
+			BeforeEach(func() {
+				cachedStorage.GetKeyOfPath(path)
+				cachedStorage.GetNextFlows(pathKey)
+			})
+			Context("When a Flow is saved", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.SaveFlow(Flow{})
+					cachedStorage.GetKeyOfPath(path)
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+					Expect(backing.getNextFlowsCalls).To(Equal(2))
+				})
+			})
+			Context("When a Flow is updated or deleted", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.UpdateFlow(flowKey, Flow{})
+					cachedStorage.GetNextFlows(pathKey)
+					cachedStorage.DeleteFlow(flowKey)
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getNextFlowsCalls).To(Equal(3))
+				})
+			})
+			Context("When a Path is deleted", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.DeletePath(pathKey)
+					cachedStorage.GetKeyOfPath(path)
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+				})
+			})
+			Context("When a Path is saved", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.SavePath(path)
+					cachedStorage.GetKeyOfPath(path)
+					Expect(backing.getKeyOfPathCalls).To(Equal(2))
+				})
+			})
+			Context("When a Flow is chained to a Path", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.ChainNextFlowToPath(flowKey, pathKey)
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getNextFlowsCalls).To(Equal(2))
+				})
+			})
+			Context("When a Flow is unchained from a Path", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.UnchainNextFlowFromPath(flowKey, pathKey)
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getNextFlowsCalls).To(Equal(2))
+				})
+			})
+			Context("When the cache is invalidated", func() {
+				It("Then the cache should be cleared", func() {
+					cachedStorage.Invalidate()
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getNextFlowsCalls).To(Equal(2))
+				})
+			})
+		})
+		Describe("Given a lookup is passed to the wrapped Storage", func() {
+			Context("When the cache is invalidated before the lookup returns", func() {
+				It("Then the result read before the invalidation should not be cached", func() {
+					backing.duringGetNextFlows = cachedStorage.Invalidate
+					cachedStorage.GetNextFlows(pathKey)
+					backing.duringGetNextFlows = nil
+					cachedStorage.GetNextFlows(pathKey)
+					Expect(backing.getNextFlowsCalls).To(Equal(2))
+				})
+			})
+		})
+	})
+})
+
+// countingStorage answers lookups with fixed values and counts how often it is asked
+type countingStorage struct {
+	Storage
+	pathKey           Key
+	namespaceKeys     map[string]Key // Keys of the Path in namespaces other than the default
+	getKeyOfPathErr   error
+	nextFlows         []Flow
+	nextKeys          []Key
+	getKeyOfPathCalls int
+	getNextFlowsCalls int
+	getPatternsCalls  int
+
+	duringGetNextFlows func() // called while GetNextFlows is reading, to race a write against it
+}
+
+func (cs *countingStorage) GetPatternPaths(pathType string) ([]messenger.Path, []Key, error) {
+	cs.getPatternsCalls++
+	return []messenger.Path{{Route: "sensors/#", Type: pathType}}, []Key{cs.pathKey}, nil
+}
+
+func (cs *countingStorage) GetKeyOfPath(path messenger.Path) (Key, error) {
+	cs.getKeyOfPathCalls++
+	if key, ok := cs.namespaceKeys[path.Namespace]; ok {
+		return key, cs.getKeyOfPathErr
+	}
+	return cs.pathKey, cs.getKeyOfPathErr
+}
+
+func (cs *countingStorage) GetNextFlows(key Key) ([]Flow, []Key, error) {
+	cs.getNextFlowsCalls++
+	if cs.duringGetNextFlows != nil {
+		cs.duringGetNextFlows()
+	}
+	return copyFlows(cs.nextFlows), copyKeys(cs.nextKeys), nil
+}
+
+func (cs *countingStorage) SaveFlow(flow Flow) (Key, error) {
+	return NewRandomKey(), nil
+}
+
+func (cs *countingStorage) UpdateFlow(key Key, flow Flow) error {
+	return nil
+}
+
+func (cs *countingStorage) DeleteFlow(key Key) error {
+	return nil
+}
+
+func (cs *countingStorage) SavePath(path messenger.Path) (Key, error) {
+	return NewRandomKey(), nil
+}
+
+func (cs *countingStorage) DeletePath(key Key) error {
+	return nil
+}
+
+func (cs *countingStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
+	return nil
+}
+
+func (cs *countingStorage) UnchainNextFlowFromPath(flowKey Key, pathKey Key) error {
+	return nil
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+	"github.com/edfungus/conduction/messenger"
+	"github.com/gogo/protobuf/proto"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	ErrContinuationCannotBeRetrieved error = fmt.Errorf("Could not retrieve Continuation from storage")
+	ErrAwaitCannotBeRetrieved        error = fmt.Errorf("Could not retrieve Await from storage")
+	ErrAwaitAlreadyReceived          error = fmt.Errorf("Await has already received its response")
+	ErrAwaitIdentityMissing          error = fmt.Errorf("Response has no identity so it cannot be matched to the Continuation waiting on its Path")
+)
+
+// Continuation is the rest of a Flow chain held back until the responses of waiting Flows arrive
+type Continuation struct {
+	UUID      uuid.UUID         `json:"uuid,omitempty"`
+	Identity  string            `json:"identity"` // Sent with the messages to waiting Flows so their responses can be matched to the Continuation
+	Flows     []Flow            `json:"flows"`    // Flows to run once every Await has received a response. Flows must have their UUID set
+	Awaits    []Await           `json:"awaits"`
+	Return    *messenger.Path   `json:"return,omitempty"`    // Return Path of the message that started the chain
+	Aggregate bool              `json:"aggregate,omitempty"` // When true, the payloads of every Await are merged into a JSON object keyed by Await name
+	Deadline  time.Time         `json:"deadline,omitempty"`  // When set, the Continuation continues with the responses it has once this passes
+	Origin    *messenger.Path   `json:"origin,omitempty"`    // Origin of the message that started the chain, which the message continued after a timeout has
+	Metadata  map[string][]byte `json:"metadata,omitempty"`  // Metadata of the message that started the chain that the message continued after a timeout carries on, such as its hop count
+}
+
+// Await is a response that a Continuation is waiting for
+type Await struct {
+	UUID     uuid.UUID       `json:"uuid,omitempty"`
+	Name     string          `json:"name"` // Name of the Flow waiting for the response
+	Path     *messenger.Path `json:"path"` // Path the response will arrive on
+	Payload  []byte          `json:"payload,omitempty"`
+	Received bool            `json:"received"`
+}
+
+// IsComplete returns whether every Await has received a response
+func (c Continuation) IsComplete() bool {
+	for _, await := range c.Awaits {
+		if !await.Received {
+			return false
+		}
+	}
+	return true
+}
+
+// Missing returns the names of the Awaits that have not received a response
+func (c Continuation) Missing() []string {
+	var missing []string
+	for i, await := range c.Awaits {
+		if !await.Received {
+			missing = append(missing, await.key(i))
+		}
+	}
+	return missing
+}
+
+// Payload returns the payload of the last received response, or every response merged when the Continuation aggregates
+func (c Continuation) Payload() []byte {
+	if c.Aggregate {
+		return c.aggregatePayload()
+	}
+	var payload []byte
+	for _, await := range c.Awaits {
+		if await.Received {
+			payload = await.Payload
+		}
+	}
+	return payload
+}
+
+// aggregatePayload merges the responses into a JSON object keyed by Await name. JSON responses are kept as JSON, other responses become strings and missing responses are null
+func (c Continuation) aggregatePayload() []byte {
+	merged := make(map[string]json.RawMessage, len(c.Awaits))
+	for i, await := range c.Awaits {
+		var value json.RawMessage
+		switch {
+		case !await.Received:
+			value = json.RawMessage("null")
+		case json.Valid(await.Payload):
+			value = json.RawMessage(await.Payload)
+		default:
+			value, _ = json.Marshal(string(await.Payload))
+		}
+		merged[await.key(i)] = value
+	}
+	payload, err := json.Marshal(merged)
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+// key names the Await in a merged payload. Awaits without a name are named by their position
+func (a Await) key(position int) string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return strconv.Itoa(position)
+}
+
+// continuationDTO keeps the return and origin Paths serialized because their metadata is what identifies them
+type continuationDTO struct {
+	ID        quad.IRI   `quad:"@id"`
+	Identity  string     `quad:"identity"`
+	Flows     []quad.IRI `quad:"continues,optional"`
+	Awaits    []quad.IRI `quad:"awaits"`
+	Return    string     `quad:"return,optional"`
+	Aggregate bool       `quad:"aggregates,optional"`
+	Expires   bool       `quad:"expires,optional"` // Set with Deadline so Continuations that can expire can be found without scanning every node
+	Deadline  int64      `quad:"deadline,optional"`
+	Origin    string     `quad:"continuationOrigin,optional"`
+	Metadata  string     `quad:"continuationMetadata,optional"`
+}
+
+// NewContinuationDTO returns a new continuationDTO of the Continuation, continuing to the Flows and waiting on the Awaits
+func NewContinuationDTO(id quad.IRI, continuation Continuation, flows []quad.IRI, awaits []quad.IRI) (continuationDTO, error) {
+	returnPath, err := encodePath(continuation.Return)
+	if err != nil {
+		return continuationDTO{}, err
+	}
+	origin, err := encodePath(continuation.Origin)
+	if err != nil {
+		return continuationDTO{}, err
+	}
+	dto := continuationDTO{
+		ID:        id,
+		Identity:  continuation.Identity,
+		Flows:     flows,
+		Awaits:    awaits,
+		Return:    returnPath,
+		Aggregate: continuation.Aggregate,
+		Origin:    origin,
+		Metadata:  encodePathMetadata(continuation.Metadata),
+	}
+	if !continuation.Deadline.IsZero() {
+		dto.Expires = true
+		dto.Deadline = continuation.Deadline.UnixNano()
+	}
+	return dto, nil
+}
+
+// awaitDTO uses its own predicates for route and type so Awaits are never mistaken for Paths
+type awaitDTO struct {
+	ID        quad.IRI `quad:"@id"`
+	Name      string   `quad:"awaitName,optional"`
+	Route     string   `quad:"awaitRoute"`
+	Type      string   `quad:"awaitType"`
+	Identity  string   `quad:"awaitIdentity"`
+	AwaitedAt int64    `quad:"awaitedAt"`
+	Payload   string   `quad:"awaitPayload,optional"`
+	Received  bool     `quad:"received,optional"`
+	Namespace string   `quad:"awaitNamespace,optional"`
+}
+
+// NewAwaitDTO returns a new awaitDTO
+func NewAwaitDTO(id quad.IRI, name string, route string, pathType string, identity string, awaitedAt int64) awaitDTO {
+	return awaitDTO{
+		ID:        id,
+		Name:      name,
+		Route:     route,
+		Type:      pathType,
+		Identity:  identity,
+		AwaitedAt: awaitedAt,
+	}
+}
+
+// SaveContinuation adds the Continuation and its Awaits to the graph in one transaction, so Awaits are never left without their Continuation
+func (gs *GraphStorage) SaveContinuation(continuation Continuation) (Key, error) {
+	var flowIRIs []quad.IRI
+	for _, flow := range continuation.Flows {
+		flowKey := Key{UUID: flow.UUID}
+		_, err := gs.GetFlowByKey(flowKey)
+		if err != nil {
+			return Key{}, err
+		}
+		flowIRIs = append(flowIRIs, flowKey.QuadIRI())
+	}
+	tx := cayley.NewTransaction()
+	var awaitIRIs []quad.IRI
+	awaitedAt := time.Now().UnixNano()
+	for _, await := range continuation.Awaits {
+		awaitKey := NewRandomKey()
+		awaitDTO := NewAwaitDTO(awaitKey.QuadIRI(), await.Name, await.Path.Route, await.Path.Type, continuation.Identity, awaitedAt)
+		awaitDTO.Namespace = await.Path.Namespace
+		_, err := schema.WriteAsQuads(transactionWriter{tx}, awaitDTO)
+		if err != nil {
+			return Key{}, err
+		}
+		awaitIRIs = append(awaitIRIs, awaitKey.QuadIRI())
+	}
+	continuationKey := NewRandomKey()
+	continuationDTO, err := NewContinuationDTO(continuationKey.QuadIRI(), continuation, flowIRIs, awaitIRIs)
+	if err != nil {
+		return Key{}, err
+	}
+	_, err = schema.WriteAsQuads(transactionWriter{tx}, continuationDTO)
+	if err != nil {
+		return Key{}, err
+	}
+	err = gs.store.ApplyTransaction(tx)
+	if err != nil {
+		return Key{}, err
+	}
+	return continuationKey, nil
+}
+
+// GetContinuationByKey returns the Continuation with its Flows and Awaits
+func (gs *GraphStorage) GetContinuationByKey(key Key) (Continuation, error) {
+	var continuationDTO continuationDTO
+	err := schema.LoadTo(nil, gs.store, &continuationDTO, key.QuadValue())
+	if err != nil {
+		return Continuation{}, ErrContinuationCannotBeRetrieved
+	}
+	returnPath, err := decodePath(continuationDTO.Return)
+	if err != nil {
+		return Continuation{}, err
+	}
+	origin, err := decodePath(continuationDTO.Origin)
+	if err != nil {
+		return Continuation{}, err
+	}
+	metadata, err := decodePathMetadata(continuationDTO.Metadata)
+	if err != nil {
+		return Continuation{}, err
+	}
+	continuation := Continuation{
+		UUID:      key.UUID,
+		Identity:  continuationDTO.Identity,
+		Return:    returnPath,
+		Aggregate: continuationDTO.Aggregate,
+		Origin:    origin,
+		Metadata:  metadata,
+	}
+	if continuationDTO.Expires {
+		continuation.Deadline = time.Unix(0, continuationDTO.Deadline)
+	}
+	for _, flowIRI := range continuationDTO.Flows {
+		flowKey, err := NewKeyFromQuadIRI(flowIRI)
+		if err != nil {
+			return Continuation{}, err
+		}
+		flow, err := gs.GetFlowByKey(flowKey)
+		if err != nil {
+			return Continuation{}, err
+		}
+		continuation.Flows = append(continuation.Flows, flow)
+	}
+	var awaitDTOs []awaitDTO
+	for _, awaitIRI := range continuationDTO.Awaits {
+		awaitKey, err := NewKeyFromQuadIRI(awaitIRI)
+		if err != nil {
+			return Continuation{}, err
+		}
+		awaitDTO, err := gs.getAwaitDTOByKey(awaitKey)
+		if err != nil {
+			return Continuation{}, err
+		}
+		awaitDTOs = append(awaitDTOs, awaitDTO)
+	}
+	sortAwaitDTOs(awaitDTOs)
+	for _, awaitDTO := range awaitDTOs {
+		await, err := convertAwaitDTOToAwait(awaitDTO)
+		if err != nil {
+			return Continuation{}, err
+		}
+		continuation.Awaits = append(continuation.Awaits, await)
+	}
+	return continuation, nil
+}
+
+// GetKeysOfAwaits returns the Keys of Awaits in the namespace of the Path still waiting for a response on it, oldest first. Only Awaits of the Continuation
+// with the identity of the Path are returned. A Path without an identity matches none, and ErrAwaitIdentityMissing is returned if Awaits are waiting on it,
+// so a response is never matched to a Continuation it does not belong to
+func (gs *GraphStorage) GetKeysOfAwaits(path messenger.Path) ([]Key, error) {
+	p := cayley.StartPath(gs.store, quad.StringToValue(path.Type)).In(quad.IRI("awaitType")).Has(quad.IRI("awaitRoute"), quad.StringToValue(path.Route))
+	if path.Identity != "" {
+		p = p.Has(quad.IRI("awaitIdentity"), quad.StringToValue(path.Identity))
+	}
+	var awaitDTOs []awaitDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&awaitDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, err
+	}
+	sortAwaitDTOs(awaitDTOs)
+	var keys []Key
+	for _, awaitDTO := range awaitDTOs {
+		if awaitDTO.Received || awaitDTO.Namespace != path.Namespace {
+			continue
+		}
+		if path.Identity == "" {
+			return nil, ErrAwaitIdentityMissing
+		}
+		key, err := NewKeyFromQuadIRI(awaitDTO.ID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ResolveAwait stores the response payload in the Await and returns the Continuation it belongs to
+func (gs *GraphStorage) ResolveAwait(key Key, payload []byte) (Continuation, Key, error) {
+	gs.awaitLock.Lock()
+	defer gs.awaitLock.Unlock()
+	awaitDTO, err := gs.getAwaitDTOByKey(key)
+	if err != nil {
+		return Continuation{}, Key{}, err
+	}
+	if awaitDTO.Received {
+		return Continuation{}, Key{}, ErrAwaitAlreadyReceived
+	}
+	continuationKey, err := gs.getContinuationKeyOfAwait(key)
+	if err != nil {
+		return Continuation{}, Key{}, err
+	}
+	// Received and payload are optional and unset until now so they can be added without removing anything
+	err = gs.store.AddQuadSet([]quad.Quad{
+		quad.Make(key.QuadValue(), quad.IRI("received"), quad.Bool(true), nil),
+		quad.Make(key.QuadValue(), quad.IRI("awaitPayload"), quad.String(base64.StdEncoding.EncodeToString(payload)), nil),
+	})
+	if err != nil {
+		return Continuation{}, Key{}, err
+	}
+	continuation, err := gs.GetContinuationByKey(continuationKey)
+	if err != nil {
+		return Continuation{}, Key{}, err
+	}
+	return continuation, continuationKey, nil
+}
+
+// TakeExpiredContinuations removes and returns the Continuations whose deadline has passed by now and are still waiting.
+// Continuations that have every response are left to the resolver that completed them
+func (gs *GraphStorage) TakeExpiredContinuations(now time.Time) ([]Continuation, error) {
+	gs.awaitLock.Lock()
+	defer gs.awaitLock.Unlock()
+	p := cayley.StartPath(gs.store, quad.Bool(true)).In(quad.IRI("expires"))
+	var continuationDTOs []continuationDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&continuationDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, err
+	}
+	var expired []Continuation
+	for _, continuationDTO := range continuationDTOs {
+		if continuationDTO.Deadline > now.UnixNano() {
+			continue
+		}
+		key, err := NewKeyFromQuadIRI(continuationDTO.ID)
+		if err != nil {
+			return nil, err
+		}
+		continuation, err := gs.GetContinuationByKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if continuation.IsComplete() {
+			continue
+		}
+		err = gs.DeleteContinuation(key)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, continuation)
+	}
+	return expired, nil
+}
+
+// DeleteContinuation removes the Continuation and its Awaits from the graph. The Flows it continues to are not removed
+func (gs *GraphStorage) DeleteContinuation(key Key) error {
+	var continuationDTO continuationDTO
+	err := schema.LoadTo(nil, gs.store, &continuationDTO, key.QuadValue())
+	if err != nil {
+		return ErrContinuationCannotBeRetrieved
+	}
+	for _, awaitIRI := range continuationDTO.Awaits {
+		awaitKey, err := NewKeyFromQuadIRI(awaitIRI)
+		if err != nil {
+			return err
+		}
+		err = gs.removeNodeFromGraph(awaitKey)
+		if err != nil {
+			return err
+		}
+	}
+	return gs.removeNodeFromGraph(key)
+}
+
+func (gs *GraphStorage) getAwaitDTOByKey(key Key) (awaitDTO, error) {
+	var awaitDTO awaitDTO
+	err := schema.LoadTo(nil, gs.store, &awaitDTO, key.QuadValue())
+	if err != nil {
+		return awaitDTO, ErrAwaitCannotBeRetrieved
+	}
+	return awaitDTO, nil
+}
+
+func (gs *GraphStorage) getContinuationKeyOfAwait(key Key) (Key, error) {
+	p := cayley.StartPath(gs.store, key.QuadValue()).In(quad.IRI("awaits"))
+	continuationList, err := p.Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return Key{}, ErrResolvingKey
+	}
+	if len(continuationList) != 1 {
+		return Key{}, ErrContinuationCannotBeRetrieved
+	}
+	return NewKeyFromQuadValue(continuationList[0])
+}
+
+func convertAwaitDTOToAwait(awaitDTO awaitDTO) (Await, error) {
+	key, err := NewKeyFromQuadIRI(awaitDTO.ID)
+	if err != nil {
+		return Await{}, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(awaitDTO.Payload)
+	if err != nil {
+		return Await{}, err
+	}
+	return Await{
+		UUID: key.UUID,
+		Name: awaitDTO.Name,
+		Path: &messenger.Path{
+			Route:     awaitDTO.Route,
+			Type:      awaitDTO.Type,
+			Identity:  awaitDTO.Identity,
+			Namespace: awaitDTO.Namespace,
+		},
+		Payload:  payload,
+		Received: awaitDTO.Received,
+	}, nil
+}
+
+// encodePath serializes the whole Path, including identity and metadata, so it can be stored as a single value
+func encodePath(path *messenger.Path) (string, error) {
+	if path == nil {
+		return "", nil
+	}
+	encoded, err := proto.Marshal(path)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encoded), nil
+}
+
+func decodePath(encoded string) (*messenger.Path, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	path := &messenger.Path{}
+	err = proto.Unmarshal(decoded, path)
+	if err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+func sortAwaitDTOs(awaitDTOs []awaitDTO) {
+	sort.SliceStable(awaitDTOs, func(i, j int) bool {
+		return awaitDTOs[i].AwaitedAt < awaitDTOs[j].AwaitedAt
+	})
+}
@@ -5,23 +5,69 @@ import (
 	"github.com/satori/go.uuid"
 )
 
+// AllNamespaces in the sharedWith of a Flow lets Paths of every namespace trigger it
+const AllNamespaces string = "*"
+
 type Flows struct {
 	Flows []Flow `json:"flows"`
 }
 
 type Flow struct {
-	UUID        uuid.UUID       `json:"uuid,omitempty"`
-	Name        string          `json:"name"`
-	Description string          `json:"description"`
-	Path        *messenger.Path `json:"path"`
+	UUID        uuid.UUID         `json:"uuid,omitempty"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Path        *messenger.Path   `json:"path"`
+	Wait        bool              `json:"wait,omitempty"`        // When true, the Flows running alongside this one are held until its response arrives
+	Aggregate   bool              `json:"aggregate,omitempty"`   // When true on a waiting Flow, the responses of every waiting Flow alongside it are merged into a JSON object keyed by Flow name
+	WaitFor     *messenger.Path   `json:"waitFor,omitempty"`     // Path the response arrives on. Defaults to Path
+	Condition   string            `json:"condition,omitempty"`   // Expression the message must match to be forwarded to this Flow. See package expression
+	Transform   string            `json:"transform,omitempty"`   // Template the payload is reshaped with before it is forwarded to this Flow. See package transform
+	TriggeredBy []uuid.UUID       `json:"triggeredBy,omitempty"` // Paths that trigger this Flow. Set by storage and ignored when saving; link Paths with ChainNextFlowToPath instead
+	Version     int64             `json:"version,omitempty"`     // Set by storage. Goes up by one each time the Flow or the Paths triggering it change
+	Namespace   string            `json:"namespace,omitempty"`   // Team or tenant the Flow belongs to. Its Path and waitFor are in the same namespace. Empty is the default namespace
+	SharedWith  []string          `json:"sharedWith,omitempty"`  // Other namespaces whose Paths may trigger the Flow, or AllNamespaces
+	Labels      map[string]string `json:"labels,omitempty"`      // Owner, environment, feature and such, for picking Flows with a Selector
+	Disabled    bool              `json:"disabled,omitempty"`    // When true, the Flow stays linked but messages are not forwarded to it
+}
+
+// AllowsNamespace returns whether Paths of the namespace may trigger the Flow
+func (f Flow) AllowsNamespace(namespace string) bool {
+	if namespace == f.Namespace {
+		return true
+	}
+	for _, shared := range f.SharedWith {
+		if shared == namespace || shared == AllNamespaces {
+			return true
+		}
+	}
+	return false
 }
 
-// AddKeyToFlows adds/replace keys in the optional UUID field
-func AddKeyToFlows(flows []Flow, keys []Key) Flows {
-	combineList := Flows{Flows: []Flow{}}
-	for i := range flows {
-		flows[i].UUID = keys[i].UUID
-		combineList.Flows = append(combineList.Flows, flows[i])
+// inNamespace returns the Flow with its Path and waitFor put in the namespace of the Flow when they have none. Paths of another namespace are rejected
+func (f Flow) inNamespace() (Flow, error) {
+	for _, path := range []**messenger.Path{&f.Path, &f.WaitFor} {
+		if *path == nil {
+			continue
+		}
+		if (*path).Namespace != "" && (*path).Namespace != f.Namespace {
+			return Flow{}, ErrPathOutsideNamespace
+		}
+		copied := **path
+		copied.Namespace = f.Namespace
+		*path = &copied
 	}
-	return combineList
+	return f, nil
+}
+
+// ResponsePath returns the Path a waiting Flow expects its response on
+func (f Flow) ResponsePath() *messenger.Path {
+	if f.WaitFor != nil {
+		return f.WaitFor
+	}
+	return f.Path
+}
+
+// NewFlows returns the Flows ready to be sent as JSON, which is an empty list rather than null when there are none
+func NewFlows(flows []Flow) Flows {
+	return Flows{Flows: append([]Flow{}, flows...)}
 }
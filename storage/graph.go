@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+)
+
+const (
+	NodeKindPath string = "path"
+	NodeKindFlow string = "flow"
+
+	EdgeKindTriggers string = "triggers" // Path to the Flow it triggers
+	EdgeKindSendsTo  string = "sendsTo"  // Flow to the Path it sends to
+	EdgeKindWaitsFor string = "waitsFor" // Flow to the Path its response arrives on
+	EdgeKindMatches  string = "matches"  // Path to a pattern Path in its namespace that also receives its messages
+)
+
+// Graph is the Paths and Flows in storage as nodes and how messages move between them as edges
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode is a Path or Flow. ID is its uuid
+type GraphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Label     string `json:"label"` // Name of a Flow, type and route of a Path, followed by the namespace if it has one
+	Namespace string `json:"namespace,omitempty"`
+	Pattern   bool   `json:"pattern,omitempty"`
+}
+
+// GraphEdge connects the nodes with the IDs From and To
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// GetGraph returns every Path and Flow and the edges between them
+func (gs *GraphStorage) GetGraph() (Graph, error) {
+	graph := Graph{
+		Nodes: []GraphNode{},
+		Edges: []GraphEdge{},
+	}
+	var pathDTOs []pathDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), cayley.StartPath(gs.store).Has(quad.IRI("route")).BuildIterator())
+	if err != nil {
+		return Graph{}, err
+	}
+	var flowDTOs []flowDTO
+	err = schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&flowDTOs), cayley.StartPath(gs.store).Has(quad.IRI("description")).BuildIterator())
+	if err != nil {
+		return Graph{}, err
+	}
+	for _, pathDTO := range pathDTOs {
+		id, err := nodeIDOf(pathDTO.ID)
+		if err != nil {
+			return Graph{}, err
+		}
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID:        id,
+			Kind:      NodeKindPath,
+			Label:     labelInNamespace(fmt.Sprintf("%s %s", pathDTO.Type, pathDTO.Route), pathDTO.Namespace),
+			Namespace: pathDTO.Namespace,
+			Pattern:   pathDTO.Pattern,
+		})
+		for _, flowIRI := range pathDTO.Flows {
+			flowID, err := nodeIDOf(flowIRI)
+			if err != nil {
+				return Graph{}, err
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: id, To: flowID, Kind: EdgeKindTriggers})
+		}
+		if !pathDTO.Pattern {
+			continue
+		}
+		for _, otherDTO := range pathDTOs {
+			if otherDTO.Pattern || otherDTO.Type != pathDTO.Type || otherDTO.Namespace != pathDTO.Namespace {
+				continue
+			}
+			if _, ok := MatchRoute(pathDTO.Route, otherDTO.Route); !ok {
+				continue
+			}
+			otherID, err := nodeIDOf(otherDTO.ID)
+			if err != nil {
+				return Graph{}, err
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: otherID, To: id, Kind: EdgeKindMatches})
+		}
+	}
+	for _, flowDTO := range flowDTOs {
+		id, err := nodeIDOf(flowDTO.ID)
+		if err != nil {
+			return Graph{}, err
+		}
+		graph.Nodes = append(graph.Nodes, GraphNode{
+			ID:        id,
+			Kind:      NodeKindFlow,
+			Label:     labelInNamespace(flowDTO.Name, flowDTO.Namespace),
+			Namespace: flowDTO.Namespace,
+		})
+		pathID, err := nodeIDOf(flowDTO.Path)
+		if err != nil {
+			return Graph{}, err
+		}
+		graph.Edges = append(graph.Edges, GraphEdge{From: id, To: pathID, Kind: EdgeKindSendsTo})
+		if flowDTO.WaitFor != "" {
+			waitForID, err := nodeIDOf(flowDTO.WaitFor)
+			if err != nil {
+				return Graph{}, err
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{From: id, To: waitForID, Kind: EdgeKindWaitsFor})
+		}
+	}
+	sortGraph(graph)
+	return graph, nil
+}
+
+// GetGraphAroundPath returns the part of the graph that leads to or follows from a message on the Path, up to maxDepth Flows away in either direction
+func (gs *GraphStorage) GetGraphAroundPath(pathKey Key, maxDepth int) (Graph, error) {
+	downstream, err := gs.GetDownstream(pathKey, maxDepth)
+	if err != nil {
+		return Graph{}, err
+	}
+	upstream, err := gs.GetUpstream(pathKey, maxDepth)
+	if err != nil {
+		return Graph{}, err
+	}
+	included := map[string]bool{pathKey.String(): true}
+	for _, traversal := range []Traversal{downstream, upstream} {
+		for _, flow := range traversal.Flows {
+			included[flow.UUID.String()] = true
+		}
+		for _, path := range traversal.Paths {
+			included[path.Key.String()] = true
+		}
+	}
+	graph, err := gs.GetGraph()
+	if err != nil {
+		return Graph{}, err
+	}
+	return graph.only(func(node GraphNode) bool {
+		return included[node.ID]
+	}), nil
+}
+
+// InNamespace returns the part of the graph whose nodes are in the namespace
+func (g Graph) InNamespace(namespace string) Graph {
+	return g.only(func(node GraphNode) bool {
+		return node.Namespace == namespace
+	})
+}
+
+// only returns the nodes the filter keeps and the edges between them
+func (g Graph) only(keep func(GraphNode) bool) Graph {
+	kept := Graph{
+		Nodes: []GraphNode{},
+		Edges: []GraphEdge{},
+	}
+	included := make(map[string]bool)
+	for _, node := range g.Nodes {
+		if keep(node) {
+			kept.Nodes = append(kept.Nodes, node)
+			included[node.ID] = true
+		}
+	}
+	for _, edge := range g.Edges {
+		if included[edge.From] && included[edge.To] {
+			kept.Edges = append(kept.Edges, edge)
+		}
+	}
+	return kept
+}
+
+func labelInNamespace(label string, namespace string) string {
+	if namespace == "" {
+		return label
+	}
+	return fmt.Sprintf("%s in %s", label, namespace)
+}
+
+func nodeIDOf(iri quad.IRI) (string, error) {
+	key, err := NewKeyFromQuadIRI(iri)
+	if err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
+// sortGraph orders nodes by kind and label, and edges by their nodes, so the same graph is always exported the same way
+func sortGraph(graph Graph) {
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		a, b := graph.Nodes[i], graph.Nodes[j]
+		if a.Kind != b.Kind {
+			return a.Kind > b.Kind
+		}
+		if a.Label != b.Label {
+			return a.Label < b.Label
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+}
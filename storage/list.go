@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	DefaultListLimit int = 50
+	MaxListLimit     int = 500
+
+	SortByName  string = "name"
+	SortByRoute string = "route"
+	SortByType  string = "type"
+)
+
+var (
+	ErrInvalidSortField error = fmt.Errorf("Cannot sort by the given field")
+	ErrInvalidCursor    error = fmt.Errorf("Cursor is not from a previous page of this list")
+	ErrInvalidLimit     error = fmt.Errorf("Limit must be between 1 and %d", MaxListLimit)
+)
+
+// ListOptions narrows, orders and pages the Flows or Paths returned by a list
+type ListOptions struct {
+	Namespace   string   // Only Paths and Flows in this namespace. Empty lists every namespace
+	Type        string   // Only Paths of this type, or Flows that send to them
+	RoutePrefix string   // Only Paths whose route starts with this, or Flows that send to them
+	Name        string   // Only Flows whose name contains this, ignoring case. Paths have no name so this is ignored for them
+	Selector    Selector // Only Flows or Paths whose labels it picks
+	SortBy      string   // SortByName, SortByRoute or SortByType. Defaults to SortByName for Flows and SortByRoute for Paths
+	Descending  bool
+	Cursor      string // Next cursor of the previous page. Empty for the first page
+	Limit       int    // Most entries on the page. Defaults to DefaultListLimit
+}
+
+// listEntry is the position of a Flow or Path in a list and the value it is sorted by
+type listEntry struct {
+	position  int
+	sortValue string
+	key       Key
+}
+
+// ListFlows returns a page of Flows matching the options and the cursor of the next page, which is empty on the last page
+func (gs *GraphStorage) ListFlows(options ListOptions) ([]Flow, []Key, string, error) {
+	sortBy := options.SortBy
+	if sortBy == "" {
+		sortBy = SortByName
+	}
+	if sortBy != SortByName && sortBy != SortByRoute && sortBy != SortByType {
+		return nil, nil, "", ErrInvalidSortField
+	}
+	p := cayley.StartPath(gs.store).Has(quad.IRI("description"))
+	var flowDTOs []flowDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&flowDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var flows []Flow
+	var entries []listEntry
+	for _, flowDTO := range flowDTOs {
+		key, err := NewKeyFromQuadIRI(flowDTO.ID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		flow, err := gs.GetFlowByKey(key)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if !matchesPathOptions(*flow.Path, options) || !strings.Contains(strings.ToLower(flow.Name), strings.ToLower(options.Name)) || !options.Selector.Matches(flow.Labels) {
+			continue
+		}
+		sortValue := flow.Name
+		switch sortBy {
+		case SortByRoute:
+			sortValue = flow.Path.Route
+		case SortByType:
+			sortValue = flow.Path.Type
+		}
+		entries = append(entries, listEntry{position: len(flows), sortValue: sortValue, key: key})
+		flows = append(flows, flow)
+	}
+	page, next, err := pageEntries(entries, options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	pageFlows := []Flow{}
+	pageKeys := []Key{}
+	for _, entry := range page {
+		pageFlows = append(pageFlows, flows[entry.position])
+		pageKeys = append(pageKeys, entry.key)
+	}
+	return pageFlows, pageKeys, next, nil
+}
+
+// ListPaths returns a page of Paths matching the options and the cursor of the next page, which is empty on the last page
+func (gs *GraphStorage) ListPaths(options ListOptions) ([]StoredPath, []Key, string, error) {
+	sortBy := options.SortBy
+	if sortBy == "" {
+		sortBy = SortByRoute
+	}
+	if sortBy != SortByRoute && sortBy != SortByType {
+		return nil, nil, "", ErrInvalidSortField
+	}
+	p := cayley.StartPath(gs.store).Has(quad.IRI("route"))
+	var pathDTOs []pathDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var paths []messenger.Path
+	var entries []listEntry
+	for _, pathDTO := range pathDTOs {
+		key, err := NewKeyFromQuadIRI(pathDTO.ID)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		path, err := pathDTO.path()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if !matchesPathOptions(path, options) || !options.Selector.Matches(path.Labels) {
+			continue
+		}
+		sortValue := path.Route
+		if sortBy == SortByType {
+			sortValue = path.Type
+		}
+		entries = append(entries, listEntry{position: len(paths), sortValue: sortValue, key: key})
+		paths = append(paths, path)
+	}
+	page, next, err := pageEntries(entries, options)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	pagePaths := []StoredPath{}
+	pageKeys := []Key{}
+	for _, entry := range page {
+		storedPath, err := gs.newStoredPath(entry.key, paths[entry.position])
+		if err != nil {
+			return nil, nil, "", err
+		}
+		pagePaths = append(pagePaths, storedPath)
+		pageKeys = append(pageKeys, entry.key)
+	}
+	return pagePaths, pageKeys, next, nil
+}
+
+func matchesPathOptions(path messenger.Path, options ListOptions) bool {
+	if options.Type != "" && path.Type != options.Type {
+		return false
+	}
+	if options.Namespace != "" && path.Namespace != options.Namespace {
+		return false
+	}
+	return strings.HasPrefix(path.Route, options.RoutePrefix)
+}
+
+// pageEntries sorts the entries and returns those after the cursor, up to the limit. Ties are ordered by Key so every entry has one place in the list
+func pageEntries(entries []listEntry, options ListOptions) ([]listEntry, string, error) {
+	limit := options.Limit
+	if limit == 0 {
+		limit = DefaultListLimit
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, "", ErrInvalidLimit
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].isBefore(entries[j], options.Descending)
+	})
+	start := 0
+	if options.Cursor != "" {
+		after, err := decodeCursor(options.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(entries), func(i int) bool {
+			return after.isBefore(entries[i], options.Descending)
+		})
+	}
+	end := start + limit
+	if end >= len(entries) {
+		return entries[start:], "", nil
+	}
+	return entries[start:end], encodeCursor(entries[end-1]), nil
+}
+
+func (le listEntry) isBefore(other listEntry, descending bool) bool {
+	if descending {
+		le, other = other, le
+	}
+	if le.sortValue != other.sortValue {
+		return le.sortValue < other.sortValue
+	}
+	return le.key.String() < other.key.String()
+}
+
+// encodeCursor returns a cursor that continues the list after the entry, even if the entry is later removed
+func encodeCursor(entry listEntry) string {
+	cursor, _ := json.Marshal([]string{entry.sortValue, entry.key.String()})
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+func decodeCursor(cursor string) (listEntry, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return listEntry{}, ErrInvalidCursor
+	}
+	var values []string
+	err = json.Unmarshal(decoded, &values)
+	if err != nil || len(values) != 2 {
+		return listEntry{}, ErrInvalidCursor
+	}
+	key, err := NewKeyFromString(values[1])
+	if err != nil {
+		return listEntry{}, ErrInvalidCursor
+	}
+	return listEntry{sortValue: values[0], key: key}, nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/edfungus/conduction/messenger"
+	"github.com/satori/go.uuid"
+)
+
+// StoredPath is a Path as it is kept in storage, with its uuid and the uuids of the Flows it triggers
+type StoredPath struct {
+	UUID uuid.UUID `json:"uuid"`
+	messenger.Path
+	Triggers []uuid.UUID `json:"triggers"`
+	Version  int64       `json:"version"` // Goes up by one each time the Path or the Flows it triggers change
+}
+
+// GetStoredPathByKey returns the Path of the uuid with its uuid and the uuids of the Flows it triggers
+func (gs *GraphStorage) GetStoredPathByKey(key Key) (StoredPath, error) {
+	path, err := gs.GetPathByKey(key)
+	if err != nil {
+		return StoredPath{}, err
+	}
+	return gs.newStoredPath(key, path)
+}
+
+func (gs *GraphStorage) newStoredPath(key Key, path messenger.Path) (StoredPath, error) {
+	flowKeys, err := gs.getKeysTriggeredByKey(key)
+	if err != nil {
+		return StoredPath{}, err
+	}
+	version, err := gs.getVersion(key, "pathVersion")
+	if err != nil {
+		return StoredPath{}, err
+	}
+	storedPath := StoredPath{
+		UUID:     key.UUID,
+		Path:     path,
+		Triggers: uuidsOfKeys(flowKeys),
+		Version:  version,
+	}
+	if storedPath.Triggers == nil {
+		storedPath.Triggers = []uuid.UUID{}
+	}
+	return storedPath, nil
+}
+
+// uuidsOfKeys returns the uuids of the Keys in order, so lists built from the graph are always the same
+func uuidsOfKeys(keys []Key) []uuid.UUID {
+	var uuids []uuid.UUID
+	for _, key := range keys {
+		uuids = append(uuids, key.UUID)
+	}
+	sort.Slice(uuids, func(i, j int) bool {
+		return uuids[i].String() < uuids[j].String()
+	})
+	return uuids
+}
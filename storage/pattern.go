@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+	"github.com/edfungus/conduction/messenger"
+)
+
+// Route segments that make a Path a pattern. Routes are split into segments on "/"
+const (
+	SingleLevelWildcard string = "+" // Matches exactly one segment, like an MQTT topic filter
+	MultiLevelWildcard  string = "#" // Matches the rest of the route and must be the last segment
+)
+
+var (
+	ErrMultiLevelWildcardNotLast error = errors.New("Multi level wildcard # must be the last segment of the route")
+)
+
+// ValidatePatternRoute returns an error if the route uses wildcards in a way that can never match
+func ValidatePatternRoute(route string) error {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if segment == MultiLevelWildcard && i != len(segments)-1 {
+			return ErrMultiLevelWildcardNotLast
+		}
+	}
+	return nil
+}
+
+// IsPatternRoute returns whether the route has wildcards or {name} templates in it
+func IsPatternRoute(route string) bool {
+	for _, segment := range strings.Split(route, "/") {
+		if isWildcardSegment(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRoute returns whether the route matches the pattern and the segments captured by it.
+// A {name} segment is captured as name, a + segment by its position among the + segments starting at 1 and a # segment as # with the rest of the route
+func MatchRoute(pattern string, route string) (map[string]string, bool) {
+	patternSegments := strings.Split(pattern, "/")
+	routeSegments := strings.Split(route, "/")
+	captures := make(map[string]string)
+	singleLevelCount := 0
+	for i, patternSegment := range patternSegments {
+		if patternSegment == MultiLevelWildcard && i == len(patternSegments)-1 {
+			if i > len(routeSegments) {
+				return nil, false
+			}
+			captures[MultiLevelWildcard] = strings.Join(routeSegments[i:], "/")
+			return captures, true
+		}
+		if i >= len(routeSegments) {
+			return nil, false
+		}
+		switch {
+		case patternSegment == SingleLevelWildcard:
+			singleLevelCount++
+			captures[strconv.Itoa(singleLevelCount)] = routeSegments[i]
+		case isTemplateSegment(patternSegment):
+			captures[patternSegment[1:len(patternSegment)-1]] = routeSegments[i]
+		case patternSegment != routeSegments[i]:
+			return nil, false
+		}
+	}
+	if len(patternSegments) != len(routeSegments) {
+		return nil, false
+	}
+	return captures, true
+}
+
+// SortPatternsBySpecificity orders patterns so the ones with the most literal segments come first, then the ones without a multi level wildcard. Ties are ordered by route so the order is stable
+func SortPatternsBySpecificity(paths []messenger.Path, keys []Key) {
+	sort.Sort(patternsBySpecificity{paths: paths, keys: keys})
+}
+
+type patternsBySpecificity struct {
+	paths []messenger.Path
+	keys  []Key
+}
+
+func (p patternsBySpecificity) Len() int {
+	return len(p.paths)
+}
+
+func (p patternsBySpecificity) Less(i, j int) bool {
+	iLiterals, jLiterals := countLiteralSegments(p.paths[i].Route), countLiteralSegments(p.paths[j].Route)
+	if iLiterals != jLiterals {
+		return iLiterals > jLiterals
+	}
+	iMultiLevel, jMultiLevel := strings.HasSuffix(p.paths[i].Route, MultiLevelWildcard), strings.HasSuffix(p.paths[j].Route, MultiLevelWildcard)
+	if iMultiLevel != jMultiLevel {
+		return jMultiLevel
+	}
+	return p.paths[i].Route < p.paths[j].Route
+}
+
+func (p patternsBySpecificity) Swap(i, j int) {
+	p.paths[i], p.paths[j] = p.paths[j], p.paths[i]
+	if len(p.keys) == len(p.paths) {
+		p.keys[i], p.keys[j] = p.keys[j], p.keys[i]
+	}
+}
+
+func countLiteralSegments(route string) int {
+	count := 0
+	for _, segment := range strings.Split(route, "/") {
+		if !isWildcardSegment(segment) {
+			count++
+		}
+	}
+	return count
+}
+
+func isWildcardSegment(segment string) bool {
+	return segment == SingleLevelWildcard || segment == MultiLevelWildcard || isTemplateSegment(segment)
+}
+
+func isTemplateSegment(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// GetPatternPaths returns every pattern Path of the type in every namespace with their Keys, most specific first
+func (gs *GraphStorage) GetPatternPaths(pathType string) ([]messenger.Path, []Key, error) {
+	p := cayley.StartPath(gs.store, quad.StringToValue(pathType)).In(quad.IRI("type")).Has(quad.IRI("pattern"), quad.Bool(true))
+	var pathDTOs []pathDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []messenger.Path
+	var keys []Key
+	for _, pathDTO := range pathDTOs {
+		key, err := NewKeyFromQuadIRI(pathDTO.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		paths = append(paths, messenger.Path{
+			Route:     pathDTO.Route,
+			Type:      pathDTO.Type,
+			Namespace: pathDTO.Namespace,
+		})
+		keys = append(keys, key)
+	}
+	SortPatternsBySpecificity(paths, keys)
+	return paths, keys, nil
+}
+
+// getPatternPathKeysMatchingPath returns the Keys of pattern Paths in the namespace of the Path that a message from the Path would also trigger
+func (gs *GraphStorage) getPatternPathKeysMatchingPath(pathKey Key) ([]Key, error) {
+	path, err := gs.GetPathByKey(pathKey)
+	if err != nil {
+		return nil, err
+	}
+	patterns, patternKeys, err := gs.GetPatternPaths(path.Type)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for i, pattern := range patterns {
+		if _, ok := MatchRoute(pattern.Route, path.Route); ok && pattern.Namespace == path.Namespace {
+			keys = append(keys, patternKeys[i])
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,92 @@
+// +build all unit
+
+package storage
+
+import (
+	"github.com/edfungus/conduction/messenger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Pattern", func() {
+		Describe("Given a route", func() {
+			Context("When it has wildcards or templates", func() {
+				It("Then it should be a pattern", func() {
+					Expect(IsPatternRoute("sensors/+/temp")).To(BeTrue())
+					Expect(IsPatternRoute("home/#")).To(BeTrue())
+					Expect(IsPatternRoute("GET_/users/{id}")).To(BeTrue())
+				})
+			})
+			Context("When it has no wildcards or templates", func() {
+				It("Then it should not be a pattern", func() {
+					Expect(IsPatternRoute("sensors/kitchen/temp")).To(BeFalse())
+					Expect(IsPatternRoute("GET_/users/{}")).To(BeFalse())
+					Expect(IsPatternRoute("home/a#b")).To(BeFalse())
+				})
+			})
+		})
+		Describe("Given a pattern and a route", func() {
+			Context("When the pattern has single level wildcards", func() {
+				It("Then each wildcard should match one segment and be captured by position", func() {
+					captures, ok := MatchRoute("sensors/+/+/temp", "sensors/house/kitchen/temp")
+					Expect(ok).To(BeTrue())
+					Expect(captures).To(Equal(map[string]string{"1": "house", "2": "kitchen"}))
+
+					_, ok = MatchRoute("sensors/+/temp", "sensors/house/kitchen/temp")
+					Expect(ok).To(BeFalse())
+					_, ok = MatchRoute("sensors/+/temp", "sensors/kitchen/humidity")
+					Expect(ok).To(BeFalse())
+				})
+			})
+			Context("When the pattern ends with a multi level wildcard", func() {
+				It("Then it should match the rest of the route including the parent level", func() {
+					captures, ok := MatchRoute("home/#", "home/kitchen/light")
+					Expect(ok).To(BeTrue())
+					Expect(captures).To(Equal(map[string]string{"#": "kitchen/light"}))
+
+					captures, ok = MatchRoute("home/#", "home")
+					Expect(ok).To(BeTrue())
+					Expect(captures).To(Equal(map[string]string{"#": ""}))
+
+					_, ok = MatchRoute("home/#", "garden/light")
+					Expect(ok).To(BeFalse())
+				})
+			})
+			Context("When the pattern has templates", func() {
+				It("Then each template should capture its segment by name", func() {
+					captures, ok := MatchRoute("GET_/users/{id}/posts/{post}", "GET_/users/42/posts/7")
+					Expect(ok).To(BeTrue())
+					Expect(captures).To(Equal(map[string]string{"id": "42", "post": "7"}))
+
+					_, ok = MatchRoute("GET_/users/{id}", "POST_/users/42")
+					Expect(ok).To(BeFalse())
+					_, ok = MatchRoute("GET_/users/{id}", "GET_/users")
+					Expect(ok).To(BeFalse())
+				})
+			})
+		})
+		Describe("Given several patterns", func() {
+			Context("When they are sorted by specificity", func() {
+				It("Then patterns with fewer wildcards should come first and their Keys should follow", func() {
+					keys := []Key{NewRandomKey(), NewRandomKey(), NewRandomKey(), NewRandomKey()}
+					paths := []messenger.Path{
+						{Route: "sensors/#"},
+						{Route: "sensors/+/+"},
+						{Route: "sensors/+/temp"},
+						{Route: "sensors/{room}/temp"},
+					}
+					sortedKeys := []Key{keys[0], keys[1], keys[2], keys[3]}
+					SortPatternsBySpecificity(paths, sortedKeys)
+					Expect(paths).To(Equal([]messenger.Path{
+						{Route: "sensors/+/temp"},
+						{Route: "sensors/{room}/temp"},
+						{Route: "sensors/+/+"},
+						{Route: "sensors/#"},
+					}))
+					Expect(sortedKeys).To(Equal([]Key{keys[2], keys[3], keys[1], keys[0]}))
+				})
+			})
+		})
+	})
+})
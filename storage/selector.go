@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Operators of the requirements in a Selector
+const (
+	SelectorEquals    string = "="
+	SelectorNotEquals string = "!="
+	SelectorIn        string = "in"
+	SelectorNotIn     string = "notin"
+	SelectorExists    string = "exists"
+	SelectorNotExists string = "!"
+)
+
+var (
+	ErrInvalidSelector   error = errors.New("Selector is not valid. Expected comma separated requirements like owner=iot, env!=prod, env in (dev,test), owner or !owner")
+	ErrInvalidLabelKey   error = errors.New("Label keys must be letters, digits, '-', '_', '.' or '/' and start and end with a letter or digit")
+	ErrInvalidLabelValue error = errors.New("Label values must be empty or letters, digits, '-', '_' or '.' and start and end with a letter or digit")
+)
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+	setRequirement    = regexp.MustCompile(`^([^\s!=(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+)
+
+// Selector picks Flows and Paths by their labels, like a Kubernetes label selector. Every requirement must hold. The empty Selector picks everything
+type Selector struct {
+	requirements []requirement
+}
+
+type requirement struct {
+	key      string
+	operator string
+	values   []string
+}
+
+// ValidateLabels returns an error if a key or value of the labels cannot be written in a Selector
+func ValidateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("%v. %s", ErrInvalidLabelKey, key)
+		}
+		if !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("%v. %s", ErrInvalidLabelValue, value)
+		}
+	}
+	return nil
+}
+
+// ParseSelector reads comma separated requirements. key=value and key==value need the label to be the value, key!=value needs it to be missing or another value,
+// key in (a,b) needs it to be one of the values, key notin (a,b) needs it to be missing or none of them, key needs the label and !key needs it missing
+func ParseSelector(selector string) (Selector, error) {
+	var s Selector
+	for _, part := range splitSelector(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			if strings.TrimSpace(selector) == "" {
+				continue
+			}
+			return Selector{}, ErrInvalidSelector
+		}
+		r, err := parseRequirement(part)
+		if err != nil {
+			return Selector{}, err
+		}
+		s.requirements = append(s.requirements, r)
+	}
+	return s, nil
+}
+
+// splitSelector splits the selector on the commas that are not inside the parentheses of a set
+func splitSelector(selector string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+func parseRequirement(part string) (requirement, error) {
+	if match := setRequirement.FindStringSubmatch(part); match != nil {
+		var values []string
+		for _, value := range strings.Split(match[3], ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+		r := requirement{key: match[1], operator: match[2], values: values}
+		return r, r.validate()
+	}
+	for _, operator := range []string{SelectorNotEquals, "==", SelectorEquals} {
+		if i := strings.Index(part, operator); i >= 0 {
+			r := requirement{
+				key:      strings.TrimSpace(part[:i]),
+				operator: SelectorEquals,
+				values:   []string{strings.TrimSpace(part[i+len(operator):])},
+			}
+			if operator == SelectorNotEquals {
+				r.operator = SelectorNotEquals
+			}
+			return r, r.validate()
+		}
+	}
+	if strings.HasPrefix(part, SelectorNotExists) {
+		r := requirement{key: strings.TrimSpace(strings.TrimPrefix(part, SelectorNotExists)), operator: SelectorNotExists}
+		return r, r.validate()
+	}
+	r := requirement{key: part, operator: SelectorExists}
+	return r, r.validate()
+}
+
+func (r requirement) validate() error {
+	if !labelKeyPattern.MatchString(r.key) {
+		return fmt.Errorf("%v. %s", ErrInvalidSelector, ErrInvalidLabelKey)
+	}
+	for _, value := range r.values {
+		if !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("%v. %s", ErrInvalidSelector, ErrInvalidLabelValue)
+		}
+	}
+	return nil
+}
+
+// Matches returns whether the labels meet every requirement of the Selector
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s.requirements {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.operator {
+	case SelectorExists:
+		return ok
+	case SelectorNotExists:
+		return !ok
+	case SelectorEquals, SelectorIn:
+		return ok && containsString(r.values, value)
+	case SelectorNotEquals, SelectorNotIn:
+		return !ok || !containsString(r.values, value)
+	}
+	return false
+}
+
+// Empty returns whether the Selector has no requirements and so picks everything
+func (s Selector) Empty() bool {
+	return len(s.requirements) == 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+// +build all unit
+
+package storage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Selector", func() {
+		labels := map[string]string{"owner": "iot", "env": "dev"}
+		Describe("Given a selector with equality requirements", func() {
+			Context("When it is matched against labels", func() {
+				It("Then every requirement should need to hold", func() {
+					for selector, expected := range map[string]bool{
+						"owner=iot":           true,
+						"owner==iot":          true,
+						"owner=iot,env!=prod": true,
+						"owner=iot,env=prod":  false,
+						"team!=lights":        true,
+						"owner!=iot":          false,
+						"":                    true,
+					} {
+						s, err := ParseSelector(selector)
+						Expect(err).To(BeNil())
+						Expect(s.Matches(labels)).To(Equal(expected), selector)
+					}
+				})
+			})
+		})
+		Describe("Given a selector with set and existence requirements", func() {
+			Context("When it is matched against labels", func() {
+				It("Then the label should need to be in or out of the set, or present or missing", func() {
+					for selector, expected := range map[string]bool{
+						"env in (dev, test)":         true,
+						"env notin (dev,test)":       false,
+						"team notin (lights)":        true,
+						"owner, !team":               true,
+						"!owner":                     false,
+						"env in (prod),owner in (a)": false,
+					} {
+						s, err := ParseSelector(selector)
+						Expect(err).To(BeNil())
+						Expect(s.Matches(labels)).To(Equal(expected), selector)
+					}
+				})
+			})
+		})
+		Describe("Given a selector that is not valid", func() {
+			Context("When it is parsed", func() {
+				It("Then an error should be returned", func() {
+					for _, selector := range []string{"owner=iot,,env=dev", "=iot", "owner=i ot", "env in dev", "!"} {
+						_, err := ParseSelector(selector)
+						Expect(err).ToNot(BeNil(), selector)
+					}
+				})
+			})
+		})
+		Describe("Given labels", func() {
+			Context("When a key or value cannot be written in a selector", func() {
+				It("Then the labels should not be valid", func() {
+					Expect(ValidateLabels(map[string]string{"app.io/owner": "iot", "env": ""})).To(BeNil())
+					Expect(ValidateLabels(map[string]string{"owner,env": "iot"})).ToNot(BeNil())
+					Expect(ValidateLabels(map[string]string{"owner": "i=ot"})).ToNot(BeNil())
+				})
+			})
+		})
+	})
+})
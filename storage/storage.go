@@ -2,11 +2,14 @@ package storage
 
 import (
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/cayleygraph/cayley"
 	"github.com/cayleygraph/cayley/graph"
@@ -24,13 +27,37 @@ var Logger = logrus.New()
 type Storage interface {
 	SaveFlow(flow Flow) (Key, error)
 	GetFlowByKey(key Key) (Flow, error)
+	UpdateFlow(key Key, flow Flow) error
+	DeleteFlow(key Key) error
+	ListFlows(options ListOptions) ([]Flow, []Key, string, error)
 
 	SavePath(path messenger.Path) (Key, error)
 	GetPathByKey(key Key) (messenger.Path, error)
+	GetStoredPathByKey(key Key) (StoredPath, error)
 	GetKeyOfPath(path messenger.Path) (Key, error)
+	GetPatternPaths(pathType string) ([]messenger.Path, []Key, error)
+	DeletePath(key Key) error
+	ListPaths(options ListOptions) ([]StoredPath, []Key, string, error)
 
 	ChainNextFlowToPath(flowKey Key, pathKey Key) error
+	UnchainNextFlowFromPath(flowKey Key, pathKey Key) error
 	GetNextFlows(key Key) ([]Flow, []Key, error)
+	GetDownstream(pathKey Key, maxDepth int) (Traversal, error)
+	GetUpstream(pathKey Key, maxDepth int) (Traversal, error)
+	GetGraph() (Graph, error)
+	GetGraphAroundPath(pathKey Key, maxDepth int) (Graph, error)
+
+	SaveContinuation(continuation Continuation) (Key, error)
+	GetContinuationByKey(key Key) (Continuation, error)
+	GetKeysOfAwaits(path messenger.Path) ([]Key, error)
+	ResolveAwait(key Key, payload []byte) (Continuation, Key, error)
+	DeleteContinuation(key Key) error
+	TakeExpiredContinuations(now time.Time) ([]Continuation, error)
+
+	AppendAuditEntry(entry AuditEntry) error
+	ListAuditEntries(options AuditOptions) ([]AuditEntry, string, error)
+
+	Atomically(change func(Storage) error) error
 }
 
 const (
@@ -41,6 +68,15 @@ var (
 	ErrFlowCannotBeRetrieved error = fmt.Errorf("Could not retrieve Flow from storage")
 	ErrPathCannotBeRetrieved error = fmt.Errorf("Could not retrieve Path from storage")
 	ErrResolvingKey          error = fmt.Errorf("Error resolving key in database")
+	ErrPathNotFound          error = fmt.Errorf("Path was not found in graph store")
+	ErrMultiplePaths         error = fmt.Errorf("There are multiple matching Paths. They are expected to be unique")
+	ErrChainCreatesCycle     error = fmt.Errorf("Chaining the Flow to the Path would create a loop")
+	ErrChainAlreadyExists    error = fmt.Errorf("Flow is already chained to the Path")
+	ErrChainNotFound         error = fmt.Errorf("Flow is not chained to the Path")
+	ErrFlowHasContinuations  error = fmt.Errorf("Flow cannot be deleted while a Continuation is waiting to run it")
+	ErrPathInUse             error = fmt.Errorf("Path cannot be deleted while Flows send to or wait on it")
+	ErrNamespaceNotShared    error = fmt.Errorf("Flow is not shared with the namespace of the Path so the Path cannot trigger it")
+	ErrPathOutsideNamespace  error = fmt.Errorf("Flow can only send to and wait on Paths in its own namespace")
 )
 
 type flowDTO struct {
@@ -48,34 +84,150 @@ type flowDTO struct {
 	Name        string   `quad:"name"`
 	Description string   `quad:"description"`
 	Path        quad.IRI `quad:"path"`
+	Wait        bool     `quad:"wait,optional"`
+	Aggregate   bool     `quad:"aggregate,optional"`
+	WaitFor     quad.IRI `quad:"waitFor,optional"`
+	Condition   string   `quad:"condition,optional"`
+	Transform   string   `quad:"transform,optional"`
+	Version     int64    `quad:"flowVersion,optional"`
+	Namespace   string   `quad:"flowNamespace,optional"`
+	SharedWith  []string `quad:"sharedWith,optional"`
+	Labels      string   `quad:"flowLabels,optional"` // JSON, like the metadata of Paths
+	Disabled    bool     `quad:"flowDisabled,optional"`
+	// Identity and metadata of the Paths the Flow sends to and waits on belong to the Flow, as other Flows may use the same Paths
+	PathIdentity    string `quad:"flowPathIdentity,optional"`
+	PathMetadata    string `quad:"flowPathMetadata,optional"`
+	WaitForIdentity string `quad:"flowWaitForIdentity,optional"`
+	WaitForMetadata string `quad:"flowWaitForMetadata,optional"`
 }
 
-// NewFlowDTO returns a new flowDTO
-func NewFlowDTO(id quad.IRI, name string, description string, path quad.IRI) flowDTO {
-	return flowDTO{
-		ID:          id,
-		Name:        name,
-		Description: description,
-		Path:        path,
-	}
+// newFlowDTO returns the flowDTO of the Flow at the version, sending to and waiting on the Paths of the IRIs.
+// The identity and metadata of the Flow's Paths are kept on the flowDTO
+func newFlowDTO(id quad.IRI, flow Flow, path quad.IRI, waitFor quad.IRI, version int64) flowDTO {
+	dto := flowDTO{
+		ID:           id,
+		Name:         flow.Name,
+		Description:  flow.Description,
+		Path:         path,
+		Wait:         flow.Wait,
+		Aggregate:    flow.Aggregate,
+		WaitFor:      waitFor,
+		Condition:    flow.Condition,
+		Transform:    flow.Transform,
+		Version:      version,
+		Namespace:    flow.Namespace,
+		SharedWith:   flow.SharedWith,
+		Labels:       encodeLabels(flow.Labels),
+		Disabled:     flow.Disabled,
+		PathIdentity: flow.Path.Identity,
+		PathMetadata: encodePathMetadata(flow.Path.Metadata),
+	}
+	if flow.WaitFor != nil {
+		dto.WaitForIdentity = flow.WaitFor.Identity
+		dto.WaitForMetadata = encodePathMetadata(flow.WaitFor.Metadata)
+	}
+	return dto
+}
+
+// withoutDetails returns the Path without its identity, metadata and labels, so saving it for a Flow leaves those of the stored Path alone.
+// Labels of a Path only change through SavePath
+func withoutDetails(path messenger.Path) messenger.Path {
+	path.Identity = ""
+	path.Metadata = nil
+	path.Labels = nil
+	return path
 }
 
+// pathDTO keeps identity and metadata under their own predicates so they are not mistaken for those of Continuations. Metadata is stored as JSON
 type pathDTO struct {
-	ID    quad.IRI   `quad:"@id"`
-	Route string     `quad:"route"`
-	Type  string     `quad:"type"`
-	Flows []quad.IRI `quad:"triggers,optional"`
+	ID        quad.IRI   `quad:"@id"`
+	Route     string     `quad:"route"`
+	Type      string     `quad:"type"`
+	Pattern   bool       `quad:"pattern,optional"`
+	Flows     []quad.IRI `quad:"triggers,optional"`
+	Identity  string     `quad:"pathIdentity,optional"`
+	Metadata  string     `quad:"pathMetadata,optional"`
+	Version   int64      `quad:"pathVersion,optional"`
+	Namespace string     `quad:"pathNamespace,optional"`
+	Labels    string     `quad:"pathLabels,optional"`
 }
 
-func NewPathDTO(id quad.IRI, route string, pathType string, flows []quad.IRI) pathDTO {
+func NewPathDTO(id quad.IRI, path messenger.Path, flows []quad.IRI) pathDTO {
 	return pathDTO{
-		ID:    id,
-		Route: route,
-		Type:  pathType,
-		Flows: flows,
+		ID:        id,
+		Route:     path.Route,
+		Type:      path.Type,
+		Pattern:   IsPatternRoute(path.Route),
+		Flows:     flows,
+		Identity:  path.Identity,
+		Metadata:  encodePathMetadata(path.Metadata),
+		Version:   1,
+		Namespace: path.Namespace,
+		Labels:    encodeLabels(path.Labels),
 	}
 }
 
+// path returns the Path the pathDTO describes, with its identity, metadata and labels
+func (dto pathDTO) path() (messenger.Path, error) {
+	path := messenger.Path{
+		Route:     dto.Route,
+		Type:      dto.Type,
+		Identity:  dto.Identity,
+		Namespace: dto.Namespace,
+	}
+	metadata, err := decodePathMetadata(dto.Metadata)
+	if err != nil {
+		return messenger.Path{}, err
+	}
+	path.Metadata = metadata
+	labels, err := decodeLabels(dto.Labels)
+	if err != nil {
+		return messenger.Path{}, err
+	}
+	path.Labels = labels
+	return path, nil
+}
+
+func encodePathMetadata(metadata map[string][]byte) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(metadata)
+	return string(encoded)
+}
+
+func decodePathMetadata(encoded string) (map[string][]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var metadata map[string][]byte
+	err := json.Unmarshal([]byte(encoded), &metadata)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func encodeLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(labels)
+	return string(encoded)
+}
+
+func decodeLabels(encoded string) (map[string]string, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var labels map[string]string
+	err := json.Unmarshal([]byte(encoded), &labels)
+	if err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
 type GraphStorageConfig struct {
 	Host         string
 	Port         int
@@ -90,7 +242,9 @@ func (gsc GraphStorageConfig) GetDatabaseConnectionPath() string {
 }
 
 type GraphStorage struct {
-	store *cayley.Handle
+	store     *cayley.Handle
+	awaitLock sync.Mutex // Resolving Awaits one at a time means only the last resolver sees its Continuation complete
+	writeLock sync.Mutex // Changes to Flows, Paths and their links happen one at a time so version checks and version bumps cannot interleave
 }
 
 // NewGraphStorage returns a new Storage that uses Cayley and CockroachDB
@@ -124,14 +278,79 @@ func NewGraphStorageBolt(filepath string) (*GraphStorage, error) {
 	}, nil
 }
 
-// SaveFlow adds a new Flow to the graph. If the Path does not exist, it will be added, else it will be made
+// Atomically runs change while holding the write lock, so no other change to Flows, Paths or their links can happen between what change reads and writes.
+// change must make its changes through the Storage it is given, which already holds the lock
+func (gs *GraphStorage) Atomically(change func(Storage) error) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return change(lockedStorage{gs})
+}
+
+// lockedStorage is the GraphStorage given to Atomically. It holds the write lock, so its changes do not take it again
+type lockedStorage struct {
+	*GraphStorage
+}
+
+func (ls lockedStorage) SaveFlow(flow Flow) (Key, error) {
+	return ls.saveFlow(flow)
+}
+
+func (ls lockedStorage) UpdateFlow(key Key, flow Flow) error {
+	return ls.updateFlow(key, flow)
+}
+
+func (ls lockedStorage) DeleteFlow(key Key) error {
+	return ls.deleteFlow(key)
+}
+
+func (ls lockedStorage) SavePath(path messenger.Path) (Key, error) {
+	return ls.savePath(path)
+}
+
+func (ls lockedStorage) DeletePath(key Key) error {
+	return ls.deletePath(key)
+}
+
+func (ls lockedStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
+	return ls.chainNextFlowToPath(flowKey, pathKey)
+}
+
+func (ls lockedStorage) UnchainNextFlowFromPath(flowKey Key, pathKey Key) error {
+	return ls.unchainNextFlowFromPath(flowKey, pathKey)
+}
+
+func (ls lockedStorage) Atomically(change func(Storage) error) error {
+	return change(ls)
+}
+
+// SaveFlow adds a new Flow to the graph. If the Path does not exist, it will be added, else it will be made.
+// The identity and metadata of its Paths are kept with the Flow rather than the Paths
 func (gs *GraphStorage) SaveFlow(flow Flow) (Key, error) {
-	pathKey, err := gs.SavePath(*flow.Path)
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.saveFlow(flow)
+}
+
+// saveFlow is SaveFlow for callers already holding the write lock
+func (gs *GraphStorage) saveFlow(flow Flow) (Key, error) {
+	flow, err := flow.inNamespace()
+	if err != nil {
+		return Key{}, err
+	}
+	pathKey, err := gs.savePath(withoutDetails(*flow.Path))
 	if err != nil {
 		return Key{}, err
 	}
+	var waitForIRI quad.IRI
+	if flow.WaitFor != nil {
+		waitForKey, err := gs.savePath(withoutDetails(*flow.WaitFor))
+		if err != nil {
+			return Key{}, err
+		}
+		waitForIRI = waitForKey.QuadIRI()
+	}
 	flowKey := NewRandomKey()
-	flowDTO := NewFlowDTO(flowKey.QuadIRI(), flow.Name, flow.Description, pathKey.QuadIRI())
+	flowDTO := newFlowDTO(flowKey.QuadIRI(), flow, pathKey.QuadIRI(), waitForIRI, 1)
 	err = gs.writeToGraph(flowDTO)
 	if err != nil {
 		return Key{}, err
@@ -139,7 +358,7 @@ func (gs *GraphStorage) SaveFlow(flow Flow) (Key, error) {
 	return flowKey, nil
 }
 
-// GetFlowByKey returns a Flow of the sepcified uuid from the graph
+// GetFlowByKey returns a Flow of the sepcified uuid from the graph, with its uuid and the uuids of the Paths that trigger it
 func (gs *GraphStorage) GetFlowByKey(key Key) (Flow, error) {
 	var flowDTO flowDTO
 	err := schema.LoadTo(nil, gs.store, &flowDTO, key.QuadValue())
@@ -154,27 +373,185 @@ func (gs *GraphStorage) GetFlowByKey(key Key) (Flow, error) {
 	if err != nil {
 		return Flow{}, err
 	}
-	return Flow{
+	path.Identity = flowDTO.PathIdentity
+	path.Metadata, err = decodePathMetadata(flowDTO.PathMetadata)
+	if err != nil {
+		return Flow{}, err
+	}
+	triggerKeys, err := gs.getKeysTriggeringKey(key)
+	if err != nil {
+		return Flow{}, err
+	}
+	flow := Flow{
+		UUID:        key.UUID,
 		Name:        flowDTO.Name,
 		Description: flowDTO.Description,
-		Path: &messenger.Path{
-			Route: path.Route,
-			Type:  path.Type,
-		},
-	}, nil
+		Path:        &path,
+		Wait:        flowDTO.Wait,
+		Aggregate:   flowDTO.Aggregate,
+		Condition:   flowDTO.Condition,
+		Transform:   flowDTO.Transform,
+		TriggeredBy: uuidsOfKeys(triggerKeys),
+		Version:     flowDTO.Version,
+		Namespace:   flowDTO.Namespace,
+		Disabled:    flowDTO.Disabled,
+	}
+	flow.Labels, err = decodeLabels(flowDTO.Labels)
+	if err != nil {
+		return Flow{}, err
+	}
+	if len(flowDTO.SharedWith) > 0 {
+		flow.SharedWith = append([]string{}, flowDTO.SharedWith...)
+		sort.Strings(flow.SharedWith)
+	}
+	if flowDTO.WaitFor != "" {
+		waitForKey, err := NewKeyFromQuadIRI(flowDTO.WaitFor)
+		if err != nil {
+			return Flow{}, err
+		}
+		waitFor, err := gs.GetPathByKey(waitForKey)
+		if err != nil {
+			return Flow{}, err
+		}
+		waitFor.Identity = flowDTO.WaitForIdentity
+		waitFor.Metadata, err = decodePathMetadata(flowDTO.WaitForMetadata)
+		if err != nil {
+			return Flow{}, err
+		}
+		flow.WaitFor = &waitFor
+	}
+	return flow, nil
+}
+
+// UpdateFlow replaces the Flow of the Key. The Paths that trigger the Flow keep triggering it, so changes that would let a message loop back to one of them,
+// or that would stop sharing the Flow with one of their namespaces, are rejected. When the Flow has a version, it must be the stored version or ErrVersionConflict is returned. The stored version goes up by one
+func (gs *GraphStorage) UpdateFlow(key Key, flow Flow) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.updateFlow(key, flow)
+}
+
+// updateFlow is UpdateFlow for callers already holding the write lock
+func (gs *GraphStorage) updateFlow(key Key, flow Flow) error {
+	current, err := gs.GetFlowByKey(key)
+	if err != nil {
+		return err
+	}
+	if flow.Version != 0 && flow.Version != current.Version {
+		return ErrVersionConflict
+	}
+	flow, err = flow.inNamespace()
+	if err != nil {
+		return err
+	}
+	pathKey, err := gs.savePath(withoutDetails(*flow.Path))
+	if err != nil {
+		return err
+	}
+	flowPathKeys := []Key{pathKey}
+	var waitForIRI quad.IRI
+	if flow.WaitFor != nil {
+		waitForKey, err := gs.savePath(withoutDetails(*flow.WaitFor))
+		if err != nil {
+			return err
+		}
+		waitForIRI = waitForKey.QuadIRI()
+		flowPathKeys = append(flowPathKeys, waitForKey)
+	}
+	triggerKeys, err := gs.getKeysTriggeringKey(key)
+	if err != nil {
+		return err
+	}
+	for _, triggerKey := range triggerKeys {
+		trigger, err := gs.GetPathByKey(triggerKey)
+		if err != nil {
+			return err
+		}
+		if !flow.AllowsNamespace(trigger.Namespace) {
+			return ErrNamespaceNotShared
+		}
+		createsCycle, err := gs.canPathsOfFlowReachPath(key, flowPathKeys, triggerKey)
+		if err != nil {
+			return err
+		}
+		if createsCycle {
+			return ErrChainCreatesCycle
+		}
+	}
+	flowDTO := newFlowDTO(key.QuadIRI(), flow, pathKey.QuadIRI(), waitForIRI, current.Version+1)
+	return gs.replaceInGraph(key, flowDTO)
+}
+
+// DeleteFlow removes the Flow and unchains it from the Paths that trigger it. Flows that a Continuation is waiting to run cannot be deleted
+func (gs *GraphStorage) DeleteFlow(key Key) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.deleteFlow(key)
+}
+
+// deleteFlow is DeleteFlow for callers already holding the write lock
+func (gs *GraphStorage) deleteFlow(key Key) error {
+	_, err := gs.GetFlowByKey(key)
+	if err != nil {
+		return err
+	}
+	p := cayley.StartPath(gs.store, key.QuadValue()).In(quad.IRI("continues"))
+	continuationList, err := p.Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return ErrResolvingKey
+	}
+	if len(continuationList) > 0 {
+		return ErrFlowHasContinuations
+	}
+	return gs.removeNodeFromGraph(key)
 }
 
-// SavePath adds path to graph if new, else it will return the id of the existing path. Path are unique based on route and type combined
+// SavePath adds path to graph if new, else it will return the id of the existing path. Path are unique based on namespace, route and type combined.
+// When an existing Path is saved with an identity or metadata, they replace those already stored. Labels given for an existing Path replace its labels:
+// nil labels leave them alone, while an empty, non-nil map removes them.
+// The version of an existing Path only goes up when one of these changes
 func (gs *GraphStorage) SavePath(path messenger.Path) (Key, error) {
-	pathExists, err := gs.doesPathExists(path)
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.savePath(path)
+}
+
+// savePath is SavePath for callers already holding the write lock
+func (gs *GraphStorage) savePath(path messenger.Path) (Key, error) {
+	pathDTOs, err := gs.getPathDTOsOfPath(path)
 	if err != nil {
 		return Key{}, err
 	}
-	if pathExists {
-		return gs.GetKeyOfPath(path)
+	if len(pathDTOs) > 1 {
+		return Key{}, ErrMultiplePaths
+	}
+	if len(pathDTOs) == 1 {
+		existing := pathDTOs[0]
+		pathKey, err := NewKeyFromQuadIRI(existing.ID)
+		if err != nil {
+			return Key{}, err
+		}
+		details := make(map[string]string)
+		if path.Identity != "" || len(path.Metadata) > 0 {
+			if path.Identity != existing.Identity {
+				details["pathIdentity"] = path.Identity
+			}
+			if metadata := encodePathMetadata(path.Metadata); metadata != existing.Metadata {
+				details["pathMetadata"] = metadata
+			}
+		}
+		if path.Labels != nil {
+			if labels := encodeLabels(path.Labels); labels != existing.Labels {
+				details["pathLabels"] = labels
+			}
+		}
+		if len(details) == 0 {
+			return pathKey, nil
+		}
+		return pathKey, gs.replacePathDetails(pathKey, details)
 	}
 	pathKey := NewRandomKey()
-	pathDTO := NewPathDTO(pathKey.QuadIRI(), path.Route, path.Type, nil)
+	pathDTO := NewPathDTO(pathKey.QuadIRI(), path, nil)
 	err = gs.writeToGraph(pathDTO)
 	if err != nil {
 		return Key{}, err
@@ -184,13 +561,13 @@ func (gs *GraphStorage) SavePath(path messenger.Path) (Key, error) {
 
 // GetKeyOfPath returns the Key of a given Path if it exists
 func (gs *GraphStorage) GetKeyOfPath(path messenger.Path) (Key, error) {
-	pathDTOList, err := gs.getPathDTOsByRouteAndType(path.Route, path.Type)
+	pathDTOList, err := gs.getPathDTOsOfPath(path)
 	if err != nil {
 		return Key{}, err
 	}
 	switch length := len(pathDTOList); length {
 	case 0:
-		return Key{}, errors.New("Path was not found in graph store")
+		return Key{}, ErrPathNotFound
 	case 1:
 		pathKey, err := NewKeyFromQuadIRI(pathDTOList[0].ID)
 		if err != nil {
@@ -198,7 +575,7 @@ func (gs *GraphStorage) GetKeyOfPath(path messenger.Path) (Key, error) {
 		}
 		return pathKey, nil
 	default:
-		return Key{}, errors.New("There are multiple matching Paths. They are expected to be unique")
+		return Key{}, ErrMultiplePaths
 	}
 }
 
@@ -209,14 +586,79 @@ func (gs *GraphStorage) GetPathByKey(key Key) (messenger.Path, error) {
 	if err != nil {
 		return messenger.Path{}, ErrPathCannotBeRetrieved
 	}
-	return messenger.Path{
-		Route: pathDTO.Route,
-		Type:  pathDTO.Type,
-	}, nil
+	return pathDTO.path()
+}
+
+// DeletePath removes the Path and unchains the Flows it triggers. Paths that Flows send to or wait on cannot be deleted
+func (gs *GraphStorage) DeletePath(key Key) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.deletePath(key)
 }
 
-// ChainNextFlowToPath connects Flows to be triggered by a Path
+// deletePath is DeletePath for callers already holding the write lock
+func (gs *GraphStorage) deletePath(key Key) error {
+	_, err := gs.GetPathByKey(key)
+	if err != nil {
+		return err
+	}
+	p := cayley.StartPath(gs.store, key.QuadValue()).In(quad.IRI("path"), quad.IRI("waitFor"))
+	flowList, err := p.Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return ErrResolvingKey
+	}
+	if len(flowList) > 0 {
+		return ErrPathInUse
+	}
+	return gs.removeNodeFromGraph(key)
+}
+
+// ChainNextFlowToPath connects Flows to be triggered by a Path. Links that would let a message loop back to the Path, or from a namespace the Flow is not shared with, are rejected
 func (gs *GraphStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.chainNextFlowToPath(flowKey, pathKey)
+}
+
+// chainNextFlowToPath is ChainNextFlowToPath for callers already holding the write lock
+func (gs *GraphStorage) chainNextFlowToPath(flowKey Key, pathKey Key) error {
+	flow, err := gs.GetFlowByKey(flowKey)
+	if err != nil {
+		return err
+	}
+	path, err := gs.GetPathByKey(pathKey)
+	if err != nil {
+		return err
+	}
+	if !flow.AllowsNamespace(path.Namespace) {
+		return ErrNamespaceNotShared
+	}
+	createsCycle, err := gs.canFlowReachPath(flowKey, pathKey)
+	if err != nil {
+		return err
+	}
+	if createsCycle {
+		return ErrChainCreatesCycle
+	}
+	err = gs.linkKeyToTriggerKey(pathKey, flowKey)
+	if graph.IsQuadExist(err) {
+		return ErrChainAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+	return gs.bumpChainVersions(flowKey, pathKey)
+}
+
+// UnchainNextFlowFromPath stops the Path from triggering the Flow. The Flow and Path are kept
+func (gs *GraphStorage) UnchainNextFlowFromPath(flowKey Key, pathKey Key) error {
+	gs.writeLock.Lock()
+	defer gs.writeLock.Unlock()
+	return gs.unchainNextFlowFromPath(flowKey, pathKey)
+}
+
+// unchainNextFlowFromPath is UnchainNextFlowFromPath for callers already holding the write lock
+func (gs *GraphStorage) unchainNextFlowFromPath(flowKey Key, pathKey Key) error {
 	_, err := gs.GetFlowByKey(flowKey)
 	if err != nil {
 		return err
@@ -225,11 +667,23 @@ func (gs *GraphStorage) ChainNextFlowToPath(flowKey Key, pathKey Key) error {
 	if err != nil {
 		return err
 	}
-	err = gs.linkKeyToTriggerKey(pathKey, flowKey)
+	err = gs.unlinkKeyFromTriggerKey(pathKey, flowKey)
+	if graph.IsQuadNotExist(err) {
+		return ErrChainNotFound
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	return gs.bumpChainVersions(flowKey, pathKey)
+}
+
+// bumpChainVersions moves both the Flow and Path to a new version, since the Paths triggering the Flow and the Flows the Path triggers have changed
+func (gs *GraphStorage) bumpChainVersions(flowKey Key, pathKey Key) error {
+	err := gs.bumpVersion(flowKey, "flowVersion")
+	if err != nil {
+		return err
+	}
+	return gs.bumpVersion(pathKey, "pathVersion")
 }
 
 // GetNextFlows returns a list of Flows that are triggers by the Flow
@@ -249,28 +703,40 @@ func (gs *GraphStorage) GetNextFlows(key Key) ([]Flow, []Key, error) {
 	return flowList, flowKeyList, nil
 }
 
-func (gs *GraphStorage) doesPathExists(path messenger.Path) (bool, error) {
-	pathDTOList, err := gs.getPathDTOsByRouteAndType(path.Route, path.Type)
+// getPathDTOsOfPath returns the pathDTOs with the namespace, route and type of the Path
+func (gs *GraphStorage) getPathDTOsOfPath(path messenger.Path) ([]pathDTO, error) {
+	p := cayley.StartPath(gs.store, quad.StringToValue(path.Type)).In(quad.IRI("type")).Has(quad.IRI("route"), quad.StringToValue(path.Route))
+	var pathDTOs []pathDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), p.BuildIterator())
 	if err != nil {
-		return false, nil
+		return nil, err
 	}
-	if len(pathDTOList) > 0 {
-		return true, nil
+	var matching []pathDTO
+	for _, pathDTO := range pathDTOs {
+		if pathDTO.Namespace == path.Namespace {
+			matching = append(matching, pathDTO)
+		}
 	}
-	return false, nil
-}
-
-func (gs *GraphStorage) getPathDTOsByRouteAndType(pathRoute string, pathType string) ([]pathDTO, error) {
-	p := cayley.StartPath(gs.store, quad.StringToValue(pathType)).In(quad.IRI("type")).Has(quad.IRI("route"), quad.StringToValue(pathRoute))
-	var pathDTOs []pathDTO
-	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), p.BuildIterator())
-	return pathDTOs, err
+	return matching, nil
 }
 
 func (gs *GraphStorage) linkKeyToTriggerKey(originKey Key, destinationKey Key) error {
 	return gs.store.AddQuad(quad.Make(originKey.QuadValue(), quad.IRI("triggers"), destinationKey.QuadValue(), nil))
 }
 
+func (gs *GraphStorage) unlinkKeyFromTriggerKey(originKey Key, destinationKey Key) error {
+	return gs.store.RemoveQuad(quad.Make(originKey.QuadValue(), quad.IRI("triggers"), destinationKey.QuadValue(), nil))
+}
+
+func (gs *GraphStorage) getKeysTriggeringKey(key Key) ([]Key, error) {
+	p := cayley.StartPath(gs.store, key.QuadValue()).In(quad.IRI("triggers"))
+	keyQuadList, err := p.Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return nil, ErrResolvingKey
+	}
+	return convertQuadValueListToKeyList(keyQuadList)
+}
+
 func (gs *GraphStorage) getKeysTriggeredByKey(key Key) ([]Key, error) {
 	p := cayley.StartPath(gs.store, key.QuadValue()).Out(quad.IRI("triggers"))
 	keyQuadList, err := p.Iterate(nil).AllValues(gs.store)
@@ -284,6 +750,63 @@ func (gs *GraphStorage) getKeysTriggeredByKey(key Key) ([]Key, error) {
 	return keys, nil
 }
 
+// canFlowReachPath returns whether a message sent by the Flow can come back to the Path through the Flows that its Paths trigger
+func (gs *GraphStorage) canFlowReachPath(flowKey Key, pathKey Key) (bool, error) {
+	flowPathKeys, err := gs.getPathKeysOfFlow(flowKey)
+	if err != nil {
+		return false, err
+	}
+	return gs.canPathsOfFlowReachPath(flowKey, flowPathKeys, pathKey)
+}
+
+// canPathsOfFlowReachPath is canFlowReachPath for the Flow sending to or waiting on flowPathKeys instead of the Paths saved for it
+func (gs *GraphStorage) canPathsOfFlowReachPath(flowKey Key, flowPathKeys []Key, pathKey Key) (bool, error) {
+	visited := map[Key]bool{flowKey: true}
+	pathKeys := append([]Key{}, flowPathKeys...)
+	for len(pathKeys) > 0 {
+		currentPathKey := pathKeys[0]
+		pathKeys = pathKeys[1:]
+		if currentPathKey.Equals(pathKey) {
+			return true, nil
+		}
+		if visited[currentPathKey] {
+			continue
+		}
+		visited[currentPathKey] = true
+		patternKeys, err := gs.getPatternPathKeysMatchingPath(currentPathKey)
+		if err != nil {
+			return false, err
+		}
+		pathKeys = append(pathKeys, patternKeys...)
+		triggeredKeys, err := gs.getKeysTriggeredByKey(currentPathKey)
+		if err != nil {
+			return false, err
+		}
+		for _, triggeredKey := range triggeredKeys {
+			if visited[triggeredKey] {
+				continue
+			}
+			visited[triggeredKey] = true
+			nextPathKeys, err := gs.getPathKeysOfFlow(triggeredKey)
+			if err != nil {
+				return false, err
+			}
+			pathKeys = append(pathKeys, nextPathKeys...)
+		}
+	}
+	return false, nil
+}
+
+// getPathKeysOfFlow returns the Paths a Flow sends to or waits on
+func (gs *GraphStorage) getPathKeysOfFlow(key Key) ([]Key, error) {
+	p := cayley.StartPath(gs.store, key.QuadValue()).Out(quad.IRI("path"), quad.IRI("waitFor"))
+	keyQuadList, err := p.Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return nil, ErrResolvingKey
+	}
+	return convertQuadValueListToKeyList(keyQuadList)
+}
+
 func convertQuadValueListToKeyList(quads []quad.Value) ([]Key, error) {
 	var keys []Key
 	for _, v := range quads {
@@ -309,6 +832,61 @@ func (gs *GraphStorage) writeToGraph(dto interface{}) error {
 	return nil
 }
 
+// replaceInGraph swaps the quads describing the node for those of the dto in one transaction. Quads pointing at the node are kept
+func (gs *GraphStorage) replaceInGraph(key Key, dto interface{}) error {
+	tx := cayley.NewTransaction()
+	it := gs.store.QuadIterator(quad.Subject, gs.store.ValueOf(key.QuadValue()))
+	defer it.Close()
+	for it.Next() {
+		tx.RemoveQuad(gs.store.Quad(it.Result()))
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	_, err := schema.WriteAsQuads(transactionWriter{tx}, dto)
+	if err != nil {
+		return err
+	}
+	return gs.store.ApplyTransaction(tx)
+}
+
+// replacePathDetails swaps the stored values of the predicates of the Path, such as its identity, metadata or labels, in one transaction, then moves the Path to a new version.
+// Predicates given an empty value are removed
+func (gs *GraphStorage) replacePathDetails(key Key, details map[string]string) error {
+	tx := cayley.NewTransaction()
+	for predicate, detail := range details {
+		values, err := cayley.StartPath(gs.store, key.QuadValue()).Out(quad.IRI(predicate)).Iterate(nil).AllValues(gs.store)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			tx.RemoveQuad(quad.Make(key.QuadValue(), quad.IRI(predicate), value, nil))
+		}
+		if detail != "" {
+			tx.AddQuad(quad.Make(key.QuadValue(), quad.IRI(predicate), detail, nil))
+		}
+	}
+	err := gs.store.ApplyTransaction(tx)
+	if err != nil {
+		return err
+	}
+	return gs.bumpVersion(key, "pathVersion")
+}
+
+// transactionWriter lets schema write quads into a transaction
+type transactionWriter struct {
+	tx *graph.Transaction
+}
+
+func (tw transactionWriter) WriteQuad(q quad.Quad) error {
+	tw.tx.AddQuad(q)
+	return nil
+}
+
+func (gs *GraphStorage) removeNodeFromGraph(key Key) error {
+	return gs.store.RemoveNode(gs.store.ValueOf(key.QuadValue()))
+}
+
 // Close ends graph database session. Currently, it will delete temporary database file if used
 func (gs *GraphStorage) Close() {
 	gs.store.Close()
@@ -3,7 +3,12 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/cayleygraph/cayley"
 	"github.com/cayleygraph/cayley/quad"
@@ -158,6 +163,75 @@ var _ = Describe("Conduction", func() {
 					Expect(GetPathByKey.Type).To(Equal(path.Type))
 				})
 			})
+			Context("When Flows send to a Path with their own identity and metadata", func() {
+				It("Then each Flow should keep its own and the stored Path should keep its own", func() {
+					path := messenger.Path{
+						Route:    "lights/kitchen",
+						Type:     "MQTT",
+						Identity: "kitchen",
+						Metadata: map[string][]byte{"qos": []byte("1")},
+					}
+					flowKey, err := graph.SaveFlow(Flow{
+						Name:        "Kitchen light",
+						Description: "Turns on the kitchen light",
+						Path:        &path,
+					})
+					Expect(err).To(BeNil())
+					other := messenger.Path{
+						Route:    path.Route,
+						Type:     path.Type,
+						Metadata: map[string][]byte{"qos": []byte("2"), "retain": []byte("true")},
+					}
+					otherKey, err := graph.SaveFlow(Flow{
+						Name:        "Kitchen light at night",
+						Description: "Dims the kitchen light",
+						Path:        &other,
+					})
+					Expect(err).To(BeNil())
+
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(*flow.Path).To(Equal(path))
+					otherFlow, err := graph.GetFlowByKey(otherKey)
+					Expect(err).To(BeNil())
+					Expect(*otherFlow.Path).To(Equal(other))
+					pathKey, err := graph.GetKeyOfPath(path)
+					Expect(err).To(BeNil())
+					stored, err := graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(stored).To(Equal(messenger.Path{Route: path.Route, Type: path.Type}))
+
+					// Updating the Flow without identity or metadata clears them
+					flow.Path = &messenger.Path{Route: path.Route, Type: path.Type}
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					flow, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(*flow.Path).To(Equal(messenger.Path{Route: path.Route, Type: path.Type}))
+					otherFlow, err = graph.GetFlowByKey(otherKey)
+					Expect(err).To(BeNil())
+					Expect(*otherFlow.Path).To(Equal(other))
+
+					// Saving the Path itself with metadata replaces only that of the stored Path
+					replaced := messenger.Path{
+						Route:    path.Route,
+						Type:     path.Type,
+						Metadata: map[string][]byte{"qos": []byte("0")},
+					}
+					_, err = graph.SavePath(replaced)
+					Expect(err).To(BeNil())
+					stored, err = graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(stored).To(Equal(replaced))
+					paths, _, _, err := graph.ListPaths(ListOptions{})
+					Expect(err).To(BeNil())
+					Expect(paths).To(HaveLen(1))
+					Expect(paths[0].Path).To(Equal(replaced))
+					otherFlow, err = graph.GetFlowByKey(otherKey)
+					Expect(err).To(BeNil())
+					Expect(*otherFlow.Path).To(Equal(other))
+				})
+			})
 			Context("When the Path does not already exist", func() {
 				It("Then a new Path should be inserted and id returned", func() {
 					// Path to save
@@ -316,6 +390,522 @@ var _ = Describe("Conduction", func() {
 				})
 			})
 		})
+		Describe("Given a Flow is chained to a Path", func() {
+			var (
+				flowKey Key
+				pathKey Key
+			)
+			BeforeEach(func() {
+				var err error
+				pathKey, err = graph.SavePath(messenger.Path{
+					Route: "/unchain",
+					Type:  "path-trigger",
+				})
+				Expect(err).To(BeNil())
+				flowKey, err = graph.SaveFlow(Flow{
+					Name:        "Flow Name",
+					Description: "Flow Description",
+					Path: &messenger.Path{
+						Route: "/some-route",
+						Type:  "mqtt",
+					},
+				})
+				Expect(err).To(BeNil())
+				err = graph.ChainNextFlowToPath(flowKey, pathKey)
+				Expect(err).To(BeNil())
+			})
+			Context("When the Flow is chained again", func() {
+				It("Then an error will be returned", func() {
+					err := graph.ChainNextFlowToPath(flowKey, pathKey)
+					Expect(err).To(Equal(ErrChainAlreadyExists))
+				})
+			})
+			Context("When the Flow is unchained", func() {
+				It("Then the Path will no longer trigger the Flow but both will remain", func() {
+					err := graph.UnchainNextFlowFromPath(flowKey, pathKey)
+					Expect(err).To(BeNil())
+
+					flows, _, err := graph.GetNextFlows(pathKey)
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(0))
+					_, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					_, err = graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+
+					err = graph.UnchainNextFlowFromPath(flowKey, pathKey)
+					Expect(err).To(Equal(ErrChainNotFound))
+				})
+			})
+			Context("When either id does not exists", func() {
+				It("Then an error will be thrown", func() {
+					err := graph.UnchainNextFlowFromPath(NewRandomKey(), pathKey)
+					Expect(err).To(Equal(ErrFlowCannotBeRetrieved))
+					err = graph.UnchainNextFlowFromPath(flowKey, NewRandomKey())
+					Expect(err).To(Equal(ErrPathCannotBeRetrieved))
+				})
+			})
+		})
+		Describe("Given updating and deleting a chained Flow", func() {
+			var (
+				flowKey    Key
+				triggerKey Key
+			)
+			BeforeEach(func() {
+				var err error
+				triggerKey, err = graph.SavePath(messenger.Path{
+					Route: "/update",
+					Type:  "path-trigger",
+				})
+				Expect(err).To(BeNil())
+				flowKey, err = graph.SaveFlow(Flow{
+					Name:        "Flow Name",
+					Description: "Flow Description",
+					Path: &messenger.Path{
+						Route: "/some-route",
+						Type:  "mqtt",
+					},
+				})
+				Expect(err).To(BeNil())
+				err = graph.ChainNextFlowToPath(flowKey, triggerKey)
+				Expect(err).To(BeNil())
+			})
+			Context("When the Flow is updated", func() {
+				It("Then every field should be replaced and the Flow should still be triggered by the Path", func() {
+					err := graph.UpdateFlow(flowKey, Flow{
+						Name:        "New Name",
+						Description: "Fixed description",
+						Path: &messenger.Path{
+							Route: "/other-route",
+							Type:  "mqtt",
+						},
+						Condition: "payload.on == true",
+					})
+					Expect(err).To(BeNil())
+
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Name).To(Equal("New Name"))
+					Expect(flow.Description).To(Equal("Fixed description"))
+					Expect(flow.Path.Route).To(Equal("/other-route"))
+					Expect(flow.Condition).To(Equal("payload.on == true"))
+					flows, keys, err := graph.GetNextFlows(triggerKey)
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+					Expect(keys[0]).To(Equal(flowKey))
+				})
+			})
+			Context("When the Flow is updated with a version", func() {
+				It("Then the version should be checked and go up by one", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Version).To(Equal(int64(2))) // Saved, then chained
+					path, err := graph.GetStoredPathByKey(triggerKey)
+					Expect(err).To(BeNil())
+					Expect(path.Version).To(Equal(int64(2)))
+
+					flow.Description = "Fixed description"
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(Equal(ErrVersionConflict))
+
+					updated, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(updated.Version).To(Equal(int64(3)))
+					Expect(updated.Description).To(Equal("Fixed description"))
+				})
+			})
+			Context("When the Flow is updated at the same version by several callers at once", func() {
+				It("Then only one update should succeed", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					results := make(chan error)
+					for i := 0; i < 5; i++ {
+						go func(description string) {
+							update := flow
+							update.Description = description
+							results <- graph.UpdateFlow(flowKey, update)
+						}(fmt.Sprintf("Description %d", i))
+					}
+					succeeded := 0
+					for i := 0; i < 5; i++ {
+						if err := <-results; err == nil {
+							succeeded++
+						} else {
+							Expect(err).To(Equal(ErrVersionConflict))
+						}
+					}
+					Expect(succeeded).To(Equal(1))
+					updated, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(updated.Version).To(Equal(flow.Version + 1))
+				})
+			})
+			Context("When the Flow is updated without changing its Path", func() {
+				It("Then the version of the Path should stay the same", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flow.Path.Metadata = map[string][]byte{"qos": []byte("1")}
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					pathKey, err := graph.GetKeyOfPath(*flow.Path)
+					Expect(err).To(BeNil())
+					path, err := graph.GetStoredPathByKey(pathKey)
+					Expect(err).To(BeNil())
+
+					flow, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flow.Description = "Fixed description"
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					unchanged, err := graph.GetStoredPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(unchanged.Version).To(Equal(path.Version))
+				})
+			})
+			Context("When the update would send messages back to a Path that triggers the Flow", func() {
+				It("Then an error will be returned and the Flow left as it was", func() {
+					err := graph.UpdateFlow(flowKey, Flow{
+						Name:        "Flow Name",
+						Description: "Flow Description",
+						Path: &messenger.Path{
+							Route: "/update",
+							Type:  "path-trigger",
+						},
+					})
+					Expect(err).To(Equal(ErrChainCreatesCycle))
+
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Path.Route).To(Equal("/some-route"))
+				})
+			})
+			Context("When the Flow does not exist", func() {
+				It("Then an error will be returned", func() {
+					err := graph.UpdateFlow(NewRandomKey(), Flow{Path: &messenger.Path{Route: "/some-route", Type: "mqtt"}})
+					Expect(err).To(Equal(ErrFlowCannotBeRetrieved))
+					err = graph.DeleteFlow(NewRandomKey())
+					Expect(err).To(Equal(ErrFlowCannotBeRetrieved))
+				})
+			})
+			Context("When the Flow is deleted", func() {
+				It("Then the Flow should be removed and unchained from the Path", func() {
+					err := graph.DeleteFlow(flowKey)
+					Expect(err).To(BeNil())
+
+					_, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(Equal(ErrFlowCannotBeRetrieved))
+					flows, _, err := graph.GetNextFlows(triggerKey)
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(0))
+				})
+			})
+			Context("When a Continuation is waiting to run the Flow", func() {
+				It("Then the Flow cannot be deleted", func() {
+					_, err := graph.SaveContinuation(Continuation{
+						Identity: NewRandomKey().String(),
+						Flows:    []Flow{{UUID: flowKey.UUID}},
+						Awaits:   []Await{{Path: &messenger.Path{Route: "lightOn", Type: "mqtt"}}},
+					})
+					Expect(err).To(BeNil())
+
+					err = graph.DeleteFlow(flowKey)
+					Expect(err).To(Equal(ErrFlowHasContinuations))
+				})
+			})
+			Context("When the Path is deleted", func() {
+				It("Then Paths that Flows send to are kept and others removed with their chains", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flowPathKey, err := graph.GetKeyOfPath(*flow.Path)
+					Expect(err).To(BeNil())
+					err = graph.DeletePath(flowPathKey)
+					Expect(err).To(Equal(ErrPathInUse))
+
+					err = graph.DeletePath(triggerKey)
+					Expect(err).To(BeNil())
+					_, err = graph.GetPathByKey(triggerKey)
+					Expect(err).To(Equal(ErrPathCannotBeRetrieved))
+					_, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					err = graph.DeletePath(triggerKey)
+					Expect(err).To(Equal(ErrPathCannotBeRetrieved))
+				})
+			})
+		})
+		Describe("Given listing Flows and Paths", func() {
+			BeforeEach(func() {
+				for _, flow := range []Flow{
+					{Name: "Kitchen light", Path: &messenger.Path{Route: "home/kitchen/light", Type: "mqtt"}},
+					{Name: "Bedroom light", Path: &messenger.Path{Route: "home/bedroom/light", Type: "mqtt"}},
+					{Name: "Fan", Path: &messenger.Path{Route: "home/kitchen/fan", Type: "mqtt"}},
+					{Name: "Weather", Path: &messenger.Path{Route: "GET_/weather", Type: "rest"}},
+				} {
+					flow.Description = "Flow Description"
+					_, err := graph.SaveFlow(flow)
+					Expect(err).To(BeNil())
+				}
+			})
+			Context("When Flows are filtered", func() {
+				It("Then only Flows matching every filter should be returned sorted by name", func() {
+					flows, keys, next, err := graph.ListFlows(ListOptions{Type: "mqtt", RoutePrefix: "home/", Name: "LIGHT"})
+					Expect(err).To(BeNil())
+					Expect(next).To(BeEmpty())
+					Expect(keys).To(HaveLen(2))
+					Expect(flows).To(HaveLen(2))
+					Expect(flows[0].Name).To(Equal("Bedroom light"))
+					Expect(flows[1].Name).To(Equal("Kitchen light"))
+				})
+			})
+			Context("When Flows are paged", func() {
+				It("Then each page should continue where the last ended", func() {
+					var names []string
+					options := ListOptions{SortBy: SortByRoute, Descending: true, Limit: 3}
+					for {
+						flows, _, next, err := graph.ListFlows(options)
+						Expect(err).To(BeNil())
+						Expect(len(flows)).To(BeNumerically("<=", 3))
+						for _, flow := range flows {
+							names = append(names, flow.Name)
+						}
+						if next == "" {
+							break
+						}
+						options.Cursor = next
+					}
+					Expect(names).To(Equal([]string{"Kitchen light", "Fan", "Bedroom light", "Weather"}))
+				})
+			})
+			Context("When Paths are listed", func() {
+				It("Then Paths should be filtered and sorted by route", func() {
+					paths, keys, _, err := graph.ListPaths(ListOptions{RoutePrefix: "home/kitchen/"})
+					Expect(err).To(BeNil())
+					Expect(paths).To(HaveLen(2))
+					Expect(paths[0].Route).To(Equal("home/kitchen/fan"))
+					Expect(paths[1].Route).To(Equal("home/kitchen/light"))
+					path, err := graph.GetPathByKey(keys[0])
+					Expect(err).To(BeNil())
+					Expect(path.Route).To(Equal("home/kitchen/fan"))
+				})
+			})
+			Context("When the options are invalid", func() {
+				It("Then an error should be returned", func() {
+					_, _, _, err := graph.ListPaths(ListOptions{SortBy: SortByName})
+					Expect(err).To(Equal(ErrInvalidSortField))
+					_, _, _, err = graph.ListFlows(ListOptions{Cursor: "not-a-cursor"})
+					Expect(err).To(Equal(ErrInvalidCursor))
+					_, _, _, err = graph.ListFlows(ListOptions{Limit: MaxListLimit + 1})
+					Expect(err).To(Equal(ErrInvalidLimit))
+				})
+			})
+		})
+		Describe("Given Flows chained through exact and pattern Paths", func() {
+			var (
+				inKey      Key
+				patternKey Key
+				logKey     Key
+				lightFlow  Key
+				logFlow    Key
+			)
+			BeforeEach(func() {
+				var err error
+				inKey, err = graph.SavePath(messenger.Path{Route: "/in", Type: "rest"})
+				Expect(err).To(BeNil())
+				patternKey, err = graph.SavePath(messenger.Path{Route: "lights/+", Type: "mqtt"})
+				Expect(err).To(BeNil())
+				lightFlow, err = graph.SaveFlow(Flow{
+					Name:        "Light",
+					Description: "Flow Description",
+					Path:        &messenger.Path{Route: "lights/kitchen", Type: "mqtt"},
+				})
+				Expect(err).To(BeNil())
+				logFlow, err = graph.SaveFlow(Flow{
+					Name:        "Log",
+					Description: "Flow Description",
+					Path:        &messenger.Path{Route: "/log", Type: "rest"},
+				})
+				Expect(err).To(BeNil())
+				logKey, err = graph.GetKeyOfPath(messenger.Path{Route: "/log", Type: "rest"})
+				Expect(err).To(BeNil())
+				Expect(graph.ChainNextFlowToPath(lightFlow, inKey)).To(BeNil())
+				Expect(graph.ChainNextFlowToPath(logFlow, patternKey)).To(BeNil())
+			})
+			Context("When traversing downstream", func() {
+				It("Then every Flow and Path a message leads to should be returned with its depth", func() {
+					traversal, err := graph.GetDownstream(inKey, 0)
+					Expect(err).To(BeNil())
+					Expect(traversal.Truncated).To(BeFalse())
+					Expect(traversal.Flows).To(HaveLen(2))
+					Expect(traversal.Flows[0].UUID).To(Equal(lightFlow.UUID))
+					Expect(traversal.Flows[0].Depth).To(Equal(1))
+					Expect(traversal.Flows[1].UUID).To(Equal(logFlow.UUID))
+					Expect(traversal.Flows[1].Depth).To(Equal(2))
+					Expect(traversal.Paths).To(HaveLen(2))
+					Expect(traversal.Paths[0].Route).To(Equal("lights/kitchen"))
+					Expect(traversal.Paths[1].Key).To(Equal(logKey))
+					Expect(traversal.Paths[1].Depth).To(Equal(2))
+				})
+			})
+			Context("When the depth limit is reached", func() {
+				It("Then the traversal should stop and be marked truncated", func() {
+					traversal, err := graph.GetDownstream(inKey, 1)
+					Expect(err).To(BeNil())
+					Expect(traversal.Truncated).To(BeTrue())
+					Expect(traversal.Flows).To(HaveLen(1))
+					Expect(traversal.Paths).To(HaveLen(1))
+				})
+			})
+			Context("When traversing upstream", func() {
+				It("Then every Flow and Path that can lead to a message should be returned", func() {
+					traversal, err := graph.GetUpstream(logKey, 0)
+					Expect(err).To(BeNil())
+					Expect(traversal.Flows).To(HaveLen(2))
+					Expect(traversal.Flows[0].UUID).To(Equal(logFlow.UUID))
+					Expect(traversal.Flows[1].UUID).To(Equal(lightFlow.UUID))
+					Expect(traversal.Flows[1].Depth).To(Equal(2))
+					Expect(traversal.Paths).To(HaveLen(2))
+					Expect(traversal.Paths[0].Key).To(Equal(patternKey))
+					Expect(traversal.Paths[1].Key).To(Equal(inKey))
+				})
+			})
+			Context("When the graph is exported", func() {
+				It("Then every Path and Flow should be a node with edges for how messages move", func() {
+					exported, err := graph.GetGraph()
+					Expect(err).To(BeNil())
+					Expect(exported.Nodes).To(HaveLen(6))
+					Expect(exported.Nodes[0].Kind).To(Equal(NodeKindPath))
+					Expect(exported.Nodes[5].Kind).To(Equal(NodeKindFlow))
+					kitchenKey, err := graph.GetKeyOfPath(messenger.Path{Route: "lights/kitchen", Type: "mqtt"})
+					Expect(err).To(BeNil())
+					Expect(exported.Edges).To(ConsistOf(
+						GraphEdge{From: inKey.String(), To: lightFlow.String(), Kind: EdgeKindTriggers},
+						GraphEdge{From: lightFlow.String(), To: kitchenKey.String(), Kind: EdgeKindSendsTo},
+						GraphEdge{From: kitchenKey.String(), To: patternKey.String(), Kind: EdgeKindMatches},
+						GraphEdge{From: patternKey.String(), To: logFlow.String(), Kind: EdgeKindTriggers},
+						GraphEdge{From: logFlow.String(), To: logKey.String(), Kind: EdgeKindSendsTo},
+					))
+				})
+			})
+			Context("When the graph around a Path is exported", func() {
+				It("Then only the nodes within the depth and the edges between them should be included", func() {
+					_, err := graph.SaveFlow(Flow{
+						Name:        "Unrelated",
+						Description: "Flow Description",
+						Path:        &messenger.Path{Route: "/elsewhere", Type: "rest"},
+					})
+					Expect(err).To(BeNil())
+
+					exported, err := graph.GetGraphAroundPath(inKey, 1)
+					Expect(err).To(BeNil())
+					Expect(exported.Nodes).To(HaveLen(3))
+					Expect(exported.Edges).To(HaveLen(2))
+					for _, node := range exported.Nodes {
+						Expect(node.Label).ToNot(Equal("Unrelated"))
+					}
+				})
+			})
+			Context("When the Path does not exist or the depth is invalid", func() {
+				It("Then an error should be returned", func() {
+					_, err := graph.GetDownstream(NewRandomKey(), 0)
+					Expect(err).To(Equal(ErrPathCannotBeRetrieved))
+					_, err = graph.GetUpstream(inKey, MaxTraversalDepth+1)
+					Expect(err).To(Equal(ErrInvalidDepth))
+				})
+			})
+		})
+		Describe("Given chaining a Flow to a Path could create a loop", func() {
+			var (
+				pathKey1 Key
+				pathKey2 Key
+				path1    = messenger.Path{Route: "/loop/1", Type: "mqtt"}
+				path2    = messenger.Path{Route: "/loop/2", Type: "mqtt"}
+			)
+			BeforeEach(func() {
+				var err error
+				pathKey1, err = graph.SavePath(path1)
+				Expect(err).To(BeNil())
+				pathKey2, err = graph.SavePath(path2)
+				Expect(err).To(BeNil())
+			})
+			Context("When the Flow sends to the Path that triggers it", func() {
+				It("Then an error should be returned and the Flow not chained", func() {
+					flowKey, err := graph.SaveFlow(Flow{Name: "Self", Description: "Flow Description", Path: &path1})
+					Expect(err).To(BeNil())
+
+					err = graph.ChainNextFlowToPath(flowKey, pathKey1)
+					Expect(err).To(Equal(ErrChainCreatesCycle))
+					flows, _, err := graph.GetNextFlows(pathKey1)
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(0))
+				})
+			})
+			Context("When the Flow sends to a Path that leads back to the triggering Path", func() {
+				It("Then an error should be returned", func() {
+					flowKey1, err := graph.SaveFlow(Flow{Name: "To 2", Description: "Flow Description", Path: &path2})
+					Expect(err).To(BeNil())
+					err = graph.ChainNextFlowToPath(flowKey1, pathKey1)
+					Expect(err).To(BeNil())
+
+					flowKey2, err := graph.SaveFlow(Flow{Name: "To 1", Description: "Flow Description", Path: &path1})
+					Expect(err).To(BeNil())
+					err = graph.ChainNextFlowToPath(flowKey2, pathKey2)
+					Expect(err).To(Equal(ErrChainCreatesCycle))
+				})
+			})
+			Context("When the Flow sends to a Path that does not lead back", func() {
+				It("Then the Flow should be chained", func() {
+					flowKey1, err := graph.SaveFlow(Flow{Name: "To 2", Description: "Flow Description", Path: &path2})
+					Expect(err).To(BeNil())
+					err = graph.ChainNextFlowToPath(flowKey1, pathKey1)
+					Expect(err).To(BeNil())
+
+					flowKey2, err := graph.SaveFlow(Flow{Name: "To 3", Description: "Flow Description", Path: &messenger.Path{Route: "/loop/3", Type: "mqtt"}})
+					Expect(err).To(BeNil())
+					err = graph.ChainNextFlowToPath(flowKey2, pathKey2)
+					Expect(err).To(BeNil())
+				})
+			})
+		})
+		Describe("Given saving pattern Paths", func() {
+			BeforeEach(func() {
+				for _, route := range []string{"sensors/#", "sensors/+/temp", "sensors/kitchen/temp"} {
+					_, err := graph.SavePath(messenger.Path{Route: route, Type: "mqtt"})
+					Expect(err).To(BeNil())
+				}
+				_, err := graph.SavePath(messenger.Path{Route: "GET_/users/{id}", Type: "REST"})
+				Expect(err).To(BeNil())
+			})
+			Context("When the pattern Paths of a type are retrieved", func() {
+				It("Then only the patterns of that type should be returned most specific first", func() {
+					paths, keys, err := graph.GetPatternPaths("mqtt")
+					Expect(err).To(BeNil())
+					Expect(paths).To(Equal([]messenger.Path{
+						{Route: "sensors/+/temp", Type: "mqtt"},
+						{Route: "sensors/#", Type: "mqtt"},
+					}))
+					Expect(keys).To(HaveLen(2))
+					key, err := graph.GetKeyOfPath(paths[0])
+					Expect(err).To(BeNil())
+					Expect(keys[0]).To(Equal(key))
+				})
+			})
+			Context("When a Flow sends to a Path matching a pattern that triggers it", func() {
+				It("Then chaining should be rejected as a loop", func() {
+					patternKey, err := graph.GetKeyOfPath(messenger.Path{Route: "sensors/+/temp", Type: "mqtt"})
+					Expect(err).To(BeNil())
+					flowKey, err := graph.SaveFlow(Flow{
+						Name:        "To kitchen",
+						Description: "Flow Description",
+						Path:        &messenger.Path{Route: "sensors/kitchen/temp", Type: "mqtt"},
+					})
+					Expect(err).To(BeNil())
+
+					err = graph.ChainNextFlowToPath(flowKey, patternKey)
+					Expect(err).To(Equal(ErrChainCreatesCycle))
+				})
+			})
+		})
 		Describe("Given a Path triggers Flows", func() {
 			Context("When a Path UUID is given", func() {
 				It("Then a list of Flows should be returned", func() {
@@ -356,12 +946,776 @@ var _ = Describe("Conduction", func() {
 					flows, keys, err := graph.GetNextFlows(pathTriggerKey)
 					Expect(err).To(BeNil())
 					Expect(len(flows)).To(Equal(2))
-					Expect(flows[0]).To(Equal(flow))
-					Expect(flows[1]).To(Equal(flow))
 					Expect(keys).To(ContainElement(flowKey1))
 					Expect(keys).To(ContainElement(flowKey2))
+					for i := range flows {
+						Expect(flows[i].UUID).To(Equal(keys[i].UUID))
+						Expect(flows[i].TriggeredBy).To(Equal([]uuid.UUID{pathTriggerKey.UUID}))
+						Expect(flows[i].Version).To(Equal(int64(2)))
+						flows[i].UUID = uuid.UUID{}
+						flows[i].TriggeredBy = nil
+						flows[i].Version = 0
+						Expect(flows[i]).To(Equal(flow))
+					}
+
+					// Path lists the Flows it triggers
+					storedPath, err := graph.GetStoredPathByKey(pathTriggerKey)
+					Expect(err).To(BeNil())
+					Expect(storedPath.UUID).To(Equal(pathTriggerKey.UUID))
+					Expect(storedPath.Path).To(Equal(pathTrigger))
+					Expect(storedPath.Triggers).To(ConsistOf(flowKey1.UUID, flowKey2.UUID))
+				})
+			})
+		})
+		Describe("Given saving a Flow that waits for a response", func() {
+			Context("When the Flow has a waitFor Path", func() {
+				It("Then the Flow should be returned with wait and waitFor", func() {
+					flow := Flow{
+						Name:        "Flow Name",
+						Description: "Flow Description",
+						Path: &messenger.Path{
+							Route: "turnOnLight",
+							Type:  "mqtt",
+						},
+						Wait: true,
+						WaitFor: &messenger.Path{
+							Route: "lightOn",
+							Type:  "mqtt",
+						},
+					}
+					key, err := graph.SaveFlow(flow)
+					Expect(err).To(BeNil())
+
+					newFlow, err := graph.GetFlowByKey(key)
+					Expect(err).To(BeNil())
+					Expect(newFlow.Wait).To(BeTrue())
+					Expect(newFlow.WaitFor).ToNot(BeNil())
+					Expect(newFlow.WaitFor.Route).To(Equal(flow.WaitFor.Route))
+					Expect(newFlow.WaitFor.Type).To(Equal(flow.WaitFor.Type))
+				})
+			})
+		})
+		Describe("Given saving a Flow with a condition and transform", func() {
+			Context("When the Flow is retrieved", func() {
+				It("Then the condition and transform should be returned", func() {
+					flow := Flow{
+						Name:        "Flow Name",
+						Description: "Flow Description",
+						Path: &messenger.Path{
+							Route: "fan",
+							Type:  "mqtt",
+						},
+						Condition: `payload.temperature > 30`,
+						Transform: `{"speed": "{{payload.temperature}}"}`,
+					}
+					key, err := graph.SaveFlow(flow)
+					Expect(err).To(BeNil())
+
+					newFlow, err := graph.GetFlowByKey(key)
+					Expect(err).To(BeNil())
+					Expect(newFlow.Condition).To(Equal(flow.Condition))
+					Expect(newFlow.Transform).To(Equal(flow.Transform))
+				})
+			})
+		})
+		Describe("Given a Continuation waiting for a response", func() {
+			var (
+				continuationKey Key
+				flowKey         Key
+				awaitPath       = messenger.Path{
+					Route: "lightOn",
+					Type:  "mqtt",
+				}
+				identity = NewRandomKey().String()
+			)
+			BeforeEach(func() {
+				var err error
+				flowKey, err = graph.SaveFlow(Flow{
+					Name:        "Remaining Flow",
+					Description: "Flow Description",
+					Path: &messenger.Path{
+						Route: "/return",
+						Type:  "rest",
+					},
+				})
+				Expect(err).To(BeNil())
+				continuationKey, err = graph.SaveContinuation(Continuation{
+					Identity: identity,
+					Flows:    []Flow{{UUID: flowKey.UUID}},
+					Awaits: []Await{{
+						Name: "Waiting Flow",
+						Path: &awaitPath,
+					}},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When the Continuation is retrieved", func() {
+				It("Then the Flows and Awaits should be returned", func() {
+					continuation, err := graph.GetContinuationByKey(continuationKey)
+					Expect(err).To(BeNil())
+					Expect(continuation.Identity).To(Equal(identity))
+					Expect(continuation.Flows).To(HaveLen(1))
+					Expect(continuation.Flows[0].UUID).To(Equal(flowKey.UUID))
+					Expect(continuation.Flows[0].Path.Route).To(Equal("/return"))
+					Expect(continuation.Awaits).To(HaveLen(1))
+					Expect(continuation.Awaits[0].Name).To(Equal("Waiting Flow"))
+					Expect(continuation.Awaits[0].Path.Route).To(Equal(awaitPath.Route))
+					Expect(continuation.Awaits[0].Received).To(BeFalse())
+					Expect(continuation.IsComplete()).To(BeFalse())
+				})
+			})
+			Context("When looking up Awaits by Path", func() {
+				It("Then Awaits should match on route, type and identity", func() {
+					keys, err := graph.GetKeysOfAwaits(awaitPath)
+					Expect(err).To(Equal(ErrAwaitIdentityMissing))
+					Expect(keys).To(BeEmpty())
+
+					withIdentity := awaitPath
+					withIdentity.Identity = identity
+					keys, err = graph.GetKeysOfAwaits(withIdentity)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(1))
+
+					withOtherIdentity := awaitPath
+					withOtherIdentity.Identity = "other"
+					keys, err = graph.GetKeysOfAwaits(withOtherIdentity)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(0))
+
+					keys, err = graph.GetKeysOfAwaits(messenger.Path{Route: "lightOn", Type: "rest"})
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(0))
+				})
+			})
+			Context("When the Await receives its response", func() {
+				It("Then the Continuation should be complete with the payload and the Await no longer returned", func() {
+					response := awaitPath
+					response.Identity = identity
+					keys, err := graph.GetKeysOfAwaits(response)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(1))
+
+					continuation, key, err := graph.ResolveAwait(keys[0], []byte("DONE"))
+					Expect(err).To(BeNil())
+					Expect(key).To(Equal(continuationKey))
+					Expect(continuation.IsComplete()).To(BeTrue())
+					Expect(continuation.Payload()).To(Equal([]byte("DONE")))
+
+					_, _, err = graph.ResolveAwait(keys[0], []byte("DONE"))
+					Expect(err).To(Equal(ErrAwaitAlreadyReceived))
+
+					keys, err = graph.GetKeysOfAwaits(response)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(0))
+					keys, err = graph.GetKeysOfAwaits(awaitPath)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(0))
+				})
+			})
+			Context("When the Continuation has a return Path", func() {
+				It("Then the return Path should be returned with its metadata", func() {
+					returnPath := &messenger.Path{
+						Type: "rest",
+						Metadata: map[string][]byte{
+							"controllerID": []byte("1"),
+						},
+					}
+					key, err := graph.SaveContinuation(Continuation{
+						Identity: identity,
+						Awaits:   []Await{{Path: &awaitPath}},
+						Return:   returnPath,
+					})
+					Expect(err).To(BeNil())
+
+					continuation, err := graph.GetContinuationByKey(key)
+					Expect(err).To(BeNil())
+					Expect(continuation.Flows).To(HaveLen(0))
+					Expect(*continuation.Return).To(Equal(*returnPath))
+				})
+			})
+			Context("When the Continuation has the origin and metadata of the message that started the chain", func() {
+				It("Then they should be returned as they were saved", func() {
+					origin := &messenger.Path{Route: "/commute", Type: "rest", Identity: "commuter"}
+					metadata := map[string][]byte{"conductionHops": []byte("2")}
+					key, err := graph.SaveContinuation(Continuation{
+						Identity: identity,
+						Awaits:   []Await{{Path: &awaitPath}},
+						Origin:   origin,
+						Metadata: metadata,
+					})
+					Expect(err).To(BeNil())
+
+					continuation, err := graph.GetContinuationByKey(key)
+					Expect(err).To(BeNil())
+					Expect(*continuation.Origin).To(Equal(*origin))
+					Expect(continuation.Metadata).To(Equal(metadata))
+				})
+			})
+			Context("When the Continuation aggregates and has a deadline", func() {
+				It("Then the responses should be merged and the Continuation taken once the deadline passes", func() {
+					deadline := time.Now().Add(time.Minute)
+					key, err := graph.SaveContinuation(Continuation{
+						Identity:  identity,
+						Flows:     []Flow{{UUID: flowKey.UUID}},
+						Awaits:    []Await{{Name: "weather", Path: &awaitPath}, {Name: "traffic", Path: &awaitPath}},
+						Aggregate: true,
+						Deadline:  deadline,
+					})
+					Expect(err).To(BeNil())
+
+					continuation, err := graph.GetContinuationByKey(key)
+					Expect(err).To(BeNil())
+					Expect(continuation.Aggregate).To(BeTrue())
+					Expect(continuation.Deadline.Equal(deadline)).To(BeTrue())
+
+					expired, err := graph.TakeExpiredContinuations(deadline.Add(-time.Second))
+					Expect(err).To(BeNil())
+					Expect(expired).To(HaveLen(0))
+
+					var weatherKey Key
+					for _, await := range continuation.Awaits {
+						if await.Name == "weather" {
+							weatherKey = Key{UUID: await.UUID}
+						}
+					}
+					continuation, _, err = graph.ResolveAwait(weatherKey, []byte(`{"rain":true}`))
+					Expect(err).To(BeNil())
+					Expect(continuation.Payload()).To(MatchJSON(`{"weather": {"rain": true}, "traffic": null}`))
+
+					expired, err = graph.TakeExpiredContinuations(deadline)
+					Expect(err).To(BeNil())
+					Expect(expired).To(HaveLen(1))
+					Expect(expired[0].UUID).To(Equal(key.UUID))
+					Expect(expired[0].Missing()).To(Equal([]string{"traffic"}))
+					Expect(expired[0].Flows[0].Path.Route).To(Equal("/return"))
+
+					_, err = graph.GetContinuationByKey(key)
+					Expect(err).To(Equal(ErrContinuationCannotBeRetrieved))
+					expired, err = graph.TakeExpiredContinuations(deadline)
+					Expect(err).To(BeNil())
+					Expect(expired).To(HaveLen(0))
+				})
+			})
+			Context("When the Continuation is deleted", func() {
+				It("Then the Continuation and Awaits should be removed but not the Flows", func() {
+					err := graph.DeleteContinuation(continuationKey)
+					Expect(err).To(BeNil())
+
+					_, err = graph.GetContinuationByKey(continuationKey)
+					Expect(err).To(Equal(ErrContinuationCannotBeRetrieved))
+					keys, err := graph.GetKeysOfAwaits(awaitPath)
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(0))
+					_, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+				})
+			})
+		})
+		Describe("Given changes made through an AuditedStorage", func() {
+			var (
+				audited *AuditedStorage
+				flowKey Key
+				pathKey Key
+			)
+			BeforeEach(func() {
+				var err error
+				audited = NewAuditedStorage(graph, "alice")
+				pathKey, err = audited.SavePath(messenger.Path{
+					Route: "/audit",
+					Type:  "path-trigger",
+				})
+				Expect(err).To(BeNil())
+				flowKey, err = audited.SaveFlow(Flow{
+					Name:        "Flow Name",
+					Description: "First description",
+					Path: &messenger.Path{
+						Route: "/some-route",
+						Type:  "mqtt",
+					},
+				})
+				Expect(err).To(BeNil())
+				err = audited.ChainNextFlowToPath(flowKey, pathKey)
+				Expect(err).To(BeNil())
+				flow, err := audited.GetFlowByKey(flowKey)
+				Expect(err).To(BeNil())
+				flow.Description = "Second description"
+				err = audited.UpdateFlow(flowKey, flow)
+				Expect(err).To(BeNil())
+			})
+			Context("When the audit log is listed", func() {
+				It("Then every change should be returned newest first with who made it and snapshots", func() {
+					entries, next, err := graph.ListAuditEntries(AuditOptions{})
+					Expect(err).To(BeNil())
+					Expect(next).To(BeEmpty())
+					Expect(entries).To(HaveLen(6))
+					var actions []string
+					for _, entry := range entries {
+						Expect(entry.Actor).To(Equal("alice"))
+						actions = append(actions, entry.Action)
+					}
+					Expect(actions).To(Equal([]string{AuditActionUpdate, AuditActionLink, AuditActionLink, AuditActionCreate, AuditActionCreate, AuditActionCreate}))
+					Expect(entries[1].Kind).To(Equal(AuditKindFlow))
+					Expect(entries[1].Target).To(Equal(flowKey.UUID))
+					Expect(entries[2].Kind).To(Equal(AuditKindPath))
+					Expect(entries[2].Target).To(Equal(pathKey.UUID))
+					Expect(entries[2].Flow).To(Equal(flowKey.UUID))
+					Expect(entries[3].Kind).To(Equal(AuditKindFlow))
+					Expect(entries[4].Kind).To(Equal(AuditKindPath)) // Saving the Flow made its Path
+					Expect(entries[4].Before).To(BeEmpty())
+					Expect(string(entries[4].After)).To(ContainSubstring("/some-route"))
+
+					var before, after Flow
+					Expect(json.Unmarshal(entries[0].Before, &before)).To(BeNil())
+					Expect(json.Unmarshal(entries[0].After, &after)).To(BeNil())
+					Expect(before.Description).To(Equal("First description"))
+					Expect(after.Description).To(Equal("Second description"))
+					Expect(after.Version).To(Equal(before.Version + 1))
+				})
+			})
+			Context("When the audit log is filtered and paged", func() {
+				It("Then only matching entries should be returned a page at a time", func() {
+					entries, _, err := graph.ListAuditEntries(AuditOptions{Kind: AuditKindFlow, Target: flowKey.UUID})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(3))
+					entries, _, err = graph.ListAuditEntries(AuditOptions{Target: flowKey.UUID})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(4)) // Links recorded against the Path name the Flow too
+					entries, _, err = graph.ListAuditEntries(AuditOptions{Actor: "bob"})
+					Expect(err).To(BeNil())
+					Expect(entries).To(BeEmpty())
+					entries, _, err = graph.ListAuditEntries(AuditOptions{Until: time.Now().Add(-time.Hour)})
+					Expect(err).To(BeNil())
+					Expect(entries).To(BeEmpty())
+					entries, _, err = graph.ListAuditEntries(AuditOptions{Since: time.Now().Add(-time.Hour), Kind: AuditKindPath})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(3))
+
+					first, next, err := graph.ListAuditEntries(AuditOptions{Limit: 3})
+					Expect(err).To(BeNil())
+					Expect(first).To(HaveLen(3))
+					Expect(next).ToNot(BeEmpty())
+					rest, next, err := graph.ListAuditEntries(AuditOptions{Limit: 3, Cursor: next})
+					Expect(err).To(BeNil())
+					Expect(rest).To(HaveLen(3))
+					Expect(next).To(BeEmpty())
+					Expect(rest[2].Action).To(Equal(AuditActionCreate))
+					Expect(rest[2].Kind).To(Equal(AuditKindPath))
+				})
+			})
+			Context("When the Flow is rolled back to an earlier version", func() {
+				It("Then the Flow should be as it was at that version with a new version", func() {
+					flow, err := audited.RollbackFlow(flowKey, 1, 3)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("First description"))
+					Expect(flow.Version).To(Equal(int64(4)))
+					flows, _, err := graph.GetNextFlows(pathKey)
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+
+					entries, _, err := graph.ListAuditEntries(AuditOptions{Action: AuditActionRollback})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(1))
+					Expect(entries[0].Target).To(Equal(flowKey.UUID))
+				})
+			})
+			Context("When the Flow is rolled back to the version a link moved it to", func() {
+				It("Then the Flow should be as it was after the link", func() {
+					flow, err := audited.RollbackFlow(flowKey, 2, 3)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("First description"))
+					Expect(flow.Version).To(Equal(int64(4)))
+
+					err = audited.UnchainNextFlowFromPath(flowKey, pathKey)
+					Expect(err).To(BeNil())
+					flow, err = audited.RollbackFlow(flowKey, 5, 5)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("First description"))
+					Expect(flow.TriggeredBy).To(BeEmpty())
+					Expect(flow.Version).To(Equal(int64(6)))
+				})
+			})
+			Context("When the Flow is rolled back to a version that is not in the audit log or with a stale version", func() {
+				It("Then an error should be returned and the Flow left as it was", func() {
+					_, err := audited.RollbackFlow(flowKey, 9, 3)
+					Expect(err).To(Equal(ErrVersionNotInAudit))
+					_, err = audited.RollbackFlow(flowKey, 1, 2)
+					Expect(err).To(Equal(ErrVersionConflict))
+
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("Second description"))
+				})
+			})
+			Context("When a Flow is saved with Paths that already exist", func() {
+				It("Then only the Flow should be recorded", func() {
+					_, err := audited.SaveFlow(Flow{
+						Name:        "Other flow",
+						Description: "Some description",
+						Path:        &messenger.Path{Route: "/some-route", Type: "mqtt"},
+						Wait:        true,
+						WaitFor:     &messenger.Path{Route: "/audit", Type: "path-trigger"},
+					})
+					Expect(err).To(BeNil())
+					entries, _, err := graph.ListAuditEntries(AuditOptions{Kind: AuditKindPath, Action: AuditActionCreate})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(2))
+				})
+			})
+			Context("When the Flow is updated by many actors at once", func() {
+				It("Then each update should record a different Flow before it", func() {
+					var wg sync.WaitGroup
+					for i := 0; i < 8; i++ {
+						wg.Add(1)
+						go func(i int) {
+							defer wg.Done()
+							defer GinkgoRecover()
+							flow, err := graph.GetFlowByKey(flowKey)
+							Expect(err).To(BeNil())
+							flow.Version = 0
+							flow.Description = fmt.Sprintf("Description %d", i)
+							Expect(NewAuditedStorage(&slowStorage{graph}, fmt.Sprintf("actor-%d", i)).UpdateFlow(flowKey, flow)).To(BeNil())
+						}(i)
+					}
+					wg.Wait()
+
+					entries, _, err := graph.ListAuditEntries(AuditOptions{Action: AuditActionUpdate, Target: flowKey.UUID})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(9)) // Along with the update made before
+					beforeVersions := map[int64]bool{}
+					for _, entry := range entries {
+						var before Flow
+						Expect(json.Unmarshal(entry.Before, &before)).To(BeNil())
+						Expect(beforeVersions).ToNot(HaveKey(before.Version))
+						beforeVersions[before.Version] = true
+					}
+				})
+			})
+			Context("When the audit log cannot be written", func() {
+				It("Then the change should be made but return the error", func() {
+					failing := NewAuditedStorage(&unauditableStorage{graph}, "alice")
+					flow, err := failing.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flow.Description = "Third description"
+					Expect(failing.UpdateFlow(flowKey, flow)).To(MatchError("audit log is full"))
+
+					flow, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Description).To(Equal("Third description"))
+				})
+			})
+			Context("When the Flow is deleted", func() {
+				It("Then its history should still be listed with the deletion last", func() {
+					err := audited.UnchainNextFlowFromPath(flowKey, pathKey)
+					Expect(err).To(BeNil())
+					err = audited.DeleteFlow(flowKey)
+					Expect(err).To(BeNil())
+
+					entries, _, err := graph.ListAuditEntries(AuditOptions{Kind: AuditKindFlow, Target: flowKey.UUID})
+					Expect(err).To(BeNil())
+					Expect(entries).To(HaveLen(5))
+					Expect(entries[0].Action).To(Equal(AuditActionDelete))
+					Expect(entries[0].Before).ToNot(BeEmpty())
+					Expect(entries[0].After).To(BeEmpty())
+				})
+			})
+		})
+		Describe("Given Paths and Flows in namespaces", func() {
+			var (
+				teamAPath Key
+				teamBPath Key
+				flowKey   Key
+			)
+			BeforeEach(func() {
+				var err error
+				teamAPath, err = graph.SavePath(messenger.Path{
+					Route:     "/shared-route",
+					Type:      "mqtt",
+					Namespace: "team-a",
+				})
+				Expect(err).To(BeNil())
+				teamBPath, err = graph.SavePath(messenger.Path{
+					Route:     "/shared-route",
+					Type:      "mqtt",
+					Namespace: "team-b",
+				})
+				Expect(err).To(BeNil())
+				flowKey, err = graph.SaveFlow(Flow{
+					Name:        "Team A Flow",
+					Description: "Flow Description",
+					Namespace:   "team-a",
+					Path: &messenger.Path{
+						Route: "/out",
+						Type:  "mqtt",
+					},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When Paths have the same route and type", func() {
+				It("Then each namespace should have its own Path", func() {
+					Expect(teamAPath).ToNot(Equal(teamBPath))
+					key, err := graph.SavePath(messenger.Path{
+						Route:     "/shared-route",
+						Type:      "mqtt",
+						Namespace: "team-b",
+					})
+					Expect(err).To(BeNil())
+					Expect(key).To(Equal(teamBPath))
+					path, err := graph.GetPathByKey(teamBPath)
+					Expect(err).To(BeNil())
+					Expect(path.Namespace).To(Equal("team-b"))
+				})
+			})
+			Context("When the Flow is retrieved", func() {
+				It("Then its Path should be in the namespace of the Flow", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Namespace).To(Equal("team-a"))
+					Expect(flow.Path.Namespace).To(Equal("team-a"))
+				})
+			})
+			Context("When a Flow sends to a Path in another namespace", func() {
+				It("Then the Flow should not be saved", func() {
+					_, err := graph.SaveFlow(Flow{
+						Name:      "Leaky Flow",
+						Namespace: "team-a",
+						Path: &messenger.Path{
+							Route:     "/out",
+							Type:      "mqtt",
+							Namespace: "team-b",
+						},
+					})
+					Expect(err).To(Equal(ErrPathOutsideNamespace))
+				})
+			})
+			Context("When a Path in another namespace is chained to the Flow", func() {
+				It("Then it should only be allowed once the Flow is shared with that namespace", func() {
+					err := graph.ChainNextFlowToPath(flowKey, teamBPath)
+					Expect(err).To(Equal(ErrNamespaceNotShared))
+					err = graph.ChainNextFlowToPath(flowKey, teamAPath)
+					Expect(err).To(BeNil())
+
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flow.SharedWith = []string{"team-b"}
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					err = graph.ChainNextFlowToPath(flowKey, teamBPath)
+					Expect(err).To(BeNil())
+
+					flow, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					flow.SharedWith = nil
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(Equal(ErrNamespaceNotShared))
+				})
+			})
+			Context("When Paths are listed by namespace", func() {
+				It("Then only Paths in the namespace should be returned", func() {
+					paths, keys, _, err := graph.ListPaths(ListOptions{Namespace: "team-b"})
+					Expect(err).To(BeNil())
+					Expect(paths).To(HaveLen(1))
+					Expect(keys).To(Equal([]Key{teamBPath}))
+				})
+			})
+			Context("When looking up Awaits by Path", func() {
+				It("Then only Awaits in the namespace of the Path should be returned", func() {
+					identity := NewRandomKey().String()
+					_, err := graph.SaveContinuation(Continuation{
+						Identity: identity,
+						Flows:    []Flow{{UUID: flowKey.UUID}},
+						Awaits: []Await{{
+							Name: "Team A Flow",
+							Path: &messenger.Path{Route: "/reply", Type: "mqtt", Namespace: "team-a"},
+						}},
+					})
+					Expect(err).To(BeNil())
+					keys, err := graph.GetKeysOfAwaits(messenger.Path{Route: "/reply", Type: "mqtt", Namespace: "team-b", Identity: identity})
+					Expect(err).To(BeNil())
+					Expect(keys).To(BeEmpty())
+					keys, err = graph.GetKeysOfAwaits(messenger.Path{Route: "/reply", Type: "mqtt", Namespace: "team-a", Identity: identity})
+					Expect(err).To(BeNil())
+					Expect(keys).To(HaveLen(1))
+				})
+			})
+			Context("When a pattern Path would match a Path in another namespace", func() {
+				It("Then the pattern should only match Paths in its own namespace", func() {
+					outKey, err := graph.GetKeyOfPath(messenger.Path{Route: "/out", Type: "mqtt", Namespace: "team-a"})
+					Expect(err).To(BeNil())
+					otherPatternKey, err := graph.SavePath(messenger.Path{Route: "#", Type: "mqtt", Namespace: "team-b"})
+					Expect(err).To(BeNil())
+					ownPatternKey, err := graph.SavePath(messenger.Path{Route: "#", Type: "mqtt", Namespace: "team-a"})
+					Expect(err).To(BeNil())
+
+					upstream, err := graph.GetUpstream(otherPatternKey, 1)
+					Expect(err).To(BeNil())
+					Expect(upstream.Flows).To(BeEmpty())
+					upstream, err = graph.GetUpstream(ownPatternKey, 1)
+					Expect(err).To(BeNil())
+					Expect(upstream.Flows).To(HaveLen(1))
+
+					exported, err := graph.GetGraph()
+					Expect(err).To(BeNil())
+					Expect(exported.Edges).To(ContainElement(GraphEdge{From: outKey.String(), To: ownPatternKey.String(), Kind: EdgeKindMatches}))
+					Expect(exported.Edges).ToNot(ContainElement(GraphEdge{From: outKey.String(), To: otherPatternKey.String(), Kind: EdgeKindMatches}))
+					Expect(exported.Nodes).To(ContainElement(GraphNode{ID: flowKey.String(), Kind: NodeKindFlow, Label: "Team A Flow in team-a", Namespace: "team-a"}))
+
+					scoped := exported.InNamespace("team-b")
+					Expect(scoped.Nodes).To(HaveLen(2))
+					for _, node := range scoped.Nodes {
+						Expect(node.Namespace).To(Equal("team-b"))
+					}
+					Expect(scoped.Edges).To(Equal([]GraphEdge{{From: teamBPath.String(), To: otherPatternKey.String(), Kind: EdgeKindMatches}}))
+				})
+			})
+		})
+		Describe("Given Flows and Paths with labels", func() {
+			var (
+				flowKey Key
+				pathKey Key
+			)
+			BeforeEach(func() {
+				var err error
+				flowKey, err = graph.SaveFlow(Flow{
+					Name:        "Labelled Flow",
+					Description: "Flow Description",
+					Path: &messenger.Path{
+						Route: "/labelled",
+						Type:  "mqtt",
+					},
+					Labels: map[string]string{"owner": "iot", "env": "prod"},
+				})
+				Expect(err).To(BeNil())
+				_, err = graph.SaveFlow(Flow{
+					Name:        "Other Flow",
+					Description: "Flow Description",
+					Path: &messenger.Path{
+						Route: "/other",
+						Type:  "mqtt",
+					},
+				})
+				Expect(err).To(BeNil())
+				pathKey, err = graph.SavePath(messenger.Path{
+					Route:  "/sensor",
+					Type:   "mqtt",
+					Labels: map[string]string{"owner": "iot"},
+				})
+				Expect(err).To(BeNil())
+			})
+			Context("When the Flow is disabled and retrieved", func() {
+				It("Then its labels and disabled should be returned", func() {
+					flow, err := graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Labels).To(Equal(map[string]string{"owner": "iot", "env": "prod"}))
+					Expect(flow.Disabled).To(BeFalse())
+					flow.Disabled = true
+					err = graph.UpdateFlow(flowKey, flow)
+					Expect(err).To(BeNil())
+					flow, err = graph.GetFlowByKey(flowKey)
+					Expect(err).To(BeNil())
+					Expect(flow.Disabled).To(BeTrue())
+				})
+			})
+			Context("When Flows and Paths are listed with a selector", func() {
+				It("Then only those whose labels it picks should be returned", func() {
+					selector, err := ParseSelector("owner=iot,env!=dev")
+					Expect(err).To(BeNil())
+					flows, keys, _, err := graph.ListFlows(ListOptions{Selector: selector})
+					Expect(err).To(BeNil())
+					Expect(flows).To(HaveLen(1))
+					Expect(keys).To(Equal([]Key{flowKey}))
+
+					selector, err = ParseSelector("owner")
+					Expect(err).To(BeNil())
+					paths, keys, _, err := graph.ListPaths(ListOptions{Selector: selector})
+					Expect(err).To(BeNil())
+					Expect(paths).To(HaveLen(1))
+					Expect(keys).To(Equal([]Key{pathKey}))
+				})
+			})
+			Context("When an existing Path is saved with other labels", func() {
+				It("Then its labels should be replaced and left alone when none are given", func() {
+					_, err := graph.SavePath(messenger.Path{
+						Route:  "/sensor",
+						Type:   "mqtt",
+						Labels: map[string]string{"owner": "lights"},
+					})
+					Expect(err).To(BeNil())
+					_, err = graph.SavePath(messenger.Path{
+						Route: "/sensor",
+						Type:  "mqtt",
+					})
+					Expect(err).To(BeNil())
+					path, err := graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(path.Labels).To(Equal(map[string]string{"owner": "lights"}))
+				})
+				It("Then its labels should be left alone when a Flow sending to it is saved or updated with other labels", func() {
+					flow := Flow{
+						Name:        "Sensor Flow",
+						Description: "Flow Description",
+						Path: &messenger.Path{
+							Route:  "/sensor",
+							Type:   "mqtt",
+							Labels: map[string]string{"owner": "flows"},
+						},
+					}
+					key, err := graph.SaveFlow(flow)
+					Expect(err).To(BeNil())
+					path, err := graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(path.Labels).To(Equal(map[string]string{"owner": "iot"}))
+
+					flow.Path.Labels = map[string]string{}
+					err = graph.UpdateFlow(key, flow)
+					Expect(err).To(BeNil())
+					path, err = graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(path.Labels).To(Equal(map[string]string{"owner": "iot"}))
+				})
+				It("Then its labels should be removed when empty labels are given", func() {
+					_, err := graph.SavePath(messenger.Path{
+						Route:  "/sensor",
+						Type:   "mqtt",
+						Labels: map[string]string{},
+					})
+					Expect(err).To(BeNil())
+					path, err := graph.GetPathByKey(pathKey)
+					Expect(err).To(BeNil())
+					Expect(path.Labels).To(BeEmpty())
 				})
 			})
 		})
 	})
 })
+
+// slowStorage is a Storage that is slow to return Flows it has read, so changes reading a Flow while another changes it are likely to overlap
+type slowStorage struct {
+	Storage
+}
+
+func (ss *slowStorage) GetFlowByKey(key Key) (Flow, error) {
+	flow, err := ss.Storage.GetFlowByKey(key)
+	time.Sleep(10 * time.Millisecond)
+	return flow, err
+}
+
+func (ss *slowStorage) Atomically(change func(Storage) error) error {
+	return ss.Storage.Atomically(func(storage Storage) error {
+		return change(&slowStorage{storage})
+	})
+}
+
+// unauditableStorage is a Storage whose audit log cannot be written
+type unauditableStorage struct {
+	Storage
+}
+
+func (us *unauditableStorage) Atomically(change func(Storage) error) error {
+	return us.Storage.Atomically(func(storage Storage) error {
+		return change(&unauditableStorage{storage})
+	})
+}
+
+func (us *unauditableStorage) AppendAuditEntry(entry AuditEntry) error {
+	return errors.New("audit log is full")
+}
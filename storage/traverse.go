@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+	"github.com/cayleygraph/cayley/schema"
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	DefaultTraversalDepth int = 10
+	MaxTraversalDepth     int = 100
+)
+
+var (
+	ErrInvalidDepth error = fmt.Errorf("Depth must be between 1 and %d", MaxTraversalDepth)
+)
+
+// Traversal is every Flow and Path reached by following the graph from a Path
+type Traversal struct {
+	Flows     []TraversedFlow `json:"flows"`
+	Paths     []TraversedPath `json:"paths"`
+	Truncated bool            `json:"truncated"` // Set when the depth limit stopped the traversal before it reached every Flow
+}
+
+// TraversedFlow is a Flow and how many Flows away from the starting Path it is, counting itself
+type TraversedFlow struct {
+	Flow
+	Depth int `json:"depth"`
+}
+
+// TraversedPath is a Path and how many Flows away from the starting Path it is
+type TraversedPath struct {
+	Key
+	messenger.Path
+	Depth int `json:"depth"`
+}
+
+// GetDownstream returns what a message arriving on the Path leads to: the Flows it triggers, the Paths those Flows send to or wait on, the Flows those Paths trigger and so on, up to maxDepth Flows away
+func (gs *GraphStorage) GetDownstream(pathKey Key, maxDepth int) (Traversal, error) {
+	return gs.traverse(pathKey, maxDepth, gs.getFlowKeysTriggeredByPath, gs.getPathKeysOfFlow)
+}
+
+// GetUpstream returns what can lead to a message arriving on the Path: the Flows that send to or wait on it, the Paths that trigger those Flows and so on, up to maxDepth Flows away
+func (gs *GraphStorage) GetUpstream(pathKey Key, maxDepth int) (Traversal, error) {
+	return gs.traverse(pathKey, maxDepth, gs.getFlowKeysSendingToPath, gs.getKeysTriggeringKey)
+}
+
+// traverse walks from the Path to Flows with flowsOfPath and from Flows back to Paths with pathsOfFlow, one Flow deeper each step
+func (gs *GraphStorage) traverse(pathKey Key, maxDepth int, flowsOfPath func(Key) ([]Key, error), pathsOfFlow func(Key) ([]Key, error)) (Traversal, error) {
+	if maxDepth == 0 {
+		maxDepth = DefaultTraversalDepth
+	}
+	if maxDepth < 0 || maxDepth > MaxTraversalDepth {
+		return Traversal{}, ErrInvalidDepth
+	}
+	_, err := gs.GetPathByKey(pathKey)
+	if err != nil {
+		return Traversal{}, err
+	}
+	traversal := Traversal{
+		Flows: []TraversedFlow{},
+		Paths: []TraversedPath{},
+	}
+	visited := map[Key]bool{pathKey: true}
+	frontier := []Key{pathKey}
+	for depth := 1; len(frontier) > 0; depth++ {
+		var flowKeys []Key
+		for _, key := range frontier {
+			keys, err := flowsOfPath(key)
+			if err != nil {
+				return Traversal{}, err
+			}
+			for _, flowKey := range keys {
+				if !visited[flowKey] {
+					visited[flowKey] = true
+					flowKeys = append(flowKeys, flowKey)
+				}
+			}
+		}
+		if depth > maxDepth {
+			traversal.Truncated = len(flowKeys) > 0
+			break
+		}
+		frontier = nil
+		for _, flowKey := range flowKeys {
+			flow, err := gs.GetFlowByKey(flowKey)
+			if err != nil {
+				return Traversal{}, err
+			}
+			traversal.Flows = append(traversal.Flows, TraversedFlow{Flow: flow, Depth: depth})
+			pathKeys, err := pathsOfFlow(flowKey)
+			if err != nil {
+				return Traversal{}, err
+			}
+			for _, nextPathKey := range pathKeys {
+				if visited[nextPathKey] {
+					continue
+				}
+				visited[nextPathKey] = true
+				path, err := gs.GetPathByKey(nextPathKey)
+				if err != nil {
+					return Traversal{}, err
+				}
+				traversal.Paths = append(traversal.Paths, TraversedPath{Key: nextPathKey, Path: path, Depth: depth})
+				frontier = append(frontier, nextPathKey)
+			}
+		}
+	}
+	return traversal, nil
+}
+
+// InNamespace returns the Flows and Paths of the traversal that are in the namespace
+func (t Traversal) InNamespace(namespace string) Traversal {
+	kept := Traversal{
+		Flows:     []TraversedFlow{},
+		Paths:     []TraversedPath{},
+		Truncated: t.Truncated,
+	}
+	for _, flow := range t.Flows {
+		if flow.Namespace == namespace {
+			kept.Flows = append(kept.Flows, flow)
+		}
+	}
+	for _, path := range t.Paths {
+		if path.Namespace == namespace {
+			kept.Paths = append(kept.Paths, path)
+		}
+	}
+	return kept
+}
+
+// getFlowKeysTriggeredByPath returns the Flows a message on the Path triggers, including through pattern Paths that match it
+func (gs *GraphStorage) getFlowKeysTriggeredByPath(pathKey Key) ([]Key, error) {
+	patternKeys, err := gs.getPatternPathKeysMatchingPath(pathKey)
+	if err != nil {
+		return nil, err
+	}
+	var flowKeys []Key
+	for _, key := range append([]Key{pathKey}, patternKeys...) {
+		keys, err := gs.getKeysTriggeredByKey(key)
+		if err != nil {
+			return nil, err
+		}
+		flowKeys = append(flowKeys, keys...)
+	}
+	return flowKeys, nil
+}
+
+// getFlowKeysSendingToPath returns the Flows that send to or wait on the Path. For a pattern Path, Flows sending to Paths the pattern matches are included
+func (gs *GraphStorage) getFlowKeysSendingToPath(pathKey Key) ([]Key, error) {
+	path, err := gs.GetPathByKey(pathKey)
+	if err != nil {
+		return nil, err
+	}
+	pathKeys := []Key{pathKey}
+	if IsPatternRoute(path.Route) {
+		matchingKeys, err := gs.getPathKeysMatchingPattern(path)
+		if err != nil {
+			return nil, err
+		}
+		pathKeys = append(pathKeys, matchingKeys...)
+	}
+	var flowKeys []Key
+	for _, key := range pathKeys {
+		p := cayley.StartPath(gs.store, key.QuadValue()).In(quad.IRI("path"), quad.IRI("waitFor"))
+		keyQuadList, err := p.Iterate(nil).AllValues(gs.store)
+		if err != nil {
+			return nil, ErrResolvingKey
+		}
+		keys, err := convertQuadValueListToKeyList(keyQuadList)
+		if err != nil {
+			return nil, err
+		}
+		flowKeys = append(flowKeys, keys...)
+	}
+	return flowKeys, nil
+}
+
+// getPathKeysMatchingPattern returns the Keys of the Paths in the namespace of the pattern that are not patterns themselves and that the pattern matches
+func (gs *GraphStorage) getPathKeysMatchingPattern(pattern messenger.Path) ([]Key, error) {
+	p := cayley.StartPath(gs.store, quad.StringToValue(pattern.Type)).In(quad.IRI("type"))
+	var pathDTOs []pathDTO
+	err := schema.LoadIteratorTo(nil, gs.store, reflect.ValueOf(&pathDTOs), p.BuildIterator())
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, pathDTO := range pathDTOs {
+		if pathDTO.Pattern || pathDTO.Namespace != pattern.Namespace {
+			continue
+		}
+		if _, ok := MatchRoute(pattern.Route, pathDTO.Route); !ok {
+			continue
+		}
+		key, err := NewKeyFromQuadIRI(pathDTO.ID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/cayleygraph/cayley"
+	"github.com/cayleygraph/cayley/quad"
+)
+
+var (
+	ErrVersionConflict error = fmt.Errorf("Stored version has changed since it was read")
+)
+
+// getVersion returns the version of the node stored under the predicate. Nodes saved before versions were kept are version 0
+func (gs *GraphStorage) getVersion(key Key, predicate string) (int64, error) {
+	values, err := cayley.StartPath(gs.store, key.QuadValue()).Out(quad.IRI(predicate)).Iterate(nil).AllValues(gs.store)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for _, value := range values {
+		if v, ok := value.(quad.Int); ok && int64(v) > version {
+			version = int64(v)
+		}
+	}
+	return version, nil
+}
+
+// bumpVersion adds one to the version of the node stored under the predicate
+func (gs *GraphStorage) bumpVersion(key Key, predicate string) error {
+	version, err := gs.getVersion(key, predicate)
+	if err != nil {
+		return err
+	}
+	tx := cayley.NewTransaction()
+	if version > 0 {
+		tx.RemoveQuad(quad.Make(key.QuadValue(), quad.IRI(predicate), quad.Int(version), nil))
+	}
+	tx.AddQuad(quad.Make(key.QuadValue(), quad.IRI(predicate), quad.Int(version+1), nil))
+	return gs.store.ApplyTransaction(tx)
+}
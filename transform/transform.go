@@ -0,0 +1,183 @@
+// Package transform reshapes message payloads before they are forwarded. A transform is a template where each {{ }} placeholder holds an
+// expression (see package expression) evaluated against the message, for example
+//
+//	{"celsius": "{{payload.temp}}", "room": "{{metadata.routeParam.room}}", "reading": "{{payload}}"}
+//
+// When the template is JSON, a string that is only a placeholder is replaced by the value itself, keeping numbers, objects and arrays as they are,
+// and other strings have their placeholders filled in as text. Any other template is filled in as text, so {{payload.temp}} on its own extracts the field
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/edfungus/conduction/expression"
+	"github.com/edfungus/conduction/messenger"
+)
+
+const (
+	placeholderStart string = "{{"
+	placeholderEnd   string = "}}"
+)
+
+// Transform is a parsed template that can be applied to messages
+type Transform struct {
+	source   string
+	document interface{} // Decoded JSON template. Nil when the template is text
+	text     template
+}
+
+// template is text split into literal text and placeholders
+type template []part
+
+type part struct {
+	text        string
+	placeholder *expression.Expression
+}
+
+// Parse returns the Transform of source or an error describing the invalid placeholder
+func Parse(source string) (*Transform, error) {
+	t := &Transform{source: source}
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err == nil && !decoder.More() {
+		if _, isString := document.(string); !isString {
+			document, err = parseDocument(document)
+			if err != nil {
+				return nil, err
+			}
+			t.document = document
+			return t, nil
+		}
+	}
+	text, err := parseTemplate(source)
+	if err != nil {
+		return nil, err
+	}
+	t.text = text
+	return t, nil
+}
+
+// String returns the source the Transform was parsed from
+func (t *Transform) String() string {
+	return t.source
+}
+
+// Apply returns the payload made by filling the template in with the message
+func (t *Transform) Apply(message messenger.Message) []byte {
+	if t.document == nil {
+		return []byte(t.text.fill(message))
+	}
+	var output bytes.Buffer
+	encoder := json.NewEncoder(&output)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(fillDocument(t.document, message))
+	return bytes.TrimRight(output.Bytes(), "\n")
+}
+
+// parseDocument replaces every string in the decoded JSON with its template
+func parseDocument(document interface{}) (interface{}, error) {
+	switch value := document.(type) {
+	case string:
+		return parseTemplate(value)
+	case map[string]interface{}:
+		for key, item := range value {
+			parsed, err := parseDocument(item)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = parsed
+		}
+	case []interface{}:
+		for i, item := range value {
+			parsed, err := parseDocument(item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = parsed
+		}
+	}
+	return document, nil
+}
+
+func fillDocument(document interface{}, message messenger.Message) interface{} {
+	switch value := document.(type) {
+	case template:
+		if len(value) == 1 && value[0].placeholder != nil {
+			return value[0].placeholder.Value(message)
+		}
+		return value.fill(message)
+	case map[string]interface{}:
+		filled := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			filled[key] = fillDocument(item, message)
+		}
+		return filled
+	case []interface{}:
+		filled := make([]interface{}, len(value))
+		for i, item := range value {
+			filled[i] = fillDocument(item, message)
+		}
+		return filled
+	}
+	return document
+}
+
+func parseTemplate(source string) (template, error) {
+	var t template
+	rest := source
+	for {
+		start := strings.Index(rest, placeholderStart)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], placeholderEnd)
+		if end < 0 {
+			return nil, fmt.Errorf("Placeholder in '%s' is missing %s", source, placeholderEnd)
+		}
+		end += start
+		placeholder, err := expression.Parse(rest[start+len(placeholderStart) : end])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid placeholder in '%s'. %v", source, err)
+		}
+		if start > 0 {
+			t = append(t, part{text: rest[:start]})
+		}
+		t = append(t, part{placeholder: placeholder})
+		rest = rest[end+len(placeholderEnd):]
+	}
+	if rest != "" || len(t) == 0 {
+		t = append(t, part{text: rest})
+	}
+	return t, nil
+}
+
+func (t template) fill(message messenger.Message) string {
+	var filled bytes.Buffer
+	for _, p := range t {
+		if p.placeholder == nil {
+			filled.WriteString(p.text)
+			continue
+		}
+		filled.WriteString(formatValue(p.placeholder.Value(message)))
+	}
+	return filled.String()
+}
+
+// formatValue writes strings as they are, null as nothing and everything else as JSON
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
@@ -0,0 +1,78 @@
+// +build all unit
+
+package transform
+
+import (
+	"github.com/edfungus/conduction/messenger"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conduction", func() {
+	Describe("Transform", func() {
+		message := messenger.Message{
+			Payload: []byte(`{"temp": 21.5, "unit": "C", "tags": ["a", "b"]}`),
+			Metadata: map[string][]byte{
+				"routeParam.room": []byte("kitchen"),
+			},
+			Origin: &messenger.Path{
+				Route: "sensors/kitchen/temp",
+				Type:  "MQTT",
+			},
+		}
+		apply := func(source string, message messenger.Message) string {
+			t, err := Parse(source)
+			Expect(err).To(BeNil())
+			return string(t.Apply(message))
+		}
+		Describe("Given a text template", func() {
+			Context("When it is only a placeholder", func() {
+				It("Then the value should be extracted", func() {
+					Expect(apply(`{{payload.temp}}`, message)).To(Equal(`21.5`))
+					Expect(apply(`{{ payload.unit }}`, message)).To(Equal(`C`))
+					Expect(apply(`{{payload.tags}}`, message)).To(Equal(`["a","b"]`))
+					Expect(apply(`{{payload.missing}}`, message)).To(Equal(``))
+				})
+			})
+			Context("When it mixes text and placeholders", func() {
+				It("Then the placeholders should be filled in as text", func() {
+					Expect(apply(`{{metadata.routeParam.room}} is {{payload.temp}}{{payload.unit}}`, message)).To(Equal(`kitchen is 21.5C`))
+					Expect(apply(`no placeholders`, message)).To(Equal(`no placeholders`))
+				})
+			})
+			Context("When the payload is not JSON", func() {
+				It("Then the whole payload should be available as text", func() {
+					Expect(apply(`state={{payload}}`, messenger.Message{Payload: []byte("ON")})).To(Equal(`state=ON`))
+				})
+			})
+		})
+		Describe("Given a JSON template", func() {
+			Context("When a string is only a placeholder", func() {
+				It("Then it should be replaced by the value keeping its JSON type", func() {
+					output := apply(`{"celsius": "{{payload.temp}}", "room": "{{metadata.routeParam.room}}", "reading": "{{payload}}", "hot": "{{payload.temp > 30}}", "version": 2}`, message)
+					Expect(output).To(MatchJSON(`{"celsius": 21.5, "room": "kitchen", "reading": {"temp": 21.5, "unit": "C", "tags": ["a", "b"]}, "hot": false, "version": 2}`))
+				})
+			})
+			Context("When a string mixes text and placeholders", func() {
+				It("Then the placeholders should be filled in as text", func() {
+					output := apply(`[{"label": "{{origin.type}}:{{origin.route}}"}, "<{{payload.unit}}>"]`, message)
+					Expect(output).To(MatchJSON(`[{"label": "MQTT:sensors/kitchen/temp"}, "<C>"]`))
+				})
+			})
+		})
+		Describe("Given an invalid template", func() {
+			Context("When it is parsed", func() {
+				It("Then an error should be returned", func() {
+					for _, source := range []string{
+						`{{payload.temp`,
+						`{{payload.temp >}}`,
+						`{"a": "{{body.temp}}"}`,
+					} {
+						_, err := Parse(source)
+						Expect(err).ToNot(BeNil(), source)
+					}
+				})
+			})
+		})
+	})
+})